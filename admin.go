@@ -0,0 +1,198 @@
+package glutton
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mushorg/glutton/producer"
+
+	"github.com/spf13/viper"
+)
+
+// ruleStatus is the admin API view of a loaded rule
+type ruleStatus struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Priority  int    `json:"priority"`
+	Type      string `json:"type"`
+	Target    string `json:"target,omitempty"`
+	Match     string `json:"match"`
+	Handler   string `json:"handler,omitempty"`
+	Scheduled bool   `json:"scheduled"`
+	Active    bool   `json:"active"`
+}
+
+// startAdmin serves the admin API and the metrics endpoint if enabled
+func (g *Glutton) startAdmin() error {
+	if !viper.GetBool("admin.enabled") {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	g.registerAdminHandlers(mux)
+
+	ln, err := net.Listen("tcp", viper.GetString("admin.addr"))
+	if err != nil {
+		return fmt.Errorf("failed to start admin listener: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			g.Logger.Error("Admin server failed", producer.ErrAttr(err))
+		}
+	}()
+	go func() {
+		<-g.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			g.Logger.Error("Failed to shut down admin server", producer.ErrAttr(err))
+		}
+	}()
+
+	g.Logger.Info("Admin server started", slog.String("addr", ln.Addr().String()), slog.String("reporter", "glutton"))
+	return nil
+}
+
+func (g *Glutton) registerAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /rules", g.adminRules)
+	mux.HandleFunc("GET /metrics", g.adminMetrics)
+	mux.HandleFunc("GET /profiles", g.adminProfiles)
+	mux.HandleFunc("GET /profiles/{ip}", g.adminProfile)
+	mux.HandleFunc("GET /transcripts/{session}", g.adminTranscript)
+}
+
+func (g *Glutton) ruleStatuses() []ruleStatus {
+	now := time.Now()
+	statuses := make([]ruleStatus, 0, len(g.rules))
+	for _, rule := range g.rules {
+		statuses = append(statuses, ruleStatus{
+			Index:     rule.Index(),
+			Name:      rule.Name,
+			Group:     rule.Group,
+			Priority:  rule.Priority,
+			Type:      rule.Type,
+			Target:    rule.Target,
+			Match:     rule.Match,
+			Handler:   rule.Handler(),
+			Scheduled: rule.Schedule != nil,
+			Active:    rule.Active(now),
+		})
+	}
+	return statuses
+}
+
+// adminRules lists the resolved rules and whether they are currently active
+func (g *Glutton) adminRules(w http.ResponseWriter, _ *http.Request) {
+	g.writeJSON(w, g.ruleStatuses())
+}
+
+func (g *Glutton) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		g.Logger.Error("Failed to encode admin response", producer.ErrAttr(err))
+	}
+}
+
+// maxProfilesLimit caps the profiles listed per request
+const maxProfilesLimit = 1000
+
+// adminProfiles lists attacker profiles, most recently seen first
+func (g *Glutton) adminProfiles(w http.ResponseWriter, r *http.Request) {
+	if g.profiles == nil {
+		http.Error(w, "profile store disabled", http.StatusNotFound)
+		return
+	}
+	limit, offset := 100, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxProfilesLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	profiles, err := g.profiles.List(limit, offset)
+	if err != nil {
+		g.Logger.Error("Failed to list profiles", producer.ErrAttr(err))
+		http.Error(w, "failed to list profiles", http.StatusInternalServerError)
+		return
+	}
+	g.writeJSON(w, profiles)
+}
+
+// adminProfile returns the full profile of a source IP
+func (g *Glutton) adminProfile(w http.ResponseWriter, r *http.Request) {
+	if g.profiles == nil {
+		http.Error(w, "profile store disabled", http.StatusNotFound)
+		return
+	}
+	ip, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid IP", http.StatusBadRequest)
+		return
+	}
+	p, err := g.profiles.Get(ip.Unmap().String())
+	if err != nil {
+		g.Logger.Error("Failed to read profile", producer.ErrAttr(err))
+		http.Error(w, "failed to read profile", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		http.Error(w, "profile not found", http.StatusNotFound)
+		return
+	}
+	g.writeJSON(w, p)
+}
+
+// adminMetrics exposes runtime gauges in the Prometheus text format
+func (g *Glutton) adminMetrics(w http.ResponseWriter, _ *http.Request) {
+	b := &strings.Builder{}
+
+	statuses := g.ruleStatuses()
+	active := 0
+	fmt.Fprintln(b, "# HELP glutton_rule_active Whether the rule's schedule currently allows it to match.")
+	fmt.Fprintln(b, "# TYPE glutton_rule_active gauge")
+	for _, status := range statuses {
+		value := 0
+		if status.Active {
+			value = 1
+			active++
+		}
+		fmt.Fprintf(b, "glutton_rule_active{index=\"%d\",name=%q,handler=%q} %d\n", status.Index, status.Name, status.Handler, value)
+	}
+	fmt.Fprintln(b, "# HELP glutton_rules Number of loaded rules.")
+	fmt.Fprintln(b, "# TYPE glutton_rules gauge")
+	fmt.Fprintf(b, "glutton_rules %d\n", len(statuses))
+	fmt.Fprintln(b, "# HELP glutton_rules_active Number of rules currently active.")
+	fmt.Fprintln(b, "# TYPE glutton_rules_active gauge")
+	fmt.Fprintf(b, "glutton_rules_active %d\n", active)
+	fmt.Fprintln(b, "# HELP glutton_goroutines Number of running goroutines.")
+	fmt.Fprintln(b, "# TYPE glutton_goroutines gauge")
+	fmt.Fprintf(b, "glutton_goroutines %d\n", runtime.NumGoroutine())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		g.Logger.Debug("Failed to write metrics", producer.ErrAttr(err))
+	}
+}
@@ -0,0 +1,112 @@
+package glutton
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mushorg/glutton/profile"
+	"github.com/mushorg/glutton/rules"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAdmin(t *testing.T) *http.ServeMux {
+	t.Helper()
+	rs, err := rules.Init(strings.NewReader(`version: 2
+rules:
+  - name: expired
+    match: tcp dst port 3389
+    type: conn_handler
+    target: rdp
+    schedule:
+      start: 2020-01-01T00:00:00Z
+      end: 2020-02-01T00:00:00Z
+  - match: tcp
+    type: conn_handler
+    target: tcp
+`))
+	require.NoError(t, err)
+	g := &Glutton{rules: rs}
+	mux := http.NewServeMux()
+	g.registerAdminHandlers(mux)
+	return mux
+}
+
+func TestAdminRules(t *testing.T) {
+	mux := testAdmin(t)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rules", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	statuses := []ruleStatus{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 2)
+	require.Equal(t, "expired", statuses[0].Name)
+	require.True(t, statuses[0].Scheduled)
+	require.False(t, statuses[0].Active)
+	require.True(t, statuses[1].Active)
+}
+
+func TestAdminMetrics(t *testing.T) {
+	mux := testAdmin(t)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `glutton_rule_active{index="0",name="expired",handler="rdp"} 0`)
+	require.Contains(t, rec.Body.String(), "glutton_rules_active 1")
+}
+
+func TestAdminProfiles(t *testing.T) {
+	store, err := profile.Open(filepath.Join(t.TempDir(), "profiles.db"), profile.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.Record("192.0.2.1", profile.Activity{Session: true, Port: 23, Handler: "telnet"}))
+	require.NoError(t, store.Flush())
+
+	g := &Glutton{profiles: store, Logger: slog.Default()}
+	mux := http.NewServeMux()
+	g.registerAdminHandlers(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	profiles := []profile.Profile{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &profiles))
+	require.Len(t, profiles, 1)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles?offset=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &profiles))
+	require.Empty(t, profiles)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles?offset=-1", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	for _, limit := range []string{"0", "-1", "1001"} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles?limit="+limit, nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code, limit)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles/192.0.2.1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	p := profile.Profile{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	require.Equal(t, []string{"telnet"}, p.Handlers)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles/192.0.2.2", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profiles/nope", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/transcript"
+
+	"github.com/spf13/pflag"
+)
+
+const replayUsage = `Usage: glutton replay [flags] <session ID or .cast file>
+
+Plays back the transcript of an interactive session. By default it's fetched
+from the admin API of the running sensor, --var-dir reads the artifact store
+of a stopped sensor instead.
+
+Flags:
+`
+
+// runReplay implements the replay subcommand
+func runReplay(args []string) error {
+	flags := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	admin := flags.String("admin", "127.0.0.1:9090", "Admin API address of the sensor")
+	varDir := flags.String("var-dir", "", "Read the artifact store under this var-dir instead of the admin API")
+	speed := flags.Float64("speed", 1, "Playback speed")
+	idle := flags.Float64("idle", 2, "Cap pauses at this many seconds, 0 keeps them")
+	outputOnly := flags.Bool("output-only", false, "Only play what the sensor sent")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, replayUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("missing session")
+	}
+
+	data, err := loadTranscript(flags.Arg(0), *admin, *varDir)
+	if err != nil {
+		return err
+	}
+	t, err := transcript.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse transcript: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = transcript.Play(ctx, os.Stdout, t, transcript.PlayOptions{
+		Speed:   *speed,
+		MaxIdle: time.Duration(*idle * float64(time.Second)),
+		Output:  *outputOnly,
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// loadTranscript reads a transcript file, or the transcript of a session from
+// the artifact store under varDir or the admin API
+func loadTranscript(name, admin, varDir string) ([]byte, error) {
+	if _, err := os.Stat(name); err == nil {
+		return os.ReadFile(name)
+	}
+	if varDir != "" {
+		store, err := artifact.Open(filepath.Join(varDir, "artifacts"), artifact.Options{})
+		if err != nil {
+			return nil, err
+		}
+		defer store.Close()
+		meta, err := store.FindSession(name, artifact.KindTranscript)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, fmt.Errorf("no transcript of session %s", name)
+		}
+		return store.Read(meta.SHA256)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get("http://" + admin + "/transcripts/" + url.PathEscape(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transcript: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to fetch transcript: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mushorg/glutton"
+	"github.com/mushorg/glutton/rules"
+
+	"github.com/spf13/pflag"
+)
+
+const rulesUsage = `Usage: glutton rules <command> [flags]
+
+Commands:
+  dump       Print the fully resolved, ordered rule list
+  explain    Show how the rules evaluate a flow, e.g.
+             glutton rules explain --proto tcp --src 1.2.3.4:5555 --dst 10.0.0.1:445
+`
+
+// runRules implements the rules subcommands
+func runRules(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, rulesUsage)
+		return errors.New("missing rules command")
+	}
+
+	flags := pflag.NewFlagSet("rules "+args[0], pflag.ContinueOnError)
+	rulesPath := flags.StringP("rules", "r", "config/rules.yaml", "Rules file path")
+	proto := flags.String("proto", "tcp", "Flow transport protocol (tcp or udp)")
+	src := flags.String("src", "", "Flow source address (ip:port)")
+	dst := flags.String("dst", "", "Flow destination address (ip:port)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	rs, err := glutton.LoadRules(*rulesPath)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	switch args[0] {
+	case "dump":
+		return dumpRules(os.Stdout, rs)
+	case "explain":
+		srcAddr, dstAddr, err := flowAddrs(*proto, *src, *dst)
+		if err != nil {
+			return err
+		}
+		return explainRules(os.Stdout, rs, *proto, srcAddr, dstAddr)
+	default:
+		fmt.Fprint(os.Stderr, rulesUsage)
+		return fmt.Errorf("unknown rules command: %s", args[0])
+	}
+}
+
+// dumpRules prints the rules in evaluation order
+func dumpRules(out io.Writer, rs rules.Rules) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tPRIORITY\tGROUP\tNAME\tTYPE\tTARGET\tACTIVE\tMATCH")
+	now := time.Now()
+	for i, rule := range rs {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i, rule.Priority, orDash(rule.Group), orDash(rule.Name), rule.Type, orDash(rule.Target), activeState(rule, now), rule.Match)
+	}
+	return w.Flush()
+}
+
+func flowAddrs(proto, src, dst string) (net.Addr, net.Addr, error) {
+	if src == "" || dst == "" {
+		return nil, nil, errors.New("both --src and --dst are required")
+	}
+	switch proto {
+	case "tcp":
+		srcAddr, err := net.ResolveTCPAddr(proto, src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --src: %w", err)
+		}
+		dstAddr, err := net.ResolveTCPAddr(proto, dst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --dst: %w", err)
+		}
+		return srcAddr, dstAddr, nil
+	case "udp":
+		srcAddr, err := net.ResolveUDPAddr(proto, src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --src: %w", err)
+		}
+		dstAddr, err := net.ResolveUDPAddr(proto, dst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --dst: %w", err)
+		}
+		return srcAddr, dstAddr, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported --proto: %s", proto)
+	}
+}
+
+// explainRules prints every rule considered for the flow, which ones matched
+// and the handler the winning rule resolves to
+func explainRules(out io.Writer, rs rules.Rules, proto string, srcAddr, dstAddr net.Addr) error {
+	evaluations, winner, err := rs.Explain(proto, srcAddr, dstAddr)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tRESULT\tNAME\tTYPE\tTARGET\tMATCH")
+	for _, evaluation := range evaluations {
+		rule := evaluation.Rule
+		result := "no match"
+		switch {
+		case rule == winner:
+			result = "winner"
+		case evaluation.Matched && !evaluation.Active:
+			result = "inactive"
+		case evaluation.Matched:
+			result = "shadowed"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", rule.Index(), result, orDash(rule.Name), rule.Type, orDash(rule.Target), rule.Match)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	if winner == nil {
+		fallback := "default"
+		if proto == "udp" {
+			fallback = "udp"
+		}
+		fmt.Fprintf(out, "%s %s -> %s: no rule matched, falling back to handler %q\n", proto, srcAddr, dstAddr, fallback)
+		return nil
+	}
+	fmt.Fprintf(out, "%s %s -> %s: rule %d (%s) wins, handler %q\n", proto, srcAddr, dstAddr, winner.Index(), orDash(winner.Name), winner.Handler())
+	return nil
+}
+
+// activeState describes whether a rule's schedule currently allows it to match
+func activeState(rule *rules.Rule, now time.Time) string {
+	switch {
+	case rule.Schedule == nil:
+		return "always"
+	case rule.Active(now):
+		return "yes"
+	default:
+		return "no"
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
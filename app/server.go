@@ -23,6 +23,19 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		if err := runRules(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Println(`
   _____ _       _   _
  / ____| |     | | | |
@@ -0,0 +1,429 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mushorg/glutton/yara"
+
+	"github.com/klauspost/compress/zstd"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Artifact kinds
+const (
+	KindPayload    = "payloads"
+	KindSample     = "samples"
+	KindTranscript = "transcripts"
+	KindMail       = "mails"
+)
+
+const (
+	// maxSources caps the source IPs kept per artifact, the oldest are dropped first
+	maxSources = 64
+	// evictTarget is the share of MaxSize eviction frees down to, so a full
+	// store doesn't evict on every new artifact
+	evictTarget = 0.9
+)
+
+var bucketArtifacts = []byte("artifacts")
+
+// ErrTooLarge is returned for artifacts bigger than the MaxObjectSize
+var ErrTooLarge = errors.New("artifact exceeds the maximum object size")
+
+// Info describes a sighting of an artifact
+type Info struct {
+	Kind    string
+	Handler string
+	Source  string
+	// URL the artifact was downloaded from, if any
+	URL string
+	// Session is the ID of the session the artifact was seen in, if any
+	Session string
+}
+
+// Meta is the index entry of an artifact
+type Meta struct {
+	SHA256    string    `json:"sha256"`
+	Kind      string    `json:"kind"`
+	Size      int64     `json:"size"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Count     int64     `json:"count"`
+	Sources   []string  `json:"sources,omitempty"`
+	Handlers  []string  `json:"handlers,omitempty"`
+	MIMEType  string    `json:"mimeType"`
+	URLs      []string  `json:"urls,omitempty"`
+	Sessions  []string  `json:"sessions,omitempty"`
+	// StoredSize is the size on disk, smaller than Size when compressed
+	StoredSize int64        `json:"storedSize"`
+	Compressed bool         `json:"compressed,omitempty"`
+	Yara       *yara.Result `json:"yara,omitempty"`
+	// RemoteURL is where the artifact was replicated to, if it was
+	RemoteURL string `json:"remoteURL,omitempty"`
+}
+
+// Options configure a Store
+type Options struct {
+	// MaxSize is the total size on disk artifacts may use, zero is unlimited
+	MaxSize int64
+	// MaxAge evicts artifacts not seen for this long, zero keeps them forever
+	MaxAge time.Duration
+	// MaxObjectSize rejects bigger artifacts, zero is unlimited
+	MaxObjectSize int64
+	// Compress stores new artifacts zstd compressed
+	Compress bool
+	Logger   *slog.Logger
+}
+
+// Store keeps artifacts content-addressed by SHA256 under a root directory
+// with a metadata index next to them
+type Store struct {
+	root    string
+	opts    Options
+	db      *bolt.DB
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	// mu serializes writes so the total size stays exact
+	mu    sync.Mutex
+	total int64
+	now   func() time.Time
+}
+
+// Open opens or creates the store rooted at root
+func Open(root string, opts Options) (*Store, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact store: %w", err)
+	}
+	db, err := bolt.Open(filepath.Join(root, "index.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact index: %w", err)
+	}
+	s := &Store{root: root, opts: opts, db: db, now: time.Now}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketArtifacts)
+		if err != nil {
+			return err
+		}
+		return forEach(b, func(meta *Meta) error {
+			s.total += meta.StoredSize
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to load artifact index: %w", err), db.Close())
+	}
+	if s.encoder, err = zstd.NewWriter(nil); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	if s.decoder, err = zstd.NewReader(nil); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	return s, nil
+}
+
+// Close closes the index
+func (s *Store) Close() error {
+	s.decoder.Close()
+	return errors.Join(s.encoder.Close(), s.db.Close())
+}
+
+// path returns where an artifact is kept, sharded by the first bytes of its
+// hash to keep directories small
+func (s *Store) path(meta *Meta) string {
+	name := meta.SHA256
+	if meta.Compressed {
+		name += ".zst"
+	}
+	return filepath.Join(s.root, meta.Kind, meta.SHA256[:2], meta.SHA256[2:4], name)
+}
+
+// Put stores data, or records another sighting if it is already stored,
+// and returns its index entry
+func (s *Store) Put(data []byte, info Info) (*Meta, error) {
+	if s.opts.MaxObjectSize > 0 && int64(len(data)) > s.opts.MaxObjectSize {
+		return nil, ErrTooLarge
+	}
+	if info.Kind == "" || filepath.Base(info.Kind) != info.Kind {
+		return nil, fmt.Errorf("invalid artifact kind %q", info.Kind)
+	}
+	sum := sha256.Sum256(data)
+	sha256Hash := hex.EncodeToString(sum[:])
+	now := s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.Get(sha256Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = &Meta{
+			SHA256:    sha256Hash,
+			Kind:      info.Kind,
+			Size:      int64(len(data)),
+			FirstSeen: now,
+			MIMEType:  http.DetectContentType(data),
+		}
+	}
+	if _, err := os.Stat(s.path(meta)); err != nil {
+		// new, or the file was removed behind our back
+		s.total -= meta.StoredSize
+		if err := s.write(meta, data); err != nil {
+			return nil, err
+		}
+		s.total += meta.StoredSize
+	}
+	meta.LastSeen = now
+	meta.Count++
+	meta.Sources = appendCapped(meta.Sources, info.Source)
+	meta.Handlers = appendCapped(meta.Handlers, info.Handler)
+	meta.URLs = appendCapped(meta.URLs, info.URL)
+	meta.Sessions = appendCapped(meta.Sessions, info.Session)
+	if err := s.put(meta); err != nil {
+		return nil, err
+	}
+
+	if s.opts.MaxSize > 0 && s.total > s.opts.MaxSize {
+		if err := s.evict(int64(float64(s.opts.MaxSize)*evictTarget), time.Time{}); err != nil {
+			s.opts.Logger.Error("Failed to evict artifacts", slog.String("error", err.Error()), slog.String("reporter", "artifact"))
+		}
+	}
+	return meta, nil
+}
+
+func appendCapped(s []string, v string) []string {
+	if v == "" {
+		return s
+	}
+	if i := slices.Index(s, v); i >= 0 {
+		// move it to the end, the most recent
+		s = slices.Delete(s, i, i+1)
+	}
+	s = append(s, v)
+	if len(s) > maxSources {
+		s = s[len(s)-maxSources:]
+	}
+	return s
+}
+
+// write stores the content of an artifact, through a temporary file so a
+// crash never leaves a partial artifact under its hash
+func (s *Store) write(meta *Meta, data []byte) error {
+	meta.Compressed = s.opts.Compress
+	if meta.Compressed {
+		data = s.encoder.EncodeAll(data, nil)
+	}
+	path := s.path(meta)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write artifact: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return errors.Join(fmt.Errorf("failed to write artifact: %w", err), tmp.Close(), os.Remove(tmp.Name()))
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to write artifact: %w", err), os.Remove(tmp.Name()))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Join(fmt.Errorf("failed to write artifact: %w", err), os.Remove(tmp.Name()))
+	}
+	meta.StoredSize = int64(len(data))
+	return nil
+}
+
+func (s *Store) put(meta *Meta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketArtifacts).Put([]byte(meta.SHA256), data)
+	})
+}
+
+// SetYara records the YARA result of an artifact
+func (s *Store) SetYara(sha256Hash string, result *yara.Result) error {
+	return s.update(sha256Hash, func(meta *Meta) {
+		meta.Yara = result
+	})
+}
+
+// SetRemoteURL records where an artifact was replicated to
+func (s *Store) SetRemoteURL(sha256Hash, url string) error {
+	return s.update(sha256Hash, func(meta *Meta) {
+		meta.RemoteURL = url
+	})
+}
+
+func (s *Store) update(sha256Hash string, fn func(*Meta)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.Get(sha256Hash)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("unknown artifact %s: %w", sha256Hash, os.ErrNotExist)
+	}
+	fn(meta)
+	return s.put(meta)
+}
+
+// Get returns the index entry of an artifact, or nil if it isn't stored
+func (s *Store) Get(sha256Hash string) (*Meta, error) {
+	var meta *Meta
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketArtifacts).Get([]byte(sha256Hash))
+		if data == nil {
+			return nil
+		}
+		meta = &Meta{}
+		return json.Unmarshal(data, meta)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact %s: %w", sha256Hash, err)
+	}
+	return meta, nil
+}
+
+// Read returns the content of an artifact
+func (s *Store) Read(sha256Hash string) ([]byte, error) {
+	meta, err := s.Get(sha256Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.path(meta))
+	if err != nil {
+		return nil, err
+	}
+	if meta.Compressed {
+		return s.decoder.DecodeAll(data, nil)
+	}
+	return data, nil
+}
+
+// ForEach calls fn with the index entry of every stored artifact
+func (s *Store) ForEach(fn func(*Meta) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return forEach(tx.Bucket(bucketArtifacts), fn)
+	})
+}
+
+func forEach(b *bolt.Bucket, fn func(*Meta) error) error {
+	return b.ForEach(func(_, data []byte) error {
+		meta := &Meta{}
+		if err := json.Unmarshal(data, meta); err != nil {
+			return err
+		}
+		return fn(meta)
+	})
+}
+
+// FindSession returns the index entry of the artifact of kind seen in a
+// session, or nil if there is none
+func (s *Store) FindSession(session, kind string) (*Meta, error) {
+	var found *Meta
+	err := s.ForEach(func(meta *Meta) error {
+		if meta.Kind == kind && slices.Contains(meta.Sessions, session) {
+			found = meta
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return nil, err
+	}
+	return found, nil
+}
+
+// errStop ends ForEach early
+var errStop = errors.New("stop")
+
+// Size returns the total size of the stored artifacts on disk
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Evict removes artifacts older than MaxAge and the least recently seen
+// ones beyond MaxSize
+func (s *Store) Evict() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var before time.Time
+	if s.opts.MaxAge > 0 {
+		before = s.now().Add(-s.opts.MaxAge)
+	}
+	limit := int64(-1)
+	if s.opts.MaxSize > 0 {
+		limit = s.opts.MaxSize
+	}
+	return s.evict(limit, before)
+}
+
+// evict removes artifacts last seen before the given time, then the least
+// recently seen until the total size is at most target. A negative target
+// disables size eviction.
+func (s *Store) evict(limit int64, before time.Time) error {
+	var metas []*Meta
+	err := s.ForEach(func(meta *Meta) error {
+		metas = append(metas, meta)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].LastSeen.Before(metas[j].LastSeen)
+	})
+
+	var evicted []*Meta
+	for _, meta := range metas {
+		if !meta.LastSeen.Before(before) && (limit < 0 || s.total <= limit) {
+			break
+		}
+		if err := os.Remove(s.path(meta)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		s.total -= meta.StoredSize
+		evicted = append(evicted, meta)
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+	s.opts.Logger.Info("Evicted artifacts", slog.Int("count", len(evicted)), slog.Int64("size", s.total), slog.String("reporter", "artifact"))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketArtifacts)
+		for _, meta := range evicted {
+			if err := b.Delete([]byte(meta.SHA256)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
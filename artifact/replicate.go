@@ -0,0 +1,212 @@
+package artifact
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetries    = 10
+	defaultBackoff    = 5 * time.Second
+	defaultMaxBackoff = 10 * time.Minute
+	// queueSize bounds the pending uploads, artifacts not fitting are picked
+	// up by the next sweep
+	queueSize = 1024
+	// uploadTimeout bounds a single upload attempt
+	uploadTimeout = 5 * time.Minute
+)
+
+// Uploader puts artifacts into remote storage
+type Uploader interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	URL(key string) string
+}
+
+// ReplicatorOptions configure a Replicator
+type ReplicatorOptions struct {
+	// Prefix is prepended to the SHA256 hash to form the object key
+	Prefix string
+	// Filter selects the artifacts to replicate, all of them if nil
+	Filter func(*Meta) bool
+	// Retries is how often a failed upload is retried before waiting for the
+	// next sweep
+	Retries int
+	// Backoff is the delay before the first retry, doubled on every retry up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Sweep is how often the index is searched for artifacts that were not
+	// replicated yet, zero only sweeps on start
+	Sweep  time.Duration
+	Logger *slog.Logger
+}
+
+type upload struct {
+	sha256  string
+	attempt int
+}
+
+// Replicator copies artifacts of a Store to remote storage in the background
+type Replicator struct {
+	store    *Store
+	uploader Uploader
+	opts     ReplicatorOptions
+	ctx      context.Context
+	queue    chan upload
+	// pending holds the artifacts queued or waiting for a retry, so they
+	// aren't uploaded twice
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// NewReplicator starts replicating the artifacts of store, including those
+// stored before, until ctx is done
+func NewReplicator(ctx context.Context, store *Store, uploader Uploader, opts ReplicatorOptions) *Replicator {
+	if opts.Retries <= 0 {
+		opts.Retries = defaultRetries
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	r := &Replicator{
+		store:    store,
+		uploader: uploader,
+		opts:     opts,
+		ctx:      ctx,
+		queue:    make(chan upload, queueSize),
+		pending:  map[string]bool{},
+	}
+	go r.run()
+	go func() {
+		var tick <-chan time.Time
+		if opts.Sweep > 0 {
+			ticker := time.NewTicker(opts.Sweep)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			if err := r.sweep(); err != nil {
+				r.opts.Logger.Error("Failed to sweep artifacts for replication", slog.String("error", err.Error()), slog.String("reporter", "artifact"))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+	}()
+	return r
+}
+
+// Replicate queues an artifact for upload and returns the URL it will be
+// available at, or an empty string if it isn't replicated
+func (r *Replicator) Replicate(meta *Meta) string {
+	if !r.selected(meta) {
+		return meta.RemoteURL
+	}
+	url := r.uploader.URL(r.opts.Prefix + meta.SHA256)
+	if meta.RemoteURL != url {
+		r.enqueue(upload{sha256: meta.SHA256})
+	}
+	return url
+}
+
+func (r *Replicator) selected(meta *Meta) bool {
+	return r.opts.Filter == nil || r.opts.Filter(meta)
+}
+
+// sweep queues the selected artifacts without a remote copy
+func (r *Replicator) sweep() error {
+	return r.store.ForEach(func(meta *Meta) error {
+		if r.selected(meta) && meta.RemoteURL != r.uploader.URL(r.opts.Prefix+meta.SHA256) {
+			r.enqueue(upload{sha256: meta.SHA256})
+		}
+		return nil
+	})
+}
+
+func (r *Replicator) enqueue(u upload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u.attempt == 0 && r.pending[u.sha256] {
+		return
+	}
+	select {
+	case r.queue <- u:
+		r.pending[u.sha256] = true
+	default:
+		delete(r.pending, u.sha256)
+	}
+}
+
+func (r *Replicator) done(sha256Hash string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, sha256Hash)
+}
+
+func (r *Replicator) run() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case u := <-r.queue:
+			r.upload(u)
+		}
+	}
+}
+
+func (r *Replicator) upload(u upload) {
+	logger := r.opts.Logger.With(slog.String("sha256", u.sha256), slog.String("reporter", "artifact"))
+	err := r.put(u.sha256)
+	switch {
+	case err == nil:
+		r.done(u.sha256)
+		logger.Debug("Replicated artifact")
+	case errors.Is(err, os.ErrNotExist):
+		// evicted in the meantime
+		r.done(u.sha256)
+	case u.attempt >= r.opts.Retries:
+		r.done(u.sha256)
+		logger.Error("Failed to replicate artifact, giving up until the next sweep", slog.String("error", err.Error()), slog.Int("attempts", u.attempt+1))
+	default:
+		backoff := min(r.opts.Backoff<<u.attempt, r.opts.MaxBackoff)
+		logger.Warn("Failed to replicate artifact, retrying", slog.String("error", err.Error()), slog.Duration("backoff", backoff))
+		time.AfterFunc(backoff, func() {
+			if r.ctx.Err() == nil {
+				r.enqueue(upload{sha256: u.sha256, attempt: u.attempt + 1})
+			}
+		})
+	}
+}
+
+func (r *Replicator) put(sha256Hash string) error {
+	meta, err := r.store.Get(sha256Hash)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return os.ErrNotExist
+	}
+	data, err := r.store.Read(sha256Hash)
+	if err != nil {
+		return err
+	}
+	key := r.opts.Prefix + sha256Hash
+	ctx, cancel := context.WithTimeout(r.ctx, uploadTimeout)
+	defer cancel()
+	if err := r.uploader.Put(ctx, key, data, meta.MIMEType); err != nil {
+		return err
+	}
+	return r.store.SetRemoteURL(sha256Hash, r.uploader.URL(key))
+}
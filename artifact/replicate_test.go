@@ -0,0 +1,74 @@
+package artifact
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUploader struct {
+	mu       sync.Mutex
+	failures int
+	objects  map[string][]byte
+	uploaded chan string
+}
+
+func (u *fakeUploader) Put(_ context.Context, key string, data []byte, _ string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failures > 0 {
+		u.failures--
+		return errors.New("503 Service Unavailable")
+	}
+	u.objects[key] = data
+	u.uploaded <- key
+	return nil
+}
+
+func (u *fakeUploader) URL(key string) string {
+	return "http://minio:9000/glutton/" + key
+}
+
+func TestReplicator(t *testing.T) {
+	s, _ := testStore(t, Options{})
+	old, err := s.Put([]byte("stored before"), Info{Kind: KindSample})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	uploader := &fakeUploader{failures: 2, objects: map[string][]byte{}, uploaded: make(chan string, 10)}
+	r := NewReplicator(ctx, s, uploader, ReplicatorOptions{
+		Prefix:  "glutton/",
+		Filter:  func(meta *Meta) bool { return meta.Kind == KindSample },
+		Backoff: time.Millisecond,
+	})
+
+	// the artifact stored before is picked up on start, after two retries
+	require.Equal(t, "glutton/"+old.SHA256, <-uploader.uploaded)
+
+	meta, err := s.Put([]byte("#!/bin/sh"), Info{Kind: KindSample})
+	require.NoError(t, err)
+	require.Equal(t, "http://minio:9000/glutton/glutton/"+meta.SHA256, r.Replicate(meta))
+	require.Equal(t, "glutton/"+meta.SHA256, <-uploader.uploaded)
+	require.Equal(t, []byte("#!/bin/sh"), uploader.objects["glutton/"+meta.SHA256])
+
+	require.Eventually(t, func() bool {
+		stored, err := s.Get(meta.SHA256)
+		require.NoError(t, err)
+		return stored.RemoteURL == "http://minio:9000/glutton/glutton/"+meta.SHA256
+	}, time.Second, time.Millisecond)
+
+	// already replicated artifacts aren't uploaded again
+	stored, err := s.Get(meta.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, stored.RemoteURL, r.Replicate(stored))
+
+	payload, err := s.Put([]byte("small payload"), Info{Kind: KindPayload})
+	require.NoError(t, err)
+	require.Empty(t, r.Replicate(payload))
+	require.Empty(t, uploader.uploaded)
+}
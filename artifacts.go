@@ -0,0 +1,141 @@
+package glutton
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/s3"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	// artifactEvictInterval is how often artifacts past their age are evicted
+	artifactEvictInterval = time.Hour
+	// replicationSweepInterval is how often artifacts whose upload failed are
+	// queued again
+	replicationSweepInterval = time.Hour
+)
+
+// initArtifacts opens the artifact store under var-dir and starts its eviction
+func (g *Glutton) initArtifacts() error {
+	var err error
+	g.artifacts, err = artifact.Open(filepath.Join(viper.GetString("var-dir"), "artifacts"), artifact.Options{
+		MaxSize:       viper.GetInt64("artifacts.max_size") << 20,
+		MaxAge:        time.Duration(viper.GetInt("artifacts.max_age")) * 24 * time.Hour,
+		MaxObjectSize: viper.GetInt64("artifacts.max_object_size") << 20,
+		Compress:      viper.GetBool("artifacts.compress"),
+		Logger:        g.Logger,
+	})
+	if err != nil {
+		return err
+	}
+	if err := g.initReplication(); err != nil {
+		return fmt.Errorf("failed to initialize artifact replication: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(artifactEvictInterval)
+		defer ticker.Stop()
+		for {
+			if err := g.artifacts.Evict(); err != nil {
+				g.Logger.Error("Failed to evict artifacts", producer.ErrAttr(err))
+			}
+			select {
+			case <-g.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// initReplication starts copying samples and large payloads to an
+// S3-compatible bucket
+func (g *Glutton) initReplication() error {
+	if !viper.GetBool("artifacts.s3.enabled") {
+		return nil
+	}
+	accessKey, secretKey := viper.GetString("artifacts.s3.access_key"), viper.GetString("artifacts.s3.secret_key")
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	client, err := s3.New(s3.Config{
+		Endpoint:     viper.GetString("artifacts.s3.endpoint"),
+		Region:       viper.GetString("artifacts.s3.region"),
+		Bucket:       viper.GetString("artifacts.s3.bucket"),
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		PathStyle:    viper.GetBool("artifacts.s3.path_style"),
+	})
+	if err != nil {
+		return err
+	}
+	minPayloadSize := viper.GetInt64("artifacts.s3.min_payload_size") << 10
+	g.replicator = artifact.NewReplicator(g.ctx, g.artifacts, client, artifact.ReplicatorOptions{
+		Prefix: viper.GetString("artifacts.s3.prefix"),
+		Filter: func(meta *artifact.Meta) bool {
+			return meta.Kind != artifact.KindPayload || meta.Size >= minPayloadSize
+		},
+		Sweep:  replicationSweepInterval,
+		Logger: g.Logger,
+	})
+	return nil
+}
+
+// Store keeps a blob captured in a session in the artifact store and returns
+// its SHA256 hash. A reference to the artifact and its YARA matches are
+// attached to the next event of the session.
+func (g *Glutton) Store(md connection.Metadata, data []byte, info artifact.Info) (string, error) {
+	meta, opts, err := g.storeArtifact(md, data, info)
+	if err != nil {
+		return "", err
+	}
+	g.attachmentsMu.Lock()
+	g.attachments[md.ID] = append(g.attachments[md.ID], opts...)
+	g.attachmentsMu.Unlock()
+	return meta.SHA256, nil
+}
+
+// storeArtifact stores a blob and returns the event options referencing it
+func (g *Glutton) storeArtifact(md connection.Metadata, data []byte, info artifact.Info) (*artifact.Meta, []producer.EventOption, error) {
+	if md.Key.Src.IsValid() {
+		info.Source = md.Key.Src.Addr().String()
+	}
+	if md.ID != uuid.Nil {
+		info.Session = md.ID.String()
+	}
+	meta, err := g.artifacts.Put(data, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	g.Logger.Debug("Stored artifact", slog.String("sha256", meta.SHA256), slog.String("kind", meta.Kind), slog.Int64("count", meta.Count))
+	ref := producer.Artifact{SHA256: meta.SHA256, Kind: meta.Kind}
+	if g.replicator != nil {
+		ref.URL = g.replicator.Replicate(meta)
+	}
+	opts := []producer.EventOption{producer.WithArtifacts(ref)}
+	if result := g.scanArtifact(meta, data); result != nil {
+		opts = append(opts, producer.WithYara(*result))
+	}
+	return meta, opts, nil
+}
+
+// takeAttachments returns and forgets the event options of the artifacts a
+// session stored since its last event
+func (g *Glutton) takeAttachments(md connection.Metadata) []producer.EventOption {
+	g.attachmentsMu.Lock()
+	defer g.attachmentsMu.Unlock()
+	opts := g.attachments[md.ID]
+	delete(g.attachments, md.ID)
+	return opts
+}
@@ -0,0 +1,35 @@
+package glutton
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAttachments(t *testing.T) {
+	store, err := artifact.Open(t.TempDir(), artifact.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+	g := &Glutton{
+		Logger:      producer.NewLogger("test"),
+		artifacts:   store,
+		attachments: make(map[uuid.UUID][]producer.EventOption),
+	}
+	md := connection.Metadata{ID: uuid.New()}
+
+	hash, err := g.Store(md, []byte("payload"), artifact.Info{Kind: artifact.KindPayload, Handler: "tcp"})
+	require.NoError(t, err)
+
+	// the next event of the session references the artifact, once
+	event := &producer.Event{}
+	for _, opt := range g.takeAttachments(md) {
+		opt(event)
+	}
+	require.Equal(t, []producer.Artifact{{SHA256: hash, Kind: artifact.KindPayload}}, event.Artifacts)
+	require.Empty(t, g.takeAttachments(md))
+}
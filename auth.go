@@ -0,0 +1,96 @@
+package glutton
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mushorg/glutton/auth"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
+
+	"github.com/spf13/viper"
+)
+
+// initAuth sets up the login policy and its per handler overrides
+func (g *Glutton) initAuth() error {
+	a, err := newAuthenticator(g.ctx, "auth")
+	if err != nil {
+		return err
+	}
+	g.authenticators = map[string]*auth.Authenticator{"": a}
+	for handler := range viper.GetStringMap("auth.handlers") {
+		a, err := newAuthenticator(g.ctx, "auth.handlers."+handler)
+		if err != nil {
+			return fmt.Errorf("handler %s: %w", handler, err)
+		}
+		g.authenticators[handler] = a
+	}
+	return nil
+}
+
+// newAuthenticator creates the authenticator configured under prefix,
+// falling back to the global auth settings for unset keys
+func newAuthenticator(ctx context.Context, prefix string) (*auth.Authenticator, error) {
+	key := func(name string) string {
+		if viper.IsSet(prefix + "." + name) {
+			return prefix + "." + name
+		}
+		return "auth." + name
+	}
+	var credentials []auth.Credential
+	for _, pair := range viper.GetStringSlice(key("pairs")) {
+		c, err := auth.ParseCredential(pair)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, c)
+	}
+	return auth.New(ctx, auth.Options{
+		Policy:          viper.GetString(key("policy")),
+		Failures:        viper.GetInt(key("failures")),
+		Window:          time.Duration(viper.GetInt(key("window"))) * time.Second,
+		CredentialsFile: viper.GetString(key("credentials_file")),
+		Credentials:     credentials,
+	})
+}
+
+// Authenticate reports whether the login prompt of handler accepts a
+// username and password. Every attempt is recorded in the profile of the
+// source and produced as an auth_attempt event.
+func (g *Glutton) Authenticate(md connection.Metadata, handler, username, password string) bool {
+	a := g.authenticators[handler]
+	if a == nil {
+		a = g.authenticators[""]
+	}
+	success, policy := true, auth.AcceptAll
+	if a != nil {
+		var ip string
+		if md.Key.Src.IsValid() {
+			ip = md.Key.Src.Addr().String()
+		}
+		success, policy = a.Check(ip, username, password), a.Policy()
+	}
+
+	g.RecordActivity(md, profile.Activity{
+		Handler: handler,
+		Credential: &profile.Credential{
+			Handler:  handler,
+			Username: username,
+			Password: password,
+		},
+	})
+	if g.Producer != nil {
+		if err := g.Producer.LogAuth(handler, md, &producer.Auth{
+			Username: username,
+			Password: password,
+			Success:  success,
+			Policy:   policy,
+		}); err != nil {
+			g.Logger.Error("Failed to produce auth attempt", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+		}
+	}
+	return success
+}
@@ -0,0 +1,221 @@
+// Package auth decides whether the login prompts of the handlers accept a
+// username and password.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policies
+const (
+	// AcceptAll accepts every login
+	AcceptAll = "accept_all"
+	// File accepts the credentials listed in a file
+	File = "file"
+	// AfterFailures rejects the first logins of a source, then accepts one
+	// and keeps accepting that one
+	AfterFailures = "after_failures"
+	// Pairs accepts the configured credentials
+	Pairs = "pairs"
+)
+
+// Wildcard matches any username or password in a credential
+const Wildcard = "*"
+
+const (
+	defaultFailures   = 2
+	defaultWindow     = time.Hour
+	defaultMaxSources = 100000
+)
+
+// Credential is a username and password pair
+type Credential struct {
+	Username string
+	Password string
+}
+
+// ParseCredential parses a username:password pair, the password may contain
+// colons
+func ParseCredential(s string) (Credential, error) {
+	username, password, ok := strings.Cut(s, ":")
+	if !ok {
+		return Credential{}, fmt.Errorf("invalid credential %q, expected username:password", s)
+	}
+	return Credential{Username: username, Password: password}, nil
+}
+
+func (c Credential) matches(username, password string) bool {
+	return (c.Username == Wildcard || c.Username == username) && (c.Password == Wildcard || c.Password == password)
+}
+
+// Options configure an Authenticator
+type Options struct {
+	// Policy is one of the policies, accept_all if empty
+	Policy string
+	// Failures is the number of rejected logins of a source before one is
+	// accepted, for after_failures
+	Failures int
+	// Window is how long the logins of a source are remembered, for after_failures
+	Window time.Duration
+	// CredentialsFile lists the accepted username:password pairs, one per
+	// line, for file
+	CredentialsFile string
+	// Credentials are the accepted pairs, for pairs
+	Credentials []Credential
+	// MaxSources bounds the number of tracked sources, logins of new sources
+	// are rejected while the authenticator is full
+	MaxSources int
+}
+
+type source struct {
+	lastSeen time.Time
+	failures int
+	// accepted is the credential a source logged in with
+	accepted *Credential
+}
+
+// Authenticator applies a login policy
+type Authenticator struct {
+	opts        Options
+	credentials []Credential
+	mu          sync.Mutex
+	sources     map[string]*source
+	now         func() time.Time
+}
+
+// New creates an authenticator, tracking sources for after_failures until
+// ctx is done
+func New(ctx context.Context, opts Options) (*Authenticator, error) {
+	if opts.Policy == "" {
+		opts.Policy = AcceptAll
+	}
+	if opts.Failures <= 0 {
+		opts.Failures = defaultFailures
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaultWindow
+	}
+	if opts.MaxSources <= 0 {
+		opts.MaxSources = defaultMaxSources
+	}
+	a := &Authenticator{
+		opts: opts,
+		now:  time.Now,
+	}
+	switch opts.Policy {
+	case AcceptAll:
+	case File:
+		f, err := os.Open(opts.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open credentials file: %w", err)
+		}
+		defer f.Close()
+		if a.credentials, err = ReadCredentials(f); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", opts.CredentialsFile, err)
+		}
+	case Pairs:
+		a.credentials = opts.Credentials
+	case AfterFailures:
+		a.sources = make(map[string]*source)
+		go func() {
+			ticker := time.NewTicker(opts.Window / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					a.expire()
+				}
+			}
+		}()
+	default:
+		return nil, fmt.Errorf("unknown login policy %q", opts.Policy)
+	}
+	return a, nil
+}
+
+// ReadCredentials reads username:password pairs, one per line. Empty lines
+// and lines starting with # are skipped.
+func ReadCredentials(r io.Reader) ([]Credential, error) {
+	var credentials []Credential
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c, err := ParseCredential(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, scanner.Err()
+}
+
+// Policy returns the policy the authenticator applies
+func (a *Authenticator) Policy() string {
+	return a.opts.Policy
+}
+
+// Check reports whether a login of ip is accepted
+func (a *Authenticator) Check(ip, username, password string) bool {
+	switch a.opts.Policy {
+	case AcceptAll:
+		return true
+	case AfterFailures:
+		return a.checkFailures(ip, username, password)
+	}
+	for _, c := range a.credentials {
+		if c.matches(username, password) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) checkFailures(ip, username, password string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sources[ip]
+	if !ok {
+		if len(a.sources) >= a.opts.MaxSources {
+			return false
+		}
+		s = &source{}
+		a.sources[ip] = s
+	}
+	s.lastSeen = a.now()
+	if s.accepted != nil {
+		// a device doesn't change its password between logins
+		return s.accepted.matches(username, password)
+	}
+	if s.failures < a.opts.Failures {
+		s.failures++
+		return false
+	}
+	s.accepted = &Credential{Username: username, Password: password}
+	return true
+}
+
+// expire forgets sources that have been idle for a whole window
+func (a *Authenticator) expire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	since := a.now().Add(-a.opts.Window)
+	for ip, s := range a.sources {
+		if s.lastSeen.Before(since) {
+			delete(a.sources, ip)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAuthenticator(t *testing.T, opts Options) *Authenticator {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	a, err := New(ctx, opts)
+	require.NoError(t, err)
+	return a
+}
+
+func TestAcceptAll(t *testing.T) {
+	a := testAuthenticator(t, Options{})
+	require.Equal(t, AcceptAll, a.Policy())
+	require.True(t, a.Check("192.0.2.1", "root", "anything"))
+}
+
+func TestPairs(t *testing.T) {
+	a := testAuthenticator(t, Options{Policy: Pairs, Credentials: []Credential{
+		{Username: "root", Password: "xc3511"},
+		{Username: "admin", Password: Wildcard},
+	}})
+	require.True(t, a.Check("192.0.2.1", "root", "xc3511"))
+	require.False(t, a.Check("192.0.2.1", "root", "root"))
+	require.True(t, a.Check("192.0.2.1", "admin", "whatever"))
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# mirai\nroot:xc3511\n\nsupport:pass:word\r\n"), 0o600))
+	a := testAuthenticator(t, Options{Policy: File, CredentialsFile: path})
+	require.True(t, a.Check("192.0.2.1", "root", "xc3511"))
+	require.True(t, a.Check("192.0.2.1", "support", "pass:word"))
+	require.False(t, a.Check("192.0.2.1", "root", "vizxv"))
+
+	_, err := ReadCredentials(strings.NewReader("root\n"))
+	require.ErrorContains(t, err, "line 1")
+
+	_, err = New(context.Background(), Options{Policy: File, CredentialsFile: filepath.Join(t.TempDir(), "missing")})
+	require.Error(t, err)
+	_, err = New(context.Background(), Options{Policy: "nope"})
+	require.Error(t, err)
+}
+
+func TestAfterFailures(t *testing.T) {
+	a := testAuthenticator(t, Options{Policy: AfterFailures, Failures: 2})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	require.False(t, a.Check("192.0.2.1", "root", "root"))
+	require.False(t, a.Check("192.0.2.1", "root", "admin"))
+	require.True(t, a.Check("192.0.2.1", "root", "xc3511"))
+	// only the accepted credential keeps working
+	require.True(t, a.Check("192.0.2.1", "root", "xc3511"))
+	require.False(t, a.Check("192.0.2.1", "root", "root"))
+	// sources are counted separately
+	require.False(t, a.Check("192.0.2.2", "root", "xc3511"))
+
+	now = now.Add(2 * time.Hour)
+	a.expire()
+	require.Len(t, a.sources, 0)
+	require.False(t, a.Check("192.0.2.1", "root", "xc3511"))
+}
@@ -0,0 +1,69 @@
+package glutton
+
+import (
+	"time"
+
+	"github.com/mushorg/glutton/behavior"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
+	"github.com/mushorg/glutton/signatures"
+
+	"github.com/spf13/viper"
+)
+
+// initBehavior starts the behavioral classification of sources
+func (g *Glutton) initBehavior() {
+	if !viper.GetBool("behavior.enabled") {
+		return
+	}
+	g.behavior = behavior.New(g.ctx, behavior.Options{
+		Window:           time.Duration(viper.GetInt("behavior.window")) * time.Second,
+		SweepPorts:       viper.GetInt("behavior.sweep_ports"),
+		BruteforceLogins: viper.GetInt("behavior.bruteforce_logins"),
+	})
+	if g.Producer != nil {
+		g.Producer.AddEnricher(g.enrichBehavior)
+	}
+}
+
+// observeSession feeds a finished session to the behavior tracker
+func (g *Glutton) observeSession(md connection.Metadata) {
+	if g.behavior == nil || !md.Key.Src.IsValid() {
+		return
+	}
+	g.behavior.ObserveSession(md.Key.Src.Addr().String(), behavior.Session{
+		Port:     md.TargetPort,
+		BytesIn:  md.Stats.BytesIn(),
+		BytesOut: md.Stats.BytesOut(),
+	})
+}
+
+// observeActivity feeds what a handler observed to the behavior tracker
+func (g *Glutton) observeActivity(md connection.Metadata, act profile.Activity) {
+	if g.behavior == nil || !md.Key.Src.IsValid() {
+		return
+	}
+	ip := md.Key.Src.Addr().String()
+	if act.Credential != nil {
+		g.behavior.ObserveLogin(ip)
+	}
+	if act.Sample != "" {
+		g.behavior.ObserveExploit(ip)
+	}
+}
+
+// observeSignatures feeds known exploit payloads to the behavior tracker
+func (g *Glutton) observeSignatures(md connection.Metadata, matches []*signatures.Signature) {
+	if g.behavior == nil || !md.Key.Src.IsValid() || !signatures.Exploit(matches) {
+		return
+	}
+	g.behavior.ObserveExploit(md.Key.Src.Addr().String())
+}
+
+// enrichBehavior labels the event with the behavior of its source
+func (g *Glutton) enrichBehavior(event *producer.Event) {
+	if event.SrcHost != "" {
+		event.Behavior = g.behavior.Label(event.SrcHost)
+	}
+}
@@ -0,0 +1,212 @@
+package behavior
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Behavior labels, most significant first
+const (
+	ExploitDelivery      = "exploit_delivery"
+	CredentialBruteforce = "credential_bruteforce"
+	PortSweep            = "port_sweep"
+	BannerGrab           = "banner_grab"
+)
+
+const (
+	defaultWindow           = 10 * time.Minute
+	defaultSweepPorts       = 5
+	defaultBruteforceLogins = 5
+	defaultMaxSources       = 100000
+)
+
+// Options configure a Tracker
+type Options struct {
+	// Window is how long observations of a source count towards its label
+	Window time.Duration
+	// SweepPorts is the number of distinct ports without payload that make a port sweep
+	SweepPorts int
+	// BruteforceLogins is the number of logins that make a credential brute force
+	BruteforceLogins int
+	// MaxSources bounds the number of tracked sources, new sources are
+	// ignored while the tracker is full
+	MaxSources int
+}
+
+// Session is what a finished session tells about its source
+type Session struct {
+	Port     uint16
+	BytesIn  int64
+	BytesOut int64
+}
+
+type source struct {
+	lastSeen time.Time
+	// ports maps every port touched to the last time it was touched
+	ports map[uint16]time.Time
+	// payloadSeen is the last time the source sent any payload
+	payloadSeen time.Time
+	// bannerSeen is the last time the source read a banner without sending anything
+	bannerSeen  time.Time
+	logins      []time.Time
+	exploitSeen time.Time
+}
+
+// Tracker labels sources by what they did within a sliding window
+type Tracker struct {
+	opts    Options
+	mu      sync.Mutex
+	sources map[string]*source
+	now     func() time.Time
+}
+
+// New creates a tracker that forgets idle sources until ctx is done
+func New(ctx context.Context, opts Options) *Tracker {
+	if opts.Window <= 0 {
+		opts.Window = defaultWindow
+	}
+	if opts.SweepPorts <= 0 {
+		opts.SweepPorts = defaultSweepPorts
+	}
+	if opts.BruteforceLogins <= 0 {
+		opts.BruteforceLogins = defaultBruteforceLogins
+	}
+	if opts.MaxSources <= 0 {
+		opts.MaxSources = defaultMaxSources
+	}
+	t := &Tracker{
+		opts:    opts,
+		sources: make(map[string]*source),
+		now:     time.Now,
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Window / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.expire()
+			}
+		}
+	}()
+	return t
+}
+
+// get returns the state of ip, nil if the tracker is full
+func (t *Tracker) get(ip string) *source {
+	s, ok := t.sources[ip]
+	if !ok {
+		if len(t.sources) >= t.opts.MaxSources {
+			return nil
+		}
+		s = &source{ports: make(map[uint16]time.Time)}
+		t.sources[ip] = s
+	}
+	s.lastSeen = t.now()
+	return s
+}
+
+// ObserveSession records a finished session of ip
+func (t *Tracker) ObserveSession(ip string, session Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(ip)
+	if s == nil {
+		return
+	}
+	s.ports[session.Port] = s.lastSeen
+	switch {
+	case session.BytesIn > 0:
+		s.payloadSeen = s.lastSeen
+	case session.BytesOut > 0:
+		s.bannerSeen = s.lastSeen
+	}
+}
+
+// ObserveLogin records a login attempt of ip
+func (t *Tracker) ObserveLogin(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s := t.get(ip); s != nil {
+		s.logins = append(s.logins, s.lastSeen)
+		// only the last attempts are needed to reach the threshold
+		if len(s.logins) > t.opts.BruteforceLogins {
+			s.logins = s.logins[len(s.logins)-t.opts.BruteforceLogins:]
+		}
+	}
+}
+
+// ObserveExploit records a sample download or a known exploit payload from ip
+func (t *Tracker) ObserveExploit(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s := t.get(ip); s != nil {
+		s.exploitSeen = s.lastSeen
+	}
+}
+
+// Label returns the most significant behavior of ip within the window, or
+// an empty string if it did nothing remarkable
+func (t *Tracker) Label(ip string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sources[ip]
+	if !ok {
+		return ""
+	}
+	since := t.now().Add(-t.opts.Window)
+	if s.exploitSeen.After(since) {
+		return ExploitDelivery
+	}
+	logins := 0
+	for _, at := range s.logins {
+		if at.After(since) {
+			logins++
+		}
+	}
+	if logins >= t.opts.BruteforceLogins {
+		return CredentialBruteforce
+	}
+	if s.payloadSeen.After(since) {
+		return ""
+	}
+	ports := 0
+	for _, at := range s.ports {
+		if at.After(since) {
+			ports++
+		}
+	}
+	if ports >= t.opts.SweepPorts {
+		return PortSweep
+	}
+	if s.bannerSeen.After(since) {
+		return BannerGrab
+	}
+	return ""
+}
+
+// expire forgets sources that have been idle for a whole window
+func (t *Tracker) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	since := t.now().Add(-t.opts.Window)
+	for ip, s := range t.sources {
+		if s.lastSeen.Before(since) {
+			delete(t.sources, ip)
+			continue
+		}
+		for port, at := range s.ports {
+			if at.Before(since) {
+				delete(s.ports, port)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package behavior
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testTracker(t *testing.T) (*Tracker, *time.Time) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	tracker := New(ctx, Options{})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+func TestPortSweep(t *testing.T) {
+	tracker, now := testTracker(t)
+	for port := uint16(1); port <= 4; port++ {
+		tracker.ObserveSession("192.0.2.1", Session{Port: port})
+	}
+	require.Equal(t, "", tracker.Label("192.0.2.1"))
+	tracker.ObserveSession("192.0.2.1", Session{Port: 5})
+	require.Equal(t, PortSweep, tracker.Label("192.0.2.1"))
+
+	// a source sending payloads is not just sweeping
+	tracker.ObserveSession("192.0.2.1", Session{Port: 6, BytesIn: 10})
+	require.Equal(t, "", tracker.Label("192.0.2.1"))
+
+	*now = now.Add(time.Hour)
+	require.Equal(t, "", tracker.Label("192.0.2.1"))
+	tracker.expire()
+	require.Empty(t, tracker.sources)
+}
+
+func TestBannerGrab(t *testing.T) {
+	tracker, _ := testTracker(t)
+	tracker.ObserveSession("192.0.2.1", Session{Port: 21, BytesOut: 20})
+	require.Equal(t, BannerGrab, tracker.Label("192.0.2.1"))
+	require.Equal(t, "", tracker.Label("192.0.2.2"))
+}
+
+func TestCredentialBruteforce(t *testing.T) {
+	tracker, now := testTracker(t)
+	for i := 0; i < 4; i++ {
+		tracker.ObserveLogin("192.0.2.1")
+	}
+	require.Equal(t, "", tracker.Label("192.0.2.1"))
+	tracker.ObserveLogin("192.0.2.1")
+	require.Equal(t, CredentialBruteforce, tracker.Label("192.0.2.1"))
+
+	*now = now.Add(time.Hour)
+	tracker.ObserveLogin("192.0.2.1")
+	require.Equal(t, "", tracker.Label("192.0.2.1"))
+}
+
+func TestExploitDelivery(t *testing.T) {
+	tracker, _ := testTracker(t)
+	for i := 0; i < 5; i++ {
+		tracker.ObserveLogin("192.0.2.1")
+	}
+	tracker.ObserveExploit("192.0.2.1")
+	require.Equal(t, ExploitDelivery, tracker.Label("192.0.2.1"))
+}
+
+func TestMaxSources(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker := New(ctx, Options{MaxSources: 1})
+	tracker.ObserveSession("192.0.2.1", Session{Port: 21, BytesOut: 20})
+	tracker.ObserveSession("192.0.2.2", Session{Port: 21, BytesOut: 20})
+	require.Len(t, tracker.sources, 1)
+	require.Equal(t, "", tracker.Label("192.0.2.2"))
+}
@@ -1,76 +1,127 @@
 package connection
 
 import (
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
 	"net"
-	"strconv"
+	"net/netip"
 	"sync"
 	"time"
 
 	"github.com/mushorg/glutton/rules"
 
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
 )
 
-type CKey [2]uint64
+const (
+	// DefaultMaxSessions bounds the table when no size is configured
+	DefaultMaxSessions = 65536
+	// DefaultIdleTimeout is how long a session may go unseen before it is expired
+	DefaultIdleTimeout = 5 * time.Minute
+)
 
-func newConnKey(clientAddr gopacket.Endpoint, clientPort gopacket.Endpoint) (CKey, error) {
-	if clientAddr.EndpointType() != layers.EndpointIPv4 {
-		return CKey{}, errors.New("clientAddr endpoint must be of type layers.EndpointIPv4")
-	}
+// CKey identifies a flow by its 5-tuple
+type CKey struct {
+	Network string
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+}
 
-	if clientPort.EndpointType() != layers.EndpointTCPPort {
-		return CKey{}, errors.New("clientPort endpoint must be of type layers.EndpointTCPPort")
-	}
+func (ck CKey) String() string {
+	return fmt.Sprintf("%s %s->%s", ck.Network, ck.Src, ck.Dst)
+}
 
-	return CKey{clientAddr.FastHash(), clientPort.FastHash()}, nil
+// NewConnKey creates a flow key. IPv4-mapped IPv6 addresses are unmapped so
+// both notations of the same flow map to the same key.
+func NewConnKey(network string, src, dst netip.AddrPort) CKey {
+	return CKey{
+		Network: network,
+		Src:     netip.AddrPortFrom(src.Addr().Unmap(), src.Port()),
+		Dst:     netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port()),
+	}
 }
 
-func NewConnKeyByString(host, port string) (CKey, error) {
-	clientAddr := layers.NewIPEndpoint(net.ParseIP(host).To4())
-	p, err := strconv.Atoi(port)
+// NewConnKeyFromAddrs creates a flow key from the source and destination addresses
+func NewConnKeyFromAddrs(network string, srcAddr, dstAddr net.Addr) (CKey, error) {
+	if srcAddr == nil || dstAddr == nil {
+		return CKey{}, errors.New("missing flow address")
+	}
+	src, err := netip.ParseAddrPort(srcAddr.String())
+	if err != nil {
+		return CKey{}, fmt.Errorf("invalid source address: %w", err)
+	}
+	dst, err := netip.ParseAddrPort(dstAddr.String())
 	if err != nil {
-		return CKey{}, err
+		return CKey{}, fmt.Errorf("invalid destination address: %w", err)
 	}
-	clientPort := layers.NewTCPPortEndpoint(layers.TCPPort(p))
-	return newConnKey(clientAddr, clientPort)
+	return NewConnKey(network, src, dst), nil
 }
 
+// NewConnKeyFromNetConn creates the flow key of an accepted TCP connection
 func NewConnKeyFromNetConn(conn net.Conn) (CKey, error) {
-	host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
-	return NewConnKeyByString(host, port)
+	return NewConnKeyFromAddrs("tcp", conn.RemoteAddr(), conn.LocalAddr())
 }
 
 type Metadata struct {
+	// ID uniquely identifies the session, also across reused 5-tuples
+	ID         uuid.UUID
+	Key        CKey
 	Added      time.Time
 	Rule       *rules.Rule
 	TargetPort uint16
-	//TargetIP   net.IP
+	// TargetIP is the sensor address the connection was sent to
+	TargetIP net.IP
+	// Stats counts the session traffic, shared by all copies of the metadata
+	Stats *Stats
+	// TLS is set once a ClientHello was seen on the connection
+	TLS *TLS
+}
+
+type session struct {
+	md       Metadata
+	lastSeen time.Time
+	elem     *list.Element
 }
 
+// ConnTable is the registry of active sessions. It is bounded in size, the
+// least recently seen session is evicted first, and sessions that have not
+// been seen for the idle timeout are expired.
 type ConnTable struct {
-	table map[CKey]Metadata
-	mtx   sync.RWMutex
+	table       map[CKey]*session
+	lru         *list.List
+	maxSessions int
+	idleTimeout time.Duration
+	onExpire    func(Metadata)
+	mtx         sync.Mutex
 }
 
-func New(ctx context.Context) *ConnTable {
+func New(ctx context.Context, maxSessions int, idleTimeout time.Duration) *ConnTable {
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
 	ct := &ConnTable{
-		table: make(map[CKey]Metadata, 1024),
+		table:       make(map[CKey]*session, 1024),
+		lru:         list.New(),
+		maxSessions: maxSessions,
+		idleTimeout: idleTimeout,
 	}
-	// every 5 minutes using a ticker, flush the table
 
+	// sessions are closed by their handlers, expiring idle ones only catches
+	// UDP flows and anything a handler failed to close
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
+		ticker := time.NewTicker(idleTimeout / 2)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				ct.FlushOlderThan(5 * time.Minute)
+				ct.FlushOlderThan(idleTimeout)
 			}
 		}
 	}()
@@ -78,62 +129,183 @@ func New(ctx context.Context) *ConnTable {
 	return ct
 }
 
-// RegisterConn a connection in the table
+// RegisterConn registers an accepted TCP connection in the table
 func (t *ConnTable) RegisterConn(conn net.Conn, rule *rules.Rule) (Metadata, error) {
-	srcIP, srcPort, err := net.SplitHostPort(conn.RemoteAddr().String())
+	ck, err := NewConnKeyFromNetConn(conn)
 	if err != nil {
-		return Metadata{}, fmt.Errorf("failed to split remote address: %w", err)
+		return Metadata{}, err
 	}
+	return t.Register(ck, rule)
+}
 
-	_, dstPort, err := net.SplitHostPort(conn.LocalAddr().String())
-	if err != nil {
-		return Metadata{}, fmt.Errorf("failed to split local address: %w", err)
+// Register a session in the table. A TCP 5-tuple can only be reused once the
+// previous connection is gone, so a TCP registration always starts a new
+// session. UDP packets of a flow that is still registered join its session.
+func (t *ConnTable) Register(ck CKey, rule *rules.Rule) (Metadata, error) {
+	md, _, err := t.RegisterFlow(ck, rule)
+	return md, err
+}
+
+// RegisterFlow registers a session like Register and reports whether it
+// started a new session rather than joining a registered UDP flow
+func (t *ConnTable) RegisterFlow(ck CKey, rule *rules.Rule) (Metadata, bool, error) {
+	if !ck.Src.IsValid() || !ck.Dst.IsValid() {
+		return Metadata{}, false, fmt.Errorf("invalid connection key: %s", ck)
 	}
-	port, err := strconv.Atoi(dstPort)
-	if err != nil {
-		return Metadata{}, fmt.Errorf("failed to parse dstPort: %w", err)
+	md, started, evicted := t.register(ck, rule)
+	t.expired(evicted)
+	return md, started, nil
+}
+
+func (t *ConnTable) register(ck CKey, rule *rules.Rule) (Metadata, bool, []Metadata) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := time.Now()
+	if s, ok := t.table[ck]; ok {
+		if ck.Network == "udp" {
+			s.lastSeen = now
+			t.lru.MoveToFront(s.elem)
+			return s.md, false, nil
+		}
+		t.remove(s)
+	}
+
+	s := &session{
+		md: Metadata{
+			ID:         uuid.New(),
+			Key:        ck,
+			Added:      now,
+			TargetPort: ck.Dst.Port(),
+			TargetIP:   net.IP(ck.Dst.Addr().AsSlice()),
+			Rule:       rule,
+			Stats:      &Stats{},
+		},
+		lastSeen: now,
+	}
+	s.elem = t.lru.PushFront(s)
+	t.table[ck] = s
+
+	var evicted []Metadata
+	for t.lru.Len() > t.maxSessions {
+		oldest := t.lru.Back().Value.(*session)
+		t.remove(oldest)
+		evicted = append(evicted, oldest.md)
 	}
-	return t.Register(srcIP, srcPort, uint16(port), rule)
+	return s.md, true, evicted
 }
 
-// Register a connection in the table
-func (t *ConnTable) Register(srcIP, srcPort string, dstPort uint16, rule *rules.Rule) (Metadata, error) {
+// OnExpire sets a function called with every session that is expired or
+// evicted rather than closed
+func (t *ConnTable) OnExpire(fn func(Metadata)) {
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
+	t.onExpire = fn
+}
 
-	ck, err := NewConnKeyByString(srcIP, srcPort)
-	if err != nil {
-		return Metadata{}, err
+// expired passes expired sessions to the OnExpire function, outside the lock
+// so it may use the table
+func (t *ConnTable) expired(mds []Metadata) {
+	if len(mds) == 0 {
+		return
 	}
-	if md, ok := t.table[ck]; ok {
-		return md, nil
+	t.mtx.Lock()
+	fn := t.onExpire
+	t.mtx.Unlock()
+	if fn == nil {
+		return
+	}
+	for _, md := range mds {
+		fn(md)
+	}
+}
+
+func (t *ConnTable) remove(s *session) {
+	t.lru.Remove(s.elem)
+	delete(t.table, s.md.Key)
+}
+
+// Touch marks a session as seen
+func (t *ConnTable) Touch(ck CKey) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if s, ok := t.table[ck]; ok {
+		s.lastSeen = time.Now()
+		t.lru.MoveToFront(s.elem)
+	}
+}
+
+// WrapConn returns a conn that counts its traffic in the session stats and
+// keeps the session from expiring while there is traffic
+func (t *ConnTable) WrapConn(conn net.Conn, md Metadata) net.Conn {
+	return &countingConn{Conn: conn, stats: md.Stats, touch: func() { t.touchSession(md) }}
+}
+
+// touchSession marks a session as seen unless it has been replaced
+func (t *ConnTable) touchSession(md Metadata) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if s, ok := t.table[md.Key]; ok && s.md.ID == md.ID {
+		s.lastSeen = time.Now()
+		t.lru.MoveToFront(s.elem)
 	}
+}
+
+// Close removes a session from the table. A session that has already been
+// replaced by a newer one on the same 5-tuple is left alone.
+func (t *ConnTable) Close(md Metadata) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
 
-	md := Metadata{
-		Added:      time.Now(),
-		TargetPort: dstPort,
-		Rule:       rule,
+	s, ok := t.table[md.Key]
+	if !ok || s.md.ID != md.ID {
+		return false
 	}
-	t.table[ck] = md
-	return md, nil
+	t.remove(s)
+	return true
 }
 
+// FlushOlderThan expires sessions that have not been seen for the given duration
 func (t *ConnTable) FlushOlderThan(s time.Duration) {
+	t.expired(t.flushOlderThan(s))
+}
+
+func (t *ConnTable) flushOlderThan(s time.Duration) []Metadata {
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
 
 	threshold := time.Now().Add(-1 * s)
 
-	for ck, md := range t.table {
-		if md.Added.Before(threshold) {
-			delete(t.table, ck)
+	// the list is ordered by last seen, the oldest sessions are at the back
+	var expired []Metadata
+	for elem := t.lru.Back(); elem != nil; elem = t.lru.Back() {
+		session := elem.Value.(*session)
+		if session.lastSeen.After(threshold) {
+			break
 		}
+		t.remove(session)
+		expired = append(expired, session.md)
 	}
+	return expired
 }
 
-// TODO: what happens when I return a *Metadata and then FlushOlderThan() deletes it?
-func (t *ConnTable) Get(ck CKey) Metadata {
-	t.mtx.RLock()
-	defer t.mtx.RUnlock()
-	return t.table[ck]
+// Get returns a copy of the session metadata
+func (t *ConnTable) Get(ck CKey) (Metadata, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s, ok := t.table[ck]
+	if !ok {
+		return Metadata{}, false
+	}
+	return s.md, true
+}
+
+// Len returns the number of registered sessions
+func (t *ConnTable) Len() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return len(t.table)
 }
@@ -3,6 +3,7 @@ package connection
 import (
 	"context"
 	"net"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -10,7 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var localhost1234Key = CKey([2]uint64{9580489724559085892, 13327978790310486453})
+var localhost1234Key = NewConnKey("tcp", netip.MustParseAddrPort("127.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.1:4321"))
 
 func testTCPConn(t *testing.T) (net.Conn, CKey) {
 	t.Helper()
@@ -29,18 +30,16 @@ func testTCPConn(t *testing.T) (net.Conn, CKey) {
 		_ = conn.Close()
 	})
 
-	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
-	require.NoError(t, err)
-	key, err := NewConnKeyByString(host, port)
-	require.NoError(t, err)
-
+	key := NewConnKey("tcp", netip.MustParseAddrPort(conn.RemoteAddr().String()), netip.MustParseAddrPort(conn.LocalAddr().String()))
 	return conn, key
 }
 
-func TestNewConnKeyByString(t *testing.T) {
-	ck, err := NewConnKeyByString("127.0.0.1", "1234")
-	require.NoError(t, err)
-	require.Equal(t, localhost1234Key, ck)
+func TestNewConnKey(t *testing.T) {
+	mapped := NewConnKey("tcp", netip.MustParseAddrPort("[::ffff:127.0.0.1]:1234"), netip.MustParseAddrPort("[::ffff:10.0.0.1]:4321"))
+	require.Equal(t, localhost1234Key, mapped)
+
+	udp := NewConnKey("udp", localhost1234Key.Src, localhost1234Key.Dst)
+	require.NotEqual(t, localhost1234Key, udp)
 }
 
 func TestNewConnKeyFromNetConn(t *testing.T) {
@@ -51,40 +50,192 @@ func TestNewConnKeyFromNetConn(t *testing.T) {
 }
 
 func TestNewConnTable(t *testing.T) {
-	table := New(context.Background())
+	table := New(context.Background(), 0, 0)
 	require.NotNil(t, table)
 }
 
 func TestRegister(t *testing.T) {
-	table := New(context.Background())
-	targetPort := 4321
-	m1, err := table.Register("127.0.0.1", "1234", uint16(targetPort), &rules.Rule{})
-	require.NoError(t, err)
-	require.NotNil(t, m1)
-	m2 := table.Get(localhost1234Key)
-	require.NotNil(t, m1)
-	require.Equal(t, targetPort, int(m2.TargetPort))
+	table := New(context.Background(), 0, 0)
+	m1, err := table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+	require.NotEqual(t, m1.ID.String(), "00000000-0000-0000-0000-000000000000")
+	m2, ok := table.Get(localhost1234Key)
+	require.True(t, ok)
+	require.Equal(t, 4321, int(m2.TargetPort))
+	require.Equal(t, "10.0.0.1", m2.TargetIP.String())
 	require.Equal(t, m1, m2)
+
+	// a reused TCP 5-tuple is a new session
+	m3, err := table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+	require.NotEqual(t, m1.ID, m3.ID)
+	require.Equal(t, 1, table.Len())
+
+	_, err = table.Register(CKey{Network: "tcp"}, &rules.Rule{})
+	require.Error(t, err)
+}
+
+func TestRegisterUDP(t *testing.T) {
+	table := New(context.Background(), 0, 0)
+	udpKey := NewConnKey("udp", localhost1234Key.Src, localhost1234Key.Dst)
+	m1, err := table.Register(udpKey, &rules.Rule{Target: "udp"})
+	require.NoError(t, err)
+	m2, err := table.Register(udpKey, &rules.Rule{Target: "udp"})
+	require.NoError(t, err)
+	require.Equal(t, m1.ID, m2.ID)
+
+	// the same ports over TCP don't collide with the UDP flow
+	m3, err := table.Register(localhost1234Key, &rules.Rule{Target: "tcp"})
+	require.NoError(t, err)
+	require.NotEqual(t, m1.ID, m3.ID)
+	require.Equal(t, 2, table.Len())
 }
 
 func TestRegisterConn(t *testing.T) {
 	conn, ck := testTCPConn(t)
-	table := New(context.Background())
+	table := New(context.Background(), 0, 0)
 	md, err := table.RegisterConn(conn, &rules.Rule{Target: "tcp"})
 	require.NoError(t, err)
-	require.NotNil(t, md)
-	m := table.Get(ck)
-	require.NotNil(t, m)
+	require.Equal(t, ck, md.Key)
+	m, ok := table.Get(ck)
+	require.True(t, ok)
 	require.Equal(t, "tcp", m.Rule.Target)
+	require.Equal(t, "127.0.0.1", m.TargetIP.String())
+}
+
+func TestClose(t *testing.T) {
+	table := New(context.Background(), 0, 0)
+	stale, err := table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+	md, err := table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+
+	// closing the replaced session keeps the current one
+	require.False(t, table.Close(stale))
+	_, ok := table.Get(localhost1234Key)
+	require.True(t, ok)
+
+	require.True(t, table.Close(md))
+	_, ok = table.Get(localhost1234Key)
+	require.False(t, ok)
+	require.False(t, table.Close(md))
+}
+
+func TestMaxSessions(t *testing.T) {
+	table := New(context.Background(), 2, 0)
+	keys := []CKey{
+		NewConnKey("tcp", netip.MustParseAddrPort("127.0.0.1:1"), localhost1234Key.Dst),
+		NewConnKey("tcp", netip.MustParseAddrPort("127.0.0.1:2"), localhost1234Key.Dst),
+		NewConnKey("tcp", netip.MustParseAddrPort("127.0.0.1:3"), localhost1234Key.Dst),
+	}
+	for i, ck := range keys {
+		_, err := table.Register(ck, &rules.Rule{})
+		require.NoError(t, err)
+		if i == 1 {
+			// the first session is now the most recently seen one
+			table.Touch(keys[0])
+		}
+	}
+	require.Equal(t, 2, table.Len())
+	_, ok := table.Get(keys[0])
+	require.True(t, ok)
+	_, ok = table.Get(keys[1])
+	require.False(t, ok)
+	_, ok = table.Get(keys[2])
+	require.True(t, ok)
 }
 
 func TestFlushOlderThan(t *testing.T) {
-	table := New(context.Background())
-	targetPort := 4321
-	md, err := table.Register("127.0.0.1", "1234", uint16(targetPort), &rules.Rule{})
+	table := New(context.Background(), 0, 0)
+	md, err := table.Register(localhost1234Key, &rules.Rule{})
 	require.NoError(t, err)
 	require.NotNil(t, md)
+	table.FlushOlderThan(time.Hour)
+	_, ok := table.Get(localhost1234Key)
+	require.True(t, ok)
 	table.FlushOlderThan(time.Duration(0))
-	m := table.Get(localhost1234Key)
-	require.Empty(t, m)
+	_, ok = table.Get(localhost1234Key)
+	require.False(t, ok)
+}
+
+func TestOnExpire(t *testing.T) {
+	table := New(context.Background(), 1, 0)
+	var expired []Metadata
+	table.OnExpire(func(md Metadata) {
+		expired = append(expired, md)
+	})
+
+	udpKey := NewConnKey("udp", netip.MustParseAddrPort("192.0.2.1:5353"), netip.MustParseAddrPort("198.51.100.1:53"))
+	first, started, err := table.RegisterFlow(udpKey, &rules.Rule{})
+	require.NoError(t, err)
+	require.True(t, started)
+	md, started, err := table.RegisterFlow(udpKey, &rules.Rule{})
+	require.NoError(t, err)
+	require.False(t, started, "packets of a registered flow join its session")
+	require.Equal(t, first.ID, md.ID)
+
+	_, err = table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+	require.Len(t, expired, 1, "the flow is evicted from the full table")
+	require.Equal(t, first.ID, expired[0].ID)
+
+	table.FlushOlderThan(0)
+	require.Len(t, expired, 2)
+	require.Equal(t, localhost1234Key, expired[1].Key)
+	require.Zero(t, table.Len())
+}
+
+func TestConnTableWrapConn(t *testing.T) {
+	table := New(context.Background(), 0, 0)
+	md, err := table.Register(localhost1234Key, &rules.Rule{})
+	require.NoError(t, err)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := table.WrapConn(server, md)
+	defer conn.Close()
+	go func() {
+		_, _ = client.Write([]byte("hello"))
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Read(make([]byte, 5))
+	require.NoError(t, err)
+	table.FlushOlderThan(10 * time.Millisecond)
+	_, ok := table.Get(localhost1234Key)
+	require.True(t, ok, "session with traffic should not expire")
+	require.Equal(t, int64(5), md.Stats.BytesIn())
+
+	time.Sleep(20 * time.Millisecond)
+	table.FlushOlderThan(10 * time.Millisecond)
+	_, ok = table.Get(localhost1234Key)
+	require.False(t, ok, "idle session should expire")
+}
+
+func TestWrapConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	stats := &Stats{}
+	conn := WrapConn(server, stats)
+	defer conn.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("hello"))
+		_, _ = client.Read(make([]byte, 3))
+	}()
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	_, err = conn.Write([]byte("hi!"))
+	require.NoError(t, err)
+	stats.AddInteraction()
+
+	require.Equal(t, int64(5), stats.BytesIn())
+	require.Equal(t, int64(3), stats.BytesOut())
+	require.Equal(t, int64(1), stats.Interactions())
+
+	var nilStats *Stats
+	nilStats.AddIn(1)
+	require.Zero(t, nilStats.BytesIn())
 }
@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the traffic counters of a session. They are shared by all copies
+// of the session metadata, a nil Stats ignores updates.
+type Stats struct {
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	interactions atomic.Int64
+}
+
+// AddIn counts bytes received from the peer
+func (s *Stats) AddIn(n int) {
+	if s != nil && n > 0 {
+		s.bytesIn.Add(int64(n))
+	}
+}
+
+// AddOut counts bytes sent to the peer
+func (s *Stats) AddOut(n int) {
+	if s != nil && n > 0 {
+		s.bytesOut.Add(int64(n))
+	}
+}
+
+// AddInteraction counts an event produced by the session handler
+func (s *Stats) AddInteraction() {
+	if s != nil {
+		s.interactions.Add(1)
+	}
+}
+
+func (s *Stats) BytesIn() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.bytesIn.Load()
+}
+
+func (s *Stats) BytesOut() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.bytesOut.Load()
+}
+
+func (s *Stats) Interactions() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.interactions.Load()
+}
+
+// touchInterval limits how often traffic marks a session as seen
+const touchInterval = time.Second
+
+// countingConn updates the session stats on every read and write
+type countingConn struct {
+	net.Conn
+	stats *Stats
+	// touch, if set, marks the session as seen, at most every touchInterval
+	touch     func()
+	lastTouch atomic.Int64
+}
+
+// WrapConn returns a conn that counts its traffic in stats
+func WrapConn(conn net.Conn, stats *Stats) net.Conn {
+	return &countingConn{Conn: conn, stats: stats}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.AddIn(n)
+	c.seen(n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.AddOut(n)
+	c.seen(n)
+	return n, err
+}
+
+func (c *countingConn) seen(n int) {
+	if c.touch == nil || n <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := c.lastTouch.Load()
+	if now-last < int64(touchInterval) || !c.lastTouch.CompareAndSwap(last, now) {
+		return
+	}
+	c.touch()
+}
+
+// CloseWrite half-closes the underlying conn, conns without half-close
+// support stop accepting writes instead
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.SetWriteDeadline(time.Now())
+}
+
+// CloseRead half-closes the underlying conn, conns without half-close
+// support stop accepting reads instead
+func (c *countingConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return c.Conn.SetReadDeadline(time.Now())
+}
+
+// Unwrap returns the underlying conn
+func (c *countingConn) Unwrap() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,19 @@
+package connection
+
+// TLS describes the TLS session of a connection, from the ClientHello the
+// client sent and the handshake that followed if Glutton terminated TLS
+type TLS struct {
+	// ServerName is the SNI the client asked for
+	ServerName string `json:"sni,omitempty"`
+	// ALPN lists the application protocols the client offered
+	ALPN []string `json:"alpn,omitempty"`
+	// CipherSuites lists the cipher suites the client offered, in its order
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+	// JA3 and JA4 fingerprint the ClientHello
+	JA3 string `json:"ja3,omitempty"`
+	JA4 string `json:"ja4,omitempty"`
+	// Version and CipherSuite were negotiated, empty if TLS wasn't terminated
+	// or the handshake failed
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+}
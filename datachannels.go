@@ -0,0 +1,161 @@
+package glutton
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+
+	"github.com/spf13/viper"
+)
+
+// dataKey identifies an expected data connection by its source and the
+// sensor port it's sent to
+type dataKey struct {
+	src  netip.Addr
+	port uint16
+}
+
+// dataListener accepts the data connections of a session, which reach the
+// TPROXY listener like any other connection
+type dataListener struct {
+	g        *Glutton
+	md       connection.Metadata
+	key      dataKey
+	addr     *net.TCPAddr
+	conns    chan net.Conn
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// ListenData reserves a port on the sensor address of a session for a data
+// connection of its source, like a passive FTP transfer. The port is
+// released when the listener is closed, so handlers must close it.
+func (g *Glutton) ListenData(md connection.Metadata) (net.Listener, error) {
+	if !md.Key.Src.IsValid() || md.TargetIP == nil {
+		return nil, errors.New("session has no addresses")
+	}
+	ports := viper.GetIntSlice("data_channels.ports")
+	if len(ports) != 2 || ports[0] <= 0 || ports[1] < ports[0] || ports[1] > 65535 {
+		return nil, fmt.Errorf("invalid data channel port range %v", ports)
+	}
+
+	g.dataMu.Lock()
+	defer g.dataMu.Unlock()
+	if g.dataListeners == nil {
+		g.dataListeners = make(map[dataKey]*dataListener)
+	}
+	src := md.Key.Src.Addr().Unmap()
+	for range 32 {
+		port := ports[0] + rand.IntN(ports[1]-ports[0]+1)
+		key := dataKey{src: src, port: uint16(port)}
+		if g.dataListeners[key] != nil {
+			continue
+		}
+		l := &dataListener{
+			g:     g,
+			md:    md,
+			key:   key,
+			addr:  &net.TCPAddr{IP: md.TargetIP, Port: port},
+			conns: make(chan net.Conn, 1),
+			done:  make(chan struct{}),
+		}
+		g.dataListeners[key] = l
+		return l, nil
+	}
+	return nil, errors.New("no free data channel port")
+}
+
+// acceptData hands a connection to the data listener expecting it and
+// reports whether there was one
+func (g *Glutton) acceptData(conn net.Conn) bool {
+	local, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return false
+	}
+	remote, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	g.dataMu.Lock()
+	l := g.dataListeners[dataKey{src: remote.Addr().Unmap(), port: local.Port()}]
+	g.dataMu.Unlock()
+	if l == nil {
+		return false
+	}
+	select {
+	case l.conns <- conn:
+	default:
+		// a transfer is already waiting to be accepted
+		conn.Close()
+	}
+	return true
+}
+
+// Accept waits for the data connection until the deadline or the listener
+// is closed
+func (l *dataListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	l.mu.Lock()
+	deadline := l.deadline
+	l.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case conn := <-l.conns:
+		if l.g.connTable != nil {
+			// transfers keep the session they belong to alive
+			conn = l.g.connTable.WrapConn(conn, l.md)
+		}
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// SetDeadline sets when Accept gives up, like net.TCPListener
+func (l *dataListener) SetDeadline(t time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadline = t
+	return nil
+}
+
+// Close releases the port, a connection accepted meanwhile is closed
+func (l *dataListener) Close() error {
+	l.once.Do(func() {
+		l.g.dataMu.Lock()
+		delete(l.g.dataListeners, l.key)
+		l.g.dataMu.Unlock()
+		close(l.done)
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+		}
+	})
+	return nil
+}
+
+// Addr returns the sensor address and port the client connects to
+func (l *dataListener) Addr() net.Addr {
+	return l.addr
+}
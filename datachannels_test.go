@@ -0,0 +1,69 @@
+package glutton
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+// addrConn is a conn with the addresses of a TPROXY connection
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestListenData(t *testing.T) {
+	viper.Set("data_channels.ports", []int{50000, 50000})
+	defer viper.Set("data_channels.ports", nil)
+	g := &Glutton{}
+	md := connection.Metadata{
+		Key:      connection.NewConnKey("tcp", netip.MustParseAddrPort("192.0.2.1:40000"), netip.MustParseAddrPort("198.51.100.1:21")),
+		TargetIP: net.ParseIP("198.51.100.1"),
+	}
+
+	l, err := g.ListenData(md)
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.1:50000", l.Addr().String())
+	_, err = g.ListenData(md)
+	require.Error(t, err, "the only port is taken")
+
+	dataConn := func(src string) net.Conn {
+		c, _ := net.Pipe()
+		return addrConn{
+			Conn:   c,
+			local:  net.TCPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:50000")),
+			remote: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(src)),
+		}
+	}
+	require.False(t, g.acceptData(dataConn("192.0.2.2:40001")), "other sources are dispatched as usual")
+	conn := dataConn("192.0.2.1:40001")
+	require.True(t, g.acceptData(conn))
+	accepted, err := l.Accept()
+	require.NoError(t, err)
+	require.Equal(t, conn, accepted)
+
+	dl := l.(interface{ SetDeadline(time.Time) error })
+	require.NoError(t, dl.SetDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = l.Accept()
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	require.NoError(t, l.Close())
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.False(t, g.acceptData(dataConn("192.0.2.1:40002")))
+
+	l, err = g.ListenData(md)
+	require.NoError(t, err, "closing released the port")
+	l.Close()
+}
@@ -0,0 +1,133 @@
+package glutton
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/downloader"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
+
+	"github.com/spf13/viper"
+)
+
+// Download statuses
+const (
+	downloadOK       = "ok"
+	downloadBlocked  = "blocked"
+	downloadTooLarge = "too_large"
+	downloadFailed   = "failed"
+	downloadDropped  = "dropped"
+)
+
+// initDownloader sets up fetching the samples referenced in payloads
+func (g *Glutton) initDownloader() error {
+	if !viper.GetBool("downloads.enabled") {
+		return nil
+	}
+	var err error
+	g.downloader, err = downloader.New(downloader.Options{
+		MaxSize:      viper.GetInt64("downloads.max_size") << 20,
+		Timeout:      time.Duration(viper.GetInt("downloads.timeout")) * time.Second,
+		Proxy:        viper.GetString("downloads.proxy"),
+		AllowPrivate: viper.GetBool("downloads.allow_private"),
+		UserAgent:    viper.GetString("downloads.user_agent"),
+	})
+	if err != nil {
+		return err
+	}
+	g.downloadSlots = make(chan struct{}, max(viper.GetInt("downloads.concurrency"), 1))
+	g.recentDownloads = make(map[string]time.Time)
+	return nil
+}
+
+// FetchSamples downloads the samples a payload references, like the targets
+// of wget, curl, tftp and ftpget commands, in the background. Each attempt
+// is produced as a download event of the session.
+func (g *Glutton) FetchSamples(md connection.Metadata, handler string, payload []byte) {
+	if g.downloader == nil {
+		return
+	}
+	for _, url := range downloader.ExtractURLs(payload) {
+		if !g.claimDownload(url) {
+			continue
+		}
+		select {
+		case g.downloadSlots <- struct{}{}:
+			go func() {
+				defer func() { <-g.downloadSlots }()
+				g.fetchSample(md, handler, url)
+			}()
+		default:
+			g.produceDownload(handler, md, &producer.Download{URL: url, Status: downloadDropped, Error: "too many concurrent downloads"})
+		}
+	}
+}
+
+// claimDownload reports whether url wasn't fetched recently, so a bot
+// spreading the same dropper doesn't make us fetch it over and over
+func (g *Glutton) claimDownload(url string) bool {
+	window := time.Duration(viper.GetInt("downloads.dedupe_window")) * time.Second
+	now := time.Now()
+	g.downloadsMu.Lock()
+	defer g.downloadsMu.Unlock()
+	for u, fetched := range g.recentDownloads {
+		if now.Sub(fetched) >= window {
+			delete(g.recentDownloads, u)
+		}
+	}
+	if _, ok := g.recentDownloads[url]; ok {
+		return false
+	}
+	g.recentDownloads[url] = now
+	return true
+}
+
+func (g *Glutton) fetchSample(md connection.Metadata, handler, url string) {
+	logger := g.Logger.With(slog.String("url", url), slog.String("handler", handler), slog.String("session", md.ID.String()))
+	start := time.Now()
+	data, err := g.downloader.Fetch(g.ctx, url)
+	download := &producer.Download{URL: url, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		download.Status = downloadStatus(err)
+		download.Error = err.Error()
+		logger.Info("Failed to fetch sample", producer.ErrAttr(err), slog.String("status", download.Status))
+		g.produceDownload(handler, md, download)
+		return
+	}
+
+	download.Status = downloadOK
+	download.Size = int64(len(data))
+	meta, opts, err := g.storeArtifact(md, data, artifact.Info{Kind: artifact.KindSample, Handler: handler, URL: url})
+	if err != nil {
+		logger.Error("Failed to store sample", producer.ErrAttr(err))
+	} else {
+		download.SHA256 = meta.SHA256
+		g.RecordActivity(md, profile.Activity{Handler: handler, Sample: meta.SHA256})
+		logger.Info("New sample fetched", slog.String("sample_hash", meta.SHA256), slog.Int64("size", download.Size))
+	}
+	g.produceDownload(handler, md, download, opts...)
+}
+
+func downloadStatus(err error) string {
+	switch {
+	case errors.Is(err, downloader.ErrBlocked):
+		return downloadBlocked
+	case errors.Is(err, downloader.ErrTooLarge):
+		return downloadTooLarge
+	default:
+		return downloadFailed
+	}
+}
+
+func (g *Glutton) produceDownload(handler string, md connection.Metadata, download *producer.Download, opts ...producer.EventOption) {
+	if g.Producer == nil {
+		return
+	}
+	if err := g.Producer.LogDownload(handler, md, download, opts...); err != nil {
+		g.Logger.Error("Failed to produce download", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+	}
+}
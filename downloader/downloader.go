@@ -0,0 +1,282 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxSize   = 10 << 20
+	defaultTimeout   = 30 * time.Second
+	defaultUserAgent = "Wget/1.21.2"
+	maxRedirects     = 5
+)
+
+var (
+	// ErrBlocked is returned for URLs resolving to private, loopback or other
+	// non-public addresses
+	ErrBlocked = errors.New("destination address is not public")
+	// ErrTooLarge is returned for downloads bigger than MaxSize
+	ErrTooLarge = errors.New("download exceeds the maximum size")
+	// ErrUnsupported is returned for URL schemes that can't be fetched
+	ErrUnsupported = errors.New("unsupported URL")
+)
+
+// nonPublic are special-purpose ranges not covered by the netip.Addr checks
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),  // shared address space
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, may embed private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("2002::/16"),      // 6to4, may embed private IPv4
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+	netip.MustParsePrefix("192.88.99.0/24"), // 6to4 relay anycast
+	netip.MustParsePrefix("255.255.255.255/32"),
+}
+
+// Options configure a Downloader
+type Options struct {
+	// MaxSize is the biggest download kept, bigger ones are aborted
+	MaxSize int64
+	// Timeout bounds a whole download, including redirects
+	Timeout time.Duration
+	// Proxy is the URL of an HTTP proxy all downloads go through, tftp
+	// downloads are refused when set
+	Proxy string
+	// AllowPrivate allows downloads from non-public addresses
+	AllowPrivate bool
+	UserAgent    string
+}
+
+// Downloader fetches samples over http, https, ftp and tftp
+type Downloader struct {
+	opts   Options
+	proxy  *url.URL
+	dialer *net.Dialer
+	client *http.Client
+}
+
+// New returns a Downloader
+func New(opts Options) (*Downloader, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = defaultUserAgent
+	}
+	d := &Downloader{opts: opts, dialer: &net.Dialer{}}
+	if !opts.AllowPrivate {
+		// checked again on connect, the name may resolve differently by then
+		d.dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if blocked(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrBlocked, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		DialContext:         d.dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableKeepAlives:   true,
+	}
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download proxy: %w", err)
+		}
+		if proxy.Scheme != "http" || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid download proxy %q, must be an http:// URL", opts.Proxy)
+		}
+		d.proxy = proxy
+		transport.Proxy = http.ProxyURL(proxy)
+		// the proxy itself is usually on a private network
+		transport.DialContext = (&net.Dialer{}).DialContext
+	}
+	d.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return d.check(req.Context(), req.URL.Hostname())
+		},
+	}
+	return d, nil
+}
+
+// blocked reports whether ip is not a public unicast address
+func blocked(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return true
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the addresses of host, failing if any of them is blocked
+func (d *Downloader) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !d.opts.AllowPrivate && blocked(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrBlocked, ip)
+		}
+		return []netip.Addr{ip}, nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !d.opts.AllowPrivate && blocked(ip) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrBlocked, host, ip)
+		}
+	}
+	return ips, nil
+}
+
+// check fails if host resolves to a blocked address, also when the proxy
+// does the resolving
+func (d *Downloader) check(ctx context.Context, host string) error {
+	_, err := d.resolve(ctx, host)
+	return err
+}
+
+// Fetch downloads rawURL and returns its content
+func (d *Downloader) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrUnsupported)
+	}
+	switch {
+	case u.Scheme == "tftp" && d.proxy != nil:
+		return nil, fmt.Errorf("%w: tftp can't go through the proxy", ErrUnsupported)
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp" && u.Scheme != "tftp":
+		return nil, fmt.Errorf("%w: scheme %q", ErrUnsupported, u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+	if err := d.check(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ftp":
+		return d.fetchFTP(ctx, u)
+	case "tftp":
+		return d.fetchTFTP(ctx, u)
+	default:
+		return d.fetchHTTP(ctx, u)
+	}
+}
+
+func (d *Downloader) fetchHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", d.opts.UserAgent)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if resp.ContentLength > d.opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+	return d.read(resp.Body)
+}
+
+// read reads r to the end, failing beyond MaxSize
+func (d *Downloader) read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, d.opts.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > d.opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty download")
+	}
+	return data, nil
+}
+
+// dial connects to addr directly, or through the proxy with CONNECT
+func (d *Downloader) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if d.proxy == nil {
+		return d.dialer.DialContext(ctx, "tcp", addr)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.Join(err, conn.Close())
+		}
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Join(fmt.Errorf("proxy refused to connect to %s: %s", addr, resp.Status), conn.Close())
+	}
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+// bufferedConn reads what was buffered while reading the CONNECT response first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,192 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bins.sh":
+			// chunked, without a Content-Length
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "#!/bin/sh\n")
+		case "/redirect":
+			http.Redirect(w, r, "/bins.sh", http.StatusFound)
+		case "/big":
+			fmt.Fprint(w, strings.Repeat("A", 64))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	d, err := New(Options{AllowPrivate: true, MaxSize: 32})
+	require.NoError(t, err)
+	data, err := d.Fetch(context.Background(), server.URL+"/bins.sh")
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\n", string(data))
+	data, err = d.Fetch(context.Background(), server.URL+"/redirect")
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\n", string(data))
+
+	_, err = d.Fetch(context.Background(), server.URL+"/big")
+	require.ErrorIs(t, err, ErrTooLarge)
+	_, err = d.Fetch(context.Background(), server.URL+"/missing")
+	require.ErrorContains(t, err, "404")
+	_, err = d.Fetch(context.Background(), "gopher://example.com/x")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestBlocked(t *testing.T) {
+	d, err := New(Options{})
+	require.NoError(t, err)
+	for _, u := range []string{
+		"http://127.0.0.1/x",
+		"http://10.0.0.1/x",
+		"http://169.254.169.254/latest/meta-data/",
+		"ftp://[::1]/x",
+		"tftp://192.168.1.1/x",
+		"http://100.64.0.1/x",
+		"http://[::ffff:127.0.0.1]/x",
+	} {
+		_, err := d.Fetch(context.Background(), u)
+		require.ErrorIs(t, err, ErrBlocked, u)
+	}
+	require.False(t, blocked(netip.MustParseAddr("198.51.100.1")))
+}
+
+// serveFTP is a single-session FTP server handing out content in passive mode
+func serveFTP(t *testing.T, content string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dataLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+		dataLn.Close()
+	})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "220 ready\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch cmd {
+			case "USER":
+				fmt.Fprint(conn, "331 password please\r\n")
+			case "PASS":
+				fmt.Fprint(conn, "230 logged in\r\n")
+			case "TYPE":
+				fmt.Fprint(conn, "200 binary\r\n")
+			case "EPSV":
+				fmt.Fprint(conn, "500 unknown command\r\n")
+			case "PASV":
+				port := dataLn.Addr().(*net.TCPAddr).Port
+				// points elsewhere, the client must ignore the address
+				fmt.Fprintf(conn, "227 Entering Passive Mode (10,9,8,7,%d,%d)\r\n", port>>8, port&0xff)
+			case "RETR":
+				if arg != "pub/bot.sh" {
+					fmt.Fprint(conn, "550 not found\r\n")
+					continue
+				}
+				data, err := dataLn.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Fprint(conn, "150 sending\r\n")
+				fmt.Fprint(data, content)
+				data.Close()
+				fmt.Fprint(conn, "226 done\r\n")
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestFetchFTP(t *testing.T) {
+	addr := serveFTP(t, "#!/bin/sh\necho bot\n")
+	d, err := New(Options{AllowPrivate: true})
+	require.NoError(t, err)
+	data, err := d.Fetch(context.Background(), "ftp://user:pass@"+addr+"/pub/bot.sh")
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\necho bot\n", string(data))
+}
+
+func TestFetchTFTP(t *testing.T) {
+	content := []byte(strings.Repeat("B", 600))
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 516)
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil || !strings.HasPrefix(string(buf[2:n]), "mirai.arm\x00octet\x00") {
+			return
+		}
+		// answer from a new port, as servers do
+		transfer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer transfer.Close()
+		for block := 1; (block-1)*512 <= len(content); block++ {
+			packet := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, tftpOpData), uint16(block))
+			packet = append(packet, content[(block-1)*512:min(block*512, len(content))]...)
+			if _, err := transfer.WriteToUDP(packet, client); err != nil {
+				return
+			}
+			if _, _, err := transfer.ReadFromUDP(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	d, err := New(Options{AllowPrivate: true})
+	require.NoError(t, err)
+	data, err := d.Fetch(context.Background(), "tftp://"+conn.LocalAddr().String()+"/mirai.arm")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy sees the absolute URL
+		fmt.Fprintf(w, "via proxy: %s", r.URL)
+	}))
+	defer proxy.Close()
+
+	// the proxy may be private, the destination may not
+	d, err := New(Options{Proxy: proxy.URL})
+	require.NoError(t, err)
+	data, err := d.Fetch(context.Background(), "http://198.51.100.1/bins.sh")
+	require.NoError(t, err)
+	require.Equal(t, "via proxy: http://198.51.100.1/bins.sh", string(data))
+	_, err = d.Fetch(context.Background(), "http://10.0.0.1/bins.sh")
+	require.ErrorIs(t, err, ErrBlocked)
+	_, err = d.Fetch(context.Background(), "tftp://198.51.100.1/bins.sh")
+	require.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = New(Options{Proxy: "socks5://127.0.0.1:1080"})
+	require.Error(t, err)
+}
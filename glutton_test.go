@@ -4,6 +4,9 @@ import (
 	"context"
 	"testing"
 
+	"github.com/google/uuid"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/signatures"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
@@ -17,4 +20,18 @@ func TestNewGlutton(t *testing.T) {
 	g, err := New(context.Background())
 	require.NoError(t, err, "error initializing glutton")
 	require.NotNil(t, g, "nil instance but no error")
-}
\ No newline at end of file
+}
+
+func TestMatchSignatures(t *testing.T) {
+	engine, err := LoadSignatures("config/signatures.yaml")
+	require.NoError(t, err)
+	g := &Glutton{signatures: engine, matches: make(map[uuid.UUID][]*signatures.Signature)}
+	md := connection.Metadata{ID: uuid.New()}
+
+	// the handler matches the whole request, the event payload is the body
+	matches := g.MatchSignatures(md, "http", []byte("GET /vpn/../vpns/cfg/smb.conf HTTP/1.1\r\n\r\n"))
+	require.NotEmpty(t, matches)
+	require.NoError(t, g.ProduceTCP("http", nil, md, nil, nil))
+	_, ok := g.takeMatches(md)
+	require.False(t, ok, "the event took the matches")
+}
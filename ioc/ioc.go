@@ -0,0 +1,178 @@
+package ioc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mushorg/glutton/downloader"
+)
+
+const (
+	// maxInput caps how much of a payload is searched
+	maxInput = 1 << 20
+	// maxPerKind caps the indicators of each kind
+	maxPerKind = 32
+	// minHexRun is the fewest escaped bytes in a row considered a dropper chunk
+	minHexRun = 4
+)
+
+var (
+	urlPattern    = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp|ldaps?|rmi|dns|iiop|corba|nis|nds)://[^\s'"<>|` + "`" + `(){}\\]+`)
+	ipPattern     = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	domainPattern = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,24}\b`)
+	hexRun        = regexp.MustCompile(`(?:\\x[0-9a-fA-F]{2})+`)
+	// base64Token is long enough to skip most identifiers
+	base64Token = regexp.MustCompile(`[A-Za-z0-9+/]{40,}={0,2}`)
+	// commandHints in decoded base64 mark it as a dropper rather than any
+	// base64 blob like a cookie
+	commandHints = []string{"http", "wget", "curl", "tftp", "/bin/", "/tmp/", "chmod", "busybox", "powershell", "bash", "sh -c", "nc "}
+)
+
+// Dropper is an encoded blob in a payload, like a binary written out with
+// echo -ne '\x7f\x45...' or a script piped through base64 -d
+type Dropper struct {
+	// Encoding is base64 or hex
+	Encoding string `json:"encoding"`
+	SHA256   string `json:"sha256"`
+	Size     int    `json:"size"`
+	MIMEType string `json:"mimeType"`
+}
+
+// IOCs are the indicators of compromise found in a payload
+type IOCs struct {
+	URLs     []string  `json:"urls,omitempty"`
+	IPs      []string  `json:"ips,omitempty"`
+	Domains  []string  `json:"domains,omitempty"`
+	Droppers []Dropper `json:"droppers,omitempty"`
+}
+
+// Empty reports whether no indicator was found
+func (i *IOCs) Empty() bool {
+	return i == nil || len(i.URLs) == 0 && len(i.IPs) == 0 && len(i.Domains) == 0 && len(i.Droppers) == 0
+}
+
+// Extract returns the URLs, IPs, domains and encoded droppers in payload.
+// Indicators in decoded droppers are included. It returns nil if there are
+// none.
+func Extract(payload []byte) *IOCs {
+	if len(payload) > maxInput {
+		payload = payload[:maxInput]
+	}
+	iocs := &IOCs{}
+	iocs.extract(payload)
+	iocs.extractDroppers(payload)
+	if iocs.Empty() {
+		return nil
+	}
+	return iocs
+}
+
+func (i *IOCs) extract(payload []byte) {
+	text := string(payload)
+	// the download extractor also knows commands like tftp host get file
+	for _, u := range append(urlPattern.FindAllString(text, -1), downloader.ExtractURLs(payload)...) {
+		u = strings.TrimRight(u, ".,:;")
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		i.URLs = appendUnique(i.URLs, u)
+		i.addHost(parsed.Hostname())
+	}
+	for _, ip := range ipPattern.FindAllString(text, -1) {
+		i.addHost(ip)
+	}
+	for _, domain := range domainPattern.FindAllString(text, -1) {
+		if knownTLD(domain) {
+			i.addHost(domain)
+		}
+	}
+}
+
+// addHost records an IP or domain
+func (i *IOCs) addHost(host string) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.IsGlobalUnicast() || ip.IsPrivate() {
+			i.IPs = appendUnique(i.IPs, ip.String())
+		}
+		return
+	}
+	if strings.Contains(host, ".") && !ipPattern.MatchString(host) {
+		i.Domains = appendUnique(i.Domains, strings.ToLower(host))
+	}
+}
+
+func (i *IOCs) extractDroppers(payload []byte) {
+	// echo loaders write a binary in chunks appended to the same file
+	var chunks []byte
+	for _, run := range hexRun.FindAll(payload, -1) {
+		if len(run)/4 < minHexRun {
+			continue
+		}
+		decoded, err := hex.DecodeString(strings.ReplaceAll(string(run), `\x`, ""))
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, decoded...)
+	}
+	if len(chunks) > 0 {
+		i.addDropper("hex", chunks)
+	}
+
+	for _, token := range base64Token.FindAll(payload, -1) {
+		decoded, err := base64.StdEncoding.DecodeString(string(token))
+		if err != nil {
+			continue
+		}
+		if !bytes.HasPrefix(decoded, []byte("\x7fELF")) && !hasCommand(decoded) {
+			continue
+		}
+		i.addDropper("base64", decoded)
+	}
+}
+
+func (i *IOCs) addDropper(encoding string, data []byte) {
+	if len(i.Droppers) >= maxPerKind {
+		return
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	for _, d := range i.Droppers {
+		if d.SHA256 == hash {
+			return
+		}
+	}
+	i.Droppers = append(i.Droppers, Dropper{
+		Encoding: encoding,
+		SHA256:   hash,
+		Size:     len(data),
+		MIMEType: http.DetectContentType(data),
+	})
+	// the decoded script usually holds the actual download URLs
+	i.extract(data)
+}
+
+func hasCommand(data []byte) bool {
+	lower := bytes.ToLower(data)
+	for _, hint := range commandHints {
+		if bytes.Contains(lower, []byte(hint)) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(s []string, v string) []string {
+	if len(s) >= maxPerKind || slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
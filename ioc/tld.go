@@ -0,0 +1,34 @@
+package ioc
+
+import (
+	"strings"
+)
+
+// tlds are the top-level domains a bare token has to end in to count as a
+// domain. Country codes that double as file extensions common in shell
+// payloads, like .sh, .pl and .py, are left out so bins.sh isn't a domain.
+// Hosts of URLs are domains regardless.
+var tlds = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, tld := range strings.Fields(`
+		com net org info biz edu gov mil int arpa name pro mobi asia tel
+		io xyz top online site club shop store app dev cloud tech space website
+		live link click pw vip icu buzz fun work cyou monster rest bar host fyi
+		ac ad ae af ag ai al am ao aq ar as at au aw ax az ba bb bd be bf bg bh
+		bi bj bm bn bo br bs bt bw by ca cd cf cg ch ci ck cl cm cn co cr cu cv
+		cw cx cy cz de dj dk dm do dz ec ee eg er es et eu fi fj fk fm fo fr ga
+		gd ge gf gg gh gi gl gm gn gp gq gr gs gt gu gw gy hk hm hn hr ht hu id
+		ie il im in iq ir is it je jm jo jp ke kg kh ki km kn kp kr kw ky kz la
+		lb lc li lk lr ls lt lu lv ly ma mc me mg mh ml mm mn mo mp mq mr ms mt
+		mu mv mw mx my mz na nc ne nf ng ni nl no np nr nu nz om pa pe pf pg ph
+		pk pn pr pt qa re ro ru rw sa sb sc sd se sg si sk sl sm sn sr ss st su
+		sv sx sy sz tc td tf tg th tj tk tl tm tn to tr tt tv tw tz ua ug uk us
+		uy uz va vc ve vg vi vn vu wf ws ye yt za zm zw`) {
+		m[tld] = true
+	}
+	return m
+}()
+
+func knownTLD(domain string) bool {
+	return tlds[strings.ToLower(domain[strings.LastIndex(domain, ".")+1:])]
+}
@@ -1,19 +1,21 @@
 package glutton
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
 )
 
 var (
-	// iptables -t mangle -I PREROUTING -p tcp ! --dport 22 -m state ! --state ESTABLISHED,RELATED -j TPROXY --on-port 5000 --on-ip 127.0.0.1
+	// iptables -t mangle -I PREROUTING -d 1.2.3.4 -p tcp ! --dport 22 -m state ! --state ESTABLISHED,RELATED -j TPROXY --on-port 5000 --on-ip 127.0.0.1
 	specTCP = "-p;%s;-m;state;!;--state;ESTABLISHED,RELATED;!;--dport;%d;-j;TPROXY;--on-port;%d;--on-ip;127.0.0.1"
 	specUDP = "-p;%s;-m;state;!;--state;ESTABLISHED,RELATED;!;--dport;%d;-j;TPROXY;--on-port;%d;--on-ip;127.0.0.1"
 )
 
-func genRuleSpec(chain, iface, protocol, _ string, sshPort, dport uint32) []string {
+func genRuleSpec(chain, iface, protocol, dstIP string, sshPort, dport uint32) []string {
 	var spec string
 	switch protocol {
 	case "udp":
@@ -21,6 +23,9 @@ func genRuleSpec(chain, iface, protocol, _ string, sshPort, dport uint32) []stri
 	case "tcp":
 		spec = specTCP
 	}
+	if dstIP != "" {
+		spec = "-d;" + dstIP + ";" + spec
+	}
 	switch chain {
 	case "PREROUTING":
 		spec = "-i;%s;" + spec
@@ -30,19 +35,45 @@ func genRuleSpec(chain, iface, protocol, _ string, sshPort, dport uint32) []stri
 	return strings.Split(fmt.Sprintf(spec, iface, protocol, sshPort, dport), ";")
 }
 
-func setTProxyIPTables(iface, srcIP, protocol string, port, sshPort uint32) error {
+// setTProxyIPTables redirects traffic for every sensor address to the local listener
+func setTProxyIPTables(iface string, dstIPs []net.IP, protocol string, port, sshPort uint32) error {
 	ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
 	if err != nil {
 		return err
 	}
-	return ipt.AppendUnique("mangle", "PREROUTING", genRuleSpec("PREROUTING", iface, protocol, srcIP, sshPort, port)...)
+	// replace the catch-all rule older versions left behind
+	if err := ipt.DeleteIfExists("mangle", "PREROUTING", genRuleSpec("PREROUTING", iface, protocol, "", sshPort, port)...); err != nil {
+		return err
+	}
+	for _, ip := range dstIPs {
+		if ip.To4() == nil {
+			continue
+		}
+		if err := ipt.AppendUnique("mangle", "PREROUTING", genRuleSpec("PREROUTING", iface, protocol, ip.String(), sshPort, port)...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func flushTProxyIPTables(iface, srcIP, protocol string, port, sshPort uint32) error {
+func flushTProxyIPTables(iface string, dstIPs []net.IP, protocol string, port, sshPort uint32) error {
 	ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
 	if err != nil {
 		return err
 	}
 
-	return ipt.Delete("mangle", "PREROUTING", genRuleSpec("PREROUTING", iface, protocol, srcIP, sshPort, port)...)
+	// older versions installed a single rule without a destination
+	dsts := []string{""}
+	for _, ip := range dstIPs {
+		if ip.To4() != nil {
+			dsts = append(dsts, ip.String())
+		}
+	}
+	var errs []error
+	for _, dst := range dsts {
+		if err := ipt.DeleteIfExists("mangle", "PREROUTING", genRuleSpec("PREROUTING", iface, protocol, dst, sshPort, port)...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,22 @@
+package glutton
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenRuleSpec(t *testing.T) {
+	spec := genRuleSpec("PREROUTING", "eth0", "tcp", "203.0.113.5", 22, 5000)
+	require.Equal(t,
+		"-i eth0 -d 203.0.113.5 -p tcp -m state ! --state ESTABLISHED,RELATED ! --dport 22 -j TPROXY --on-port 5000 --on-ip 127.0.0.1",
+		strings.Join(spec, " "),
+	)
+
+	legacy := genRuleSpec("PREROUTING", "eth0", "udp", "", 22, 5001)
+	require.Equal(t,
+		"-i eth0 -p udp -m state ! --state ESTABLISHED,RELATED ! --dport 22 -j TPROXY --on-port 5001 --on-ip 127.0.0.1",
+		strings.Join(legacy, " "),
+	)
+}
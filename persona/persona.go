@@ -0,0 +1,215 @@
+// Package persona describes the device a sensor pretends to be, so every
+// handler presents the same hostname, operating system, banners and versions.
+package persona
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"slices"
+
+	"gopkg.in/yaml.v2"
+)
+
+// OS is the operating system of a persona
+type OS struct {
+	// Name is what uname -s prints, Linux if empty
+	Name string `yaml:"name"`
+	// Kernel is the kernel release, uname -r
+	Kernel string `yaml:"kernel"`
+	// Build is the kernel build string, uname -v
+	Build string `yaml:"build"`
+	// Machine is the hardware name, uname -m
+	Machine string `yaml:"machine"`
+}
+
+// Persona is a device profile
+type Persona struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Hostname    string `yaml:"hostname"`
+	OS          OS     `yaml:"os"`
+	// Addresses are the sensor addresses the persona answers on
+	Addresses []string `yaml:"addresses"`
+	// Services are the handlers the persona exposes, connections to other
+	// handlers are closed right away. Empty exposes all.
+	Services []string `yaml:"services"`
+	// Banners are keyed by what presents them, see the Banner keys
+	Banners map[string]string `yaml:"banners"`
+	// Versions of the software the persona runs, keyed by name
+	Versions map[string]string `yaml:"versions"`
+	// Files seed the filesystem of emulated shells, keyed by absolute path
+	Files map[string]string `yaml:"files"`
+	// Mailbox is the inbox served over POP3 and IMAP
+	Mailbox []Mail `yaml:"mailbox"`
+	// Certificate is the subject of the certificate presented over TLS
+	Certificate Certificate `yaml:"certificate"`
+}
+
+// Certificate is the subject of the self-signed certificate of a persona,
+// like the one an appliance generates on first boot
+type Certificate struct {
+	// CommonName is the hostname if empty
+	CommonName   string `yaml:"common_name"`
+	Organization string `yaml:"organization"`
+	// Names are host names and addresses added to the hostname
+	Names []string `yaml:"names"`
+}
+
+// Mail is a message in the mailbox of a persona
+type Mail struct {
+	From    string `yaml:"from"`
+	To      string `yaml:"to"`
+	Subject string `yaml:"subject"`
+	// Age is how many days before the session the message arrived
+	Age  int    `yaml:"age"`
+	Body string `yaml:"body"`
+}
+
+// Banner keys
+const (
+	// BannerTelnet is printed before the telnet login prompt
+	BannerTelnet = "telnet"
+	// BannerLogin is the telnet username prompt
+	BannerLogin = "login"
+	// BannerShell is printed once a shell starts
+	BannerShell = "shell"
+	// BannerFTP is the FTP greeting, without the reply code
+	BannerFTP = "ftp"
+	// BannerSMTP is the SMTP greeting, without the reply code
+	BannerSMTP = "smtp"
+	// BannerRFB is the VNC desktop name
+	BannerRFB = "rfb"
+	// BannerPOP3 is the POP3 greeting, without +OK
+	BannerPOP3 = "pop3"
+	// BannerIMAP is the IMAP greeting, without the tag and capabilities
+	BannerIMAP = "imap"
+)
+
+// Banner returns the banner for key or fallback if the persona has none
+func (p *Persona) Banner(key, fallback string) string {
+	if p != nil {
+		if banner, ok := p.Banners[key]; ok {
+			return banner
+		}
+	}
+	return fallback
+}
+
+// Version returns the version of software or fallback if the persona has none
+func (p *Persona) Version(software, fallback string) string {
+	if p != nil {
+		if version, ok := p.Versions[software]; ok {
+			return version
+		}
+	}
+	return fallback
+}
+
+// Serves reports whether the persona exposes handler
+func (p *Persona) Serves(handler string) bool {
+	return p == nil || len(p.Services) == 0 || slices.Contains(p.Services, handler)
+}
+
+// Config is the format of a persona file
+type Config struct {
+	// Default is the persona used when neither rule nor address select one
+	Default  string     `yaml:"default"`
+	Personas []*Persona `yaml:"personas"`
+}
+
+// Set is the personas of a sensor
+type Set struct {
+	personas  map[string]*Persona
+	addresses map[string]*Persona
+	def       *Persona
+}
+
+// Parse reads a persona file
+func Parse(r io.Reader) (*Set, error) {
+	config := &Config{}
+	if err := yaml.NewDecoder(r).Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	s := &Set{
+		personas:  make(map[string]*Persona),
+		addresses: make(map[string]*Persona),
+	}
+	for i, p := range config.Personas {
+		if p.Name == "" {
+			return nil, fmt.Errorf("persona %d has no name", i)
+		}
+		if s.personas[p.Name] != nil {
+			return nil, fmt.Errorf("duplicate persona %q", p.Name)
+		}
+		s.personas[p.Name] = p
+		for _, addr := range p.Addresses {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("persona %q: invalid address %q", p.Name, addr)
+			}
+			if other := s.addresses[ip.String()]; other != nil {
+				return nil, fmt.Errorf("persona %q: address %s is taken by %q", p.Name, addr, other.Name)
+			}
+			s.addresses[ip.String()] = p
+		}
+		for name := range p.Files {
+			if !path.IsAbs(name) {
+				return nil, fmt.Errorf("persona %q: file %q is not an absolute path", p.Name, name)
+			}
+		}
+	}
+	if config.Default != "" {
+		if s.def = s.personas[config.Default]; s.def == nil {
+			return nil, fmt.Errorf("unknown default persona %q", config.Default)
+		}
+	}
+	return s, nil
+}
+
+// Load reads the persona file at path
+func Load(path string) (*Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Get returns the persona called name, nil if there is none
+func (s *Set) Get(name string) *Persona {
+	if s == nil {
+		return nil
+	}
+	return s.personas[name]
+}
+
+// Select returns the persona called name if it's not empty, otherwise the
+// persona answering on the sensor address ip, otherwise the default persona.
+// It's nil if none applies.
+func (s *Set) Select(name string, ip net.IP) *Persona {
+	if s == nil {
+		return nil
+	}
+	if name != "" {
+		return s.personas[name]
+	}
+	if ip != nil {
+		if p := s.addresses[ip.String()]; p != nil {
+			return p
+		}
+	}
+	return s.def
+}
+
+// Len returns the number of personas
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.personas)
+}
@@ -0,0 +1,81 @@
+package persona
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPersonas = `
+default: camera
+personas:
+  - name: camera
+    hostname: IPC
+    banners:
+      ftp: "(vsFTPd 2.0.7)"
+  - name: server
+    hostname: web01
+    addresses: ["192.0.2.10"]
+    services: [http, telnet]
+    versions:
+      docker: 20.10.21
+    files:
+      /etc/issue: "Ubuntu\n"
+`
+
+func TestSelect(t *testing.T) {
+	s, err := Parse(strings.NewReader(testPersonas))
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Len())
+
+	require.Equal(t, "server", s.Select("server", nil).Name)
+	require.Equal(t, "server", s.Select("", net.ParseIP("192.0.2.10")).Name)
+	require.Equal(t, "server", s.Select("", net.ParseIP("192.0.2.10").To16()).Name)
+	require.Equal(t, "camera", s.Select("", net.ParseIP("192.0.2.11")).Name)
+	// a rule wins over the address
+	require.Equal(t, "camera", s.Select("camera", net.ParseIP("192.0.2.10")).Name)
+	require.Nil(t, s.Select("missing", nil))
+
+	var none *Set
+	require.Nil(t, none.Select("", nil))
+}
+
+func TestPresentation(t *testing.T) {
+	s, err := Parse(strings.NewReader(testPersonas))
+	require.NoError(t, err)
+
+	camera, server := s.Get("camera"), s.Get("server")
+	require.Equal(t, "(vsFTPd 2.0.7)", camera.Banner(BannerFTP, "Welcome!"))
+	require.Equal(t, "Welcome!", server.Banner(BannerFTP, "Welcome!"))
+	require.Equal(t, "20.10.21", server.Version("docker", "20.10.9"))
+	require.Equal(t, "20.10.9", camera.Version("docker", "20.10.9"))
+
+	require.True(t, camera.Serves("smb"))
+	require.True(t, server.Serves("telnet"))
+	require.False(t, server.Serves("smb"))
+
+	var none *Persona
+	require.Equal(t, "Welcome!", none.Banner(BannerFTP, "Welcome!"))
+	require.True(t, none.Serves("smb"))
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no name":          "personas: [{hostname: x}]",
+		"duplicate":        "personas: [{name: a}, {name: a}]",
+		"invalid address":  "personas: [{name: a, addresses: [nope]}]",
+		"address taken":    "personas: [{name: a, addresses: [192.0.2.1]}, {name: b, addresses: [192.0.2.1]}]",
+		"relative file":    "personas: [{name: a, files: {etc/issue: x}}]",
+		"unknown default":  "default: b\npersonas: [{name: a}]",
+		"invalid yaml doc": "personas: {",
+	} {
+		_, err := Parse(strings.NewReader(data))
+		require.Error(t, err, name)
+	}
+
+	s, err := Parse(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Nil(t, s.Select("", nil))
+}
@@ -0,0 +1,45 @@
+package glutton
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/rules"
+)
+
+//go:embed config/personas.yaml
+var defaultPersonas []byte
+
+// LoadPersonas loads the persona file at path. If the file does not exist
+// the embedded default personas are used instead.
+func LoadPersonas(path string) (*persona.Set, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return persona.Parse(bytes.NewReader(defaultPersonas))
+	}
+	return persona.Load(path)
+}
+
+// checkPersonas makes sure every persona the rules name exists
+func checkPersonas(rs rules.Rules, personas *persona.Set) error {
+	for _, rule := range rs {
+		if rule.Persona != "" && personas.Get(rule.Persona) == nil {
+			return fmt.Errorf("rule %q: unknown persona %q", rule.Name, rule.Persona)
+		}
+	}
+	return nil
+}
+
+// Persona returns the persona presented on a connection, selected by its
+// rule or its destination address. It's nil if no persona applies, handlers
+// then use their built-in presentation.
+func (g *Glutton) Persona(md connection.Metadata) *persona.Persona {
+	var name string
+	if md.Rule != nil {
+		name = md.Rule.Persona
+	}
+	return g.personas.Select(name, md.TargetIP)
+}
@@ -12,9 +12,13 @@ import (
 	"time"
 
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/ioc"
+	"github.com/mushorg/glutton/profile"
 	"github.com/mushorg/glutton/scanner"
+	"github.com/mushorg/glutton/yara"
 
 	"github.com/d1str0/hpfeeds"
+	"github.com/google/uuid"
 	"github.com/spf13/viper"
 )
 
@@ -23,27 +27,136 @@ const (
 	tlsTimeout  = 5 * time.Second
 )
 
+// Enricher adds context to an event before it is sent
+type Enricher func(*Event)
+
 // Producer for the producer
 type Producer struct {
 	sensorID   string
 	httpClient *http.Client
 	hpfClient  hpfeeds.Client
 	hpfChannel chan []byte
+	enrichers  []Enricher
 }
 
+const (
+	// EventSessionStart is the type of the event emitted when a session is accepted
+	EventSessionStart = "session.start"
+	// EventSessionEnd is the type of the event emitted when a session handler returns
+	EventSessionEnd = "session.end"
+	// EventDownload is the type of the event emitted for every sample download attempt
+	EventDownload = "download"
+	// EventAuthAttempt is the type of the event emitted for every login attempt
+	EventAuthAttempt = "auth_attempt"
+)
+
 // Event is a struct for glutton events
 type Event struct {
-	Timestamp time.Time   `json:"timestamp,omitempty"`
-	Transport string      `json:"transport,omitempty"`
-	SrcHost   string      `json:"srcHost,omitempty"`
-	SrcPort   string      `json:"srcPort,omitempty"`
-	DstPort   uint16      `json:"dstPort,omitempty"`
-	SensorID  string      `json:"sensorID,omitempty"`
-	Rule      string      `json:"rule,omitempty"`
-	Handler   string      `json:"handler,omitempty"`
-	Payload   string      `json:"payload,omitempty"`
-	Scanner   string      `json:"scanner,omitempty"`
-	Decoded   interface{} `json:"decoded,omitempty"`
+	Timestamp time.Time        `json:"timestamp,omitempty"`
+	Type      string           `json:"type,omitempty"`
+	SessionID string           `json:"sessionID,omitempty"`
+	Transport string           `json:"transport,omitempty"`
+	SrcHost   string           `json:"srcHost,omitempty"`
+	SrcPort   string           `json:"srcPort,omitempty"`
+	DstHost   string           `json:"dstHost,omitempty"`
+	DstPort   uint16           `json:"dstPort,omitempty"`
+	SensorID  string           `json:"sensorID,omitempty"`
+	Rule      string           `json:"rule,omitempty"`
+	RuleName  string           `json:"ruleName,omitempty"`
+	RuleIndex *int             `json:"ruleIndex,omitempty"`
+	Handler   string           `json:"handler,omitempty"`
+	TLS       *connection.TLS  `json:"tls,omitempty"`
+	Payload   string           `json:"payload,omitempty"`
+	Raw       []byte           `json:"raw,omitempty"`
+	Scanner   string           `json:"scanner,omitempty"`
+	Behavior  string           `json:"behavior,omitempty"`
+	Tags      []string         `json:"tags,omitempty"`
+	Yara      []yara.Result    `json:"yara,omitempty"`
+	Artifacts []Artifact       `json:"artifacts,omitempty"`
+	IOCs      *ioc.IOCs        `json:"iocs,omitempty"`
+	Decoded   interface{}      `json:"decoded,omitempty"`
+	Session   *Session         `json:"session,omitempty"`
+	Download  *Download        `json:"download,omitempty"`
+	Auth      *Auth            `json:"auth,omitempty"`
+	Profile   *profile.Summary `json:"profile,omitempty"`
+}
+
+// EventOption sets optional fields of an event
+type EventOption func(*Event)
+
+// WithTags tags an event, e.g. with the signatures its payload matched
+func WithTags(tags ...string) EventOption {
+	return func(e *Event) {
+		e.Tags = append(e.Tags, tags...)
+	}
+}
+
+// WithRaw sets the complete data the payload was taken from, like the whole
+// HTTP request of which the payload is the body
+func WithRaw(raw []byte) EventOption {
+	return func(e *Event) {
+		e.Raw = raw
+	}
+}
+
+// WithYara adds the YARA results of blobs stored with the event
+func WithYara(results ...yara.Result) EventOption {
+	return func(e *Event) {
+		e.Yara = append(e.Yara, results...)
+	}
+}
+
+// WithIOCs adds the indicators of compromise found in the event's payload
+func WithIOCs(iocs *ioc.IOCs) EventOption {
+	return func(e *Event) {
+		e.IOCs = iocs
+	}
+}
+
+// WithArtifacts adds references to the blobs stored with the event
+func WithArtifacts(artifacts ...Artifact) EventOption {
+	return func(e *Event) {
+		e.Artifacts = append(e.Artifacts, artifacts...)
+	}
+}
+
+// Artifact references a blob kept in the artifact store
+type Artifact struct {
+	SHA256 string `json:"sha256"`
+	Kind   string `json:"kind"`
+	// URL of the replicated copy, the upload may still be in progress
+	URL string `json:"url,omitempty"`
+}
+
+// Session summarizes a finished session
+type Session struct {
+	// Duration of the session in seconds
+	Duration     float64 `json:"duration"`
+	BytesIn      int64   `json:"bytesIn"`
+	BytesOut     int64   `json:"bytesOut"`
+	Interactions int64   `json:"interactions"`
+	CloseReason  string  `json:"closeReason,omitempty"`
+}
+
+// Download is the outcome of a sample download attempt
+type Download struct {
+	URL string `json:"url"`
+	// Status is ok, blocked, too_large or failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Duration of the download in seconds
+	Duration float64 `json:"duration"`
+}
+
+// Auth is a login attempt against a handler
+type Auth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Success  bool   `json:"success"`
+	// Policy is the login policy that accepted or rejected the attempt
+	Policy string `json:"policy,omitempty"`
 }
 
 func makeEventTCP(handler string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, sensorID string) (*Event, error) {
@@ -65,13 +178,16 @@ func makeEventTCP(handler string, conn net.Conn, md connection.Metadata, payload
 		DstPort:   uint16(md.TargetPort),
 		SensorID:  sensorID,
 		Handler:   handler,
+		TLS:       md.TLS,
 		Payload:   base64.StdEncoding.EncodeToString(payload),
 		Scanner:   scannerName,
 		Decoded:   decoded,
 	}
-	if md.Rule != nil {
-		event.Rule = md.Rule.String()
+	if md.TargetIP != nil {
+		event.DstHost = md.TargetIP.String()
 	}
+	event.setRule(md)
+	event.setSession(md)
 	return &event, nil
 }
 
@@ -93,12 +209,59 @@ func makeEventUDP(handler string, srcAddr *net.UDPAddr, md connection.Metadata,
 		Scanner:   scannerName,
 		Decoded:   decoded,
 	}
-	if md.Rule != nil {
-		event.Rule = md.Rule.String()
+	if md.TargetIP != nil {
+		event.DstHost = md.TargetIP.String()
+	}
+	event.setRule(md)
+	event.setSession(md)
+	return &event, nil
+}
+
+func makeEventSession(eventType, handler string, md connection.Metadata, sensorID string) (*Event, error) {
+	srcIP := md.Key.Src.Addr()
+	_, scannerName, err := scanner.IsScanner(net.IP(srcIP.AsSlice()))
+	if err != nil {
+		return nil, err
+	}
+
+	event := Event{
+		Timestamp: time.Now().UTC(),
+		Type:      eventType,
+		Transport: md.Key.Network,
+		SrcHost:   srcIP.String(),
+		SrcPort:   strconv.Itoa(int(md.Key.Src.Port())),
+		DstPort:   md.TargetPort,
+		SensorID:  sensorID,
+		Handler:   handler,
+		Scanner:   scannerName,
+	}
+	if md.TargetIP != nil {
+		event.DstHost = md.TargetIP.String()
 	}
+	event.setRule(md)
+	event.setSession(md)
 	return &event, nil
 }
 
+// setSession ties the event to the session it belongs to
+func (e *Event) setSession(md connection.Metadata) {
+	if md.ID != uuid.Nil {
+		e.SessionID = md.ID.String()
+	}
+}
+
+// setRule records which rule dispatched the connection
+func (e *Event) setRule(md connection.Metadata) {
+	if md.Rule == nil {
+		return
+	}
+	e.Rule = md.Rule.String()
+	e.RuleName = md.Rule.Name
+	if index := md.Rule.Index(); index >= 0 {
+		e.RuleIndex = &index
+	}
+}
+
 // New initializes the producers
 func New(sensorID string) (*Producer, error) {
 	producer := &Producer{
@@ -127,30 +290,90 @@ func New(sensorID string) (*Producer, error) {
 }
 
 // LogTCP is a meta caller for all producers
-func (p *Producer) LogTCP(handler string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}) error {
+func (p *Producer) LogTCP(handler string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...EventOption) error {
 	event, err := makeEventTCP(handler, conn, md, payload, decoded, p.sensorID)
 	if err != nil {
 		return err
 	}
-	if viper.GetBool("producers.hpfeeds.enabled") {
-		if err := p.logHPFeeds(event); err != nil {
-			return err
-		}
-	}
-	if viper.GetBool("producers.http.enabled") {
-		if err := p.logHTTP(event); err != nil {
-			return err
-		}
+	for _, opt := range opts {
+		opt(event)
 	}
-	return nil
+	return p.Log(event)
 }
 
 // LogUDP is a meta caller for all producers
-func (p *Producer) LogUDP(handler string, srcAddr *net.UDPAddr, md connection.Metadata, payload []byte, decoded interface{}) error {
+func (p *Producer) LogUDP(handler string, srcAddr *net.UDPAddr, md connection.Metadata, payload []byte, decoded interface{}, opts ...EventOption) error {
 	event, err := makeEventUDP(handler, srcAddr, md, payload, decoded, p.sensorID)
 	if err != nil {
 		return err
 	}
+	for _, opt := range opts {
+		opt(event)
+	}
+	return p.Log(event)
+}
+
+// LogSessionStart emits the session.start event of a session
+func (p *Producer) LogSessionStart(handler string, md connection.Metadata) error {
+	event, err := makeEventSession(EventSessionStart, handler, md, p.sensorID)
+	if err != nil {
+		return err
+	}
+	return p.Log(event)
+}
+
+// LogSessionEnd emits the session.end event of a session with its summary
+func (p *Producer) LogSessionEnd(handler string, md connection.Metadata, closeReason string, opts ...EventOption) error {
+	event, err := makeEventSession(EventSessionEnd, handler, md, p.sensorID)
+	if err != nil {
+		return err
+	}
+	event.Session = &Session{
+		Duration:     time.Since(md.Added).Seconds(),
+		BytesIn:      md.Stats.BytesIn(),
+		BytesOut:     md.Stats.BytesOut(),
+		Interactions: md.Stats.Interactions(),
+		CloseReason:  closeReason,
+	}
+	for _, opt := range opts {
+		opt(event)
+	}
+	return p.Log(event)
+}
+
+// LogDownload emits the download event of a sample download attempt
+func (p *Producer) LogDownload(handler string, md connection.Metadata, download *Download, opts ...EventOption) error {
+	event, err := makeEventSession(EventDownload, handler, md, p.sensorID)
+	if err != nil {
+		return err
+	}
+	event.Download = download
+	for _, opt := range opts {
+		opt(event)
+	}
+	return p.Log(event)
+}
+
+// LogAuth emits the auth_attempt event of a login attempt
+func (p *Producer) LogAuth(handler string, md connection.Metadata, auth *Auth) error {
+	event, err := makeEventSession(EventAuthAttempt, handler, md, p.sensorID)
+	if err != nil {
+		return err
+	}
+	event.Auth = auth
+	return p.Log(event)
+}
+
+// AddEnricher registers an enricher that runs on every event before it is sent
+func (p *Producer) AddEnricher(e Enricher) {
+	p.enrichers = append(p.enrichers, e)
+}
+
+// Log sends an event to all enabled producers
+func (p *Producer) Log(event *Event) error {
+	for _, enrich := range p.enrichers {
+		enrich(event)
+	}
 	if viper.GetBool("producers.hpfeeds.enabled") {
 		if err := p.logHPFeeds(event); err != nil {
 			return err
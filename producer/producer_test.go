@@ -1,9 +1,12 @@
 package producer
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/mushorg/glutton/connection"
@@ -48,3 +51,64 @@ func TestProducerLog(t *testing.T) {
 	err = p.LogUDP("test", &net.UDPAddr{}, md, []byte{123}, nil)
 	require.NoError(t, err)
 }
+
+func TestMakeEventRule(t *testing.T) {
+	rs, err := rules.Init(strings.NewReader(`rules:
+  - name: catch-all
+    match: udp
+    type: conn_handler
+    target: udp
+`))
+	require.NoError(t, err)
+
+	event, err := makeEventUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, connection.Metadata{Rule: rs[0], TargetIP: net.ParseIP("10.0.0.1")}, nil, nil, "test")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", event.DstHost)
+	require.Equal(t, "catch-all", event.RuleName)
+	require.NotNil(t, event.RuleIndex)
+	require.Equal(t, 0, *event.RuleIndex)
+
+	event, err = makeEventUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, connection.Metadata{Rule: &rules.Rule{Target: "udp"}}, nil, nil, "test")
+	require.NoError(t, err)
+	require.Nil(t, event.RuleIndex)
+}
+
+func TestMakeEventSession(t *testing.T) {
+	table := connection.New(context.Background(), 0, 0)
+	md, err := table.Register(connection.NewConnKey("tcp", netip.MustParseAddrPort("192.0.2.1:1234"), netip.MustParseAddrPort("10.0.0.1:23")), &rules.Rule{Target: "telnet"})
+	require.NoError(t, err)
+
+	event, err := makeEventSession(EventSessionStart, "telnet", md, "test")
+	require.NoError(t, err)
+	require.Equal(t, EventSessionStart, event.Type)
+	require.Equal(t, md.ID.String(), event.SessionID)
+	require.Equal(t, "tcp", event.Transport)
+	require.Equal(t, "192.0.2.1", event.SrcHost)
+	require.Equal(t, "1234", event.SrcPort)
+	require.Equal(t, "10.0.0.1", event.DstHost)
+	require.Equal(t, uint16(23), event.DstPort)
+	require.Nil(t, event.Session)
+
+	// handler events carry the session ID too
+	event, err = makeEventUDP("udp", &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, md, nil, nil, "test")
+	require.NoError(t, err)
+	require.Equal(t, md.ID.String(), event.SessionID)
+}
+
+func TestMakeEventTLS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	info := &connection.TLS{ServerName: "example.com", ALPN: []string{"h2", "http/1.1"}}
+	event, err := makeEventTCP("http", conn, connection.Metadata{TLS: info}, nil, nil, "test")
+	require.NoError(t, err)
+	require.Same(t, info, event.TLS)
+
+	event, err = makeEventTCP("http", conn, connection.Metadata{}, nil, nil, "test")
+	require.NoError(t, err)
+	require.Nil(t, event.TLS)
+}
@@ -0,0 +1,375 @@
+package profile
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// maxEntries caps every list kept in a profile, the oldest entries are
+	// dropped first
+	maxEntries = 256
+	// maxPending is how many recorded activities are buffered before they
+	// are written without waiting for the next Flush
+	maxPending = 4096
+)
+
+var (
+	bucketProfiles = []byte("profiles")
+	// bucketSeen indexes profiles by last seen time, its keys are the big
+	// endian Unix nanoseconds followed by the IP
+	bucketSeen = []byte("seen")
+)
+
+// Credential is a username and password pair tried against a handler
+type Credential struct {
+	Handler  string `json:"handler"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Activity is what a source did in a single observation. Empty fields are
+// ignored.
+type Activity struct {
+	// Session marks the start of a new session
+	Session    bool
+	Port       uint16
+	Handler    string
+	Scanner    string
+	Credential *Credential
+	Command    string
+	// Sample is the SHA256 hash of a downloaded sample
+	Sample string
+}
+
+// Profile aggregates the activity of a source IP across protocols
+type Profile struct {
+	IP          string       `json:"ip"`
+	FirstSeen   time.Time    `json:"firstSeen"`
+	LastSeen    time.Time    `json:"lastSeen"`
+	Sessions    int64        `json:"sessions"`
+	Scanner     string       `json:"scanner,omitempty"`
+	Ports       []uint16     `json:"ports,omitempty"`
+	Handlers    []string     `json:"handlers,omitempty"`
+	Credentials []Credential `json:"credentials,omitempty"`
+	Commands    []string     `json:"commands,omitempty"`
+	Samples     []string     `json:"samples,omitempty"`
+}
+
+// Summary is the short form of a profile attached to events
+type Summary struct {
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Sessions    int64     `json:"sessions"`
+	Scanner     string    `json:"scanner,omitempty"`
+	Ports       int       `json:"ports"`
+	Handlers    []string  `json:"handlers,omitempty"`
+	Credentials int       `json:"credentials"`
+	Commands    int       `json:"commands"`
+	Samples     int       `json:"samples"`
+}
+
+// Summary returns the short form of the profile
+func (p *Profile) Summary() *Summary {
+	return &Summary{
+		FirstSeen:   p.FirstSeen,
+		LastSeen:    p.LastSeen,
+		Sessions:    p.Sessions,
+		Scanner:     p.Scanner,
+		Ports:       len(p.Ports),
+		Handlers:    p.Handlers,
+		Credentials: len(p.Credentials),
+		Commands:    len(p.Commands),
+		Samples:     len(p.Samples),
+	}
+}
+
+// apply merges an activity observed at the given time into the profile
+func (p *Profile) apply(act Activity, at time.Time) {
+	if p.FirstSeen.IsZero() || at.Before(p.FirstSeen) {
+		p.FirstSeen = at
+	}
+	if at.After(p.LastSeen) {
+		p.LastSeen = at
+	}
+	if act.Session {
+		p.Sessions++
+	}
+	if act.Scanner != "" {
+		p.Scanner = act.Scanner
+	}
+	if act.Port != 0 && !slices.Contains(p.Ports, act.Port) {
+		p.Ports = capped(append(p.Ports, act.Port))
+		slices.Sort(p.Ports)
+	}
+	if act.Handler != "" && !slices.Contains(p.Handlers, act.Handler) {
+		p.Handlers = capped(append(p.Handlers, act.Handler))
+	}
+	if act.Credential != nil && !slices.Contains(p.Credentials, *act.Credential) {
+		p.Credentials = capped(append(p.Credentials, *act.Credential))
+	}
+	if act.Command != "" {
+		p.Commands = capped(append(p.Commands, act.Command))
+	}
+	if act.Sample != "" && !slices.Contains(p.Samples, act.Sample) {
+		p.Samples = capped(append(p.Samples, act.Sample))
+	}
+}
+
+func capped[T any](s []T) []T {
+	if len(s) > maxEntries {
+		return s[len(s)-maxEntries:]
+	}
+	return s
+}
+
+// Options configure a Store
+type Options struct {
+	// MaxProfiles evicts the least recently seen profiles beyond this count,
+	// zero is unlimited
+	MaxProfiles int
+	// MaxAge evicts profiles not seen for this long, zero keeps them forever
+	MaxAge time.Duration
+}
+
+// observation is an activity waiting to be merged into a profile
+type observation struct {
+	act Activity
+	at  time.Time
+}
+
+// Store persists profiles keyed by source IP. Recorded activities are
+// buffered and written in one transaction per Flush.
+type Store struct {
+	db   *bolt.DB
+	opts Options
+	now  func() time.Time
+
+	// mu guards the activities recorded since the last flush
+	mu      sync.Mutex
+	pending map[string][]observation
+	queued  int
+	// flushMu makes readers wait while a flush commits, so the activities
+	// being written are neither missed nor counted twice
+	flushMu  sync.RWMutex
+	flushing map[string][]observation
+}
+
+// Open opens or creates the profile store at path
+func Open(path string, opts Options) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profile store: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketProfiles)
+		if err != nil {
+			return err
+		}
+		seen, err := tx.CreateBucketIfNotExists(bucketSeen)
+		if err != nil {
+			return err
+		}
+		if seen.Stats().KeyN > 0 {
+			return nil
+		}
+		// stores written before the index existed
+		return b.ForEach(func(ip, data []byte) error {
+			p := &Profile{}
+			if err := json.Unmarshal(data, p); err != nil {
+				return err
+			}
+			return seen.Put(seenKey(p.LastSeen, string(ip)), nil)
+		})
+	}); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to create profile bucket: %w", err), db.Close())
+	}
+	return &Store{db: db, opts: opts, now: time.Now, pending: map[string][]observation{}}, nil
+}
+
+// Close writes the buffered activities and closes the underlying database
+func (s *Store) Close() error {
+	return errors.Join(s.Flush(), s.db.Close())
+}
+
+// seenKey is the index key of a profile, ordered by last seen time
+func seenKey(lastSeen time.Time, ip string) []byte {
+	key := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(ip)), uint64(lastSeen.UnixNano()))
+	return append(key, ip...)
+}
+
+// Record queues an activity of ip to be merged into its profile. The queue
+// is written by Flush, or right away once it holds maxPending activities.
+func (s *Store) Record(ip string, act Activity) error {
+	s.mu.Lock()
+	s.pending[ip] = append(s.pending[ip], observation{act: act, at: s.now().UTC()})
+	s.queued++
+	full := s.queued >= maxPending
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush merges the queued activities into the stored profiles
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = map[string][]observation{}
+	s.queued = 0
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	s.flushing = pending
+	defer func() { s.flushing = nil }()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, seen := tx.Bucket(bucketProfiles), tx.Bucket(bucketSeen)
+		for ip, observations := range pending {
+			p := &Profile{IP: ip}
+			if data := b.Get([]byte(ip)); data != nil {
+				if err := json.Unmarshal(data, p); err != nil {
+					return fmt.Errorf("failed to decode profile %s: %w", ip, err)
+				}
+				if err := seen.Delete(seenKey(p.LastSeen, ip)); err != nil {
+					return err
+				}
+			}
+			for _, o := range observations {
+				p.apply(o.act, o.at)
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(ip), data); err != nil {
+				return err
+			}
+			if err := seen.Put(seenKey(p.LastSeen, ip), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store profiles: %w", err)
+	}
+	return nil
+}
+
+// Get returns the profile of ip including activities not flushed yet, or nil
+// if the source was never seen
+func (s *Store) Get(ip string) (*Profile, error) {
+	s.flushMu.RLock()
+	defer s.flushMu.RUnlock()
+
+	var p *Profile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketProfiles).Get([]byte(ip))
+		if data == nil {
+			return nil
+		}
+		p = &Profile{}
+		return json.Unmarshal(data, p)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile %s: %w", ip, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, observations := range [][]observation{s.flushing[ip], s.pending[ip]} {
+		for _, o := range observations {
+			if p == nil {
+				p = &Profile{IP: ip}
+			}
+			p.apply(o.act, o.at)
+		}
+	}
+	return p, nil
+}
+
+// List returns up to limit profiles after skipping offset, most recently
+// seen first. A limit of zero or less returns all remaining profiles.
+func (s *Store) List(limit, offset int) ([]*Profile, error) {
+	profiles := []*Profile{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketProfiles)
+		c := tx.Bucket(bucketSeen).Cursor()
+		for k, _ := c.Last(); k != nil && (limit <= 0 || len(profiles) < limit); k, _ = c.Prev() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			ip := k[8:]
+			data := b.Get(ip)
+			if data == nil {
+				continue
+			}
+			p := &Profile{}
+			if err := json.Unmarshal(data, p); err != nil {
+				return err
+			}
+			profiles = append(profiles, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// Evict removes profiles not seen for MaxAge and the least recently seen
+// ones beyond MaxProfiles, and returns how many it removed
+func (s *Store) Evict() (int, error) {
+	var before time.Time
+	if s.opts.MaxAge > 0 {
+		before = s.now().Add(-s.opts.MaxAge)
+	}
+	evicted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, seen := tx.Bucket(bucketProfiles), tx.Bucket(bucketSeen)
+		excess := 0
+		if s.opts.MaxProfiles > 0 {
+			excess = seen.Stats().KeyN - s.opts.MaxProfiles
+		}
+		// the index is ordered by last seen, the oldest profiles come first
+		var keys [][]byte
+		c := seen.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			lastSeen := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+			if excess <= 0 && !lastSeen.Before(before) {
+				break
+			}
+			keys = append(keys, slices.Clone(k))
+			excess--
+		}
+		for _, k := range keys {
+			if err := seen.Delete(k); err != nil {
+				return err
+			}
+			if err := b.Delete(k[8:]); err != nil {
+				return err
+			}
+		}
+		evicted = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict profiles: %w", err)
+	}
+	return evicted, nil
+}
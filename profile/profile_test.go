@@ -0,0 +1,133 @@
+package profile
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "profiles.db"), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+	return s
+}
+
+func TestRecord(t *testing.T) {
+	s := testStore(t, Options{})
+
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true, Port: 23, Handler: "telnet", Scanner: "censys"}))
+	require.NoError(t, s.Record("192.0.2.1", Activity{Credential: &Credential{Handler: "telnet", Username: "root", Password: "xc3511"}}))
+	require.NoError(t, s.Record("192.0.2.1", Activity{Command: "cat /proc/mounts"}))
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true, Port: 445, Handler: "smb"}))
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true, Port: 23, Handler: "telnet", Sample: "abc"}))
+	require.NoError(t, s.Record("192.0.2.1", Activity{Credential: &Credential{Handler: "telnet", Username: "root", Password: "xc3511"}}))
+
+	p, err := s.Get("192.0.2.1")
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	require.Equal(t, int64(3), p.Sessions)
+	require.Equal(t, []uint16{23, 445}, p.Ports)
+	require.Equal(t, []string{"telnet", "smb"}, p.Handlers)
+	require.Len(t, p.Credentials, 1)
+	require.Equal(t, []string{"cat /proc/mounts"}, p.Commands)
+	require.Equal(t, []string{"abc"}, p.Samples)
+	require.Equal(t, "censys", p.Scanner)
+	require.False(t, p.LastSeen.Before(p.FirstSeen))
+
+	summary := p.Summary()
+	require.Equal(t, 2, summary.Ports)
+	require.Equal(t, 1, summary.Credentials)
+
+	p, err = s.Get("192.0.2.2")
+	require.NoError(t, err)
+	require.Nil(t, p)
+}
+
+func TestCapped(t *testing.T) {
+	s := testStore(t, Options{})
+	for i := 0; i < maxEntries+10; i++ {
+		require.NoError(t, s.Record("192.0.2.1", Activity{Command: "ls"}))
+	}
+	p, err := s.Get("192.0.2.1")
+	require.NoError(t, err)
+	require.Len(t, p.Commands, maxEntries)
+}
+
+func TestFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.db")
+	s, err := Open(path, Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true, Port: 23}))
+	profiles, err := s.List(0, 0)
+	require.NoError(t, err)
+	require.Empty(t, profiles, "recorded activities are buffered until the flush")
+
+	require.NoError(t, s.Flush())
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true, Port: 22}))
+	p, err := s.Get("192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.Sessions, "Get includes buffered activities")
+	require.NoError(t, s.Close(), "closing flushes the buffer")
+
+	s, err = Open(path, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	p, err = s.Get("192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.Sessions)
+	require.Equal(t, []uint16{22, 23}, p.Ports)
+}
+
+func TestList(t *testing.T) {
+	s := testStore(t, Options{})
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true}))
+	require.NoError(t, s.Record("192.0.2.2", Activity{Session: true}))
+	require.NoError(t, s.Flush())
+	require.NoError(t, s.Record("192.0.2.1", Activity{Command: "id"}))
+	require.NoError(t, s.Flush())
+
+	profiles, err := s.List(0, 0)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	require.Equal(t, "192.0.2.1", profiles[0].IP)
+
+	profiles, err = s.List(1, 0)
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+
+	profiles, err = s.List(1, 1)
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	require.Equal(t, "192.0.2.2", profiles[0].IP)
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+	s := testStore(t, Options{MaxProfiles: 2, MaxAge: time.Hour})
+	s.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	require.NoError(t, s.Record("192.0.2.1", Activity{Session: true}))
+	require.NoError(t, s.Flush())
+	s.now = func() time.Time { return now }
+	for _, ip := range []string{"192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		require.NoError(t, s.Record(ip, Activity{Session: true}))
+		require.NoError(t, s.Flush())
+	}
+
+	evicted, err := s.Evict()
+	require.NoError(t, err)
+	require.Equal(t, 2, evicted, "the outdated profile and the least recently seen beyond the limit")
+	profiles, err := s.List(0, 0)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	require.Equal(t, "192.0.2.4", profiles[0].IP)
+	require.Equal(t, "192.0.2.3", profiles[1].IP)
+	p, err := s.Get("192.0.2.1")
+	require.NoError(t, err)
+	require.Nil(t, p)
+}
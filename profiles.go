@@ -0,0 +1,116 @@
+package glutton
+
+import (
+	"log/slog"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
+	"github.com/mushorg/glutton/scanner"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// profileFlushInterval is how often recorded activities are written
+	profileFlushInterval = time.Second
+	// profileEvictInterval is how often profiles past their age or count are evicted
+	profileEvictInterval = time.Hour
+)
+
+// initProfiles opens the attacker profile store under var-dir
+func (g *Glutton) initProfiles() error {
+	if !viper.GetBool("profiles.enabled") {
+		return nil
+	}
+	var err error
+	g.profiles, err = profile.Open(filepath.Join(viper.GetString("var-dir"), "profiles.db"), profile.Options{
+		MaxProfiles: viper.GetInt("profiles.max_profiles"),
+		MaxAge:      time.Duration(viper.GetInt("profiles.max_age")) * 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+	if g.Producer != nil {
+		g.Producer.AddEnricher(g.enrichProfile)
+	}
+
+	go func() {
+		flush := time.NewTicker(profileFlushInterval)
+		defer flush.Stop()
+		evict := time.NewTicker(profileEvictInterval)
+		defer evict.Stop()
+		g.evictProfiles()
+		for {
+			select {
+			case <-g.ctx.Done():
+				return
+			case <-flush.C:
+				if err := g.profiles.Flush(); err != nil {
+					g.Logger.Error("Failed to flush profiles", producer.ErrAttr(err))
+				}
+			case <-evict.C:
+				g.evictProfiles()
+			}
+		}
+	}()
+	return nil
+}
+
+func (g *Glutton) evictProfiles() {
+	evicted, err := g.profiles.Evict()
+	if err != nil {
+		g.Logger.Error("Failed to evict profiles", producer.ErrAttr(err))
+		return
+	}
+	if evicted > 0 {
+		g.Logger.Info("Evicted profiles", slog.Int("count", evicted), slog.String("reporter", "glutton"))
+	}
+}
+
+// recordSession adds a new session to the profile of its source
+func (g *Glutton) recordSession(handler string, md connection.Metadata) {
+	if g.profiles == nil {
+		return
+	}
+	srcIP := net.IP(md.Key.Src.Addr().AsSlice())
+	_, scannerName, err := scanner.IsScanner(srcIP)
+	if err != nil {
+		g.Logger.Debug("Failed to classify scanner", producer.ErrAttr(err))
+	}
+	g.RecordActivity(md, profile.Activity{
+		Session: true,
+		Port:    md.TargetPort,
+		Handler: handler,
+		Scanner: scannerName,
+	})
+}
+
+// RecordActivity adds what a handler observed to the profile of the session source
+func (g *Glutton) RecordActivity(md connection.Metadata, act profile.Activity) {
+	g.observeActivity(md, act)
+	if g.profiles == nil || !md.Key.Src.IsValid() {
+		return
+	}
+	if err := g.profiles.Record(md.Key.Src.Addr().String(), act); err != nil {
+		g.Logger.Error("Failed to record activity", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+	}
+}
+
+// enrichProfile attaches the profile summary of the event source
+func (g *Glutton) enrichProfile(event *producer.Event) {
+	if event.SrcHost == "" {
+		return
+	}
+	p, err := g.profiles.Get(event.SrcHost)
+	if err != nil {
+		g.Logger.Debug("Failed to read profile", producer.ErrAttr(err))
+		return
+	}
+	if p != nil {
+		event.Profile = p.Summary()
+	}
+}
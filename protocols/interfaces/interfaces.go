@@ -2,9 +2,15 @@ package interfaces
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
+	"github.com/mushorg/glutton/signatures"
 )
 
 type Logger interface {
@@ -15,9 +21,17 @@ type Logger interface {
 }
 
 type Honeypot interface {
-	ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}) error
+	ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...producer.EventOption) error
 	ProduceUDP(handler string, srcAddr, dstAddr *net.UDPAddr, md connection.Metadata, payload []byte, decoded interface{}) error
-	ConnectionByFlow([2]uint64) connection.Metadata
+	ConnectionByFlow(connection.CKey) (connection.Metadata, bool)
 	UpdateConnectionTimeout(ctx context.Context, conn net.Conn) error
 	MetadataByConnection(net.Conn) (connection.Metadata, error)
+	RecordActivity(md connection.Metadata, act profile.Activity)
+	MatchSignatures(md connection.Metadata, handler string, payload []byte) []*signatures.Signature
+	Store(md connection.Metadata, data []byte, info artifact.Info) (string, error)
+	FetchSamples(md connection.Metadata, handler string, payload []byte)
+	Authenticate(md connection.Metadata, handler, username, password string) bool
+	Persona(md connection.Metadata) *persona.Persona
+	ListenData(md connection.Metadata) (net.Listener, error)
+	TLSConfig(md connection.Metadata) (*tls.Config, error)
 }
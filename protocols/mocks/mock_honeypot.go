@@ -3,6 +3,8 @@
 package mocks
 
 import (
+	artifact "github.com/mushorg/glutton/artifact"
+
 	context "context"
 
 	connection "github.com/mushorg/glutton/connection"
@@ -10,6 +12,16 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	net "net"
+
+	persona "github.com/mushorg/glutton/persona"
+
+	producer "github.com/mushorg/glutton/producer"
+
+	profile "github.com/mushorg/glutton/profile"
+
+	signatures "github.com/mushorg/glutton/signatures"
+
+	tls "crypto/tls"
 )
 
 // MockHoneypot is an autogenerated mock type for the Honeypot type
@@ -25,8 +37,57 @@ func (_m *MockHoneypot) EXPECT() *MockHoneypot_Expecter {
 	return &MockHoneypot_Expecter{mock: &_m.Mock}
 }
 
+// Authenticate provides a mock function with given fields: md, handler, username, password
+func (_m *MockHoneypot) Authenticate(md connection.Metadata, handler string, username string, password string) bool {
+	ret := _m.Called(md, handler, username, password)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Authenticate")
+	}
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(connection.Metadata, string, string, string) bool); ok {
+		r0 = rf(md, handler, username, password)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// MockHoneypot_Authenticate_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Authenticate'
+type MockHoneypot_Authenticate_Call struct {
+	*mock.Call
+}
+
+// Authenticate is a helper method to define mock.On call
+//   - md connection.Metadata
+//   - handler string
+//   - username string
+//   - password string
+func (_e *MockHoneypot_Expecter) Authenticate(md interface{}, handler interface{}, username interface{}, password interface{}) *MockHoneypot_Authenticate_Call {
+	return &MockHoneypot_Authenticate_Call{Call: _e.mock.On("Authenticate", md, handler, username, password)}
+}
+
+func (_c *MockHoneypot_Authenticate_Call) Run(run func(md connection.Metadata, handler string, username string, password string)) *MockHoneypot_Authenticate_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata), args[1].(string), args[2].(string), args[3].(string))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_Authenticate_Call) Return(_a0 bool) *MockHoneypot_Authenticate_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockHoneypot_Authenticate_Call) RunAndReturn(run func(connection.Metadata, string, string, string) bool) *MockHoneypot_Authenticate_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // ConnectionByFlow provides a mock function with given fields: _a0
-func (_m *MockHoneypot) ConnectionByFlow(_a0 [2]uint64) connection.Metadata {
+func (_m *MockHoneypot) ConnectionByFlow(_a0 connection.CKey) (connection.Metadata, bool) {
 	ret := _m.Called(_a0)
 
 	if len(ret) == 0 {
@@ -34,13 +95,23 @@ func (_m *MockHoneypot) ConnectionByFlow(_a0 [2]uint64) connection.Metadata {
 	}
 
 	var r0 connection.Metadata
-	if rf, ok := ret.Get(0).(func([2]uint64) connection.Metadata); ok {
+	var r1 bool
+	if rf, ok := ret.Get(0).(func(connection.CKey) (connection.Metadata, bool)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(connection.CKey) connection.Metadata); ok {
 		r0 = rf(_a0)
 	} else {
 		r0 = ret.Get(0).(connection.Metadata)
 	}
 
-	return r0
+	if rf, ok := ret.Get(1).(func(connection.CKey) bool); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
 }
 
 // MockHoneypot_ConnectionByFlow_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ConnectionByFlow'
@@ -49,24 +120,167 @@ type MockHoneypot_ConnectionByFlow_Call struct {
 }
 
 // ConnectionByFlow is a helper method to define mock.On call
-//   - _a0 [2]uint64
+//   - _a0 connection.CKey
 func (_e *MockHoneypot_Expecter) ConnectionByFlow(_a0 interface{}) *MockHoneypot_ConnectionByFlow_Call {
 	return &MockHoneypot_ConnectionByFlow_Call{Call: _e.mock.On("ConnectionByFlow", _a0)}
 }
 
-func (_c *MockHoneypot_ConnectionByFlow_Call) Run(run func(_a0 [2]uint64)) *MockHoneypot_ConnectionByFlow_Call {
+func (_c *MockHoneypot_ConnectionByFlow_Call) Run(run func(_a0 connection.CKey)) *MockHoneypot_ConnectionByFlow_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.CKey))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_ConnectionByFlow_Call) Return(_a0 connection.Metadata, _a1 bool) *MockHoneypot_ConnectionByFlow_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockHoneypot_ConnectionByFlow_Call) RunAndReturn(run func(connection.CKey) (connection.Metadata, bool)) *MockHoneypot_ConnectionByFlow_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// FetchSamples provides a mock function with given fields: md, handler, payload
+func (_m *MockHoneypot) FetchSamples(md connection.Metadata, handler string, payload []byte) {
+	_m.Called(md, handler, payload)
+}
+
+// MockHoneypot_FetchSamples_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'FetchSamples'
+type MockHoneypot_FetchSamples_Call struct {
+	*mock.Call
+}
+
+// FetchSamples is a helper method to define mock.On call
+//   - md connection.Metadata
+//   - handler string
+//   - payload []byte
+func (_e *MockHoneypot_Expecter) FetchSamples(md interface{}, handler interface{}, payload interface{}) *MockHoneypot_FetchSamples_Call {
+	return &MockHoneypot_FetchSamples_Call{Call: _e.mock.On("FetchSamples", md, handler, payload)}
+}
+
+func (_c *MockHoneypot_FetchSamples_Call) Run(run func(md connection.Metadata, handler string, payload []byte)) *MockHoneypot_FetchSamples_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata), args[1].(string), args[2].([]byte))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_FetchSamples_Call) Return() *MockHoneypot_FetchSamples_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockHoneypot_FetchSamples_Call) RunAndReturn(run func(connection.Metadata, string, []byte)) *MockHoneypot_FetchSamples_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ListenData provides a mock function with given fields: md
+func (_m *MockHoneypot) ListenData(md connection.Metadata) (net.Listener, error) {
+	ret := _m.Called(md)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListenData")
+	}
+
+	var r0 net.Listener
+	var r1 error
+	if rf, ok := ret.Get(0).(func(connection.Metadata) (net.Listener, error)); ok {
+		return rf(md)
+	}
+	if rf, ok := ret.Get(0).(func(connection.Metadata) net.Listener); ok {
+		r0 = rf(md)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(net.Listener)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(connection.Metadata) error); ok {
+		r1 = rf(md)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockHoneypot_ListenData_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ListenData'
+type MockHoneypot_ListenData_Call struct {
+	*mock.Call
+}
+
+// ListenData is a helper method to define mock.On call
+//   - md connection.Metadata
+func (_e *MockHoneypot_Expecter) ListenData(md interface{}) *MockHoneypot_ListenData_Call {
+	return &MockHoneypot_ListenData_Call{Call: _e.mock.On("ListenData", md)}
+}
+
+func (_c *MockHoneypot_ListenData_Call) Run(run func(md connection.Metadata)) *MockHoneypot_ListenData_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_ListenData_Call) Return(_a0 net.Listener, _a1 error) *MockHoneypot_ListenData_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockHoneypot_ListenData_Call) RunAndReturn(run func(connection.Metadata) (net.Listener, error)) *MockHoneypot_ListenData_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MatchSignatures provides a mock function with given fields: md, handler, payload
+func (_m *MockHoneypot) MatchSignatures(md connection.Metadata, handler string, payload []byte) []*signatures.Signature {
+	ret := _m.Called(md, handler, payload)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MatchSignatures")
+	}
+
+	var r0 []*signatures.Signature
+	if rf, ok := ret.Get(0).(func(connection.Metadata, string, []byte) []*signatures.Signature); ok {
+		r0 = rf(md, handler, payload)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*signatures.Signature)
+		}
+	}
+
+	return r0
+}
+
+// MockHoneypot_MatchSignatures_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MatchSignatures'
+type MockHoneypot_MatchSignatures_Call struct {
+	*mock.Call
+}
+
+// MatchSignatures is a helper method to define mock.On call
+//   - md connection.Metadata
+//   - handler string
+//   - payload []byte
+func (_e *MockHoneypot_Expecter) MatchSignatures(md interface{}, handler interface{}, payload interface{}) *MockHoneypot_MatchSignatures_Call {
+	return &MockHoneypot_MatchSignatures_Call{Call: _e.mock.On("MatchSignatures", md, handler, payload)}
+}
+
+func (_c *MockHoneypot_MatchSignatures_Call) Run(run func(md connection.Metadata, handler string, payload []byte)) *MockHoneypot_MatchSignatures_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].([2]uint64))
+		run(args[0].(connection.Metadata), args[1].(string), args[2].([]byte))
 	})
 	return _c
 }
 
-func (_c *MockHoneypot_ConnectionByFlow_Call) Return(_a0 connection.Metadata) *MockHoneypot_ConnectionByFlow_Call {
+func (_c *MockHoneypot_MatchSignatures_Call) Return(_a0 []*signatures.Signature) *MockHoneypot_MatchSignatures_Call {
 	_c.Call.Return(_a0)
 	return _c
 }
 
-func (_c *MockHoneypot_ConnectionByFlow_Call) RunAndReturn(run func([2]uint64) connection.Metadata) *MockHoneypot_ConnectionByFlow_Call {
+func (_c *MockHoneypot_MatchSignatures_Call) RunAndReturn(run func(connection.Metadata, string, []byte) []*signatures.Signature) *MockHoneypot_MatchSignatures_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -127,17 +341,72 @@ func (_c *MockHoneypot_MetadataByConnection_Call) RunAndReturn(run func(net.Conn
 	return _c
 }
 
-// ProduceTCP provides a mock function with given fields: protocol, conn, md, payload, decoded
-func (_m *MockHoneypot) ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}) error {
-	ret := _m.Called(protocol, conn, md, payload, decoded)
+// Persona provides a mock function with given fields: md
+func (_m *MockHoneypot) Persona(md connection.Metadata) *persona.Persona {
+	ret := _m.Called(md)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Persona")
+	}
+
+	var r0 *persona.Persona
+	if rf, ok := ret.Get(0).(func(connection.Metadata) *persona.Persona); ok {
+		r0 = rf(md)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*persona.Persona)
+		}
+	}
+
+	return r0
+}
+
+// MockHoneypot_Persona_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Persona'
+type MockHoneypot_Persona_Call struct {
+	*mock.Call
+}
+
+// Persona is a helper method to define mock.On call
+//   - md connection.Metadata
+func (_e *MockHoneypot_Expecter) Persona(md interface{}) *MockHoneypot_Persona_Call {
+	return &MockHoneypot_Persona_Call{Call: _e.mock.On("Persona", md)}
+}
+
+func (_c *MockHoneypot_Persona_Call) Run(run func(md connection.Metadata)) *MockHoneypot_Persona_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_Persona_Call) Return(_a0 *persona.Persona) *MockHoneypot_Persona_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockHoneypot_Persona_Call) RunAndReturn(run func(connection.Metadata) *persona.Persona) *MockHoneypot_Persona_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ProduceTCP provides a mock function with given fields: protocol, conn, md, payload, decoded, opts
+func (_m *MockHoneypot) ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...producer.EventOption) error {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, protocol, conn, md, payload, decoded)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	if len(ret) == 0 {
 		panic("no return value specified for ProduceTCP")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, net.Conn, connection.Metadata, []byte, interface{}) error); ok {
-		r0 = rf(protocol, conn, md, payload, decoded)
+	if rf, ok := ret.Get(0).(func(string, net.Conn, connection.Metadata, []byte, interface{}, ...producer.EventOption) error); ok {
+		r0 = rf(protocol, conn, md, payload, decoded, opts...)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -156,13 +425,21 @@ type MockHoneypot_ProduceTCP_Call struct {
 //   - md connection.Metadata
 //   - payload []byte
 //   - decoded interface{}
-func (_e *MockHoneypot_Expecter) ProduceTCP(protocol interface{}, conn interface{}, md interface{}, payload interface{}, decoded interface{}) *MockHoneypot_ProduceTCP_Call {
-	return &MockHoneypot_ProduceTCP_Call{Call: _e.mock.On("ProduceTCP", protocol, conn, md, payload, decoded)}
+//   - opts ...producer.EventOption
+func (_e *MockHoneypot_Expecter) ProduceTCP(protocol interface{}, conn interface{}, md interface{}, payload interface{}, decoded interface{}, opts ...interface{}) *MockHoneypot_ProduceTCP_Call {
+	return &MockHoneypot_ProduceTCP_Call{Call: _e.mock.On("ProduceTCP",
+		append([]interface{}{protocol, conn, md, payload, decoded}, opts...)...)}
 }
 
-func (_c *MockHoneypot_ProduceTCP_Call) Run(run func(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{})) *MockHoneypot_ProduceTCP_Call {
+func (_c *MockHoneypot_ProduceTCP_Call) Run(run func(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...producer.EventOption)) *MockHoneypot_ProduceTCP_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(net.Conn), args[2].(connection.Metadata), args[3].([]byte), args[4].(interface{}))
+		variadicArgs := make([]producer.EventOption, len(args)-5)
+		for i, a := range args[5:] {
+			if a != nil {
+				variadicArgs[i] = a.(producer.EventOption)
+			}
+		}
+		run(args[0].(string), args[1].(net.Conn), args[2].(connection.Metadata), args[3].([]byte), args[4].(interface{}), variadicArgs...)
 	})
 	return _c
 }
@@ -172,7 +449,7 @@ func (_c *MockHoneypot_ProduceTCP_Call) Return(_a0 error) *MockHoneypot_ProduceT
 	return _c
 }
 
-func (_c *MockHoneypot_ProduceTCP_Call) RunAndReturn(run func(string, net.Conn, connection.Metadata, []byte, interface{}) error) *MockHoneypot_ProduceTCP_Call {
+func (_c *MockHoneypot_ProduceTCP_Call) RunAndReturn(run func(string, net.Conn, connection.Metadata, []byte, interface{}, ...producer.EventOption) error) *MockHoneypot_ProduceTCP_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -228,6 +505,156 @@ func (_c *MockHoneypot_ProduceUDP_Call) RunAndReturn(run func(string, *net.UDPAd
 	return _c
 }
 
+// RecordActivity provides a mock function with given fields: md, act
+func (_m *MockHoneypot) RecordActivity(md connection.Metadata, act profile.Activity) {
+	_m.Called(md, act)
+}
+
+// MockHoneypot_RecordActivity_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordActivity'
+type MockHoneypot_RecordActivity_Call struct {
+	*mock.Call
+}
+
+// RecordActivity is a helper method to define mock.On call
+//   - md connection.Metadata
+//   - act profile.Activity
+func (_e *MockHoneypot_Expecter) RecordActivity(md interface{}, act interface{}) *MockHoneypot_RecordActivity_Call {
+	return &MockHoneypot_RecordActivity_Call{Call: _e.mock.On("RecordActivity", md, act)}
+}
+
+func (_c *MockHoneypot_RecordActivity_Call) Run(run func(md connection.Metadata, act profile.Activity)) *MockHoneypot_RecordActivity_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata), args[1].(profile.Activity))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_RecordActivity_Call) Return() *MockHoneypot_RecordActivity_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockHoneypot_RecordActivity_Call) RunAndReturn(run func(connection.Metadata, profile.Activity)) *MockHoneypot_RecordActivity_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Store provides a mock function with given fields: md, data, info
+func (_m *MockHoneypot) Store(md connection.Metadata, data []byte, info artifact.Info) (string, error) {
+	ret := _m.Called(md, data, info)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Store")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(connection.Metadata, []byte, artifact.Info) (string, error)); ok {
+		return rf(md, data, info)
+	}
+	if rf, ok := ret.Get(0).(func(connection.Metadata, []byte, artifact.Info) string); ok {
+		r0 = rf(md, data, info)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(connection.Metadata, []byte, artifact.Info) error); ok {
+		r1 = rf(md, data, info)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockHoneypot_Store_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Store'
+type MockHoneypot_Store_Call struct {
+	*mock.Call
+}
+
+// Store is a helper method to define mock.On call
+//   - md connection.Metadata
+//   - data []byte
+//   - info artifact.Info
+func (_e *MockHoneypot_Expecter) Store(md interface{}, data interface{}, info interface{}) *MockHoneypot_Store_Call {
+	return &MockHoneypot_Store_Call{Call: _e.mock.On("Store", md, data, info)}
+}
+
+func (_c *MockHoneypot_Store_Call) Run(run func(md connection.Metadata, data []byte, info artifact.Info)) *MockHoneypot_Store_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata), args[1].([]byte), args[2].(artifact.Info))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_Store_Call) Return(_a0 string, _a1 error) *MockHoneypot_Store_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockHoneypot_Store_Call) RunAndReturn(run func(connection.Metadata, []byte, artifact.Info) (string, error)) *MockHoneypot_Store_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TLSConfig provides a mock function with given fields: md
+func (_m *MockHoneypot) TLSConfig(md connection.Metadata) (*tls.Config, error) {
+	ret := _m.Called(md)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TLSConfig")
+	}
+
+	var r0 *tls.Config
+	var r1 error
+	if rf, ok := ret.Get(0).(func(connection.Metadata) (*tls.Config, error)); ok {
+		return rf(md)
+	}
+	if rf, ok := ret.Get(0).(func(connection.Metadata) *tls.Config); ok {
+		r0 = rf(md)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*tls.Config)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(connection.Metadata) error); ok {
+		r1 = rf(md)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockHoneypot_TLSConfig_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TLSConfig'
+type MockHoneypot_TLSConfig_Call struct {
+	*mock.Call
+}
+
+// TLSConfig is a helper method to define mock.On call
+//   - md connection.Metadata
+func (_e *MockHoneypot_Expecter) TLSConfig(md interface{}) *MockHoneypot_TLSConfig_Call {
+	return &MockHoneypot_TLSConfig_Call{Call: _e.mock.On("TLSConfig", md)}
+}
+
+func (_c *MockHoneypot_TLSConfig_Call) Run(run func(md connection.Metadata)) *MockHoneypot_TLSConfig_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(connection.Metadata))
+	})
+	return _c
+}
+
+func (_c *MockHoneypot_TLSConfig_Call) Return(_a0 *tls.Config, _a1 error) *MockHoneypot_TLSConfig_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockHoneypot_TLSConfig_Call) RunAndReturn(run func(connection.Metadata) (*tls.Config, error)) *MockHoneypot_TLSConfig_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // UpdateConnectionTimeout provides a mock function with given fields: ctx, conn
 func (_m *MockHoneypot) UpdateConnectionTimeout(ctx context.Context, conn net.Conn) error {
 	ret := _m.Called(ctx, conn)
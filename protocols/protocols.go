@@ -2,6 +2,7 @@ package protocols
 
 import (
 	"context"
+	"log/slog"
 	"net"
 	"strings"
 
@@ -34,6 +35,12 @@ func MapTCPProtocolHandlers(log interfaces.Logger, h interfaces.Honeypot) map[st
 	protocolHandlers["smtp"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
 		return tcp.HandleSMTP(ctx, conn, md, log, h)
 	}
+	protocolHandlers["pop3"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		return tcp.HandlePOP3(ctx, conn, md, log, h)
+	}
+	protocolHandlers["imap"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		return tcp.HandleIMAP(ctx, conn, md, log, h)
+	}
 	protocolHandlers["rdp"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
 		return tcp.HandleRDP(ctx, conn, md, log, h)
 	}
@@ -79,7 +86,8 @@ func MapTCPProtocolHandlers(log interfaces.Logger, h interfaces.Honeypot) map[st
 	protocolHandlers["proxy_tcp"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
 		return tcp.HandleProxyTCP(ctx, conn, md, log, h)
 	}
-	protocolHandlers["tcp"] = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+	var handleTCP TCPHandlerFunc
+	handleTCP = func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
 		snip, bufConn, err := Peek(conn, 4)
 		if err != nil {
 			if err := conn.Close(); err != nil {
@@ -89,6 +97,22 @@ func MapTCPProtocolHandlers(log interfaces.Logger, h interfaces.Honeypot) map[st
 			return nil
 		}
 
+		if md.TLS == nil && isClientHello(snip) {
+			bufConn, md = peekClientHello(bufConn, md, log)
+			// terminates TLS and inspects the decrypted stream like a plain one
+			if viper.GetBool("tls.detect") {
+				tlsConn, md, err := terminateTLS(ctx, bufConn, md, log, h)
+				if err != nil {
+					if err := conn.Close(); err != nil {
+						log.Error("failed to close connection", producer.ErrAttr(err))
+					}
+					log.Debug("TLS handshake failed", slog.String("handler", "tcp"), producer.ErrAttr(err))
+					return nil
+				}
+				return handleTCP(ctx, tlsConn, md)
+			}
+		}
+
 		// Uses a basic spicy parser to detect application protocol from tcp payload
 		if viper.GetBool("spicy.enabled") {
 			if protocol, ok := parseTCPProtocol(snip, log); ok {
@@ -114,6 +138,11 @@ func MapTCPProtocolHandlers(log interfaces.Logger, h interfaces.Honeypot) map[st
 		// fallback TCP handler
 		return tcp.HandleTCP(ctx, bufConn, md, log, h)
 	}
+	protocolHandlers["tcp"] = handleTCP
+
+	for name, handler := range protocolHandlers {
+		protocolHandlers[name] = withTLS(handler, log, h)
+	}
 	return protocolHandlers
 }
 
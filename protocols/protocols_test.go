@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
 	"github.com/mushorg/glutton/protocols/mocks"
 	"github.com/mushorg/glutton/protocols/spicy"
@@ -27,6 +28,16 @@ func testConn(t *testing.T) (net.Conn, func() error) {
 func TestMapUDPProtocolHandlers(t *testing.T) {
 	h := &mocks.MockHoneypot{}
 	h.EXPECT().ProduceUDP(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	store, err := artifact.Open(t.TempDir(), artifact.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+	h.EXPECT().Store(mock.Anything, mock.Anything, mock.Anything).RunAndReturn(func(_ connection.Metadata, data []byte, info artifact.Info) (string, error) {
+		meta, err := store.Put(data, info)
+		if err != nil {
+			return "", err
+		}
+		return meta.SHA256, nil
+	}).Maybe()
 
 	l := &mocks.MockLogger{}
 	l.EXPECT().Info(mock.Anything).Return().Maybe()
@@ -37,8 +48,11 @@ func TestMapUDPProtocolHandlers(t *testing.T) {
 	l.AssertExpectations(t)
 	require.Contains(t, m, "udp", "expected UDP handler")
 	ctx := context.Background()
-	err := m["udp"](ctx, &net.UDPAddr{}, &net.UDPAddr{}, []byte{}, connection.Metadata{})
+	err = m["udp"](ctx, &net.UDPAddr{}, &net.UDPAddr{}, []byte{}, connection.Metadata{})
 	require.NoError(t, err, "expected no error from connection handler")
+	meta, err := store.Get("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	require.NoError(t, err, "payload should be stored in the artifact store")
+	require.Equal(t, artifact.KindPayload, meta.Kind)
 }
 
 func TestMapTCPProtocolHandlers(t *testing.T) {
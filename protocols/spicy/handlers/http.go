@@ -16,6 +16,7 @@ import (
 	"github.com/mushorg/glutton/protocols/interfaces"
 	"github.com/mushorg/glutton/protocols/spicy"
 	"github.com/mushorg/glutton/protocols/tcp"
+	"github.com/mushorg/glutton/signatures"
 )
 
 // Identical implementation of the original Go HTTP handler, but using Spicy for parsing
@@ -51,24 +52,6 @@ func handleEthereumRPC(body []byte, conn net.Conn) bool {
 	return true
 }
 
-func handleYarnNewApplication(method, uri string, conn net.Conn) bool {
-	if method != "POST" || !strings.Contains(uri, "cluster/apps/new-application") {
-		return false
-	}
-	resp, _ := json.Marshal(&struct {
-		ApplicationID             string      `json:"application-id"`
-		MaximumResourceCapability interface{} `json:"maximum-resource-capability"`
-	}{
-		ApplicationID: "application_1527144634877_20465",
-		MaximumResourceCapability: struct {
-			Memory int `json:"memory"`
-			VCores int `json:"vCores"`
-		}{Memory: 16384, VCores: 8},
-	})
-	_ = sendJSON(conn, resp)
-	return true
-}
-
 func handleWallet(uri string, conn net.Conn) bool {
 	if !strings.Contains(uri, "wallet") {
 		return false
@@ -94,45 +77,6 @@ func handleDockerAPIVersion(path string, conn net.Conn, log interfaces.Logger) b
 	return true
 }
 
-func handleCitrixSMB(path string, conn net.Conn) bool {
-	if !strings.HasPrefix(path, "/vpn/") {
-		return false
-	}
-	headers := `Server: Apache
-X-Frame-Options: SAMEORIGIN
-Last-Modified: Thu, 28 Nov 2019 20:19:22 GMT
-ETag: "53-5986dd42b0680"
-Accept-Ranges: bytes
-Content-Length: 93
-X-XSS-Protection: 1; mode=block
-X-Content-Type-Options: nosniff
-Content-Type: text/plain; charset=UTF-8`
-	smbCfg := "\r\n\r\n[global]\r\n\tencrypt passwords = yes\r\n\tname resolve order = lmhosts wins host bcast\r\n"
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n" + headers + smbCfg))
-	return true
-}
-
-func handleVMwareSend(ctx context.Context, body []byte, uri string, md connection.Metadata, log interfaces.Logger, hp interfaces.Honeypot) bool {
-	if !strings.Contains(uri, "hyper/send") || len(body) == 0 {
-		return false
-	}
-	parts := strings.Split(string(body), " ")
-	if len(parts) < 11 {
-		return false
-	}
-	c, err := net.Dial("tcp", parts[9]+":"+parts[10])
-	if err != nil {
-		log.Error("vmware-send dial failed", producer.ErrAttr(err))
-		return true
-	}
-	go func() {
-		if err := tcp.HandleTCP(ctx, c, md, log, hp); err != nil {
-			log.Error("vmware-send TCP relay error", producer.ErrAttr(err))
-		}
-	}()
-	return true
-}
-
 func HandleHTTP(ctx context.Context, conn net.Conn, md connection.Metadata, log interfaces.Logger, hp interfaces.Honeypot) error {
 	defer conn.Close()
 
@@ -190,15 +134,21 @@ func HandleHTTP(ctx context.Context, conn net.Conn, md connection.Metadata, log
 		log.Info("HTTP payload:\n" + hex.Dump(body[:max]))
 	}
 
+	hp.FetchSamples(md, "http", append([]byte(uriRaw+"\n"), body...))
+	matches := hp.MatchSignatures(md, "http", payload)
 	_ = hp.ProduceTCP("http", conn, md, payload, parsed)
 
+	if sig := signatures.Responder(matches); sig != nil {
+		return tcp.RespondSignature(ctx, conn, md, log, hp, sig, body)
+	}
+
 	handled := false
 	switch method {
 	case "POST":
-		handled = handleEthereumRPC(body, conn) || handleYarnNewApplication(method, uriRaw, conn)
+		handled = handleEthereumRPC(body, conn)
 	}
 
-	handled = handled || handleWallet(uriRaw, conn) || handleDockerAPIVersion(path, conn, log) || handleCitrixSMB(path, conn) || handleVMwareSend(ctx, body, uriRaw, md, log, hp)
+	handled = handled || handleWallet(uriRaw, conn) || handleDockerAPIVersion(path, conn, log)
 
 	if !handled {
 		_ = writePlainOK(conn)
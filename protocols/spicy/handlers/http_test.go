@@ -12,6 +12,7 @@ import (
 	"github.com/mushorg/glutton/protocols/mocks"
 	"github.com/mushorg/glutton/protocols/spicy"
 	"github.com/mushorg/glutton/rules"
+	"github.com/mushorg/glutton/signatures"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -81,13 +82,19 @@ func runHTTPHandler(t *testing.T, request string) *mockConn {
 	logger := createMockLogger()
 	honeypot := &mocks.MockHoneypot{}
 	honeypot.EXPECT().ProduceTCP("http", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	engine, err := signatures.Load("../../../config/signatures.yaml")
+	require.NoError(t, err)
+	honeypot.EXPECT().MatchSignatures(mock.Anything, "http", mock.Anything).RunAndReturn(func(_ connection.Metadata, handler string, payload []byte) []*signatures.Signature {
+		return engine.Match(handler, payload)
+	})
+	honeypot.EXPECT().FetchSamples(mock.Anything, "http", mock.Anything).Return()
 
 	md := connection.Metadata{
 		TargetPort: 80,
 		Rule:       &rules.Rule{Target: "http"},
 	}
 
-	err := HandleHTTP(context.Background(), conn, md, logger, honeypot)
+	err = HandleHTTP(context.Background(), conn, md, logger, honeypot)
 	require.NoError(t, err)
 	require.True(t, conn.closed)
 
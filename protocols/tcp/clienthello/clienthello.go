@@ -0,0 +1,371 @@
+// Package clienthello parses the TLS ClientHello a connection starts with
+// and computes its JA3 and JA4 fingerprints, which tell TLS libraries and
+// the tools built on them apart without terminating TLS.
+package clienthello
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordHeaderLen = 5
+	// MaxRecordLen is the longest TLS record, header included
+	MaxRecordLen = recordHeaderLen + 1<<14
+
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+)
+
+// Extension types
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var (
+	errNotHandshake   = errors.New("not a TLS handshake record")
+	errNotClientHello = errors.New("not a ClientHello")
+	errTruncated      = errors.New("truncated ClientHello")
+)
+
+// Hello is a parsed ClientHello, values in the order the client sent them
+type Hello struct {
+	// Version is the legacy client_version, 0x0303 for TLS 1.2 and 1.3
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	// SupportedVersions are the versions a TLS 1.3 client offers
+	SupportedVersions []uint16
+	ServerName        string
+	ALPN              []string
+}
+
+// RecordLen returns the length of the TLS record data starts with, header
+// included. ok is false if data doesn't start with a handshake record.
+func RecordLen(data []byte) (int, bool) {
+	if len(data) < recordHeaderLen || data[0] != recordTypeHandshake || data[1] != 0x03 {
+		return 0, false
+	}
+	return recordHeaderLen + int(binary.BigEndian.Uint16(data[3:5])), true
+}
+
+// reader reads the big endian fields of a TLS message
+type reader []byte
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+// vector reads a vector with a length prefix of size bytes
+func (r *reader) vector(size int) (reader, bool) {
+	b, ok := r.bytes(size)
+	if !ok {
+		return nil, false
+	}
+	var n int
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	v, ok := r.bytes(n)
+	return reader(v), ok
+}
+
+// uint16s reads a vector of 16 bit values with a length prefix of size bytes
+func (r *reader) uint16s(size int) ([]uint16, bool) {
+	v, ok := r.vector(size)
+	if !ok || len(v)%2 != 0 {
+		return nil, false
+	}
+	values := make([]uint16, 0, len(v)/2)
+	for len(v) > 0 {
+		value, _ := v.uint16()
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// Parse parses the ClientHello in the TLS record data starts with. A
+// ClientHello spread over several records is refused as truncated.
+func Parse(data []byte) (*Hello, error) {
+	n, ok := RecordLen(data)
+	if !ok {
+		return nil, errNotHandshake
+	}
+	if len(data) < n {
+		return nil, errTruncated
+	}
+	r := reader(data[recordHeaderLen:n])
+	if msgType, ok := r.uint8(); !ok || msgType != handshakeTypeClientHello {
+		return nil, errNotClientHello
+	}
+	body, ok := r.vector(3)
+	if !ok {
+		return nil, errTruncated
+	}
+
+	h := &Hello{}
+	if h.Version, ok = body.uint16(); !ok {
+		return nil, errTruncated
+	}
+	// random and session ID
+	if _, ok := body.bytes(32); !ok {
+		return nil, errTruncated
+	}
+	if _, ok := body.vector(1); !ok {
+		return nil, errTruncated
+	}
+	if h.CipherSuites, ok = body.uint16s(2); !ok {
+		return nil, errTruncated
+	}
+	// compression methods
+	if _, ok := body.vector(1); !ok {
+		return nil, errTruncated
+	}
+	if len(body) == 0 {
+		// no extensions, like SSL 3.0 clients
+		return h, nil
+	}
+
+	extensions, ok := body.vector(2)
+	if !ok {
+		return nil, errTruncated
+	}
+	for len(extensions) > 0 {
+		extType, ok := extensions.uint16()
+		if !ok {
+			return nil, errTruncated
+		}
+		ext, ok := extensions.vector(2)
+		if !ok {
+			return nil, errTruncated
+		}
+		h.Extensions = append(h.Extensions, extType)
+		if err := h.parseExtension(extType, ext); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// CipherSuiteNames names cipher suites, unknown ones in hex
+func CipherSuiteNames(ids []uint16) []string {
+	var names []string
+	for _, id := range ids {
+		names = append(names, tls.CipherSuiteName(id))
+	}
+	return names
+}
+
+// parseExtension reads the extensions the fingerprints and events use
+func (h *Hello) parseExtension(extType uint16, ext reader) error {
+	var ok bool
+	switch extType {
+	case extServerName:
+		names, ok := ext.vector(2)
+		if !ok {
+			return fmt.Errorf("malformed server_name extension")
+		}
+		for len(names) > 0 {
+			nameType, _ := names.uint8()
+			name, ok := names.vector(2)
+			if !ok {
+				return fmt.Errorf("malformed server_name extension")
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+			}
+		}
+		return nil
+	case extSupportedGroups:
+		h.SupportedGroups, ok = ext.uint16s(2)
+	case extECPointFormats:
+		var formats reader
+		formats, ok = ext.vector(1)
+		h.PointFormats = []uint8(formats)
+	case extSignatureAlgorithms:
+		h.SignatureAlgorithms, ok = ext.uint16s(2)
+	case extALPN:
+		var protocols reader
+		if protocols, ok = ext.vector(2); !ok {
+			break
+		}
+		for len(protocols) > 0 {
+			var protocol reader
+			if protocol, ok = protocols.vector(1); !ok {
+				break
+			}
+			h.ALPN = append(h.ALPN, string(protocol))
+		}
+	case extSupportedVersions:
+		var versions reader
+		if versions, ok = ext.vector(1); !ok || len(versions)%2 != 0 {
+			ok = false
+			break
+		}
+		for len(versions) > 0 {
+			version, _ := versions.uint16()
+			h.SupportedVersions = append(h.SupportedVersions, version)
+		}
+	default:
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("malformed extension %#04x", extType)
+	}
+	return nil
+}
+
+// isGREASE reports whether a value is one of the reserved GREASE values
+// clients send to keep servers tolerant, RFC 8701. Fingerprints skip them
+// since they change from connection to connection.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGREASE returns values without GREASE values
+func withoutGREASE(values []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(values), isGREASE)
+}
+
+func join[T uint8 | uint16](values []T, format func(T) string, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = format(v)
+	}
+	return strings.Join(parts, sep)
+}
+
+func decimal[T uint8 | uint16](v T) string {
+	return strconv.Itoa(int(v))
+}
+
+func hex4(v uint16) string {
+	return fmt.Sprintf("%04x", v)
+}
+
+// JA3String returns the JA3 fingerprint before hashing: version, cipher
+// suites, extensions, supported groups and point formats
+func (h *Hello) JA3String() string {
+	return strings.Join([]string{
+		decimal(h.Version),
+		join(withoutGREASE(h.CipherSuites), decimal[uint16], "-"),
+		join(withoutGREASE(h.Extensions), decimal[uint16], "-"),
+		join(withoutGREASE(h.SupportedGroups), decimal[uint16], "-"),
+		join(h.PointFormats, decimal[uint8], "-"),
+	}, ",")
+}
+
+// JA3 returns the JA3 fingerprint, the MD5 hash of JA3String
+func (h *Hello) JA3() string {
+	sum := md5.Sum([]byte(h.JA3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4Versions are the JA4 codes of protocol versions
+var ja4Versions = map[uint16]string{
+	0x0304: "13",
+	0x0303: "12",
+	0x0302: "11",
+	0x0301: "10",
+	0x0300: "s3",
+	0x0200: "s2",
+	0xfeff: "d1",
+	0xfefd: "d2",
+	0xfefc: "d3",
+}
+
+// ja4Hash returns the truncated SHA-256 hash of a JA4 list
+func ja4Hash(list string) string {
+	if list == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(list))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// JA4 returns the JA4 fingerprint of a ClientHello received over TCP
+func (h *Hello) JA4() string {
+	version := h.Version
+	if versions := withoutGREASE(h.SupportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	code, ok := ja4Versions[version]
+	if !ok {
+		code = "00"
+	}
+	sni := "i"
+	if h.ServerName != "" {
+		sni = "d"
+	}
+	ciphers := withoutGREASE(h.CipherSuites)
+	extensions := withoutGREASE(h.Extensions)
+	alpn := "00"
+	if len(h.ALPN) > 0 && h.ALPN[0] != "" {
+		first := h.ALPN[0]
+		alpn = first[:1] + first[len(first)-1:]
+		if !isAlphanumeric(first[0]) || !isAlphanumeric(first[len(first)-1]) {
+			encoded := hex.EncodeToString([]byte(first))
+			alpn = encoded[:1] + encoded[len(encoded)-1:]
+		}
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", code, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	slices.Sort(ciphers)
+	b := ja4Hash(join(ciphers, hex4, ","))
+
+	// SNI and ALPN are already part of the first section
+	extensions = slices.DeleteFunc(extensions, func(ext uint16) bool {
+		return ext == extServerName || ext == extALPN
+	})
+	slices.Sort(extensions)
+	list := join(extensions, hex4, ",")
+	if algorithms := withoutGREASE(h.SignatureAlgorithms); len(algorithms) > 0 {
+		list += "_" + join(algorithms, hex4, ",")
+	}
+	c := ja4Hash(list)
+	if len(extensions) == 0 {
+		c = "000000000000"
+	}
+	return a + "_" + b + "_" + c
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,87 @@
+package clienthello
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testHello has GREASE values in its cipher suites, extensions, groups and
+// versions, SNI example.com and ALPN h2 and http/1.1
+const testHello = "160301008e0100008a0303000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f00000a0a0a13011302c02bc02f010000571a1a000000000010000e00000b6578616d706c652e636f6d00170000000a000800062a2a001d0017000b00020100000d000800060403080404010010000e000c02683208687474702f312e31002b0007063a3a03040303"
+
+func TestParse(t *testing.T) {
+	data, err := hex.DecodeString(testHello)
+	require.NoError(t, err)
+	n, ok := RecordLen(data)
+	require.True(t, ok)
+	require.Equal(t, len(data), n)
+
+	h, err := Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x0303), h.Version)
+	require.Equal(t, "example.com", h.ServerName)
+	require.Equal(t, []string{"h2", "http/1.1"}, h.ALPN)
+	require.Equal(t, []uint16{0x0a0a, 0x1301, 0x1302, 0xc02b, 0xc02f}, h.CipherSuites)
+	require.Equal(t, []uint16{0x3a3a, 0x0304, 0x0303}, h.SupportedVersions)
+
+	require.Equal(t, "771,4865-4866-49195-49199,0-23-10-11-13-16-43,29-23,0", h.JA3String())
+	require.Equal(t, "1b2a6e91e91a26431a1b7474befaf71d", h.JA3())
+	require.Equal(t, "t13d0407h2_e00fd9ffaebd_1fdf4de06b7e", h.JA4())
+
+	_, err = Parse(data[:len(data)-1])
+	require.ErrorIs(t, err, errTruncated)
+	_, err = Parse([]byte("GET / HTTP/1.1\r\n"))
+	require.ErrorIs(t, err, errNotHandshake)
+	// a ServerHello
+	_, err = Parse([]byte{0x16, 0x03, 0x03, 0x00, 0x01, 0x02})
+	require.ErrorIs(t, err, errNotClientHello)
+	// a record header claiming more extension data than there is
+	broken := append([]byte(nil), data...)
+	broken[len(broken)-8] = 0xff
+	_, err = Parse(broken)
+	require.Error(t, err)
+}
+
+func TestJA4(t *testing.T) {
+	// no SNI, ALPN, extensions or TLS 1.3 like old clients
+	h := &Hello{Version: 0x0301, CipherSuites: []uint16{0x002f, 0x0035}}
+	require.Equal(t, "t10i020000_f54dd463d39b_000000000000", h.JA4())
+	require.Equal(t, "769,47-53,,,", h.JA3String())
+
+	// non-alphanumeric ALPN values are taken from their hex form
+	h.ALPN = []string{"\xab\x01"}
+	require.Equal(t, "a1", h.JA4()[8:10])
+	h.ALPN = []string{"x"}
+	require.Equal(t, "xx", h.JA4()[8:10])
+}
+
+func TestParseGoClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		conn := tls.Client(client, &tls.Config{
+			ServerName:   "camera.example",
+			NextProtos:   []string{"http/1.1"},
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		})
+		conn.Handshake()
+		client.Close()
+	}()
+
+	require.NoError(t, server.SetDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, MaxRecordLen)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	h, err := Parse(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, "camera.example", h.ServerName)
+	require.Equal(t, []string{"http/1.1"}, h.ALPN)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, h.CipherSuites)
+	require.Equal(t, "t12d02", h.JA4()[:6])
+}
@@ -0,0 +1,179 @@
+// Package email parses mail messages captured by the SMTP handler into what
+// tells campaigns apart: headers, links and attachments.
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/mushorg/glutton/ioc"
+)
+
+const (
+	// maxDepth caps the nesting of multipart bodies
+	maxDepth = 8
+	// maxParts caps the parts of a message that are looked at
+	maxParts = 64
+	// maxURLs caps the links kept of a message
+	maxURLs = 64
+	// maxAddresses caps the recipients kept of a header
+	maxAddresses = 32
+)
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// Message is what's parsed of a message
+type Message struct {
+	Subject     string       `json:"subject,omitempty"`
+	From        string       `json:"from,omitempty"`
+	To          []string     `json:"to,omitempty"`
+	Cc          []string     `json:"cc,omitempty"`
+	ReplyTo     string       `json:"replyTo,omitempty"`
+	Date        string       `json:"date,omitempty"`
+	MessageID   string       `json:"messageID,omitempty"`
+	Mailer      string       `json:"mailer,omitempty"`
+	ContentType string       `json:"contentType,omitempty"`
+	URLs        []string     `json:"urls,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	parts int
+}
+
+// Parse reads the headers of a message and walks its body for links and
+// attachments. Malformed parts of the body are skipped, only a header that
+// can't be read is an error.
+func Parse(data []byte) (*Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	h := msg.Header
+	m := &Message{
+		Subject:   decodeHeader(h.Get("Subject")),
+		From:      decodeHeader(h.Get("From")),
+		To:        addresses(h.Get("To")),
+		Cc:        addresses(h.Get("Cc")),
+		ReplyTo:   decodeHeader(h.Get("Reply-To")),
+		Date:      h.Get("Date"),
+		MessageID: h.Get("Message-Id"),
+		Mailer:    h.Get("X-Mailer"),
+	}
+	if m.Mailer == "" {
+		m.Mailer = h.Get("User-Agent")
+	}
+	m.ContentType, _, _ = mime.ParseMediaType(h.Get("Content-Type"))
+	m.walk(textproto.MIMEHeader(h), msg.Body, 0)
+	return m, nil
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header, keeping the
+// raw value if a charset is unknown
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// addresses returns the addresses of a recipient header, or the raw value if
+// it isn't a valid address list
+func addresses(value string) []string {
+	if value == "" {
+		return nil
+	}
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{decodeHeader(value)}
+	}
+	var addrs []string
+	for _, addr := range list[:min(len(list), maxAddresses)] {
+		addrs = append(addrs, addr.Address)
+	}
+	return addrs
+}
+
+// walk looks at a part of the message, recursing into multipart bodies
+func (m *Message) walk(h textproto.MIMEHeader, body io.Reader, depth int) {
+	if m.parts >= maxParts {
+		return
+	}
+	m.parts++
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxDepth || params["boundary"] == "" {
+			return
+		}
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextRawPart()
+			if err != nil {
+				return
+			}
+			m.walk(part.Header, part, depth+1)
+		}
+	}
+
+	data, err := io.ReadAll(decode(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil && len(data) == 0 {
+		return
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if disposition == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/") {
+		hash := sha256.Sum256(data)
+		m.Attachments = append(m.Attachments, Attachment{
+			Filename:    decodeHeader(filename),
+			ContentType: mediaType,
+			Size:        len(data),
+			SHA256:      hex.EncodeToString(hash[:]),
+		})
+		return
+	}
+	if mediaType == "text/html" {
+		data = []byte(html.UnescapeString(string(data)))
+	}
+	if iocs := ioc.Extract(data); iocs != nil {
+		for _, u := range iocs.URLs {
+			if len(m.URLs) < maxURLs && !slices.Contains(m.URLs, u) {
+				m.URLs = append(m.URLs, u)
+			}
+		}
+	}
+}
+
+// decode undoes the content transfer encoding of a part
+func decode(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// the decoder skips line breaks
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const multipartMessage = "From: =?UTF-8?B?UGF5UGFs?= <service@paypa1.example>\r\n" +
+	"To: alice@example.com, Bob <bob@example.org>\r\n" +
+	"Subject: =?UTF-8?Q?Your_account_is_locked?=\r\n" +
+	"Date: Mon, 19 Oct 2026 10:00:00 +0000\r\n" +
+	"Message-ID: <1234@paypa1.example>\r\n" +
+	"X-Mailer: Microsoft Outlook 16.0\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Verify at http://paypa1.example/login?id=3D1 now\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<a href=\"http://paypa1.example/login?id=1&amp;x=2\">verify</a>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/zip; name=\"invoice.zip\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.zip\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"UEsDBAoAAAAA\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(multipartMessage))
+	require.NoError(t, err)
+	require.Equal(t, "PayPal <service@paypa1.example>", m.From)
+	require.Equal(t, []string{"alice@example.com", "bob@example.org"}, m.To)
+	require.Equal(t, "Your account is locked", m.Subject)
+	require.Equal(t, "<1234@paypa1.example>", m.MessageID)
+	require.Equal(t, "Microsoft Outlook 16.0", m.Mailer)
+	require.Equal(t, "multipart/mixed", m.ContentType)
+	require.Equal(t, []string{"http://paypa1.example/login?id=1", "http://paypa1.example/login?id=1&x=2"}, m.URLs)
+
+	hash := sha256.Sum256([]byte("PK\x03\x04\x0a\x00\x00\x00\x00"))
+	require.Equal(t, []Attachment{{
+		Filename:    "invoice.zip",
+		ContentType: "application/zip",
+		Size:        9,
+		SHA256:      hex.EncodeToString(hash[:]),
+	}}, m.Attachments)
+}
+
+func TestParsePlain(t *testing.T) {
+	m, err := Parse([]byte("Subject: test\r\nTo: not an address\r\n\r\nhttps://example.com/a\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"not an address"}, m.To)
+	require.Equal(t, []string{"https://example.com/a"}, m.URLs)
+	require.Empty(t, m.Attachments)
+
+	_, err = Parse([]byte("no header"))
+	require.Error(t, err)
+}
@@ -3,34 +3,83 @@ package tcp
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
 	"github.com/mushorg/glutton/protocols/helpers"
 	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/protocols/tcp/shell"
 )
 
+const (
+	// maxFTPLine caps the length of a command line
+	maxFTPLine = 4096
+	// maxFTPUpload caps the bytes kept of an upload, the rest is discarded
+	maxFTPUpload = 32 << 20
+	// ftpTransferTimeout bounds opening a data connection and a transfer
+	ftpTransferTimeout = 60 * time.Second
+)
+
+const ftpFeatures = "211-Features:\r\n EPRT\r\n EPSV\r\n MDTM\r\n PASV\r\n REST STREAM\r\n SIZE\r\n UTF8\r\n211 End\r\n"
+
 type parsedFTP struct {
-	Direction string `json:"direction,omitempty"`
-	Payload   []byte `json:"payload,omitempty"`
+	Direction string     `json:"direction,omitempty"`
+	Payload   []byte     `json:"payload,omitempty"`
+	Upload    *ftpUpload `json:"upload,omitempty"`
+}
+
+// ftpUpload is a file stored by the client
+type ftpUpload struct {
+	Path      string `json:"path"`
+	Size      int    `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 type ftpServer struct {
 	events []parsedFTP
 	conn   net.Conn
+	r      *bufio.Reader
+	md     connection.Metadata
+	logger interfaces.Logger
+	h      interfaces.Honeypot
+
+	fs       *shell.FS
+	cwd      string
+	username string
+	loggedIn bool
+	failures int
+	binary   bool
+	offset   int64
+	// renameFrom is the path of the last RNFR
+	renameFrom string
+	// passive is the listener of the next transfer after PASV or EPSV,
+	// active the client address of the next transfer after PORT or EPRT
+	passive net.Listener
+	active  string
 }
 
-func (s *ftpServer) read(_ interfaces.Logger, _ interfaces.Honeypot) (string, error) {
-	msg, err := bufio.NewReader(s.conn).ReadString('\n')
+func (s *ftpServer) read() (string, error) {
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("command line exceeds %d bytes", maxFTPLine)
+	}
 	if err != nil {
-		return msg, err
+		return string(line), err
 	}
+	msg := string(line)
 	s.events = append(s.events, parsedFTP{
 		Direction: "read",
 		Payload:   []byte(msg),
@@ -48,15 +97,403 @@ func (s *ftpServer) write(msg string) error {
 		Payload:   []byte(msg),
 	})
 	return nil
+}
+
+// resolve returns the absolute path of a command argument
+func (s *ftpServer) resolve(arg string) string {
+	if !path.IsAbs(arg) {
+		arg = path.Join(s.cwd, arg)
+	}
+	return path.Clean(arg)
+}
+
+// closeData forgets the data connection settings of the next transfer
+func (s *ftpServer) closeData() {
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+	s.active = ""
+}
+
+// openData opens the data connection of a transfer and announces it with msg
+func (s *ftpServer) openData(msg string) (net.Conn, error) {
+	defer s.closeData()
+
+	var conn net.Conn
+	var err error
+	switch {
+	case s.passive != nil:
+		if l, ok := s.passive.(interface{ SetDeadline(time.Time) error }); ok {
+			l.SetDeadline(time.Now().Add(ftpTransferTimeout))
+		}
+		conn, err = s.passive.Accept()
+	case s.active != "":
+		conn, err = net.DialTimeout("tcp", s.active, 10*time.Second)
+	default:
+		return nil, s.write("425 Use PORT or PASV first.\r\n")
+	}
+	if err != nil {
+		s.logger.Debug("Failed to open data connection", slog.String("protocol", "ftp"), producer.ErrAttr(err))
+		return nil, s.write("425 Failed to establish connection.\r\n")
+	}
+	conn.SetDeadline(time.Now().Add(ftpTransferTimeout))
+	if err := s.write(msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// passiveMode reserves a port for the next transfer, extended for EPSV
+func (s *ftpServer) passiveMode(extended bool) string {
+	s.closeData()
+	l, err := s.h.ListenData(s.md)
+	if err != nil {
+		s.logger.Debug("Failed to listen for data connection", slog.String("protocol", "ftp"), producer.ErrAttr(err))
+		return "425 Can't open passive connection.\r\n"
+	}
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		l.Close()
+		return "425 Can't open passive connection.\r\n"
+	}
+	if extended {
+		s.passive = l
+		return fmt.Sprintf("229 Entering Extended Passive Mode (|||%d|).\r\n", addr.Port)
+	}
+	ip := addr.IP.To4()
+	if ip == nil {
+		l.Close()
+		return "425 Use EPSV on IPv6.\r\n"
+	}
+	s.passive = l
+	return fmt.Sprintf("227 Entering Passive Mode (%d,%d,%d,%d,%d,%d).\r\n", ip[0], ip[1], ip[2], ip[3], addr.Port>>8, addr.Port&0xff)
+}
+
+// activeMode sets the client address of the next transfer. Only the client
+// itself is accepted, so the sensor can't be used for FTP bounce scans.
+func (s *ftpServer) activeMode(ip net.IP, port int) string {
+	s.closeData()
+	remote, ok := s.conn.RemoteAddr().(*net.TCPAddr)
+	if ip == nil || port <= 0 || port > 65535 || !ok || !remote.IP.Equal(ip) {
+		return "500 Illegal PORT command.\r\n"
+	}
+	s.active = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	return "200 PORT command successful. Consider using PASV.\r\n"
+}
+
+// parsePORT parses the h1,h2,h3,h4,p1,p2 argument of PORT
+func parsePORT(arg string) (net.IP, int) {
+	fields := strings.Split(arg, ",")
+	if len(fields) != 6 {
+		return nil, 0
+	}
+	var b [6]byte
+	for i, f := range fields {
+		n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil {
+			return nil, 0
+		}
+		b[i] = byte(n)
+	}
+	return net.IPv4(b[0], b[1], b[2], b[3]), int(b[4])<<8 | int(b[5])
+}
+
+// parseEPRT parses the |proto|address|port| argument of EPRT, RFC 2428
+func parseEPRT(arg string) (net.IP, int) {
+	if len(arg) < 2 {
+		return nil, 0
+	}
+	fields := strings.Split(arg[1:], arg[:1])
+	if len(fields) != 4 {
+		return nil, 0
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, 0
+	}
+	return net.ParseIP(fields[1]), port
+}
+
+// listLine formats a directory entry like ls -l
+func listLine(fi fs.FileInfo) string {
+	kind := "-"
+	if fi.IsDir() {
+		kind = "d"
+	}
+	return fmt.Sprintf("%s%s    1 0        0        %8d %s %s\r\n", kind, fi.Mode().Perm().String()[1:], fi.Size(), fi.ModTime().Format("Jan 02 15:04"), fi.Name())
+}
+
+// list sends the entries of a directory, or a file, for LIST and NLST
+func (s *ftpServer) list(arg string, namesOnly bool) error {
+	// clients pass ls flags, like -la
+	var target string
+	for _, field := range strings.Fields(arg) {
+		if !strings.HasPrefix(field, "-") {
+			target = field
+		}
+	}
+	p := s.resolve(target)
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		s.closeData()
+		return s.write("550 Failed to open directory.\r\n")
+	}
+	infos := []fs.FileInfo{fi}
+	if fi.IsDir() {
+		if infos, err = s.fs.ReadDir(p); err != nil {
+			s.closeData()
+			return s.write("550 Failed to open directory.\r\n")
+		}
+	}
+	conn, err := s.openData("150 Here comes the directory listing.\r\n")
+	if conn == nil {
+		return err
+	}
+	var out strings.Builder
+	for _, fi := range infos {
+		if namesOnly {
+			out.WriteString(fi.Name() + "\r\n")
+		} else {
+			out.WriteString(listLine(fi))
+		}
+	}
+	_, err = io.WriteString(conn, out.String())
+	conn.Close()
+	if err != nil {
+		return s.write("426 Failure writing network stream.\r\n")
+	}
+	return s.write("226 Directory send OK.\r\n")
+}
 
+// retrieve sends a file
+func (s *ftpServer) retrieve(arg string) error {
+	offset := s.offset
+	s.offset = 0
+	data, err := s.fs.ReadFile(s.resolve(arg))
+	if err != nil {
+		s.closeData()
+		return s.write("550 Failed to open file.\r\n")
+	}
+	data = data[min(offset, int64(len(data))):]
+	mode := "ASCII"
+	if s.binary {
+		mode = "BINARY"
+	}
+	conn, err := s.openData(fmt.Sprintf("150 Opening %s mode data connection for %s (%d bytes).\r\n", mode, path.Base(arg), len(data)))
+	if conn == nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	conn.Close()
+	if err != nil {
+		return s.write("426 Failure writing network stream.\r\n")
+	}
+	return s.write("226 Transfer complete.\r\n")
 }
 
-// HandleFTP takes a net.Conn and does basic FTP communication
+// store receives a file, which is captured in the artifact store
+func (s *ftpServer) store(arg string, appendData bool) error {
+	s.offset = 0
+	p := s.resolve(arg)
+	if fi, err := s.fs.Stat(path.Dir(p)); err != nil || !fi.IsDir() {
+		s.closeData()
+		return s.write("553 Could not create file.\r\n")
+	}
+	conn, err := s.openData("150 Ok to send data.\r\n")
+	if conn == nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(conn, maxFTPUpload+1))
+	conn.Close()
+	upload := &ftpUpload{Path: p}
+	if len(data) > maxFTPUpload {
+		data, upload.Truncated = data[:maxFTPUpload], true
+		// drop the rest, the client sees the transfer fail
+		err = errors.New("upload too large")
+	}
+	upload.Size = len(data)
+	if len(data) > 0 {
+		hash, storeErr := s.h.Store(s.md, data, artifact.Info{Kind: artifact.KindSample, Handler: "ftp"})
+		if storeErr != nil {
+			s.logger.Error("Failed to store upload", slog.String("protocol", "ftp"), producer.ErrAttr(storeErr))
+		}
+		upload.SHA256 = hash
+	}
+	s.events = append(s.events, parsedFTP{Direction: "upload", Upload: upload})
+	switch {
+	case upload.Truncated:
+		return s.write("552 Exceeded storage allocation.\r\n")
+	case err != nil:
+		return s.write("426 Failure reading network stream.\r\n")
+	}
+	if err := s.fs.WriteFile(p, data, appendData); err != nil {
+		return s.write("552 Exceeded storage allocation.\r\n")
+	}
+	return s.write("226 Transfer complete.\r\n")
+}
+
+// handle runs a command and reports whether the session ends
+func (s *ftpServer) handle(cmd, arg string) (bool, error) {
+	switch cmd {
+	case "USER":
+		s.username, s.loggedIn = arg, false
+		return false, s.write("331 Please specify the password.\r\n")
+	case "PASS":
+		if s.username == "" {
+			return false, s.write("503 Login with USER first.\r\n")
+		}
+		if s.h.Authenticate(s.md, "ftp", s.username, arg) {
+			s.loggedIn = true
+			return false, s.write("230 Login successful.\r\n")
+		}
+		s.failures++
+		return s.failures >= maxLoginAttempts, s.write("530 Login incorrect.\r\n")
+	case "QUIT":
+		return true, s.write("221 Goodbye.\r\n")
+	case "SYST":
+		return false, s.write("215 UNIX Type: L8\r\n")
+	case "FEAT":
+		return false, s.write(ftpFeatures)
+	case "NOOP":
+		return false, s.write("200 NOOP ok.\r\n")
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			return false, s.write("200 Always in UTF8 mode.\r\n")
+		}
+		return false, s.write("501 Option not understood.\r\n")
+	}
+	if !s.loggedIn {
+		return false, s.write("530 Please login with USER and PASS.\r\n")
+	}
+
+	switch cmd {
+	case "PWD", "XPWD":
+		return false, s.write(fmt.Sprintf("257 %q is the current directory\r\n", s.cwd))
+	case "CWD", "XCWD", "CDUP", "XCUP":
+		if cmd == "CDUP" || cmd == "XCUP" {
+			arg = ".."
+		}
+		p := s.resolve(arg)
+		if fi, err := s.fs.Stat(p); err != nil || !fi.IsDir() {
+			return false, s.write("550 Failed to change directory.\r\n")
+		}
+		s.cwd = p
+		return false, s.write("250 Directory successfully changed.\r\n")
+	case "TYPE":
+		switch strings.ToUpper(arg) {
+		case "A", "A N":
+			s.binary = false
+			return false, s.write("200 Switching to ASCII mode.\r\n")
+		case "I", "L 8":
+			s.binary = true
+			return false, s.write("200 Switching to Binary mode.\r\n")
+		}
+		return false, s.write("500 Unrecognised TYPE command.\r\n")
+	case "MODE":
+		if strings.EqualFold(arg, "S") {
+			return false, s.write("200 Mode set to S.\r\n")
+		}
+		return false, s.write("504 Bad MODE command.\r\n")
+	case "STRU":
+		if strings.EqualFold(arg, "F") {
+			return false, s.write("200 Structure set to F.\r\n")
+		}
+		return false, s.write("504 Bad STRU command.\r\n")
+	case "PASV":
+		return false, s.write(s.passiveMode(false))
+	case "EPSV":
+		if strings.EqualFold(arg, "ALL") {
+			return false, s.write("200 EPSV ALL ok.\r\n")
+		}
+		return false, s.write(s.passiveMode(true))
+	case "PORT":
+		return false, s.write(s.activeMode(parsePORT(arg)))
+	case "EPRT":
+		return false, s.write(s.activeMode(parseEPRT(arg)))
+	case "LIST", "NLST":
+		return false, s.list(arg, cmd == "NLST")
+	case "RETR":
+		return false, s.retrieve(arg)
+	case "STOR", "APPE":
+		return false, s.store(arg, cmd == "APPE")
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			return false, s.write("554 Invalid REST parameter.\r\n")
+		}
+		s.offset = offset
+		return false, s.write(fmt.Sprintf("350 Restart position accepted (%d).\r\n", offset))
+	case "SIZE":
+		fi, err := s.fs.Stat(s.resolve(arg))
+		if err != nil || fi.IsDir() {
+			return false, s.write("550 Could not get file size.\r\n")
+		}
+		return false, s.write(fmt.Sprintf("213 %d\r\n", fi.Size()))
+	case "MDTM":
+		fi, err := s.fs.Stat(s.resolve(arg))
+		if err != nil || fi.IsDir() {
+			return false, s.write("550 Could not get file modification time.\r\n")
+		}
+		return false, s.write("213 " + fi.ModTime().UTC().Format("20060102150405") + "\r\n")
+	case "DELE":
+		p := s.resolve(arg)
+		if fi, err := s.fs.Stat(p); err != nil || fi.IsDir() || s.fs.Remove(p) != nil {
+			return false, s.write("550 Delete operation failed.\r\n")
+		}
+		return false, s.write("250 Delete operation successful.\r\n")
+	case "MKD", "XMKD":
+		p := s.resolve(arg)
+		if err := s.fs.Mkdir(p); err != nil {
+			return false, s.write("550 Create directory operation failed.\r\n")
+		}
+		return false, s.write(fmt.Sprintf("257 %q created\r\n", p))
+	case "RMD", "XRMD":
+		p := s.resolve(arg)
+		if fi, err := s.fs.Stat(p); err != nil || !fi.IsDir() || s.fs.Remove(p) != nil {
+			return false, s.write("550 Remove directory operation failed.\r\n")
+		}
+		return false, s.write("250 Remove directory operation successful.\r\n")
+	case "RNFR":
+		p := s.resolve(arg)
+		if _, err := s.fs.Stat(p); err != nil {
+			return false, s.write("550 RNFR command failed.\r\n")
+		}
+		s.renameFrom = p
+		return false, s.write("350 Ready for RNTO.\r\n")
+	case "RNTO":
+		from := s.renameFrom
+		s.renameFrom = ""
+		if from == "" {
+			return false, s.write("503 RNFR required first.\r\n")
+		}
+		if err := s.fs.Rename(from, s.resolve(arg)); err != nil {
+			return false, s.write("550 Rename failed.\r\n")
+		}
+		return false, s.write("250 Rename successful.\r\n")
+	case "ABOR":
+		s.closeData()
+		return false, s.write("225 No transfer to ABOR.\r\n")
+	}
+	return false, s.write("500 Unknown command.\r\n")
+}
+
+// HandleFTP takes a net.Conn and emulates an FTP server with passive and
+// active transfers on the filesystem of the persona
 func HandleFTP(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
-	server := ftpServer{
-		conn: conn,
+	p := h.Persona(md)
+	server := &ftpServer{
+		conn:   conn,
+		r:      bufio.NewReaderSize(conn, maxFTPLine),
+		md:     md,
+		logger: logger,
+		h:      h,
+		fs:     shell.NewFS(shellOptions(p)),
+		cwd:    "/",
 	}
 	defer func() {
+		server.closeData()
 		if err := h.ProduceTCP("ftp", conn, md, helpers.FirstOrEmpty[parsedFTP](server.events).Payload, server.events); err != nil {
 			logger.Error("Failed to produce events", slog.String("protocol", "ftp"), producer.ErrAttr(err))
 		}
@@ -70,7 +507,7 @@ func HandleFTP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 		return err
 	}
 
-	if _, err := conn.Write([]byte("220 Welcome!\r\n")); err != nil {
+	if err := server.write("220 " + p.Banner(persona.BannerFTP, "Welcome!") + "\r\n"); err != nil {
 		return err
 	}
 	for {
@@ -78,15 +515,13 @@ func HandleFTP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 			logger.Debug("Failed to set connection timeout", slog.String("protocol", "ftp"), producer.ErrAttr(err))
 			return nil
 		}
-		msg, err := server.read(logger, h)
-		if err != nil || err != io.EOF {
-			logger.Debug("Failed to read data", slog.String("protocol", "ftp"), producer.ErrAttr(err))
-			break
-		}
-		if len(msg) < 4 {
-			continue
+		msg, err := server.read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Debug("Failed to read data", slog.String("protocol", "ftp"), producer.ErrAttr(err))
+			}
+			return nil
 		}
-		cmd := strings.ToUpper(msg[:4])
 
 		logger.Info(
 			"ftp payload received",
@@ -97,18 +532,14 @@ func HandleFTP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 			slog.String("handler", "ftp"),
 		)
 
-		var resp string
-		switch cmd {
-		case "USER":
-			resp = "331 OK.\r\n"
-		case "PASS":
-			resp = "230 OK.\r\n"
-		default:
-			resp = "200 OK.\r\n"
+		line := strings.TrimRight(msg, "\r\n")
+		if line == "" {
+			continue
 		}
-		if err := server.write(resp); err != nil {
+		cmd, arg, _ := strings.Cut(line, " ")
+		quit, err := server.handle(strings.ToUpper(cmd), strings.TrimSpace(arg))
+		if err != nil || quit {
 			return err
 		}
 	}
-	return nil
 }
@@ -0,0 +1,136 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePORT(t *testing.T) {
+	ip, port := parsePORT("192,0,2,1,195,80")
+	require.Equal(t, "192.0.2.1", ip.String())
+	require.Equal(t, 50000, port)
+	ip, _ = parsePORT("192,0,2,1,300,80")
+	require.Nil(t, ip)
+
+	ip, port = parseEPRT("|2|2001:db8::1|50000|")
+	require.Equal(t, "2001:db8::1", ip.String())
+	require.Equal(t, 50000, port)
+	ip, _ = parseEPRT("|1|192.0.2.1|")
+	require.Nil(t, ip)
+}
+
+func TestHandleFTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	h := newFakeHoneypot()
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- HandleFTP(context.Background(), conn, connection.Metadata{}, &recordingLogger{}, h)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	c := textproto.NewConn(conn)
+	cmd := func(code int, format string, args ...any) string {
+		t.Helper()
+		id, err := c.Cmd(format, args...)
+		require.NoError(t, err)
+		c.StartResponse(id)
+		defer c.EndResponse(id)
+		_, msg, err := c.ReadResponse(code)
+		require.NoError(t, err, format)
+		return msg
+	}
+	// pasv opens a passive data connection
+	pasv := func() net.Conn {
+		t.Helper()
+		msg := cmd(229, "EPSV")
+		port, err := strconv.Atoi(strings.Trim(msg[strings.Index(msg, "(")+1:strings.Index(msg, ")")], "|"))
+		require.NoError(t, err)
+		data, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.NoError(t, err)
+		return data
+	}
+
+	_, _, err = c.ReadResponse(220)
+	require.NoError(t, err)
+	cmd(530, "LIST")
+	cmd(331, "USER anonymous")
+	cmd(230, "PASS guest@")
+	require.Equal(t, `"/" is the current directory`, cmd(257, "PWD"))
+	cmd(250, "CWD /tmp")
+	cmd(200, "TYPE I")
+
+	// upload
+	data := pasv()
+	cmd(150, "STOR bot")
+	_, err = data.Write([]byte("\x7fELF-mirai"))
+	require.NoError(t, err)
+	data.Close()
+	_, _, err = c.ReadResponse(226)
+	require.NoError(t, err)
+	require.Equal(t, "10", cmd(213, "SIZE /tmp/bot"))
+
+	// listing
+	data = pasv()
+	cmd(150, "LIST -la")
+	listing, err := io.ReadAll(data)
+	require.NoError(t, err)
+	_, _, err = c.ReadResponse(226)
+	require.NoError(t, err)
+	require.Contains(t, string(listing), "-rw-r--r--    1 0        0              10 ")
+	require.Contains(t, string(listing), " bot\r\n")
+
+	// active download
+	active, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer active.Close()
+	port := active.Addr().(*net.TCPAddr).Port
+	cmd(200, "PORT 127,0,0,1,%d,%d", port>>8, port&0xff)
+	cmd(350, "REST 4")
+	cmd(150, "RETR bot")
+	data, err = active.Accept()
+	require.NoError(t, err)
+	content, err := io.ReadAll(data)
+	require.NoError(t, err)
+	require.Equal(t, "-mirai", string(content))
+	_, _, err = c.ReadResponse(226)
+	require.NoError(t, err)
+
+	// bounce scans are refused
+	cmd(500, "PORT 192,0,2,1,0,22")
+	cmd(425, "RETR bot")
+	cmd(550, "RETR /nope")
+	cmd(221, "QUIT")
+
+	require.NoError(t, <-errs)
+	require.Equal(t, [][]byte{[]byte("\x7fELF-mirai")}, h.stored)
+	produced := <-h.produced
+	events, ok := produced.decoded.([]parsedFTP)
+	require.True(t, ok)
+	var upload *ftpUpload
+	for _, event := range events {
+		if event.Upload != nil {
+			upload = event.Upload
+		}
+	}
+	require.Equal(t, &ftpUpload{Path: "/tmp/bot", Size: 10, SHA256: fmt.Sprintf("%064x", 1)}, upload)
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -14,8 +15,10 @@ import (
 	"strings"
 
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
 	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/signatures"
 )
 
 // formatRequest generates ascii representation of a request
@@ -50,7 +53,7 @@ func sendJSON(data []byte, conn net.Conn) error {
 	return err
 }
 
-func handlePOST(req *http.Request, conn net.Conn, buf *bytes.Buffer, logger interfaces.Logger) error {
+func handlePOST(conn net.Conn, buf *bytes.Buffer) error {
 	body := buf.Bytes()
 	// Ethereum RPC call
 	if strings.Contains(string(body), "eth_blockNumber") {
@@ -60,52 +63,52 @@ func handlePOST(req *http.Request, conn net.Conn, buf *bytes.Buffer, logger inte
 		}
 		return sendJSON(data, conn)
 	}
-	// Hadoop YARN hack
-	if strings.Contains(req.RequestURI, "cluster/apps/new-application") {
-		resp, err := json.Marshal(
-			&struct {
-				ApplicationID             string      `json:"application-id"`
-				MaximumResourceCapability interface{} `json:"maximum-resource-capability"`
-			}{
-				ApplicationID: "application_1527144634877_20465",
-				MaximumResourceCapability: struct {
-					Memory int `json:"memory"`
-					VCores int `json:"vCores"`
-				}{
-					Memory: 16384,
-					VCores: 8,
-				},
-			},
-		)
-		if err != nil {
-			return err
-		}
-		logger.Info("sending hadoop yarn hack response")
-		return sendJSON(resp, conn)
+	return nil
+}
+
+// ActionVMwareHyperSend connects back to the address in the body of a VMware
+// hyper/send request and serves it with the TCP handler
+const ActionVMwareHyperSend = "vmware-hyper-send"
+
+func vmwareHyperSend(ctx context.Context, body []byte, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
+	parts := strings.Split(string(body), " ")
+	if len(parts) < 11 {
+		return nil
+	}
+	conn, err := net.Dial("tcp", parts[9]+":"+parts[10])
+	if err != nil {
+		return err
 	}
+	go func() {
+		if err := HandleTCP(ctx, conn, md, logger, h); err != nil {
+			logger.Error("Failed to handle vmware attack", producer.ErrAttr(err))
+		}
+	}()
 	return nil
 }
 
-// scanning attempts for CVE-2019-19781
-// based on https://github.com/x1sec/citrix-honeypot/
-func smbHandler(conn net.Conn, _ *http.Request) error {
-	// if strings.ContainsRune(r.URL.RawPath, '%') {
-	// with IDS evasion."
-	// }
-
-	headers := `Server: Apache
-X-Frame-Options: SAMEORIGIN
-Last-Modified: Thu, 28 Nov 2019 20:19:22 GMT
-ETag: "53-5986dd42b0680"
-Accept-Ranges: bytes
-Content-Length: 93
-X-XSS-Protection: 1; mode=block
-X-Content-Type-Options: nosniff
-Content-Type: text/plain; charset=UTF-8`
-
-	smbConfig := "\r\n\r\n[global]\r\n\tencrypt passwords = yes\r\n\tname resolve order = lmhosts wins host bcast\r\n"
-	_, err := conn.Write([]byte("HTTP/1.1 200 OK\r\n" + headers + smbConfig))
-	return err
+// RespondSignature answers an HTTP request matching sig with its action and
+// response, body is the body of the request
+func RespondSignature(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot, sig *signatures.Signature, body []byte) error {
+	logger.Info(fmt.Sprintf("HTTP request matched signature %s", sig.ID), slog.String("handler", "http"))
+	switch sig.Action {
+	case "":
+	case ActionVMwareHyperSend:
+		if err := vmwareHyperSend(ctx, body, md, logger, h); err != nil {
+			return fmt.Errorf("failed to run signature action %s: %w", sig.Action, err)
+		}
+	default:
+		logger.Warn("Unknown signature action", slog.String("action", sig.Action), slog.String("signature", sig.ID))
+	}
+
+	response := []byte("HTTP/1.1 200 OK\r\n\r\n")
+	if sig.HTTPResponse != nil {
+		response = sig.HTTPResponse.Bytes()
+	}
+	if _, err := conn.Write(response); err != nil {
+		return fmt.Errorf("failed to send HTTP response: %w", err)
+	}
+	return nil
 }
 
 type decodedHTTP struct {
@@ -124,7 +127,9 @@ func HandleHTTP(ctx context.Context, conn net.Conn, md connection.Metadata, logg
 		}
 	}()
 
-	req, err := http.ReadRequest(bufio.NewReader(conn))
+	// keep the raw request, signatures match the request line and headers too
+	raw := &bytes.Buffer{}
+	req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(conn, raw)))
 	if err != nil {
 		return fmt.Errorf("failed to read the HTTP request: %w", err)
 	}
@@ -155,19 +160,27 @@ func HandleHTTP(ctx context.Context, conn net.Conn, md connection.Metadata, logg
 		}
 		logger.Info(fmt.Sprintf("HTTP payload:\n%s", hex.Dump(buf.Bytes()[:length%1024])))
 	}
+	// commands are injected in the query or the body, headers are left out
+	// so a Referer isn't taken for a download
+	h.FetchSamples(md, "http", append([]byte(req.RequestURI+"\n"), buf.Bytes()...))
 
+	matches := h.MatchSignatures(md, "http", raw.Bytes())
 	if err := h.ProduceTCP("http", conn, md, buf.Bytes(), decodedHTTP{
 		Method: req.Method,
 		URL:    req.URL.EscapedPath(),
 		Path:   req.URL.EscapedPath(),
 		Query:  req.URL.Query().Encode(),
-	}); err != nil {
+	}, producer.WithRaw(raw.Bytes())); err != nil {
 		logger.Error("Failed to produce message", slog.String("protocol", "http"), producer.ErrAttr(err))
 	}
 
+	if sig := signatures.Responder(matches); sig != nil {
+		return RespondSignature(ctx, conn, md, logger, h, sig, buf.Bytes())
+	}
+
 	switch req.Method {
 	case http.MethodPost:
-		return handlePOST(req, conn, buf, logger)
+		return handlePOST(conn, buf)
 	}
 
 	if strings.Contains(req.RequestURI, "wallet") {
@@ -180,37 +193,80 @@ func HandleHTTP(ctx context.Context, conn net.Conn, md connection.Metadata, logg
 	}
 
 	if strings.Contains(req.RequestURI, "/v1.16/version") {
-		data, err := Res.ReadFile("resources/docker_api.json")
+		data, err := dockerVersion(h.Persona(md))
 		if err != nil {
-			return fmt.Errorf("failed to read embedded file: %w", err)
+			return err
 		}
 		_, err = conn.Write(append([]byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length:%d\r\n\r\n", len(data))), data...))
 		return err
 	}
 
-	if strings.HasPrefix(req.RequestURI, "/vpn/") {
-		return smbHandler(conn, req)
-	}
-
-	// Handler for VMWare Attack
-	if strings.Contains(req.RequestURI, "hyper/send") {
-		body := string(buf.Bytes()[:])
-		parts := strings.Split(body, " ")
-		if len(parts) >= 11 {
-			conn, err := net.Dial("tcp", parts[9]+":"+parts[10])
-			if err != nil {
-				return err
-			}
-			go func() {
-				if err := HandleTCP(ctx, conn, md, logger, h); err != nil {
-					logger.Error("Failed to handle vmware attack", producer.ErrAttr(err))
-				}
-			}()
-		}
-	}
 	_, err = conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
 	if err != nil {
 		return fmt.Errorf("failed to send HTTP response: %w", err)
 	}
 	return nil
 }
+
+// dockerArch maps machine names to the architectures Docker reports
+var dockerArch = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"i686":    "386",
+}
+
+// dockerVersion returns the Docker version response of a persona. The
+// versions and the kernel of the persona replace those of the embedded
+// response, which is served as is without a persona.
+func dockerVersion(p *persona.Persona) ([]byte, error) {
+	data, err := Res.ReadFile("resources/docker_api.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded file: %w", err)
+	}
+	if p == nil {
+		return data, nil
+	}
+	var version struct {
+		Platform      json.RawMessage
+		Components    []map[string]interface{}
+		Version       string
+		ApiVersion    string
+		MinAPIVersion string
+		GitCommit     string
+		GoVersion     string
+		Os            string
+		Arch          string
+		KernelVersion string
+		BuildTime     string
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("failed to parse embedded file: %w", err)
+	}
+	version.Version = p.Version("docker", version.Version)
+	version.ApiVersion = p.Version("docker_api", version.ApiVersion)
+	version.GoVersion = p.Version("go", version.GoVersion)
+	if p.OS.Kernel != "" {
+		version.KernelVersion = p.OS.Kernel
+	}
+	if arch, ok := dockerArch[p.OS.Machine]; ok {
+		version.Arch = arch
+	}
+	for _, c := range version.Components {
+		switch c["Name"] {
+		case "Engine":
+			c["Version"] = version.Version
+			if details, ok := c["Details"].(map[string]interface{}); ok {
+				details["ApiVersion"] = version.ApiVersion
+				details["Arch"] = version.Arch
+				details["GoVersion"] = version.GoVersion
+				details["KernelVersion"] = version.KernelVersion
+			}
+		case "containerd":
+			if v := p.Version("containerd", ""); v != "" {
+				c["Version"] = "v" + strings.TrimPrefix(v, "v")
+			}
+		}
+	}
+	return json.Marshal(version)
+}
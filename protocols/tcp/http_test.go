@@ -1,9 +1,18 @@
 package tcp
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/signatures"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,3 +21,74 @@ func TestFormatRequest(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "GET http://example.com HTTP/1.1\nHost: example.com", formatRequest(mockReq))
 }
+
+func TestHandleHTTPSignatures(t *testing.T) {
+	engine, err := signatures.Load("../../config/signatures.yaml")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		request  string
+		response string
+	}{
+		{
+			name:     "Citrix",
+			request:  "GET /vpn/../vpns/cfg/smb.conf HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			response: "[global]",
+		},
+		{
+			name:     "YARN",
+			request:  "POST /ws/v1/cluster/apps/new-application HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\n\r\n{}",
+			response: `"application-id":"application_1527144634877_20465"`,
+		},
+		{
+			name:     "Default",
+			request:  "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			response: "HTTP/1.1 200 OK\r\n\r\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hp := newFakeHoneypot()
+			hp.signatures = engine
+			addr := startTCPServer(t, func(conn net.Conn) {
+				require.NoError(t, HandleHTTP(context.Background(), conn, connection.Metadata{TargetPort: 80}, &recordingLogger{}, hp))
+			})
+
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte(tc.request))
+			require.NoError(t, err)
+			response, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			require.Contains(t, string(response), tc.response)
+
+			// the event payload is the body, the raw request is kept next to it
+			produced := <-hp.produced
+			require.Equal(t, tc.request, string(produced.raw))
+			_, body, _ := strings.Cut(tc.request, "\r\n\r\n")
+			require.Equal(t, body, string(produced.payload))
+		})
+	}
+}
+
+func TestDockerVersion(t *testing.T) {
+	data, err := dockerVersion(nil)
+	require.NoError(t, err)
+	embedded, err := Res.ReadFile("resources/docker_api.json")
+	require.NoError(t, err)
+	require.Equal(t, embedded, data)
+
+	data, err = dockerVersion(&persona.Persona{
+		OS:       persona.OS{Kernel: "4.15.0-213-generic", Machine: "aarch64"},
+		Versions: map[string]string{"docker": "20.10.21", "containerd": "1.6.12"},
+	})
+	require.NoError(t, err)
+	var version map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &version))
+	require.Equal(t, "20.10.21", version["Version"])
+	require.Equal(t, "1.41", version["ApiVersion"])
+	require.Equal(t, "4.15.0-213-generic", version["KernelVersion"])
+	require.Equal(t, "arm64", version["Arch"])
+	require.Contains(t, string(data), `"Name":"containerd","Version":"v1.6.12"`)
+}
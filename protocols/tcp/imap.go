@@ -0,0 +1,480 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/interfaces"
+)
+
+const (
+	// maxIMAPLiterals caps the literals of a command
+	maxIMAPLiterals = 8
+	// imapUIDValidity is the UIDVALIDITY of the inbox, its UIDs never change
+	imapUIDValidity = 1546300800
+	imapFlags       = `\Answered \Flagged \Deleted \Seen \Draft`
+)
+
+type imapServer struct {
+	*mailSession
+	selected bool
+}
+
+// readCommand reads a command line, with the literals it announces inlined
+// as quoted strings
+func (s *imapServer) readCommand() (string, error) {
+	var b strings.Builder
+	for range maxIMAPLiterals {
+		line, err := s.readLine()
+		if err != nil {
+			return "", err
+		}
+		size, plus, rest, ok := imapLiteral(line)
+		if !ok {
+			b.WriteString(line)
+			s.record(b.String())
+			return b.String(), nil
+		}
+		if size > maxMailLine {
+			return "", fmt.Errorf("literal exceeds %d bytes", maxMailLine)
+		}
+		if !plus {
+			if err := s.write("+ OK"); err != nil {
+				return "", err
+			}
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(s.r, data); err != nil {
+			return "", err
+		}
+		b.WriteString(rest + imapQuote(string(data)))
+	}
+	return "", fmt.Errorf("command has more than %d literals", maxIMAPLiterals)
+}
+
+// imapLiteral parses the literal {size} or non-synchronizing {size+} a line
+// ends with and returns the line before it
+func imapLiteral(line string) (int, bool, string, bool) {
+	start := strings.LastIndexByte(line, '{')
+	if start < 0 || !strings.HasSuffix(line, "}") {
+		return 0, false, "", false
+	}
+	n := line[start+1 : len(line)-1]
+	plus := strings.HasSuffix(n, "+")
+	size, err := strconv.Atoi(strings.TrimSuffix(n, "+"))
+	if err != nil || size < 0 {
+		return 0, false, "", false
+	}
+	return size, plus, line[:start], true
+}
+
+// imapQuote returns s as a quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// imapArgs splits arguments into atoms, quoted strings, which are unquoted,
+// and parenthesized lists, which are kept whole. Brackets in atoms, like
+// BODY[HEADER.FIELDS (FROM)], are part of the atom.
+func imapArgs(line string) []string {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		switch line[i] {
+		case '"':
+			var b strings.Builder
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			args = append(args, b.String())
+			i++
+		case '(':
+			start, depth := i, 0
+			for ; i < len(line); i++ {
+				if line[i] == '(' {
+					depth++
+				} else if line[i] == ')' {
+					if depth--; depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+			args = append(args, line[start:i])
+		default:
+			start, depth := i, 0
+			for ; i < len(line) && (line[i] != ' ' || depth > 0); i++ {
+				if line[i] == '[' {
+					depth++
+				} else if line[i] == ']' && depth > 0 {
+					depth--
+				}
+			}
+			args = append(args, line[start:i])
+		}
+	}
+	return args
+}
+
+// imapSequence returns the message numbers of a sequence set like 1:3,5,7:*
+func imapSequence(set string, count int) []int {
+	var numbers []int
+	number := func(s string) int {
+		if s == "*" {
+			return count
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	for _, r := range strings.Split(set, ",") {
+		from, to, ok := strings.Cut(r, ":")
+		first, last := number(from), number(from)
+		if ok {
+			last = number(to)
+		}
+		if first > last {
+			first, last = last, first
+		}
+		for n := max(first, 1); n <= min(last, count); n++ {
+			if !slices.Contains(numbers, n) {
+				numbers = append(numbers, n)
+			}
+		}
+	}
+	return numbers
+}
+
+// imapHeaderFields returns the lines of header with one of the fields, or
+// without them if not is set
+func imapHeaderFields(header []byte, fields []string, not bool) []byte {
+	var b bytes.Buffer
+	keep := false
+	for _, line := range bytes.SplitAfter(header, []byte("\r\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := bytes.Cut(line, []byte(":"))
+			keep = slices.ContainsFunc(fields, func(f string) bool { return strings.EqualFold(f, string(name)) }) != not
+		}
+		if keep {
+			b.Write(line)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// fetchItem returns the response to a FETCH data item for message n, false
+// if the item isn't supported
+func (s *imapServer) fetchItem(item string, n int) (string, bool) {
+	m := s.mailbox[n-1]
+	literal := func(name string, data []byte) string {
+		return fmt.Sprintf("%s {%d}\r\n%s", name, len(data), data)
+	}
+	switch strings.ToUpper(item) {
+	case "UID":
+		return fmt.Sprintf("UID %d", n), true
+	case "FLAGS":
+		return `FLAGS (\Seen)`, true
+	case "RFC822.SIZE":
+		return fmt.Sprintf("RFC822.SIZE %d", len(m.data)), true
+	case "INTERNALDATE":
+		return fmt.Sprintf("INTERNALDATE %q", m.date.Format("02-Jan-2006 15:04:05 -0700")), true
+	case "RFC822":
+		return literal("RFC822", m.data), true
+	case "RFC822.HEADER":
+		return literal("RFC822.HEADER", m.header()), true
+	case "RFC822.TEXT":
+		return literal("RFC822.TEXT", m.text()), true
+	}
+
+	// BODY[section]<partial> and BODY.PEEK[section]<partial>
+	upper := strings.ToUpper(item)
+	if !strings.HasPrefix(upper, "BODY[") && !strings.HasPrefix(upper, "BODY.PEEK[") {
+		return "", false
+	}
+	open, end := strings.IndexByte(item, '['), strings.LastIndexByte(item, ']')
+	if end < open {
+		return "", false
+	}
+	section := item[open+1 : end]
+	name := "BODY[" + section + "]"
+	field := strings.ToUpper(section)
+	var data []byte
+	switch {
+	case strings.HasPrefix(field, "HEADER.FIELDS"):
+		var fields []string
+		if start := strings.IndexByte(section, '('); start >= 0 {
+			fields = strings.Fields(strings.Trim(section[start:], "()"))
+		}
+		data = imapHeaderFields(m.header(), fields, strings.HasPrefix(field, "HEADER.FIELDS.NOT"))
+	case field == "HEADER":
+		data = m.header()
+	case field == "TEXT" || field == "1":
+		data = m.text()
+	default:
+		data = m.data
+	}
+	// a partial fetch <origin.length>
+	if partial := item[end+1:]; strings.HasPrefix(partial, "<") && strings.HasSuffix(partial, ">") {
+		from, length, _ := strings.Cut(strings.Trim(partial, "<>"), ".")
+		origin, err := strconv.Atoi(from)
+		if err != nil || origin < 0 {
+			return "", false
+		}
+		data = data[min(origin, len(data)):]
+		if size, err := strconv.Atoi(length); err == nil && size >= 0 {
+			data = data[:min(size, len(data))]
+		}
+		name += fmt.Sprintf("<%d>", origin)
+	}
+	return literal(name, data), true
+}
+
+// fetch sends the FETCH responses of a sequence set
+func (s *imapServer) fetch(tag, set, items string, uid bool) error {
+	items = strings.TrimSpace(items)
+	if strings.HasPrefix(items, "(") && strings.HasSuffix(items, ")") {
+		items = items[1 : len(items)-1]
+	}
+	var names []string
+	for _, item := range imapArgs(items) {
+		switch strings.ToUpper(item) {
+		case "ALL", "FAST":
+			names = append(names, "FLAGS", "INTERNALDATE", "RFC822.SIZE")
+		case "FULL":
+			names = append(names, "FLAGS", "INTERNALDATE", "RFC822.SIZE", "BODY[]")
+		default:
+			names = append(names, item)
+		}
+	}
+	if uid && !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, "UID") }) {
+		names = append([]string{"UID"}, names...)
+	}
+	for _, n := range imapSequence(set, len(s.mailbox)) {
+		var parts []string
+		for _, name := range names {
+			if part, ok := s.fetchItem(name, n); ok {
+				parts = append(parts, part)
+			}
+		}
+		if err := s.write(fmt.Sprintf("* %d FETCH (%s)", n, strings.Join(parts, " "))); err != nil {
+			return err
+		}
+	}
+	return s.write(tag + " OK Fetch completed.")
+}
+
+// capabilities lists what the server supports, STARTTLS until TLS is on
+// and the login mechanisms until the client logged in
+func (s *imapServer) capabilities() string {
+	caps := "IMAP4rev1 SASL-IR LOGIN-REFERRALS ID ENABLE LITERAL+ NAMESPACE"
+	if s.username == "" {
+		if !s.tls {
+			caps += " STARTTLS"
+		}
+		for _, mechanism := range saslMechanisms {
+			caps += " AUTH=" + mechanism
+		}
+	}
+	return caps
+}
+
+// loggedIn answers a login attempt and reports whether the session ends
+func (s *imapServer) loggedIn(tag string, accepted bool) (bool, error) {
+	if accepted {
+		return false, s.write(tag + " OK [CAPABILITY " + s.capabilities() + "] Logged in")
+	}
+	if err := s.write(tag + " NO [AUTHENTICATIONFAILED] Authentication failed."); err != nil {
+		return true, err
+	}
+	if s.failures >= maxLoginAttempts {
+		return true, s.write("* BYE Too many invalid IMAP commands.")
+	}
+	return false, nil
+}
+
+// authenticate runs the AUTHENTICATE exchange and reports whether the
+// session ends
+func (s *imapServer) authenticate(tag string, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, s.write(tag + " BAD Missing SASL mechanism.")
+	}
+	var initial string
+	if len(args) > 1 {
+		initial = args[1]
+	}
+	username, password, err := saslLogin(args[0], initial, func(challenge string) (string, error) {
+		if err := s.write("+ " + challenge); err != nil {
+			return "", err
+		}
+		return s.read()
+	})
+	switch {
+	case errors.Is(err, errAuthMechanism):
+		return false, s.write(tag + " NO [CANNOT] Unsupported authentication mechanism.")
+	case errors.Is(err, errAuthAborted):
+		return false, s.write(tag + " BAD Authentication aborted by client.")
+	case errors.Is(err, errAuthDecode), errors.Is(err, errAuthMalformed):
+		return false, s.write(tag + " BAD Invalid base64 data in continued response")
+	case err != nil:
+		return true, err
+	}
+	return s.loggedIn(tag, s.login(username, password))
+}
+
+// selectInbox opens the inbox, the only mailbox there is
+func (s *imapServer) selectInbox(tag, cmd string, args []string) error {
+	if len(args) == 0 || !strings.EqualFold(args[0], "INBOX") {
+		return s.write(tag + " NO Mailbox doesn't exist.")
+	}
+	s.selected = true
+	count := len(s.mailbox)
+	lines := []string{
+		"* FLAGS (" + imapFlags + ")",
+		"* OK [PERMANENTFLAGS (" + imapFlags + ` \*)] Flags permitted.`,
+		fmt.Sprintf("* %d EXISTS", count),
+		"* 0 RECENT",
+		fmt.Sprintf("* OK [UIDVALIDITY %d] UIDs valid", imapUIDValidity),
+		fmt.Sprintf("* OK [UIDNEXT %d] Predicted next UID", count+1),
+	}
+	if cmd == "EXAMINE" {
+		lines = append(lines, tag+" OK [READ-ONLY] Examine completed.")
+	} else {
+		lines = append(lines, tag+" OK [READ-WRITE] Select completed.")
+	}
+	return s.write(strings.Join(lines, "\r\n"))
+}
+
+// handle runs a command line and reports whether the session ends
+func (s *imapServer) handle(line string) (bool, error) {
+	args := imapArgs(line)
+	if len(args) < 2 {
+		return false, s.write("* BAD Error in IMAP command received by server.")
+	}
+	tag, cmd, args := args[0], strings.ToUpper(args[1]), args[2:]
+
+	switch cmd {
+	case "CAPABILITY":
+		return false, s.write("* CAPABILITY " + s.capabilities() + "\r\n" + tag + " OK Capability completed.")
+	case "NOOP", "CHECK":
+		return false, s.write(tag + " OK " + cmd + " completed.")
+	case "LOGOUT":
+		return true, s.write("* BYE Logging out\r\n" + tag + " OK Logout completed.")
+	case "ID":
+		return false, s.write("* ID NIL\r\n" + tag + " OK ID completed.")
+	case "STARTTLS":
+		if s.tls {
+			return false, s.write(tag + " BAD TLS is already active.")
+		}
+		config, err := s.h.TLSConfig(s.md)
+		if err != nil {
+			s.logger.Error("Failed to get TLS config", slog.String("protocol", "imap"), producer.ErrAttr(err))
+			return false, s.write(tag + " NO TLS support isn't enabled.")
+		}
+		if err := s.write(tag + " OK Begin TLS negotiation now."); err != nil {
+			return true, err
+		}
+		if err := s.startTLS(config); err != nil {
+			s.logger.Debug("TLS handshake failed", slog.String("protocol", "imap"), producer.ErrAttr(err))
+			return true, nil
+		}
+		return false, nil
+	}
+
+	if s.username == "" {
+		switch cmd {
+		case "LOGIN":
+			if len(args) < 2 {
+				return false, s.write(tag + " BAD Error in IMAP command LOGIN: Missing arguments.")
+			}
+			return s.loggedIn(tag, s.login(args[0], args[1]))
+		case "AUTHENTICATE":
+			return s.authenticate(tag, args)
+		}
+		return false, s.write(tag + " BAD Error in IMAP command received by server.")
+	}
+
+	switch cmd {
+	case "LOGIN", "AUTHENTICATE":
+		return false, s.write(tag + " BAD Error in IMAP command " + cmd + ": Already logged in.")
+	case "SELECT", "EXAMINE":
+		return false, s.selectInbox(tag, cmd, args)
+	case "LIST", "LSUB":
+		if len(args) == 2 && args[1] == "" {
+			return false, s.write(`* ` + cmd + ` (\Noselect) "/" ""` + "\r\n" + tag + " OK " + cmd + " completed.")
+		}
+		return false, s.write(`* ` + cmd + ` (\HasNoChildren) "/" INBOX` + "\r\n" + tag + " OK " + cmd + " completed.")
+	case "STATUS":
+		if len(args) == 0 || !strings.EqualFold(args[0], "INBOX") {
+			return false, s.write(tag + " NO Mailbox doesn't exist.")
+		}
+		return false, s.write(fmt.Sprintf("* STATUS INBOX (MESSAGES %d RECENT 0 UIDNEXT %d UIDVALIDITY %d UNSEEN 0)\r\n%s OK Status completed.", len(s.mailbox), len(s.mailbox)+1, imapUIDValidity, tag))
+	case "NAMESPACE":
+		return false, s.write(`* NAMESPACE (("" "/")) NIL NIL` + "\r\n" + tag + " OK Namespace completed.")
+	case "ENABLE":
+		return false, s.write("* ENABLED\r\n" + tag + " OK Enabled.")
+	}
+
+	if !s.selected {
+		return false, s.write(tag + " BAD No mailbox selected.")
+	}
+	uid := false
+	if cmd == "UID" && len(args) > 0 {
+		uid, cmd, args = true, strings.ToUpper(args[0]), args[1:]
+	}
+	switch cmd {
+	case "FETCH":
+		if len(args) < 2 {
+			return false, s.write(tag + " BAD Error in IMAP command FETCH: Invalid arguments.")
+		}
+		return false, s.fetch(tag, args[0], strings.Join(args[1:], " "), uid)
+	case "SEARCH":
+		var b strings.Builder
+		b.WriteString("* SEARCH")
+		for n := range len(s.mailbox) {
+			fmt.Fprintf(&b, " %d", n+1)
+		}
+		return false, s.write(b.String() + "\r\n" + tag + " OK Search completed.")
+	case "STORE", "EXPUNGE", "COPY":
+		return false, s.write(tag + " OK " + cmd + " completed.")
+	case "CLOSE", "UNSELECT":
+		s.selected = false
+		return false, s.write(tag + " OK " + cmd + " completed.")
+	}
+	return false, s.write(tag + " BAD Error in IMAP command received by server.")
+}
+
+// HandleIMAP takes a net.Conn and emulates an IMAP server serving the inbox
+// of the persona to any login the login policy accepts
+func HandleIMAP(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
+	session := newMailSession(ctx, "imap", conn, md, logger, h)
+	defer session.close()
+	s := &imapServer{mailSession: session}
+	greeting := s.h.Persona(s.md).Banner(persona.BannerIMAP, "IMAP server ready.")
+	if err := s.write("* OK [CAPABILITY " + s.capabilities() + "] " + greeting); err != nil {
+		return err
+	}
+	return s.serve(s.readCommand, s.handle)
+}
@@ -0,0 +1,151 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIMAPArgs(t *testing.T) {
+	require.Equal(t, []string{"a1", "LOGIN", `us"er`, "pass word"}, imapArgs(`a1 LOGIN "us\"er" "pass word"`))
+	require.Equal(t, []string{"a2", "FETCH", "1:*", "(FLAGS BODY.PEEK[HEADER.FIELDS (FROM DATE)])"}, imapArgs("a2 FETCH 1:* (FLAGS BODY.PEEK[HEADER.FIELDS (FROM DATE)])"))
+	require.Equal(t, []string{"BODY.PEEK[HEADER.FIELDS (FROM)]<0.10>", "UID"}, imapArgs("BODY.PEEK[HEADER.FIELDS (FROM)]<0.10> UID"))
+
+	require.Equal(t, []int{1, 2, 3, 5}, imapSequence("1:3,5,2", 5))
+	require.Equal(t, []int{4, 5}, imapSequence("4:*", 5))
+	require.Equal(t, []int{5}, imapSequence("*", 5))
+	require.Empty(t, imapSequence("7:9", 5))
+
+	size, plus, rest, ok := imapLiteral("a1 LOGIN admin {8+}")
+	require.True(t, ok)
+	require.Equal(t, 8, size)
+	require.True(t, plus)
+	require.Equal(t, "a1 LOGIN admin ", rest)
+	_, _, _, ok = imapLiteral("a1 LOGIN admin secret")
+	require.False(t, ok)
+}
+
+func TestHandleIMAP(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	h := newFakeHoneypot()
+	h.persona = testMailbox
+	errs := make(chan error, 1)
+	go func() {
+		errs <- HandleIMAP(context.Background(), server, connection.Metadata{}, &recordingLogger{}, h)
+	}()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+	c := textproto.NewConn(client)
+	// cmd sends a command and returns the untagged responses and the tagged one
+	cmd := func(line string) ([]string, string) {
+		t.Helper()
+		tag, _, _ := strings.Cut(line, " ")
+		require.NoError(t, c.PrintfLine("%s", line))
+		var untagged []string
+		for {
+			resp, err := c.ReadLine()
+			require.NoError(t, err)
+			if strings.HasPrefix(resp, tag+" ") {
+				return untagged, resp
+			}
+			untagged = append(untagged, resp)
+		}
+	}
+
+	greeting, err := c.ReadLine()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(greeting, "* OK [CAPABILITY IMAP4rev1"))
+	require.Contains(t, greeting, "STARTTLS")
+
+	_, resp := cmd("a0 SELECT INBOX")
+	require.Equal(t, "a0 BAD Error in IMAP command received by server.", resp)
+
+	// a synchronizing literal for the password
+	require.NoError(t, c.PrintfLine("a1 LOGIN admin {6}"))
+	cont, err := c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "+ OK", cont)
+	require.NoError(t, c.PrintfLine("secret"))
+	resp, err = c.ReadLine()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp, "a1 OK"), resp)
+
+	untagged, resp := cmd("a2 SELECT inbox")
+	require.Contains(t, untagged, "* 2 EXISTS")
+	require.Equal(t, "a2 OK [READ-WRITE] Select completed.", resp)
+
+	untagged, resp = cmd("a3 UID FETCH 1:* (FLAGS BODY.PEEK[HEADER.FIELDS (SUBJECT)])")
+	require.Equal(t, "a3 OK Fetch completed.", resp)
+	// header sections end with the blank line
+	require.Equal(t, []string{
+		`* 1 FETCH (UID 1 FLAGS (\Seen) BODY[HEADER.FIELDS (SUBJECT)] {26}`,
+		"Subject: backup failed",
+		"",
+		")",
+		`* 2 FETCH (UID 2 FLAGS (\Seen) BODY[HEADER.FIELDS (SUBJECT)] {27}`,
+		"Subject: password reset",
+		"",
+		")",
+	}, untagged)
+
+	untagged, _ = cmd("a4 FETCH 2 BODY[TEXT]")
+	require.Equal(t, []string{"* 2 FETCH (BODY[TEXT] {23}", "new password: hunter2", ")"}, untagged)
+
+	untagged, _ = cmd("a5 SEARCH ALL")
+	require.Equal(t, []string{"* SEARCH 1 2"}, untagged)
+	cmd("a6 LOGOUT")
+	require.NoError(t, <-errs)
+
+	event := <-h.produced
+	require.Equal(t, "imap", event.protocol)
+	decoded := event.decoded.(parsedMail)
+	require.Equal(t, "admin", decoded.Username)
+	// the literal is inlined in the recorded command
+	require.Equal(t, `a1 LOGIN admin "secret"`, decoded.Commands[1])
+}
+
+func TestIMAPStartTLS(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	h := newFakeHoneypot()
+	go HandleIMAP(context.Background(), server, connection.Metadata{}, &recordingLogger{}, h)
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	c := textproto.NewConn(client)
+	greeting, err := c.ReadLine()
+	require.NoError(t, err)
+	require.Contains(t, greeting, "STARTTLS")
+	require.NoError(t, c.PrintfLine("a1 STARTTLS"))
+	resp, err := c.ReadLine()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp, "a1 OK"), resp)
+
+	conn := tls.Client(client, &tls.Config{ServerName: "mail.example", NextProtos: []string{"imap"}, InsecureSkipVerify: true})
+	c = textproto.NewConn(conn)
+	require.NoError(t, c.PrintfLine("a2 CAPABILITY"))
+	untagged, err := c.ReadLine()
+	require.NoError(t, err)
+	require.NotContains(t, untagged, "STARTTLS")
+	_, err = c.ReadLine()
+	require.NoError(t, err)
+	require.NoError(t, c.PrintfLine("a3 LOGOUT"))
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	// the event carries the TLS metadata like sessions of rules setting tls
+	event := <-h.produced
+	require.True(t, event.decoded.(parsedMail).TLS)
+	require.NotNil(t, event.md.TLS)
+	require.Equal(t, "mail.example", event.md.TLS.ServerName)
+	require.Equal(t, []string{"imap"}, event.md.TLS.ALPN)
+	require.NotEmpty(t, event.md.TLS.CipherSuites)
+	require.Equal(t, "TLS 1.3", event.md.TLS.Version)
+}
@@ -0,0 +1,221 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/interfaces"
+)
+
+const (
+	// maxMailLine caps the length of a POP3 or IMAP command line
+	maxMailLine = 8192
+	// maxMailCommands caps the command lines kept of a session
+	maxMailCommands = 200
+)
+
+// mailMessage is a message of the mailbox served over POP3 and IMAP
+type mailMessage struct {
+	data []byte
+	date time.Time
+}
+
+// header returns the header of the message, including the blank line
+func (m mailMessage) header() []byte {
+	if i := strings.Index(string(m.data), "\r\n\r\n"); i >= 0 {
+		return m.data[:i+4]
+	}
+	return m.data
+}
+
+// text returns the body of the message
+func (m mailMessage) text() []byte {
+	return m.data[len(m.header()):]
+}
+
+// newMailbox renders the mailbox of a persona as messages
+func newMailbox(p *persona.Persona, hostname string, now time.Time) []mailMessage {
+	if p == nil {
+		return nil
+	}
+	var messages []mailMessage
+	for _, mail := range p.Mailbox {
+		date := now.AddDate(0, 0, -mail.Age).Truncate(time.Minute)
+		to := mail.To
+		if to == "" {
+			to = "root@" + hostname
+		}
+		id := sha256.Sum256([]byte(mail.Subject + mail.Body))
+		var b strings.Builder
+		fmt.Fprintf(&b, "Return-Path: <%s>\r\n", address(mail.From))
+		fmt.Fprintf(&b, "Delivered-To: %s\r\n", address(to))
+		fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+		fmt.Fprintf(&b, "From: %s\r\n", mail.From)
+		fmt.Fprintf(&b, "To: %s\r\n", to)
+		fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+		fmt.Fprintf(&b, "Message-ID: <%x@%s>\r\n", id[:8], hostname)
+		b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(mail.Body, "\r\n", "\n"), "\n", "\r\n"))
+		messages = append(messages, mailMessage{data: []byte(b.String()), date: date})
+	}
+	return messages
+}
+
+// address returns the address of a From or To header like "Name <a@b>"
+func address(header string) string {
+	if start, end := strings.LastIndexByte(header, '<'), strings.LastIndexByte(header, '>'); start >= 0 && end > start {
+		return header[start+1 : end]
+	}
+	return header
+}
+
+// mailSession is the state POP3 and IMAP sessions share
+type mailSession struct {
+	ctx      context.Context
+	protocol string
+	conn     net.Conn
+	r        *bufio.Reader
+	md       connection.Metadata
+	logger   interfaces.Logger
+	h        interfaces.Honeypot
+	hostname string
+	mailbox  []mailMessage
+
+	commands []string
+	tls      bool
+	username string
+	failures int
+}
+
+// parsedMail is the event of a POP3 or IMAP session
+type parsedMail struct {
+	TLS bool `json:"tls,omitempty"`
+	// Username the client logged in as
+	Username string   `json:"username,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+}
+
+func newMailSession(ctx context.Context, protocol string, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) *mailSession {
+	p := h.Persona(md)
+	s := &mailSession{
+		ctx:      ctx,
+		protocol: protocol,
+		conn:     conn,
+		r:        bufio.NewReaderSize(conn, maxMailLine),
+		md:       md,
+		logger:   logger,
+		h:        h,
+		hostname: "localhost",
+		tls:      md.TLS != nil,
+	}
+	if p != nil && p.Hostname != "" {
+		s.hostname = p.Hostname
+	}
+	s.mailbox = newMailbox(p, s.hostname, time.Now())
+	return s
+}
+
+// readLine reads a line without its line break
+func (s *mailSession) readLine() (string, error) {
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("command line exceeds %d bytes", maxMailLine)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// record keeps a command for the event of the session
+func (s *mailSession) record(msg string) {
+	if len(s.commands) < maxMailCommands {
+		s.commands = append(s.commands, msg)
+	}
+}
+
+// read reads and records a command line
+func (s *mailSession) read() (string, error) {
+	msg, err := s.readLine()
+	if err != nil {
+		return "", err
+	}
+	s.record(msg)
+	return msg, nil
+}
+
+func (s *mailSession) write(msg string) error {
+	_, err := s.conn.Write([]byte(msg + "\r\n"))
+	return err
+}
+
+// startTLS performs the server side of a TLS handshake, commands pipelined
+// before it are dropped
+func (s *mailSession) startTLS(config *tls.Config) error {
+	conn, md, err := TerminateTLS(s.ctx, s.conn, s.md, config, s.logger, s.h)
+	if err != nil {
+		return err
+	}
+	s.conn, s.md, s.r = conn, md, bufio.NewReaderSize(conn, maxMailLine)
+	s.tls = true
+	return nil
+}
+
+// login checks credentials with the login policy and reports whether they
+// were accepted
+func (s *mailSession) login(username, password string) bool {
+	if s.h.Authenticate(s.md, s.protocol, username, password) {
+		s.username = username
+		return true
+	}
+	s.failures++
+	return false
+}
+
+// close produces the event of the session and closes its connection
+func (s *mailSession) close() {
+	if len(s.commands) > 0 {
+		if err := s.h.ProduceTCP(s.protocol, s.conn, s.md, []byte(strings.Join(s.commands, "\r\n")), parsedMail{
+			TLS:      s.tls,
+			Username: s.username,
+			Commands: s.commands,
+		}); err != nil {
+			s.logger.Error("Failed to produce message", slog.String("protocol", s.protocol), producer.ErrAttr(err))
+		}
+	}
+	if err := s.conn.Close(); err != nil {
+		s.logger.Debug("Failed to close connection", slog.String("protocol", s.protocol), producer.ErrAttr(err))
+	}
+}
+
+// serve reads commands with read until handle ends the session
+func (s *mailSession) serve(read func() (string, error), handle func(line string) (bool, error)) error {
+	for {
+		if err := s.h.UpdateConnectionTimeout(s.ctx, s.conn); err != nil {
+			return err
+		}
+		line, err := read()
+		if err != nil {
+			s.logger.Debug("Failed to read command", slog.String("protocol", s.protocol), producer.ErrAttr(err))
+			return nil
+		}
+		if line == "" {
+			continue
+		}
+		quit, err := handle(line)
+		if err != nil || quit {
+			return err
+		}
+	}
+}
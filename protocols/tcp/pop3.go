@@ -0,0 +1,223 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/interfaces"
+)
+
+type pop3Server struct {
+	*mailSession
+	user    string
+	deleted map[int]bool
+}
+
+// capabilities lists the CAPA response, STLS until TLS is on
+func (s *pop3Server) capabilities() string {
+	lines := []string{"+OK", "CAPA", "TOP", "UIDL", "RESP-CODES", "PIPELINING", "USER", "SASL " + strings.Join(saslMechanisms, " ")}
+	if !s.tls {
+		lines = append(lines, "STLS")
+	}
+	return strings.Join(append(lines, "."), "\r\n")
+}
+
+// message returns the message numbered arg, counting from one
+func (s *pop3Server) message(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(s.mailbox) || s.deleted[n] {
+		return 0, false
+	}
+	return n, true
+}
+
+// multiline sends a multi-line response, dot stuffing the data
+func (s *pop3Server) multiline(status string, data []byte) error {
+	var b bytes.Buffer
+	b.WriteString(status + "\r\n")
+	for _, line := range bytes.SplitAfter(data, []byte("\r\n")) {
+		if bytes.HasPrefix(line, []byte(".")) {
+			b.WriteByte('.')
+		}
+		b.Write(line)
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) && len(data) > 0 {
+		b.WriteString("\r\n")
+	}
+	b.WriteString(".")
+	return s.write(b.String())
+}
+
+// authorize handles the commands before login and reports whether the
+// session ends
+func (s *pop3Server) authorize(cmd, arg string) (bool, error) {
+	var accepted bool
+	switch cmd {
+	case "USER":
+		if arg == "" {
+			return false, s.write("-ERR No username given.")
+		}
+		s.user = arg
+		return false, s.write("+OK")
+	case "PASS":
+		if s.user == "" {
+			return false, s.write("-ERR No username given.")
+		}
+		accepted = s.login(s.user, arg)
+	case "AUTH":
+		if arg == "" {
+			return false, s.write("+OK\r\n" + strings.Join(saslMechanisms, "\r\n") + "\r\n.")
+		}
+		mechanism, initial, _ := strings.Cut(arg, " ")
+		username, password, err := saslLogin(mechanism, initial, func(challenge string) (string, error) {
+			if err := s.write("+ " + challenge); err != nil {
+				return "", err
+			}
+			return s.read()
+		})
+		switch {
+		case errors.Is(err, errAuthMechanism):
+			return false, s.write("-ERR Unsupported authentication mechanism.")
+		case errors.Is(err, errAuthAborted):
+			return false, s.write("-ERR Authentication aborted by client.")
+		case errors.Is(err, errAuthDecode), errors.Is(err, errAuthMalformed):
+			return false, s.write("-ERR Invalid base64 data in continued response")
+		case err != nil:
+			return true, err
+		}
+		accepted = s.login(username, password)
+	case "APOP":
+		// the digest doesn't reveal the password
+		return false, s.write("-ERR [AUTH] Authentication failed.")
+	case "STLS":
+		if s.tls {
+			return false, s.write("-ERR TLS is already active.")
+		}
+		config, err := s.h.TLSConfig(s.md)
+		if err != nil {
+			s.logger.Error("Failed to get TLS config", slog.String("protocol", "pop3"), producer.ErrAttr(err))
+			return false, s.write("-ERR TLS support isn't enabled.")
+		}
+		if err := s.write("+OK Begin TLS negotiation now."); err != nil {
+			return true, err
+		}
+		if err := s.startTLS(config); err != nil {
+			s.logger.Debug("TLS handshake failed", slog.String("protocol", "pop3"), producer.ErrAttr(err))
+			return true, nil
+		}
+		s.user = ""
+		return false, nil
+	default:
+		return false, s.write("-ERR Unknown command.")
+	}
+
+	if accepted {
+		return false, s.write("+OK Logged in.")
+	}
+	return s.failures >= maxLoginAttempts, s.write("-ERR [AUTH] Authentication failed.")
+}
+
+// transact handles the commands after login and reports whether the session
+// ends
+func (s *pop3Server) transact(cmd, arg string) (bool, error) {
+	switch cmd {
+	case "STAT":
+		count, size := 0, 0
+		for i, m := range s.mailbox {
+			if !s.deleted[i+1] {
+				count++
+				size += len(m.data)
+			}
+		}
+		return false, s.write(fmt.Sprintf("+OK %d %d", count, size))
+	case "LIST", "UIDL":
+		entry := func(n int) string {
+			if cmd == "UIDL" {
+				return fmt.Sprintf("%d %08x", n, n)
+			}
+			return fmt.Sprintf("%d %d", n, len(s.mailbox[n-1].data))
+		}
+		if arg != "" {
+			n, ok := s.message(arg)
+			if !ok {
+				return false, s.write("-ERR There's no message " + arg + ".")
+			}
+			return false, s.write("+OK " + entry(n))
+		}
+		lines := []string{"+OK"}
+		for n := 1; n <= len(s.mailbox); n++ {
+			if !s.deleted[n] {
+				lines = append(lines, entry(n))
+			}
+		}
+		return false, s.write(strings.Join(append(lines, "."), "\r\n"))
+	case "RETR":
+		n, ok := s.message(arg)
+		if !ok {
+			return false, s.write("-ERR There's no message " + arg + ".")
+		}
+		m := s.mailbox[n-1]
+		return false, s.multiline(fmt.Sprintf("+OK %d octets", len(m.data)), m.data)
+	case "TOP":
+		number, lines, _ := strings.Cut(arg, " ")
+		n, ok := s.message(number)
+		count, err := strconv.Atoi(strings.TrimSpace(lines))
+		if !ok || err != nil || count < 0 {
+			return false, s.write("-ERR Invalid arguments.")
+		}
+		m := s.mailbox[n-1]
+		text := bytes.SplitAfter(m.text(), []byte("\r\n"))
+		data := append(bytes.Clone(m.header()), bytes.Join(text[:min(count, len(text))], nil)...)
+		return false, s.multiline("+OK", data)
+	case "DELE":
+		n, ok := s.message(arg)
+		if !ok {
+			return false, s.write("-ERR There's no message " + arg + ".")
+		}
+		s.deleted[n] = true
+		return false, s.write("+OK Marked to be deleted.")
+	case "RSET":
+		clear(s.deleted)
+		return false, s.write("+OK")
+	case "NOOP":
+		return false, s.write("+OK")
+	}
+	return false, s.write("-ERR Unknown command: " + cmd)
+}
+
+// handle runs a command line and reports whether the session ends
+func (s *pop3Server) handle(line string) (bool, error) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	cmd, arg = strings.ToUpper(cmd), strings.TrimSpace(arg)
+	switch cmd {
+	case "CAPA":
+		return false, s.write(s.capabilities())
+	case "QUIT":
+		return true, s.write("+OK Logging out.")
+	}
+	if s.username == "" {
+		return s.authorize(cmd, arg)
+	}
+	return s.transact(cmd, arg)
+}
+
+// HandlePOP3 takes a net.Conn and emulates a POP3 server serving the mailbox
+// of the persona to any login the login policy accepts
+func HandlePOP3(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
+	session := newMailSession(ctx, "pop3", conn, md, logger, h)
+	defer session.close()
+	s := &pop3Server{mailSession: session, deleted: make(map[int]bool)}
+	if err := s.write("+OK " + s.h.Persona(s.md).Banner(persona.BannerPOP3, "POP3 server ready.")); err != nil {
+		return err
+	}
+	return s.serve(s.read, s.handle)
+}
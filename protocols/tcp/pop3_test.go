@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/stretchr/testify/require"
+)
+
+var testMailbox = &persona.Persona{
+	Hostname: "web01",
+	Mailbox: []persona.Mail{
+		{From: "Cron Daemon <root@web01>", Subject: "backup failed", Age: 1, Body: "rsync error\n.hidden\n"},
+		{From: "admin@web01", Subject: "password reset", Body: "new password: hunter2\n"},
+	},
+}
+
+func TestNewMailbox(t *testing.T) {
+	now := time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)
+	mailbox := newMailbox(testMailbox, "web01", now)
+	require.Len(t, mailbox, 2)
+	require.Equal(t, now.AddDate(0, 0, -1), mailbox[0].date)
+	header := string(mailbox[0].header())
+	require.Contains(t, header, "Return-Path: <root@web01>\r\n")
+	require.Contains(t, header, "To: root@web01\r\n")
+	require.Contains(t, header, "Subject: backup failed\r\n")
+	require.True(t, strings.HasSuffix(header, "\r\n\r\n"))
+	require.Equal(t, "rsync error\r\n.hidden\r\n", string(mailbox[0].text()))
+
+	require.Empty(t, newMailbox(nil, "localhost", now))
+}
+
+func TestHandlePOP3(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	h := newFakeHoneypot()
+	h.persona = testMailbox
+	errs := make(chan error, 1)
+	go func() {
+		errs <- HandlePOP3(context.Background(), server, connection.Metadata{}, &recordingLogger{}, h)
+	}()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+	c := textproto.NewConn(client)
+	cmd := func(format string, args ...any) string {
+		t.Helper()
+		require.NoError(t, c.PrintfLine(format, args...))
+		line, err := c.ReadLine()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(line, "+"), "%s: %s", format, line)
+		return line
+	}
+
+	line, err := c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "+OK POP3 server ready.", line)
+	cmd("CAPA")
+	lines, err := c.ReadDotLines()
+	require.NoError(t, err)
+	require.Contains(t, lines, "STLS")
+	cmd("USER admin")
+	cmd("PASS secret")
+	require.True(t, strings.HasPrefix(cmd("STAT"), "+OK 2 "))
+	cmd("RETR 1")
+	lines, err = c.ReadDotLines()
+	require.NoError(t, err)
+	// dot stuffing is undone by the reader
+	require.Equal(t, []string{"rsync error", ".hidden"}, lines[len(lines)-2:])
+	cmd("TOP 2 0")
+	lines, err = c.ReadDotLines()
+	require.NoError(t, err)
+	require.Contains(t, lines, "Subject: password reset")
+	require.NotContains(t, lines, "new password: hunter2")
+	cmd("DELE 1")
+	require.True(t, strings.HasPrefix(cmd("STAT"), "+OK 1 "))
+	cmd("QUIT")
+	require.NoError(t, <-errs)
+
+	event := <-h.produced
+	require.Equal(t, "pop3", event.protocol)
+	decoded := event.decoded.(parsedMail)
+	require.Equal(t, "admin", decoded.Username)
+	require.Equal(t, []string{"CAPA", "USER admin", "PASS secret"}, decoded.Commands[:3])
+}
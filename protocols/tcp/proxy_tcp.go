@@ -376,7 +376,7 @@ func finishReadSide(conn net.Conn, logger interfaces.Logger, dir string) error {
 
 // It is best-effort to enables TCP keepalive for real TCP connections
 func setKeepAlive(conn net.Conn, logger interfaces.Logger, name string) {
-	tcpConn, ok := conn.(*net.TCPConn)
+	tcpConn, ok := unwrapTCPConn(conn)
 	if !ok {
 		return
 	}
@@ -389,6 +389,21 @@ func setKeepAlive(conn net.Conn, logger interfaces.Logger, name string) {
 	}
 }
 
+// unwrapTCPConn returns the TCP conn under the wrappers of a session conn,
+// like the one counting its traffic
+func unwrapTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			return tcpConn, true
+		}
+		wrapper, ok := conn.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return nil, false
+		}
+		conn = wrapper.Unwrap()
+	}
+}
+
 func stopProxyOnCancel(ctx context.Context, client, target net.Conn, logger interfaces.Logger) func() {
 	done := make(chan struct{})
 	go func() {
@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -12,8 +13,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
 	"github.com/mushorg/glutton/rules"
+	"github.com/mushorg/glutton/signatures"
+	"github.com/mushorg/glutton/tlscert"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
@@ -69,19 +76,29 @@ func (l *recordingLogger) hasAttr(key, value string) bool {
 
 type producedTCP struct {
 	protocol string
+	md       connection.Metadata
+	payload  []byte
+	raw      []byte
 	decoded  interface{}
 }
 
 type fakeHoneypot struct {
-	produced chan producedTCP
+	produced   chan producedTCP
+	signatures *signatures.Engine
+	stored     [][]byte
+	persona    *persona.Persona
 }
 
 func newFakeHoneypot() *fakeHoneypot {
 	return &fakeHoneypot{produced: make(chan producedTCP, 4)}
 }
 
-func (h *fakeHoneypot) ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}) error {
-	h.produced <- producedTCP{protocol: protocol, decoded: decoded}
+func (h *fakeHoneypot) ProduceTCP(protocol string, conn net.Conn, md connection.Metadata, payload []byte, decoded interface{}, opts ...producer.EventOption) error {
+	event := &producer.Event{}
+	for _, opt := range opts {
+		opt(event)
+	}
+	h.produced <- producedTCP{protocol: protocol, md: md, payload: payload, raw: event.Raw, decoded: decoded}
 	return nil
 }
 
@@ -89,8 +106,8 @@ func (h *fakeHoneypot) ProduceUDP(handler string, srcAddr, dstAddr *net.UDPAddr,
 	return nil
 }
 
-func (h *fakeHoneypot) ConnectionByFlow([2]uint64) connection.Metadata {
-	return connection.Metadata{}
+func (h *fakeHoneypot) ConnectionByFlow(connection.CKey) (connection.Metadata, bool) {
+	return connection.Metadata{}, false
 }
 
 func (h *fakeHoneypot) UpdateConnectionTimeout(context.Context, net.Conn) error {
@@ -101,6 +118,39 @@ func (h *fakeHoneypot) MetadataByConnection(net.Conn) (connection.Metadata, erro
 	return connection.Metadata{}, nil
 }
 
+func (h *fakeHoneypot) RecordActivity(connection.Metadata, profile.Activity) {}
+
+func (h *fakeHoneypot) Store(_ connection.Metadata, data []byte, _ artifact.Info) (string, error) {
+	h.stored = append(h.stored, data)
+	return fmt.Sprintf("%064x", len(h.stored)), nil
+}
+
+func (h *fakeHoneypot) FetchSamples(connection.Metadata, string, []byte) {}
+
+func (h *fakeHoneypot) Authenticate(connection.Metadata, string, string, string) bool {
+	return true
+}
+
+func (h *fakeHoneypot) Persona(connection.Metadata) *persona.Persona {
+	return h.persona
+}
+
+func (h *fakeHoneypot) ListenData(connection.Metadata) (net.Listener, error) {
+	return net.Listen("tcp", "127.0.0.1:0")
+}
+
+func (h *fakeHoneypot) TLSConfig(connection.Metadata) (*tls.Config, error) {
+	cert, err := tlscert.Generate("localhost")
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func (h *fakeHoneypot) MatchSignatures(_ connection.Metadata, handler string, payload []byte) []*signatures.Signature {
+	return h.signatures.Match(handler, payload)
+}
+
 func setCapture(t *testing.T, enabled bool) {
 	t.Helper()
 
@@ -371,6 +421,23 @@ func TestExpectedPipeErrorAllowsNotConnected(t *testing.T) {
 	require.True(t, expectedPipeError(err))
 }
 
+func TestUnwrapTCPConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tcpConn, ok := unwrapTCPConn(connection.WrapConn(conn, &connection.Stats{}))
+	require.True(t, ok, "keepalive is set on the conn under the session wrapper")
+	require.Same(t, conn, tcpConn)
+
+	client, _ := net.Pipe()
+	_, ok = unwrapTCPConn(client)
+	require.False(t, ok)
+}
+
 // test proxy handler closes connection on missing metadata
 func TestMissingMetadata(t *testing.T) {
 	setCapture(t, true)
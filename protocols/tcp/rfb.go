@@ -8,6 +8,7 @@ import (
 	"net"
 
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
 	"github.com/mushorg/glutton/protocols/interfaces"
 )
@@ -54,7 +55,7 @@ func HandleRFB(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 		return err
 	}
 
-	serverName := "rfb-go"
+	serverName := h.Persona(md).Banner(persona.BannerRFB, "rfb-go")
 	lenName := int32(len(serverName))
 
 	f := PixelFormat{
@@ -75,5 +76,8 @@ func HandleRFB(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 	if err := binary.Write(conn, binary.LittleEndian, f); err != nil {
 		return err
 	}
+	if _, err := conn.Write([]byte(serverName)); err != nil {
+		return err
+	}
 	return readRFB(conn, logger)
 }
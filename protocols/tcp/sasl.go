@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	errAuthAborted   = errors.New("authentication aborted")
+	errAuthDecode    = errors.New("cannot decode response")
+	errAuthMalformed = errors.New("malformed response")
+	errAuthMechanism = errors.New("unsupported mechanism")
+)
+
+// saslMechanisms are the SASL mechanisms mail handlers offer, both reveal
+// the password
+var saslMechanisms = []string{"PLAIN", "LOGIN"}
+
+// saslLogin runs the PLAIN or LOGIN exchange of SMTP AUTH, POP3 AUTH and
+// IMAP AUTHENTICATE and returns the credentials. challenge sends a base64
+// challenge and returns the response line of the client. initial is the
+// response sent along with the command, if any.
+func saslLogin(mechanism, initial string, challenge func(string) (string, error)) (string, string, error) {
+	response := func(msg, initial string) (string, error) {
+		if initial == "" {
+			var err error
+			if initial, err = challenge(base64.StdEncoding.EncodeToString([]byte(msg))); err != nil {
+				return "", err
+			}
+		}
+		switch initial = strings.TrimSpace(initial); initial {
+		case "*":
+			return "", errAuthAborted
+		case "=":
+			// an empty initial response
+			return "", nil
+		}
+		data, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return "", errAuthDecode
+		}
+		return string(data), nil
+	}
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		resp, err := response("", initial)
+		if err != nil {
+			return "", "", err
+		}
+		// authorization identity, username and password
+		fields := strings.SplitN(resp, "\x00", 3)
+		if len(fields) != 3 {
+			return "", "", errAuthMalformed
+		}
+		return fields[1], fields[2], nil
+	case "LOGIN":
+		username, err := response("Username:", initial)
+		if err != nil {
+			return "", "", err
+		}
+		password, err := response("Password:", "")
+		return username, password, err
+	}
+	return "", "", errAuthMechanism
+}
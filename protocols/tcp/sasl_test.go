@@ -0,0 +1,37 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSASLLogin(t *testing.T) {
+	// AHVzZXIAc2VjcmV0 is \x00user\x00secret
+	username, password, err := saslLogin("plain", "AHVzZXIAc2VjcmV0", nil)
+	require.NoError(t, err)
+	require.Equal(t, "user", username)
+	require.Equal(t, "secret", password)
+
+	var challenges []string
+	responses := []string{"YWRtaW4=", "cGFzc3dvcmQ="}
+	username, password, err = saslLogin("LOGIN", "", func(challenge string) (string, error) {
+		challenges = append(challenges, challenge)
+		response := responses[0]
+		responses = responses[1:]
+		return response, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"VXNlcm5hbWU6", "UGFzc3dvcmQ6"}, challenges)
+	require.Equal(t, "admin", username)
+	require.Equal(t, "password", password)
+
+	_, _, err = saslLogin("CRAM-MD5", "", nil)
+	require.ErrorIs(t, err, errAuthMechanism)
+	_, _, err = saslLogin("PLAIN", "*", nil)
+	require.ErrorIs(t, err, errAuthAborted)
+	_, _, err = saslLogin("PLAIN", "!!", nil)
+	require.ErrorIs(t, err, errAuthDecode)
+	_, _, err = saslLogin("PLAIN", "dXNlcg==", nil)
+	require.ErrorIs(t, err, errAuthMalformed)
+}
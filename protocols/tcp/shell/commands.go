@@ -0,0 +1,966 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// call is the invocation of a command
+type call struct {
+	args   []string
+	stdin  []byte
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type commandFunc func(s *Shell, c *call) int
+
+// builtins are the commands of the shell itself, run without a PATH lookup
+var builtins map[string]commandFunc
+
+// applets are the commands of the busybox binary, linked from /bin
+var applets map[string]commandFunc
+
+func init() {
+	builtins = map[string]commandFunc{
+		"cd":     cd,
+		"echo":   echo,
+		"exit":   exit,
+		"export": export,
+		"false":  func(*Shell, *call) int { return 1 },
+		"help":   help,
+		"kill":   kill,
+		"printf": printf,
+		"pwd":    pwd,
+		"true":   func(*Shell, *call) int { return 0 },
+		"unset":  unset,
+	}
+	applets = map[string]commandFunc{
+		"ash":      sh,
+		"busybox":  busybox,
+		"cat":      cat,
+		"chmod":    chmod,
+		"cp":       cp,
+		"dd":       dd,
+		"echo":     echo,
+		"env":      env,
+		"false":    builtins["false"],
+		"ftpget":   ftpget,
+		"grep":     grep,
+		"head":     head,
+		"hostname": hostname,
+		"id":       id,
+		"kill":     kill,
+		"ls":       ls,
+		"mkdir":    mkdir,
+		"mv":       mv,
+		"ps":       ps,
+		"pwd":      pwd,
+		"rm":       rm,
+		"sh":       sh,
+		"sleep":    func(*Shell, *call) int { return 0 },
+		"tftp":     tftp,
+		"touch":    touch,
+		"true":     builtins["true"],
+		"uname":    uname,
+		"wc":       wc,
+		"wget":     wget,
+		"which":    which,
+		"whoami":   whoami,
+	}
+}
+
+func appletNames() []string {
+	var names []string
+	for name := range applets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// flags splits leading single letter flags like -rf from the operands
+func flags(args []string) (map[byte]bool, []string) {
+	set := make(map[byte]bool)
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			return set, args[1:]
+		}
+		for _, f := range []byte(args[0][1:]) {
+			set[f] = true
+		}
+		args = args[1:]
+	}
+	return set, args
+}
+
+// busybox runs the applet named by the first argument
+func busybox(s *Shell, c *call) int {
+	name := c.args[0]
+	if name == "busybox" {
+		if len(c.args) == 1 {
+			fmt.Fprintf(c.stdout, "%s multi-call binary.\n\nUsage: busybox [function] [arguments]...\n\nCurrently defined functions:\n\t%s\n", s.opts.Version, strings.Join(appletNames(), ", "))
+			return 0
+		}
+		c.args = c.args[1:]
+		name = c.args[0]
+	}
+	applet := applets[name]
+	if applet == nil {
+		fmt.Fprintf(c.stderr, "%s: applet not found\n", name)
+		return 127
+	}
+	return applet(s, c)
+}
+
+func help(s *Shell, c *call) int {
+	var names []string
+	for name := range builtins {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fmt.Fprintf(c.stdout, "\nBuilt-in commands:\n-------------------\n\t%s\n\n", strings.Join(names, " "))
+	return 0
+}
+
+func sh(s *Shell, c *call) int {
+	switch {
+	case len(c.args) > 2 && c.args[1] == "-c":
+		return s.source([]byte(c.args[2]), &call{args: c.args[2:], stdout: c.stdout, stderr: c.stderr})
+	case len(c.args) > 1:
+		data, err := s.fs.read(s.abs(c.args[1]))
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: can't open '%s'\n", c.args[0], c.args[1])
+			return 2
+		}
+		return s.source(data, &call{args: c.args[1:], stdout: c.stdout, stderr: c.stderr})
+	case c.stdin != nil:
+		// like cat x | sh
+		return s.source(c.stdin, &call{args: c.args, stdout: c.stdout, stderr: c.stderr})
+	}
+	// an interactive shell within the shell looks just the same
+	return 0
+}
+
+func exit(s *Shell, c *call) int {
+	s.exited = true
+	if len(c.args) > 1 {
+		if status, err := strconv.Atoi(c.args[1]); err == nil {
+			return status
+		}
+	}
+	return s.status
+}
+
+func cd(s *Shell, c *call) int {
+	dir := s.env["HOME"]
+	if len(c.args) > 1 {
+		dir = c.args[1]
+	}
+	if dir == "-" {
+		dir = s.env["OLDPWD"]
+	}
+	p := s.abs(dir)
+	f := s.fs.stat(p)
+	switch {
+	case f == nil:
+		fmt.Fprintf(c.stderr, "-sh: cd: can't cd to %s: %v\n", dir, errNotExist)
+		return 1
+	case !f.dir:
+		fmt.Fprintf(c.stderr, "-sh: cd: can't cd to %s: %v\n", dir, errNotDir)
+		return 1
+	}
+	s.env["OLDPWD"] = s.cwd
+	s.cwd = p
+	s.env["PWD"] = p
+	return 0
+}
+
+func pwd(s *Shell, c *call) int {
+	fmt.Fprintln(c.stdout, s.cwd)
+	return 0
+}
+
+func export(s *Shell, c *call) int {
+	if len(c.args) == 1 {
+		for _, kv := range s.environ() {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(c.stdout, "export %s='%s'\n", name, value)
+		}
+		return 0
+	}
+	for _, arg := range c.args[1:] {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			s.env[name] = value
+		} else if _, ok := s.env[arg]; !ok {
+			s.env[arg] = ""
+		}
+	}
+	return 0
+}
+
+func unset(s *Shell, c *call) int {
+	for _, name := range c.args[1:] {
+		delete(s.env, name)
+	}
+	return 0
+}
+
+func env(s *Shell, c *call) int {
+	for _, kv := range s.environ() {
+		fmt.Fprintln(c.stdout, kv)
+	}
+	return 0
+}
+
+func kill(s *Shell, c *call) int {
+	if len(c.args) == 1 {
+		fmt.Fprintln(c.stderr, "kill: usage: kill [-s sigspec | -signum | -sigspec] [pid | job]... or kill -l [exitstatus]")
+		return 1
+	}
+	return 0
+}
+
+func echo(s *Shell, c *call) int {
+	args := c.args[1:]
+	newline, escapes := true, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, f := range args[0][1:] {
+			switch f {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+	out := []byte(strings.Join(args, " "))
+	if escapes {
+		var stop bool
+		out, stop = unescape(out)
+		newline = newline && !stop
+	}
+	if newline {
+		out = append(out, '\n')
+	}
+	c.stdout.Write(out)
+	return 0
+}
+
+// unescape processes the backslash escapes of echo -e and printf. It
+// reports whether output should stop, at \c.
+func unescape(in []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		if in[i] != '\\' || i+1 == len(in) {
+			out = append(out, in[i])
+			continue
+		}
+		i++
+		switch c := in[i]; c {
+		case 'a':
+			out = append(out, '\a')
+		case 'b':
+			out = append(out, '\b')
+		case 'c':
+			return out, true
+		case 'e':
+			out = append(out, 0x1b)
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case '\\':
+			out = append(out, '\\')
+		case 'x':
+			n, j := 0, i+1
+			for ; j < len(in) && j < i+3 && isHex(in[j]); j++ {
+				n = n<<4 | hexValue(in[j])
+			}
+			if j == i+1 {
+				out = append(out, '\\', 'x')
+				continue
+			}
+			out = append(out, byte(n))
+			i = j - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n, j := 0, i
+			if c == '0' {
+				j++
+			}
+			for limit := j + 3; j < len(in) && j < limit && in[j] >= '0' && in[j] <= '7'; j++ {
+				n = n<<3 | int(in[j]-'0')
+			}
+			out = append(out, byte(n))
+			i = j - 1
+		default:
+			out = append(out, '\\', c)
+		}
+	}
+	return out, false
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+// printf supports the %s, %b, %d and %% conversions
+func printf(s *Shell, c *call) int {
+	if len(c.args) < 2 {
+		fmt.Fprintln(c.stderr, "printf: usage: printf format [arguments]")
+		return 2
+	}
+	format, _ := unescape([]byte(c.args[1]))
+	args := c.args[2:]
+	next := func() string {
+		if len(args) == 0 {
+			return ""
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg
+	}
+	var out []byte
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			out = append(out, format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 's':
+			out = append(out, next()...)
+		case 'b':
+			arg, _ := unescape([]byte(next()))
+			out = append(out, arg...)
+		case 'd':
+			n, _ := strconv.Atoi(next())
+			out = strconv.AppendInt(out, int64(n), 10)
+		case '%':
+			out = append(out, '%')
+		default:
+			out = append(out, '%', format[i])
+		}
+	}
+	c.stdout.Write(out)
+	return 0
+}
+
+// input returns the content of the named files, or stdin without any
+func (s *Shell) input(c *call, names []string) ([]byte, int) {
+	if len(names) == 0 {
+		return c.stdin, 0
+	}
+	var data []byte
+	status := 0
+	for _, name := range names {
+		content, err := s.fs.read(s.abs(name))
+		if err != nil {
+			if err == errIsDir {
+				fmt.Fprintf(c.stderr, "%s: read error: %v\n", c.args[0], err)
+			} else {
+				fmt.Fprintf(c.stderr, "%s: can't open '%s': %v\n", c.args[0], name, err)
+			}
+			status = 1
+			continue
+		}
+		data = append(data, content...)
+	}
+	return data, status
+}
+
+func cat(s *Shell, c *call) int {
+	_, names := flags(c.args[1:])
+	data, status := s.input(c, names)
+	c.stdout.Write(data)
+	return status
+}
+
+func head(s *Shell, c *call) int {
+	n, byteCount, args := 10, false, c.args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0][1:]
+		args = args[1:]
+		if arg == "-" {
+			break
+		}
+		// -5 is short for -n 5
+		if arg[0] >= '0' && arg[0] <= '9' {
+			arg = "n" + arg
+		}
+		opt, value := arg[0], arg[1:]
+		if opt != 'n' && opt != 'c' {
+			fmt.Fprintf(c.stderr, "head: invalid option -- '%c'\n", opt)
+			fmt.Fprintln(c.stderr, "Usage: head [OPTIONS] [FILE]...")
+			return 1
+		}
+		if value == "" {
+			if len(args) == 0 {
+				fmt.Fprintf(c.stderr, "head: option requires an argument -- '%c'\n", opt)
+				return 1
+			}
+			value, args = args[0], args[1:]
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			fmt.Fprintf(c.stderr, "head: invalid number '%s'\n", value)
+			return 1
+		}
+		n, byteCount = count, opt == 'c'
+	}
+	data, status := s.input(c, args)
+	if byteCount {
+		c.stdout.Write(data[:min(n, len(data))])
+		return status
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	c.stdout.Write(bytes.Join(lines[:min(n, len(lines))], nil))
+	return status
+}
+
+func grep(s *Shell, c *call) int {
+	set, args := flags(c.args[1:])
+	if len(args) == 0 {
+		fmt.Fprintln(c.stderr, "Usage: grep [-HhnlLoqvsriwFE] [-m N] [-A/B/C N] PATTERN/-e PATTERN.../-f FILE [FILE]...")
+		return 2
+	}
+	pattern := args[0]
+	data, status := s.input(c, args[1:])
+	if set['i'] {
+		pattern = strings.ToLower(pattern)
+	}
+	matches := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		text := line
+		if set['i'] {
+			text = strings.ToLower(text)
+		}
+		if strings.Contains(text, pattern) == set['v'] {
+			continue
+		}
+		matches++
+		if !set['q'] && !set['c'] {
+			io.WriteString(c.stdout, strings.TrimSuffix(line, "\n")+"\n")
+		}
+	}
+	if set['c'] {
+		fmt.Fprintln(c.stdout, matches)
+	}
+	if status != 0 {
+		return 2
+	}
+	if matches == 0 {
+		return 1
+	}
+	return 0
+}
+
+func wc(s *Shell, c *call) int {
+	set, names := flags(c.args[1:])
+	data, status := s.input(c, names)
+	counts := map[byte]int{
+		'l': bytes.Count(data, []byte("\n")),
+		'w': len(bytes.Fields(data)),
+		'c': len(data),
+	}
+	var fields []string
+	for _, f := range []byte("lwc") {
+		if set[f] || len(set) == 0 {
+			fields = append(fields, fmt.Sprintf("%7d", counts[f]))
+		}
+	}
+	fmt.Fprintln(c.stdout, strings.TrimSpace(strings.Join(fields, " ")))
+	return status
+}
+
+func ls(s *Shell, c *call) int {
+	set, names := flags(c.args[1:])
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	sep := "  "
+	if set['l'] || set['1'] {
+		sep = "\n"
+	}
+	status := 0
+	var files []string
+	var dirs []string
+	for _, name := range names {
+		p := s.abs(name)
+		f := s.fs.stat(p)
+		if f == nil {
+			fmt.Fprintf(c.stderr, "ls: %s: %v\n", name, errNotExist)
+			status = 1
+			continue
+		}
+		if !f.dir || set['d'] {
+			files = append(files, s.lsEntry(set['l'], name, f))
+			continue
+		}
+		var entries []string
+		children := s.fs.list(p)
+		if set['a'] {
+			children = append([]string{".", ".."}, children...)
+		}
+		for _, child := range children {
+			if strings.HasPrefix(child, ".") && !set['a'] {
+				continue
+			}
+			f := s.fs.stat(path.Join(p, child))
+			if f == nil {
+				f = &file{dir: true, mode: 0o755}
+			}
+			entries = append(entries, s.lsEntry(set['l'], child, f))
+		}
+		listing := strings.Join(entries, sep)
+		if len(names) > 1 {
+			listing = name + ":\n" + listing
+		}
+		if listing != "" {
+			dirs = append(dirs, listing)
+		}
+	}
+	if len(files) > 0 {
+		dirs = append([]string{strings.Join(files, sep)}, dirs...)
+	}
+	if len(dirs) > 0 {
+		fmt.Fprintln(c.stdout, strings.Join(dirs, "\n\n"))
+	}
+	return status
+}
+
+func (s *Shell) lsEntry(long bool, name string, f *file) string {
+	if !long {
+		return name
+	}
+	links := 1
+	if f.dir {
+		links = 2
+	}
+	return fmt.Sprintf("%s %4d root     root     %8d %s %s", lsMode(f), links, len(f.data), f.mtime.Format("Jan _2 15:04"), name)
+}
+
+// lsMode renders the mode of f the way ls -l does, like drwxrwxrwt, unlike
+// fs.FileMode.String which puts the special bits in front
+func lsMode(f *file) string {
+	b := []byte("----------")
+	if f.dir {
+		b[0] = 'd'
+	}
+	for i, c := range "rwxrwxrwx" {
+		if f.mode&(1<<(8-i)) != 0 {
+			b[i+1] = byte(c)
+		}
+	}
+	for _, special := range []struct {
+		bit fs.FileMode
+		pos int
+		c   byte
+	}{{fs.ModeSetuid, 3, 's'}, {fs.ModeSetgid, 6, 's'}, {fs.ModeSticky, 9, 't'}} {
+		if f.mode&special.bit == 0 {
+			continue
+		}
+		// upper case when the execute bit underneath isn't set
+		if b[special.pos] == 'x' {
+			b[special.pos] = special.c
+		} else {
+			b[special.pos] = special.c - 'a' + 'A'
+		}
+	}
+	return string(b)
+}
+
+func rm(s *Shell, c *call) int {
+	set, names := flags(c.args[1:])
+	status := 0
+	for _, name := range names {
+		p := s.abs(name)
+		f := s.fs.stat(p)
+		if f != nil && f.dir && !set['r'] && !set['R'] {
+			fmt.Fprintf(c.stderr, "rm: '%s' is a directory\n", name)
+			status = 1
+			continue
+		}
+		if err := s.fs.remove(p, true); err != nil && !(set['f'] && err == errNotExist) {
+			fmt.Fprintf(c.stderr, "rm: can't remove '%s': %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func mkdir(s *Shell, c *call) int {
+	set, names := flags(c.args[1:])
+	status := 0
+	for _, name := range names {
+		p := s.abs(name)
+		if set['p'] {
+			// create the missing parents too
+			var err error
+			for dir := ""; err == nil && dir != p; {
+				next, _, _ := strings.Cut(strings.TrimPrefix(p, dir+"/"), "/")
+				dir += "/" + next
+				if f := s.fs.stat(dir); f == nil || !f.dir {
+					err = s.fs.mkdir(dir)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(c.stderr, "mkdir: can't create directory '%s': %v\n", name, err)
+				status = 1
+			}
+			continue
+		}
+		if err := s.fs.mkdir(p); err != nil {
+			fmt.Fprintf(c.stderr, "mkdir: can't create directory '%s': %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func touch(s *Shell, c *call) int {
+	_, names := flags(c.args[1:])
+	status := 0
+	for _, name := range names {
+		p := s.abs(name)
+		if s.fs.stat(p) != nil {
+			continue
+		}
+		if err := s.fs.write(p, nil, false); err != nil {
+			fmt.Fprintf(c.stderr, "touch: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func chmod(s *Shell, c *call) int {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "-R" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(c.stderr, "Usage: chmod [-Rcvf] MODE[,MODE]... FILE...")
+		return 1
+	}
+	status := 0
+	for _, name := range args[1:] {
+		f := s.fs.stat(s.abs(name))
+		if f == nil {
+			fmt.Fprintf(c.stderr, "chmod: %s: %v\n", name, errNotExist)
+			status = 1
+			continue
+		}
+		mode, ok := parseMode(args[0], f.mode)
+		if !ok {
+			fmt.Fprintf(c.stderr, "chmod: invalid mode '%s'\n", args[0])
+			return 1
+		}
+		f.mode = mode
+	}
+	return status
+}
+
+// parseMode applies an octal or symbolic mode like 777 or u+x,go-w to mode
+func parseMode(spec string, mode fs.FileMode) (fs.FileMode, bool) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil && n <= 0o7777 {
+		mode = mode&^(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) | fs.FileMode(n)&fs.ModePerm
+		for bit, special := range map[uint64]fs.FileMode{0o4000: fs.ModeSetuid, 0o2000: fs.ModeSetgid, 0o1000: fs.ModeSticky} {
+			if n&bit != 0 {
+				mode |= special
+			}
+		}
+		return mode, true
+	}
+	for _, clause := range strings.Split(spec, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return mode, false
+		}
+		var who fs.FileMode
+		for _, w := range clause[:i] {
+			switch w {
+			case 'u':
+				who |= 0o700
+			case 'g':
+				who |= 0o070
+			case 'o':
+				who |= 0o007
+			case 'a':
+				who |= 0o777
+			default:
+				return mode, false
+			}
+		}
+		if who == 0 {
+			who = 0o777
+		}
+		var perm fs.FileMode
+		for _, p := range clause[i+1:] {
+			switch p {
+			case 'r':
+				perm |= 0o444
+			case 'w':
+				perm |= 0o222
+			case 'x':
+				perm |= 0o111
+			default:
+				return mode, false
+			}
+		}
+		switch clause[i] {
+		case '+':
+			mode |= perm & who
+		case '-':
+			mode &^= perm & who
+		case '=':
+			mode = mode&^who | perm&who
+		}
+	}
+	return mode, true
+}
+
+func cp(s *Shell, c *call) int {
+	_, args := flags(c.args[1:])
+	if len(args) < 2 {
+		fmt.Fprintln(c.stderr, "Usage: cp [OPTIONS] SOURCE... DEST")
+		return 1
+	}
+	status := 0
+	dest := args[len(args)-1]
+	for _, name := range args[:len(args)-1] {
+		f := s.fs.stat(s.abs(name))
+		if f == nil {
+			fmt.Fprintf(c.stderr, "cp: can't stat '%s': %v\n", name, errNotExist)
+			status = 1
+			continue
+		}
+		if f.dir {
+			fmt.Fprintf(c.stderr, "cp: omitting directory '%s'\n", name)
+			status = 1
+			continue
+		}
+		target := s.target(dest, name)
+		if err := s.fs.write(target, f.data, false); err != nil {
+			fmt.Fprintf(c.stderr, "cp: can't create '%s': %v\n", dest, err)
+			status = 1
+			continue
+		}
+		copied := s.fs.stat(target)
+		copied.mode, copied.applet = f.mode, f.applet
+	}
+	return status
+}
+
+func mv(s *Shell, c *call) int {
+	_, args := flags(c.args[1:])
+	if len(args) < 2 {
+		fmt.Fprintln(c.stderr, "Usage: mv [-fin] SOURCE DEST")
+		return 1
+	}
+	status := 0
+	dest := args[len(args)-1]
+	for _, name := range args[:len(args)-1] {
+		if err := s.fs.rename(s.abs(name), s.target(dest, name)); err != nil {
+			fmt.Fprintf(c.stderr, "mv: can't rename '%s': %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// target returns where cp and mv put name, into dest if it's a directory
+func (s *Shell) target(dest, name string) string {
+	p := s.abs(dest)
+	if f := s.fs.stat(p); f != nil && f.dir {
+		return path.Join(p, path.Base(name))
+	}
+	return p
+}
+
+func dd(s *Shell, c *call) int {
+	ops := make(map[string]string)
+	for _, arg := range c.args[1:] {
+		if k, v, ok := strings.Cut(arg, "="); ok {
+			ops[k] = v
+		}
+	}
+	data := c.stdin
+	if name, ok := ops["if"]; ok {
+		var err error
+		if data, err = s.fs.read(s.abs(name)); err != nil {
+			fmt.Fprintf(c.stderr, "dd: can't open '%s': %v\n", name, err)
+			return 1
+		}
+	}
+	bs := 512
+	if n, err := strconv.Atoi(ops["bs"]); err == nil && n > 0 {
+		bs = n
+	}
+	if n, err := strconv.Atoi(ops["skip"]); err == nil && n > 0 {
+		data = data[min(n*bs, len(data)):]
+	}
+	if n, err := strconv.Atoi(ops["count"]); err == nil && n >= 0 {
+		data = data[:min(n*bs, len(data))]
+	}
+	if name, ok := ops["of"]; ok {
+		if err := s.fs.write(s.abs(name), data, false); err != nil {
+			fmt.Fprintf(c.stderr, "dd: can't open '%s': %v\n", name, err)
+			return 1
+		}
+	} else {
+		c.stdout.Write(data)
+	}
+	full, partial := len(data)/bs, 0
+	if len(data)%bs > 0 {
+		partial = 1
+	}
+	fmt.Fprintf(c.stderr, "%d+%d records in\n%d+%d records out\n", full, partial, full, partial)
+	return 0
+}
+
+func ps(s *Shell, c *call) int {
+	fmt.Fprint(c.stdout, `  PID USER       VSZ STAT COMMAND
+    1 root      1532 S    init
+    2 root         0 SW   [kthreadd]
+    3 root         0 SW   [ksoftirqd/0]
+  412 root      1536 S    /sbin/syslogd
+  437 root      1532 S    telnetd
+  612 root     24980 S    ./hik_server
+ 1337 root      1540 S    -sh
+ 1341 root      1532 R    ps
+`)
+	return 0
+}
+
+func uname(s *Shell, c *call) int {
+	set, _ := flags(c.args[1:])
+	fields := []struct {
+		flag  byte
+		value string
+	}{
+		{'s', s.opts.OS}, {'n', s.opts.Hostname}, {'r', s.opts.Kernel}, {'v', s.opts.KernelBuild}, {'m', s.opts.Machine},
+	}
+	var out []string
+	for _, f := range fields {
+		if set[f.flag] || set['a'] || len(set) == 0 && f.flag == 's' {
+			out = append(out, f.value)
+		}
+	}
+	if set['a'] {
+		out = append(out, "GNU/Linux")
+	}
+	fmt.Fprintln(c.stdout, strings.Join(out, " "))
+	return 0
+}
+
+func hostname(s *Shell, c *call) int {
+	fmt.Fprintln(c.stdout, s.opts.Hostname)
+	return 0
+}
+
+func id(s *Shell, c *call) int {
+	fmt.Fprintln(c.stdout, "uid=0(root) gid=0(root) groups=0(root)")
+	return 0
+}
+
+func whoami(s *Shell, c *call) int {
+	fmt.Fprintln(c.stdout, "root")
+	return 0
+}
+
+func which(s *Shell, c *call) int {
+	status := 0
+	for _, name := range c.args[1:] {
+		if p := s.lookPath(name); p != "" {
+			fmt.Fprintln(c.stdout, p)
+		} else {
+			status = 1
+		}
+	}
+	return status
+}
+
+// The download applets never reach the network, the samples are fetched
+// by the honeypot. Bots that fail with one usually try the next.
+
+func wget(s *Shell, c *call) int {
+	var target string
+	for _, arg := range c.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			target = arg
+		}
+	}
+	if target == "" {
+		fmt.Fprintln(c.stderr, "Usage: wget [-c|--continue] [-s|--spider] [-q|--quiet] [-O|--output-document FILE]\n\t[--header 'header: value'] [-Y|--proxy on/off] [-P DIR]\n\t[-U|--user-agent AGENT] URL")
+		return 1
+	}
+	host := target
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host
+		if u.Port() == "" {
+			host += ":80"
+		}
+	}
+	fmt.Fprintf(c.stderr, "Connecting to %s (%s)\nwget: can't connect to remote host (%s): Connection timed out\n", target, host, host)
+	return 1
+}
+
+func tftp(s *Shell, c *call) int {
+	if len(c.args) < 2 {
+		fmt.Fprintln(c.stderr, "Usage: tftp [OPTIONS] HOST [PORT]")
+		return 1
+	}
+	fmt.Fprintln(c.stderr, "tftp: timeout")
+	return 1
+}
+
+func ftpget(s *Shell, c *call) int {
+	var args []string
+	for i := 1; i < len(c.args); i++ {
+		switch arg := c.args[i]; {
+		case arg == "-u" || arg == "-p" || arg == "-P":
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			args = append(args, arg)
+		}
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(c.stderr, "Usage: ftpget [OPTIONS] HOST [LOCAL_FILE] REMOTE_FILE")
+		return 1
+	}
+	fmt.Fprintf(c.stderr, "ftpget: can't connect to remote host (%s): Connection timed out\n", args[0])
+	return 1
+}
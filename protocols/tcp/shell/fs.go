@@ -0,0 +1,348 @@
+package shell
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxFSSize caps the bytes a session can write to its filesystem
+const maxFSSize = 4 << 20
+
+// Errors carry the strerror text BusyBox prints
+var (
+	errNotExist = errors.New("No such file or directory")
+	errExist    = errors.New("File exists")
+	errIsDir    = errors.New("Is a directory")
+	errNotDir   = errors.New("Not a directory")
+	errNotEmpty = errors.New("Directory not empty")
+	errPerm     = errors.New("Permission denied")
+	errNoSpace  = errors.New("No space left on device")
+)
+
+// file is a regular file or directory of the virtual filesystem
+type file struct {
+	dir   bool
+	mode  fs.FileMode
+	data  []byte
+	mtime time.Time
+	// applet marks a link to the busybox binary, run as the applet of its name
+	applet bool
+}
+
+// filesystem is a session's in-memory filesystem keyed by absolute path
+type filesystem struct {
+	files map[string]*file
+	used  int
+}
+
+// elfHeader is the header of a 32-bit ARM executable, what bots read from
+// /bin/echo to pick the architecture of their payload
+var elfHeader = []byte{
+	0x7f, 'E', 'L', 'F', 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x02, 0x00, 0x28, 0x00, 0x01, 0x00, 0x00, 0x00, 0xbc, 0x14, 0x01, 0x00, 0x34, 0x00, 0x00, 0x00,
+	0xa0, 0xc4, 0x03, 0x00, 0x02, 0x00, 0x00, 0x05, 0x34, 0x00, 0x20, 0x00, 0x05, 0x00, 0x28, 0x00,
+	0x14, 0x00, 0x13, 0x00,
+}
+
+const cpuinfo = `Processor	: ARMv7 Processor rev 5 (v7l)
+BogoMIPS	: 1196.85
+Features	: swp half thumb fastmult vfp edsp neon vfpv3 tls
+CPU implementer	: 0x41
+CPU architecture: 7
+CPU variant	: 0x0
+CPU part	: 0xc09
+CPU revision	: 5
+
+Hardware	: Hisilicon Hi3518
+Revision	: 0000
+Serial		: 0000000000000000
+`
+
+const mounts = `rootfs / rootfs rw 0 0
+/dev/root / squashfs ro,relatime 0 0
+proc /proc proc rw,relatime 0 0
+sysfs /sys sysfs rw,relatime 0 0
+udev /dev tmpfs rw,relatime 0 0
+devpts /dev/pts devpts rw,relatime,mode=600,ptmxmode=000 0 0
+/dev/mtdblock1 /home/hik jffs2 rw,relatime 0 0
+tmpfs /run tmpfs rw,nosuid,noexec,relatime,size=3231524k,mode=755 0 0
+tmpfs /tmp tmpfs rw,relatime 0 0
+`
+
+const meminfo = `MemTotal:          59628 kB
+MemFree:           21740 kB
+Buffers:            1420 kB
+Cached:            14412 kB
+SwapCached:            0 kB
+`
+
+// newFilesystem returns the filesystem of a fresh session
+func newFilesystem(opts Options, now time.Time) *filesystem {
+	fsys := &filesystem{files: make(map[string]*file)}
+	for _, dir := range []string{
+		"/", "/bin", "/sbin", "/usr", "/usr/bin", "/usr/sbin", "/etc", "/dev", "/dev/pts", "/dev/shm",
+		"/home", "/home/hik", "/lib", "/mnt", "/mnt/mtd", "/proc", "/proc/self", "/sys", "/root", "/run",
+		"/tmp", "/var", "/var/run", "/var/tmp",
+	} {
+		fsys.files[dir] = &file{dir: true, mode: 0o755, mtime: now}
+	}
+	fsys.files["/tmp"].mode = 0o777 | fs.ModeSticky
+	for _, name := range appletNames() {
+		fsys.files["/bin/"+name] = &file{mode: 0o755, data: elfHeader, mtime: now, applet: true}
+	}
+	for name, data := range map[string]string{
+		"/etc/passwd":        "root:x:0:0:root:/root:/bin/sh\n",
+		"/etc/group":         "root:x:0:\n",
+		"/etc/hostname":      opts.Hostname + "\n",
+		"/etc/resolv.conf":   "nameserver 192.168.1.1\n",
+		"/proc/cpuinfo":      cpuinfo,
+		"/proc/mounts":       mounts,
+		"/proc/meminfo":      meminfo,
+		"/proc/version":      opts.OS + " version " + opts.Kernel + " (root@localhost) (gcc version 4.4.1 (Hisilicon_v100(gcc4.4-290+uclibc_0.9.32.1+eabi+linuxpthread)) ) " + opts.KernelBuild + "\n",
+		"/proc/self/exe":     string(elfHeader),
+		"/proc/self/cmdline": "-sh\x00",
+		"/dev/null":          "",
+	} {
+		fsys.files[name] = &file{mode: 0o644, data: []byte(data), mtime: now}
+	}
+	fsys.files["/dev/null"].mode = 0o666
+	for name, data := range opts.Files {
+		p := path.Clean(name)
+		for dir := path.Dir(p); fsys.files[dir] == nil; dir = path.Dir(dir) {
+			fsys.files[dir] = &file{dir: true, mode: 0o755, mtime: now}
+		}
+		fsys.files[p] = &file{mode: 0o644, data: []byte(data), mtime: now}
+	}
+	return fsys
+}
+
+// readOnly reports whether p is on a filesystem the shell can't write to
+func readOnly(p string) bool {
+	for _, dir := range []string{"/proc", "/sys"} {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (fsys *filesystem) stat(p string) *file {
+	return fsys.files[p]
+}
+
+func (fsys *filesystem) read(p string) ([]byte, error) {
+	f := fsys.files[p]
+	switch {
+	case f == nil:
+		return nil, errNotExist
+	case f.dir:
+		return nil, errIsDir
+	}
+	return f.data, nil
+}
+
+// parent checks that the directory p would be created in exists
+func (fsys *filesystem) parent(p string) error {
+	dir := fsys.files[path.Dir(p)]
+	switch {
+	case dir == nil:
+		return errNotExist
+	case !dir.dir:
+		return errNotDir
+	}
+	return nil
+}
+
+// write replaces or appends to the content of file p, creating it if needed
+func (fsys *filesystem) write(p string, data []byte, appendData bool) error {
+	if p == "/dev/null" {
+		return nil
+	}
+	if readOnly(p) {
+		return errPerm
+	}
+	if err := fsys.parent(p); err != nil {
+		return err
+	}
+	f := fsys.files[p]
+	if f == nil {
+		f = &file{mode: 0o644}
+		fsys.files[p] = f
+	}
+	if f.dir {
+		return errIsDir
+	}
+	size := len(data)
+	if !appendData {
+		size -= len(f.data)
+	}
+	if fsys.used+size > maxFSSize {
+		return errNoSpace
+	}
+	fsys.used += size
+	if appendData {
+		// copy on write, the data may be shared with other files
+		f.data = append(f.data[:len(f.data):len(f.data)], data...)
+	} else {
+		f.data = slices.Clone(data)
+	}
+	f.mtime = time.Now()
+	f.applet = false
+	return nil
+}
+
+func (fsys *filesystem) mkdir(p string) error {
+	if fsys.files[p] != nil {
+		return errExist
+	}
+	if readOnly(p) {
+		return errPerm
+	}
+	if err := fsys.parent(p); err != nil {
+		return err
+	}
+	fsys.files[p] = &file{dir: true, mode: 0o755, mtime: time.Now()}
+	return nil
+}
+
+func (fsys *filesystem) remove(p string, recursive bool) error {
+	f := fsys.files[p]
+	switch {
+	case f == nil:
+		return errNotExist
+	case readOnly(p) || p == "/":
+		return errPerm
+	case f.dir && !recursive && len(fsys.list(p)) > 0:
+		return errNotEmpty
+	}
+	for name, child := range fsys.files {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			fsys.used -= len(child.data)
+			delete(fsys.files, name)
+		}
+	}
+	fsys.used = max(fsys.used, 0)
+	return nil
+}
+
+func (fsys *filesystem) rename(from, to string) error {
+	f := fsys.files[from]
+	switch {
+	case f == nil:
+		return errNotExist
+	case readOnly(from) || readOnly(to):
+		return errPerm
+	}
+	if err := fsys.parent(to); err != nil {
+		return err
+	}
+	moved := make(map[string]*file)
+	for name, child := range fsys.files {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			delete(fsys.files, name)
+			moved[to+strings.TrimPrefix(name, from)] = child
+		}
+	}
+	for name, child := range moved {
+		fsys.files[name] = child
+	}
+	return nil
+}
+
+// list returns the sorted names of the entries of directory p
+func (fsys *filesystem) list(p string) []string {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	var names []string
+	for name := range fsys.files {
+		if name != "/" && strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// FS is the filesystem of a session for handlers other than the shell, like
+// FTP. Paths are absolute and clean.
+type FS struct {
+	fsys *filesystem
+}
+
+// NewFS returns the filesystem a shell with opts starts with
+func NewFS(opts Options) *FS {
+	return &FS{fsys: newFilesystem(opts.withDefaults(), time.Now())}
+}
+
+// fileInfo describes a file of the filesystem
+type fileInfo struct {
+	name string
+	f    *file
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return int64(len(fi.f.data)) }
+func (fi fileInfo) ModTime() time.Time { return fi.f.mtime }
+func (fi fileInfo) IsDir() bool        { return fi.f.dir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.f.dir {
+		return fi.f.mode | fs.ModeDir
+	}
+	return fi.f.mode
+}
+
+// Stat describes the file p
+func (f *FS) Stat(p string) (fs.FileInfo, error) {
+	file := f.fsys.stat(p)
+	if file == nil {
+		return nil, errNotExist
+	}
+	return fileInfo{name: path.Base(p), f: file}, nil
+}
+
+// ReadFile returns the content of file p
+func (f *FS) ReadFile(p string) ([]byte, error) {
+	return f.fsys.read(p)
+}
+
+// WriteFile replaces or appends to the content of file p, creating it if
+// needed. Writes beyond the space of the session fail.
+func (f *FS) WriteFile(p string, data []byte, appendData bool) error {
+	return f.fsys.write(p, data, appendData)
+}
+
+// Mkdir creates directory p
+func (f *FS) Mkdir(p string) error {
+	return f.fsys.mkdir(p)
+}
+
+// Remove removes file p, directories must be empty
+func (f *FS) Remove(p string) error {
+	return f.fsys.remove(p, false)
+}
+
+// Rename moves file or directory from to to
+func (f *FS) Rename(from, to string) error {
+	return f.fsys.rename(from, to)
+}
+
+// ReadDir describes the entries of directory p, sorted by name
+func (f *FS) ReadDir(p string) ([]fs.FileInfo, error) {
+	dir := f.fsys.stat(p)
+	switch {
+	case dir == nil:
+		return nil, errNotExist
+	case !dir.dir:
+		return nil, errNotDir
+	}
+	var infos []fs.FileInfo
+	for _, name := range f.fsys.list(p) {
+		infos = append(infos, fileInfo{name: name, f: f.fsys.stat(path.Join(p, name))})
+	}
+	return infos, nil
+}
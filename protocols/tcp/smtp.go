@@ -2,37 +2,44 @@ package tcp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
 	"math/big"
 	"net"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
 	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/protocols/tcp/email"
 )
 
-// maximum lines that can be read after the "DATA" command
-const maxDataRead = 500
+const (
+	// maxSMTPLine caps the length of a command line
+	maxSMTPLine = 4096
+	// maxSMTPMessage is the advertised SIZE, the rest of bigger messages is
+	// discarded
+	maxSMTPMessage = 10 << 20
+	// maxSMTPRecipients caps the recipients of a message
+	maxSMTPRecipients = 100
+	// maxSMTPCommands caps the command lines kept per event
+	maxSMTPCommands = 100
+	// maxSMTPEventPayload caps the part of a message sent as event payload,
+	// the complete message is in the artifact store
+	maxSMTPEventPayload = 16 << 10
+)
 
-// Client is a connection container
-type Client struct {
-	conn   net.Conn
-	bufin  *bufio.Reader
-	bufout *bufio.Writer
-}
-
-func (c *Client) w(s string) {
-	c.bufout.WriteString(s + "\r\n")
-	c.bufout.Flush()
-}
-func (c *Client) read() (string, error) {
-	return c.bufin.ReadString('\n')
-}
+// smtpDelay slows down replies like a busy mail server
+var smtpDelay = randomSleep
 
 func randomSleep() error {
 	// between 0.5 - 1.5 seconds
@@ -44,86 +51,397 @@ func randomSleep() error {
 	time.Sleep(duration)
 	return nil
 }
-func validateMail(query string) bool {
-	email := regexp.MustCompile("^MAIL FROM:<.+@.+>$") // naive regex
-	return email.MatchString(query)
+
+// parsePath returns the address of a MAIL FROM or RCPT TO argument and its
+// parameters, like SIZE=1024. The null sender <> is valid.
+func parsePath(arg, prefix string) (string, string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	var addr, params string
+	if strings.HasPrefix(rest, "<") {
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return "", "", false
+		}
+		addr, params = rest[1:end], rest[end+1:]
+	} else {
+		// bots often leave out the brackets
+		addr, params, _ = strings.Cut(rest, " ")
+	}
+	if addr == "" || strings.EqualFold(addr, "postmaster") {
+		return addr, strings.TrimSpace(params), true
+	}
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok || local == "" || domain == "" || strings.ContainsAny(addr, " <>") {
+		return "", "", false
+	}
+	return addr, strings.TrimSpace(params), true
+}
+
+// smtpMessage is a message received with DATA
+type smtpMessage struct {
+	MailFrom   string   `json:"mailFrom"`
+	Recipients []string `json:"recipients"`
+	Size       int      `json:"size"`
+	SHA256     string   `json:"sha256,omitempty"`
+	Truncated  bool     `json:"truncated,omitempty"`
+	*email.Message
+}
+
+// parsedSMTP is the event of a message, or of the commands of a session
+// that sent none
+type parsedSMTP struct {
+	Helo string `json:"helo,omitempty"`
+	TLS  bool   `json:"tls,omitempty"`
+	// Username the client authenticated as
+	Username string       `json:"username,omitempty"`
+	Commands []string     `json:"commands,omitempty"`
+	Message  *smtpMessage `json:"message,omitempty"`
+}
+
+type smtpServer struct {
+	ctx      context.Context
+	conn     net.Conn
+	r        *bufio.Reader
+	md       connection.Metadata
+	logger   interfaces.Logger
+	h        interfaces.Honeypot
+	hostname string
+
+	commands []string
+	helo     string
+	tls      bool
+	username string
+	failures int
+	// mailFrom and recipients are the envelope of the current transaction
+	inMail     bool
+	mailFrom   string
+	recipients []string
+}
+
+func (s *smtpServer) read() (string, error) {
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("command line exceeds %d bytes", maxSMTPLine)
+	}
+	if err != nil {
+		return "", err
+	}
+	msg := strings.TrimRight(string(line), "\r\n")
+	if len(s.commands) < maxSMTPCommands {
+		s.commands = append(s.commands, msg)
+	}
+	return msg, nil
+}
+
+func (s *smtpServer) write(msg string) error {
+	_, err := s.conn.Write([]byte(msg + "\r\n"))
+	return err
+}
+
+// produce sends the event of a message, or of the commands so far if msg is nil
+func (s *smtpServer) produce(msg *smtpMessage, data []byte) {
+	payload := data[:min(len(data), maxSMTPEventPayload)]
+	if msg == nil {
+		payload = []byte(strings.Join(s.commands, "\r\n"))
+	}
+	if err := s.h.ProduceTCP("smtp", s.conn, s.md, payload, parsedSMTP{
+		Helo:     s.helo,
+		TLS:      s.tls,
+		Username: s.username,
+		Commands: s.commands,
+		Message:  msg,
+	}); err != nil {
+		s.logger.Error("Failed to produce message", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+	}
+	s.commands = nil
+}
+
+// reset aborts the current transaction
+func (s *smtpServer) reset() {
+	s.inMail, s.mailFrom, s.recipients = false, "", nil
+}
+
+// ehlo lists the extensions of the server, STARTTLS until TLS is on
+func (s *smtpServer) ehlo() string {
+	lines := []string{s.hostname, "PIPELINING", "SIZE " + strconv.Itoa(maxSMTPMessage)}
+	if !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	mechanisms := strings.Join(saslMechanisms, " ")
+	lines = append(lines, "AUTH "+mechanisms, "AUTH="+mechanisms, "ENHANCEDSTATUSCODES", "8BITMIME")
+	var b strings.Builder
+	for _, line := range lines[:len(lines)-1] {
+		b.WriteString("250-" + line + "\r\n")
+	}
+	b.WriteString("250 " + lines[len(lines)-1])
+	return b.String()
+}
+
+// startTLS upgrades the connection and reports whether the session goes on.
+// The session starts over, commands pipelined before the handshake are
+// dropped.
+func (s *smtpServer) startTLS() (bool, error) {
+	if s.tls {
+		return true, s.write("554 5.5.1 Error: TLS already active")
+	}
+	config, err := s.h.TLSConfig(s.md)
+	if err != nil {
+		s.logger.Error("Failed to get TLS config", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+		return true, s.write("454 4.7.0 TLS not available due to local problem")
+	}
+	if err := s.write("220 2.0.0 Ready to start TLS"); err != nil {
+		return false, err
+	}
+	conn, md, err := TerminateTLS(s.ctx, s.conn, s.md, config, s.logger, s.h)
+	if err != nil {
+		s.logger.Debug("TLS handshake failed", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+		return false, nil
+	}
+	s.conn, s.md, s.r = conn, md, bufio.NewReaderSize(conn, maxSMTPLine)
+	s.tls, s.helo = true, ""
+	s.reset()
+	return true, nil
+}
+
+// auth runs the AUTH PLAIN and AUTH LOGIN exchanges and checks the
+// credentials with the login policy. It reports whether the session ends.
+func (s *smtpServer) auth(arg string) (bool, error) {
+	if s.username != "" {
+		return false, s.write("503 5.5.1 Error: already authenticated")
+	}
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	username, password, err := saslLogin(mechanism, initial, func(challenge string) (string, error) {
+		if err := s.write("334 " + challenge); err != nil {
+			return "", err
+		}
+		return s.read()
+	})
+	switch {
+	case errors.Is(err, errAuthMechanism):
+		return false, s.write("504 5.5.4 Unrecognized authentication type")
+	case errors.Is(err, errAuthAborted):
+		return false, s.write("501 5.7.0 Authentication aborted")
+	case errors.Is(err, errAuthDecode):
+		return false, s.write("501 5.5.2 Cannot decode response")
+	case errors.Is(err, errAuthMalformed):
+		return false, s.write("535 5.7.8 Error: authentication failed: Invalid authentication mechanism")
+	case err != nil:
+		return true, err
+	}
+
+	if s.h.Authenticate(s.md, "smtp", username, password) {
+		s.username = username
+		return false, s.write("235 2.7.0 Authentication successful")
+	}
+	s.failures++
+	if s.failures >= maxLoginAttempts {
+		return true, s.write("421 4.7.0 Error: too many errors")
+	}
+	return false, s.write("535 5.7.8 Error: authentication failed")
+}
+
+// readData reads a message up to the terminating dot, undoing the dot
+// stuffing. Messages bigger than maxSMTPMessage are cut.
+func (s *smtpServer) readData() ([]byte, bool, error) {
+	buf := &bytes.Buffer{}
+	truncated := false
+	lineStart := true
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, false, err
+		}
+		if lineStart {
+			if line := string(chunk); line == ".\r\n" || line == ".\n" {
+				return buf.Bytes(), truncated, nil
+			}
+			chunk = bytes.TrimPrefix(chunk, []byte("."))
+		}
+		lineStart = err == nil
+		if buf.Len()+len(chunk) > maxSMTPMessage {
+			truncated = true
+			continue
+		}
+		buf.Write(chunk)
+	}
+}
+
+// data receives a message, stores it and produces its event
+func (s *smtpServer) data() error {
+	if err := s.write("354 End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+	data, truncated, err := s.readData()
+	if err != nil {
+		return err
+	}
+	msg := &smtpMessage{
+		MailFrom:   s.mailFrom,
+		Recipients: s.recipients,
+		Size:       len(data),
+		Truncated:  truncated,
+	}
+	if len(data) > 0 {
+		hash, err := s.h.Store(s.md, data, artifact.Info{Kind: artifact.KindMail, Handler: "smtp"})
+		if err != nil {
+			s.logger.Error("Failed to store message", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+		}
+		msg.SHA256 = hash
+	}
+	if msg.Message, err = email.Parse(data); err != nil {
+		s.logger.Debug("Failed to parse message", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+	}
+	s.produce(msg, data)
+	s.reset()
+
+	if err := smtpDelay(); err != nil {
+		return err
+	}
+	if truncated {
+		return s.write("552 5.3.4 Error: message file too big")
+	}
+	id := make([]byte, 5)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	return s.write("250 2.0.0 Ok: queued as " + strings.ToUpper(hex.EncodeToString(id)))
 }
-func validateRCPT(query string) bool {
-	rcpt := regexp.MustCompile("^RCPT TO:<.+@.+>$")
-	return rcpt.MatchString(query)
+
+// handle runs a command and reports whether the session ends
+func (s *smtpServer) handle(cmd, arg string) (bool, error) {
+	switch cmd {
+	case "HELO", "EHLO":
+		if arg == "" {
+			return false, s.write("501 Syntax: " + cmd + " hostname")
+		}
+		s.helo = arg
+		s.reset()
+		if err := smtpDelay(); err != nil {
+			return true, err
+		}
+		if cmd == "HELO" {
+			return false, s.write("250 " + s.hostname)
+		}
+		return false, s.write(s.ehlo())
+	case "STARTTLS":
+		ok, err := s.startTLS()
+		return !ok, err
+	case "AUTH":
+		if s.helo == "" {
+			return false, s.write("503 5.5.1 Error: send HELO/EHLO first")
+		}
+		return s.auth(arg)
+	case "MAIL":
+		if s.helo == "" {
+			return false, s.write("503 5.5.1 Error: send HELO/EHLO first")
+		}
+		if s.inMail {
+			return false, s.write("503 5.5.1 Error: nested MAIL command")
+		}
+		addr, params, ok := parsePath(arg, "FROM:")
+		if !ok {
+			return false, s.write("501 5.1.7 Bad sender address syntax")
+		}
+		for _, param := range strings.Fields(params) {
+			if key, value, _ := strings.Cut(param, "="); strings.EqualFold(key, "SIZE") {
+				if size, err := strconv.Atoi(value); err == nil && size > maxSMTPMessage {
+					return false, s.write("552 5.3.4 Message size exceeds fixed limit")
+				}
+			}
+		}
+		if err := smtpDelay(); err != nil {
+			return true, err
+		}
+		s.inMail, s.mailFrom = true, addr
+		return false, s.write("250 2.1.0 Ok")
+	case "RCPT":
+		if !s.inMail {
+			return false, s.write("503 5.5.1 Error: need MAIL command")
+		}
+		addr, _, ok := parsePath(arg, "TO:")
+		if !ok || addr == "" {
+			return false, s.write("501 5.1.3 Bad recipient address syntax")
+		}
+		if len(s.recipients) >= maxSMTPRecipients {
+			return false, s.write("452 4.5.3 Error: too many recipients")
+		}
+		if err := smtpDelay(); err != nil {
+			return true, err
+		}
+		s.recipients = append(s.recipients, addr)
+		return false, s.write("250 2.1.5 Ok")
+	case "DATA":
+		if len(s.recipients) == 0 {
+			return false, s.write("503 5.5.1 Error: need RCPT command")
+		}
+		return false, s.data()
+	case "RSET":
+		s.reset()
+		return false, s.write("250 2.0.0 Ok")
+	case "NOOP":
+		return false, s.write("250 2.0.0 Ok")
+	case "VRFY":
+		return false, s.write("252 2.0.0 Cannot VRFY user")
+	case "HELP":
+		return false, s.write("214 2.0.0 See RFC 5321")
+	case "QUIT":
+		return true, s.write("221 2.0.0 Bye")
+	}
+	return false, s.write("502 5.5.2 Error: command not recognized")
 }
 
-// HandleSMTP takes a net.Conn and does basic SMTP communication
+// HandleSMTP takes a net.Conn and emulates an ESMTP server with AUTH and
+// STARTTLS, capturing the messages it's sent
 func HandleSMTP(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
+	p := h.Persona(md)
+	s := &smtpServer{
+		ctx:      ctx,
+		conn:     conn,
+		r:        bufio.NewReaderSize(conn, maxSMTPLine),
+		md:       md,
+		logger:   logger,
+		h:        h,
+		hostname: "localhost",
+		tls:      md.TLS != nil,
+	}
+	if p != nil && p.Hostname != "" {
+		s.hostname = p.Hostname
+	}
 	defer func() {
-		if err := conn.Close(); err != nil {
+		if len(s.commands) > 0 {
+			s.produce(nil, nil)
+		}
+		if err := s.conn.Close(); err != nil {
 			logger.Debug("Failed to close SMTP connection", slog.String("protocol", "smtp"), producer.ErrAttr(err))
 		}
 	}()
 
-	client := &Client{
-		conn:   conn,
-		bufin:  bufio.NewReader(conn),
-		bufout: bufio.NewWriter(conn),
+	if err := smtpDelay(); err != nil {
+		return err
 	}
-	if err := randomSleep(); err != nil {
+	if err := s.write("220 " + p.Banner(persona.BannerSMTP, s.hostname+" ESMTP")); err != nil {
 		return err
 	}
-	client.w("220 Welcome!")
-
 	for {
-		if err := h.UpdateConnectionTimeout(ctx, conn); err != nil {
+		if err := h.UpdateConnectionTimeout(ctx, s.conn); err != nil {
 			return err
 		}
-		data, err := client.read()
+		line, err := s.read()
 		if err != nil {
-			break
+			logger.Debug("Failed to read SMTP command", slog.String("protocol", "smtp"), producer.ErrAttr(err))
+			return nil
 		}
-		query := strings.Trim(data, "\r\n")
-		logger.Debug("SMTP Query", slog.String("query", query), slog.String("protocol", "smtp"))
-		if strings.HasPrefix(query, "HELO ") {
-			if err := randomSleep(); err != nil {
-				return err
-			}
-			client.w("250 Hello! Pleased to meet you.")
-		} else if validateMail(query) {
-			if err := randomSleep(); err != nil {
-				return err
-			}
-			client.w("250 OK")
-		} else if validateRCPT(query) {
-			if err := randomSleep(); err != nil {
-				return err
-			}
-			client.w("250 OK")
-		} else if strings.Compare(query, "DATA") == 0 {
-			client.w("354 End data with <CRLF>.<CRLF>")
-			for readctr := maxDataRead; readctr >= 0; readctr-- {
-				data, err = client.read()
-				if err != nil {
-					break
-				}
-				if err := h.ProduceTCP("smtp", conn, md, []byte(data), struct {
-					Message string `json:"message,omitempty"`
-				}{Message: query}); err != nil {
-					logger.Error("Failed to produce message", slog.String("protocol", "smpt"), producer.ErrAttr(err))
-				}
-				logger.Debug("SMTP Data", slog.String("data", data), slog.String("protocol", "smtp"))
-				// exit condition
-				if strings.Compare(data, ".\r\n") == 0 {
-					break
-				}
-			}
-			if err := randomSleep(); err != nil {
-				return err
-			}
-			client.w("250 OK")
-		} else if strings.Compare(query, "QUIT") == 0 {
-			client.w("Bye")
-			break
-		} else {
-			client.w("Recheck the command you entered.")
+		logger.Debug("SMTP Query", slog.String("query", line), slog.String("protocol", "smtp"))
+		if line == "" {
+			continue
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		quit, err := s.handle(strings.ToUpper(cmd), strings.TrimSpace(arg))
+		if err != nil || quit {
+			return err
 		}
 	}
-	return nil
 }
@@ -1,17 +1,137 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
 	"testing"
+	"time"
 
+	"github.com/mushorg/glutton/connection"
 	"github.com/stretchr/testify/require"
 )
 
-func TestValidateMail(t *testing.T) {
-	require.True(t, validateMail("MAIL FROM:<example@example.com>"), "email regex validation failed")
-	require.False(t, validateMail("MAIL FROM:<example.com>"), "email regex validation failed")
+func TestParsePath(t *testing.T) {
+	addr, params, ok := parsePath("FROM:<example@example.com> SIZE=1024", "FROM:")
+	require.True(t, ok)
+	require.Equal(t, "example@example.com", addr)
+	require.Equal(t, "SIZE=1024", params)
+
+	addr, _, ok = parsePath("to: example@example.com", "TO:")
+	require.True(t, ok)
+	require.Equal(t, "example@example.com", addr)
+
+	addr, _, ok = parsePath("FROM:<>", "FROM:")
+	require.True(t, ok)
+	require.Empty(t, addr)
+
+	_, _, ok = parsePath("FROM:<example.com>", "FROM:")
+	require.False(t, ok)
+	_, _, ok = parsePath("TO:<example@example.com", "TO:")
+	require.False(t, ok)
+	_, _, ok = parsePath("<example@example.com>", "TO:")
+	require.False(t, ok)
 }
 
-func TestValidateRCPT(t *testing.T) {
-	require.True(t, validateRCPT("RCPT TO:<example@example.com>"), "validate rcpt regex failed")
-	require.False(t, validateRCPT("RCPT TO:<example.com>"), "validate rcpt regex failed")
+func TestHandleSMTP(t *testing.T) {
+	delay := smtpDelay
+	smtpDelay = func() error { return nil }
+	defer func() { smtpDelay = delay }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	h := newFakeHoneypot()
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- HandleSMTP(context.Background(), conn, connection.Metadata{}, &recordingLogger{}, h)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	c, err := smtp.NewClient(client, "localhost")
+	require.NoError(t, err)
+	require.NoError(t, c.Hello("bot.example"))
+	ok, _ := c.Extension("STARTTLS")
+	require.True(t, ok)
+	require.NoError(t, c.StartTLS(&tls.Config{ServerName: "mail.example", InsecureSkipVerify: true}))
+	ok, _ = c.Extension("STARTTLS")
+	require.False(t, ok)
+	require.NoError(t, c.Auth(smtp.PlainAuth("", "info@example.com", "123456", "localhost")))
+
+	require.NoError(t, c.Mail("info@example.com"))
+	require.NoError(t, c.Rcpt("victim@example.org"))
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Subject: invoice\r\nTo: victim@example.org\r\n\r\nPay at http://pay.example/x\r\n.hidden\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// the message is one event
+	event := <-h.produced
+	require.Equal(t, "smtp", event.protocol)
+	decoded := event.decoded.(parsedSMTP)
+	require.Equal(t, "bot.example", decoded.Helo)
+	require.True(t, decoded.TLS)
+	// the session after STARTTLS carries the TLS metadata
+	require.NotNil(t, event.md.TLS)
+	require.Equal(t, "mail.example", event.md.TLS.ServerName)
+	require.NotEmpty(t, event.md.TLS.CipherSuite)
+	require.Equal(t, "info@example.com", decoded.Username)
+	require.Equal(t, "info@example.com", decoded.Message.MailFrom)
+	require.Equal(t, []string{"victim@example.org"}, decoded.Message.Recipients)
+	require.Equal(t, "invoice", decoded.Message.Subject)
+	require.Equal(t, []string{"http://pay.example/x"}, decoded.Message.URLs)
+	require.Len(t, h.stored, 1)
+	// dot stuffing is undone
+	require.Equal(t, "Subject: invoice\r\nTo: victim@example.org\r\n\r\nPay at http://pay.example/x\r\n.hidden\r\n", string(h.stored[0]))
+
+	require.NoError(t, c.Quit())
+	require.NoError(t, <-errs)
+	// the commands after the message end the session
+	event = <-h.produced
+	require.Equal(t, []string{"QUIT"}, event.decoded.(parsedSMTP).Commands)
+}
+
+func TestSMTPAuthLogin(t *testing.T) {
+	delay := smtpDelay
+	smtpDelay = func() error { return nil }
+	defer func() { smtpDelay = delay }()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	h := newFakeHoneypot()
+	go HandleSMTP(context.Background(), server, connection.Metadata{}, &recordingLogger{}, h)
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	c, err := smtp.NewClient(client, "localhost")
+	require.NoError(t, err)
+	require.NoError(t, c.Hello("bot"))
+	id, err := c.Text.Cmd("AUTH LOGIN")
+	require.NoError(t, err)
+	c.Text.StartResponse(id)
+	_, msg, err := c.Text.ReadResponse(334)
+	c.Text.EndResponse(id)
+	require.NoError(t, err)
+	require.Equal(t, "VXNlcm5hbWU6", msg)
+	for _, step := range []struct {
+		line string
+		code int
+	}{{"YWRtaW4=", 334}, {"cGFzc3dvcmQ=", 235}, {"AUTH PLAIN", 503}, {"BDAT 10", 502}} {
+		id, err := c.Text.Cmd("%s", step.line)
+		require.NoError(t, err)
+		c.Text.StartResponse(id)
+		_, _, err = c.Text.ReadResponse(step.code)
+		c.Text.EndResponse(id)
+		require.NoError(t, err, step.line)
+	}
 }
@@ -11,9 +11,9 @@ import (
 	"net"
 	"strconv"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
 	"github.com/mushorg/glutton/producer"
-	"github.com/mushorg/glutton/protocols/helpers"
 	"github.com/mushorg/glutton/protocols/interfaces"
 
 	"github.com/spf13/viper"
@@ -67,7 +67,7 @@ func HandleTCP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 
 	defer func() {
 		if msgLength > 0 {
-			payloadHash, err := helpers.Store(data, "payloads")
+			payloadHash, err := h.Store(md, data, artifact.Info{Kind: artifact.KindPayload, Handler: "tcp"})
 			if err != nil {
 				logger.Error("Failed to store payload", slog.String("handler", "tcp"), producer.ErrAttr(err))
 			}
@@ -80,6 +80,7 @@ func HandleTCP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 				slog.String("payload_hash", payloadHash),
 			)
 			logger.Info(fmt.Sprintf("TCP payload:\n%s", hex.Dump(data[:msgLength%1024])))
+			h.FetchSamples(md, "tcp", data)
 
 			server.events = append(server.events, parsedTCP{
 				Direction:   "read",
@@ -88,7 +89,7 @@ func HandleTCP(ctx context.Context, conn net.Conn, md connection.Metadata, logge
 			})
 		}
 
-		if err := h.ProduceTCP("tcp", conn, md, helpers.FirstOrEmpty[parsedTCP](server.events).Payload, server.events); err != nil {
+		if err := h.ProduceTCP("tcp", conn, md, data, server.events); err != nil {
 			logger.Error("Failed to produce message", slog.String("protocol", "tcp"), producer.ErrAttr(err))
 		}
 		if err := conn.Close(); err != nil {
@@ -1,86 +1,48 @@
 package tcp
 
 import (
-	"bufio"
 	"context"
-	"crypto/rand"
-	"errors"
-	"io"
 	"log/slog"
-	"math/big"
 	"net"
-	"net/http"
-	"regexp"
 	"strings"
-	"time"
 
 	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
 	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/profile"
 	"github.com/mushorg/glutton/protocols/helpers"
 	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/protocols/tcp/shell"
+	"github.com/mushorg/glutton/protocols/tcp/telnet"
 )
 
-// Mirai botnet  - https://github.com/CymmetriaResearch/MTPot/blob/master/mirai_conf.json
-// Hajime botnet - https://security.rapiditynetworks.com/publications/2016-10-16/hajime.pdf
-var miraiCom = map[string][]string{
-	"ps":                                 {"1 pts/21   00:00:00 init"},
-	"cat /proc/mounts":                   {"rootfs / rootfs rw 0 0\r\n/dev/root / ext2 rw,relatime,errors=continue 0 0\r\nproc /proc proc rw,relatime 0 0\r\nsysfs /sys sysfs rw,relatime 0 0\r\nudev /dev tmpfs rw,relatime 0 0\r\ndevpts /dev/pts devpts rw,relatime,mode=600,ptmxmode=000 0 0\r\n/dev/mtdblock1 /home/hik jffs2 rw,relatime 0 0\r\ntmpfs /run tmpfs rw,nosuid,noexec,relatime,size=3231524k,mode=755 0 0\r\n"},
-	"(cat .s || cp /bin/echo .s)":        {"cat: .s: No such file or directory"},
-	"nc":                                 {"nc: command not found"},
-	"wget":                               {"wget: missing URL"},
-	"(dd bs=52 count=1 if=.s || cat .s)": {"\x7f\x45\x4c\x46\x01\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x28\x00\x01\x00\x00\x00\xbc\x14\x01\x00\x34\x00\x00\x00"},
-	"sh":                                 {"$"},
-	"sh || shell":                        {"$"},
-	"enable\x00":                         {"-bash: enable: command not found"},
-	"system\x00":                         {"-bash: system: command not found"},
-	"shell\x00":                          {"-bash: shell: command not found"},
-	"sh\x00":                             {"$"},
-	//	"fgrep XDVR /mnt/mtd/dep2.sh\x00":		   {"cd /mnt/mtd && ./XDVRStart.hisi ./td3500 &"},
-	"busybox": {"BusyBox v1.16.1 (2014-03-04 16:00:18 CST) built-it shell (ash)\r\nEnter 'help' for a list of built-in commands.\r\n"},
-	"echo -ne '\\x48\\x6f\\x6c\\x6c\\x61\\x46\\x6f\\x72\\x41\\x6c\\x6c\\x61\\x68\\x0a'\r\n": {"\x48\x6f\x6c\x6c\x61\x46\x6f\x72\x41\x6c\x6c\x61\x68\x0arn"},
-	"cat | sh": {""},
-	"echo -e \\x6b\\x61\\x6d\\x69/dev > /dev/.nippon": {""},
-	"cat /dev/.nippon": {"kami/dev"},
-	"rm /dev/.nippon":  {""},
-	"echo -e \\x6b\\x61\\x6d\\x69/run > /run/.nippon": {""},
-	"cat /run/.nippon":              {"kami/run"},
-	"rm /run/.nippon":               {""},
-	"cat /bin/sh":                   {"\x7f\x45\x4c\x46\x01\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x28\x00\x01\x00\x00\x00\x98\x30\x00\x00\x34\x00\x00\x00"},
-	"/bin/busybox ps":               {"1 pts/21   00:00:00 init"},
-	"/bin/busybox cat /proc/mounts": {"tmpfs /run tmpfs rw,nosuid,noexec,relatime,size=3231524k,mode=755 0 0"},
-	"/bin/busybox echo -e \\x6b\\x61\\x6d\\x69/dev > /dev/.nippon": {""},
-	"/bin/busybox cat /dev/.nippon":                                {"kami/dev"},
-	"/bin/busybox rm /dev/.nippon":                                 {""},
-	"/bin/busybox echo -e \\x6b\\x61\\x6d\\x69/run > /run/.nippon": {""},
-	"/bin/busybox cat /run/.nippon":                                {"kami/run"},
-	"/bin/busybox rm /run/.nippon":                                 {""},
-	"/bin/busybox cat /bin/sh":                                     {""},
-	"/bin/busybox cat /bin/echo":                                   {"/bin/busybox cat /bin/echo\r\n\x7f\x45\x4c\x46\x01\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x28\x00\x01\x00\x00\x00\x6c\xb9\x00\x00\x34\x00\x00\x00"},
-	"rm /dev/.human":                                               {"rm: can't remove '/.t': No such file or directory\r\nrm: can't remove '/.sh': No such file or directory\r\nrm: can't remove '/.human': No such file or directory\r\ncd /dev"},
-}
-
 type parsedTelnet struct {
 	Direction string `json:"direction,omitempty"`
 	Message   string `json:"message,omitempty"`
 }
 
+type decodedTelnet struct {
+	Fingerprint *telnet.Fingerprint `json:"fingerprint,omitempty"`
+	Events      []parsedTelnet      `json:"events"`
+}
+
 type telnetServer struct {
+	conn   *telnet.Conn
 	events []parsedTelnet
-	client *http.Client
 }
 
 // write writes a telnet message to the connection
-func (s *telnetServer) write(conn net.Conn, msg string) error {
-	if _, err := conn.Write([]byte(msg)); err != nil {
+func (s *telnetServer) write(msg string) error {
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
 		return err
 	}
 	s.events = append(s.events, parsedTelnet{Direction: "write", Message: msg})
 	return nil
 }
 
-// read reads a telnet message from a connection
-func (s *telnetServer) read(conn net.Conn) (string, error) {
-	msg, err := bufio.NewReader(conn).ReadString('\n')
+// read reads a line from the connection, without telnet commands
+func (s *telnetServer) read() (string, error) {
+	msg, err := s.conn.ReadLine()
 	if err != nil {
 		return msg, err
 	}
@@ -88,56 +50,68 @@ func (s *telnetServer) read(conn net.Conn) (string, error) {
 	return msg, nil
 }
 
-func (s *telnetServer) getSample(cmd string, logger interfaces.Logger) error {
-	url := cmd[strings.Index(cmd, "http"):]
-	url = strings.Split(url, " ")[0]
-	url = strings.TrimSpace(url)
-	logger.Debug("Fetching sample", slog.String("url", url), slog.String("handler", "telnet"))
-	resp, err := s.client.Get(url)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != 200 {
-		return errors.New("failed to fetch sample: " + resp.Status)
-	}
-	defer resp.Body.Close()
-	if resp.ContentLength <= 0 {
-		return errors.New("content length is 0")
+// decoded returns the events and the client fingerprint
+func (s *telnetServer) decoded() decodedTelnet {
+	d := decodedTelnet{Events: s.events}
+	if fp := s.conn.Fingerprint(); !fp.Empty() {
+		d.Fingerprint = &fp
 	}
+	return d
+}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+// input returns everything read from the client
+func (s *telnetServer) input() []byte {
+	var input []byte
+	for _, event := range s.events {
+		if event.Direction == "read" {
+			input = append(input, event.Message...)
+		}
 	}
+	return input
+}
 
-	if len(data) == 0 {
-		return errors.New("empty response body")
-	}
+// maxLoginAttempts is how often login prompts before it hangs up
+const maxLoginAttempts = 3
 
-	sha256Hash, err := helpers.Store(data, "samples")
-	if err != nil {
-		return err
+// login prompts for credentials until the login policy accepts some
+func (s *telnetServer) login(md connection.Metadata, prompt string, logger interfaces.Logger, h interfaces.Honeypot) (bool, error) {
+	for range maxLoginAttempts {
+		if err := s.write(prompt); err != nil {
+			return false, err
+		}
+		username, err := s.read()
+		if err != nil {
+			logger.Debug("Failed to read from connection", slog.String("protocol", "telnet"), producer.ErrAttr(err))
+			return false, nil
+		}
+		if err := s.write("Password: "); err != nil {
+			return false, err
+		}
+		password, err := s.read()
+		if err != nil {
+			return false, err
+		}
+		if h.Authenticate(md, "telnet", strings.TrimSpace(username), strings.TrimSpace(password)) {
+			return true, nil
+		}
+		if err := s.write("\r\nLogin incorrect\r\n"); err != nil {
+			return false, err
+		}
 	}
-
-	logger.Info(
-		"New sample fetched",
-		slog.String("handler", "telnet"),
-		slog.String("sample_hash", sha256Hash),
-		slog.String("source", url),
-	)
-	return nil
+	return false, nil
 }
 
 // HandleTelnet handles telnet communication on a connection
 func HandleTelnet(ctx context.Context, conn net.Conn, md connection.Metadata, logger interfaces.Logger, h interfaces.Honeypot) error {
 	s := &telnetServer{
+		conn:   telnet.NewConn(conn),
 		events: []parsedTelnet{},
-		client: &http.Client{
-			Timeout: time.Duration(5 * time.Second),
-		},
 	}
 	defer func() {
-		if err := h.ProduceTCP("telnet", conn, md, []byte(helpers.FirstOrEmpty[parsedTelnet](s.events).Message), s.events); err != nil {
+		// signatures match everything the client sent, not just the payload
+		input := s.input()
+		h.MatchSignatures(md, "telnet", input)
+		if err := h.ProduceTCP("telnet", conn, md, []byte(helpers.FirstOrEmpty[parsedTelnet](s.events).Message), s.decoded(), producer.WithRaw(input)); err != nil {
 			logger.Error("Failed to produce message", producer.ErrAttr(err))
 		}
 		if err := conn.Close(); err != nil {
@@ -150,28 +124,27 @@ func HandleTelnet(ctx context.Context, conn net.Conn, md connection.Metadata, lo
 		return nil
 	}
 
-	// TODO (glaslos): Add device banner
+	p := h.Persona(md)
 
-	// telnet window size negotiation response
-	if err := s.write(conn, "\xff\xfd\x18\xff\xfd\x20\xff\xfd\x23\xff\xfd\x27"); err != nil {
+	// ask for the terminal type and window size, the answers end up in the
+	// fingerprint
+	if err := s.conn.Negotiate(); err != nil {
 		return err
 	}
 
-	// User name prompt
-	if err := s.write(conn, "Username: "); err != nil {
-		return err
-	}
-	if _, err := s.read(conn); err != nil {
-		logger.Debug("Failed to read from connection", slog.String("protocol", "telnet"), producer.ErrAttr(err))
-		return nil
-	}
-	if err := s.write(conn, "Password: "); err != nil {
-		return err
+	if banner := p.Banner(persona.BannerTelnet, ""); banner != "" {
+		if err := s.write(toCRLF(banner)); err != nil {
+			return err
+		}
 	}
-	if _, err := s.read(conn); err != nil {
+	ok, err := s.login(md, p.Banner(persona.BannerLogin, "Username: "), logger, h)
+	if err != nil || !ok {
 		return err
 	}
-	if err := s.write(conn, "welcome\r\n> "); err != nil {
+	// each session gets its own shell, so files written by one command can
+	// be read by the next
+	sh := shell.New(shellOptions(p))
+	if err := s.write(toCRLF(sh.Banner() + sh.Prompt())); err != nil {
 		return err
 	}
 
@@ -179,61 +152,48 @@ func HandleTelnet(ctx context.Context, conn net.Conn, md connection.Metadata, lo
 		if err := h.UpdateConnectionTimeout(ctx, conn); err != nil {
 			return err
 		}
-		msg, err := s.read(conn)
+		msg, err := s.read()
 		if err != nil {
 			return err
 		}
+		h.FetchSamples(md, "telnet", []byte(msg))
 		for _, cmd := range strings.Split(msg, ";") {
-			if strings.Contains(strings.Trim(cmd, " "), "wget http") {
-				go func() {
-					err := s.getSample(strings.Trim(cmd, " "), logger)
-					if err != nil {
-						logger.Error("Failed to get sample", slog.String("handler", "telnet"), producer.ErrAttr(err))
-					}
-				}()
+			if trimmed := strings.TrimSpace(cmd); trimmed != "" {
+				h.RecordActivity(md, profile.Activity{Command: trimmed})
 			}
-			if strings.TrimRight(cmd, "") == " rm /dev/.t" {
-				continue
-			}
-			if strings.TrimRight(cmd, "\r\n") == " rm /dev/.sh" {
-				continue
-			}
-			if strings.TrimRight(cmd, "\r\n") == "cd /dev/" {
-				if err := s.write(conn, "ECCHI: applet not found\r\n"); err != nil {
-					return err
-				}
-
-				if err := s.write(conn, "\r\nBusyBox v1.16.1 (2014-03-04 16:00:18 CST) built-it shell (ash)\r\nEnter 'help' for a list of built-in commands.\r\n"); err != nil {
-					return err
-				}
-				continue
-			}
-
-			if resp := miraiCom[strings.TrimSpace(cmd)]; len(resp) > 0 {
-				n, err := rand.Int(rand.Reader, big.NewInt(int64(len(resp))))
-				if err != nil {
-					return err
-				}
-				if err := s.write(conn, resp[n.Int64()]+"\r\n"); err != nil {
-					return err
-				}
-			} else {
-				// /bin/busybox YDKBI
-				re := regexp.MustCompile(`\/bin\/busybox (?P<applet>[A-Za-z]+)`)
-				match := re.FindStringSubmatch(cmd)
-				if len(match) > 1 {
-					if err := s.write(conn, match[1]+": applet not found\r\n"); err != nil {
-						return err
-					}
-
-					if err := s.write(conn, "BusyBox v1.16.1 (2014-03-04 16:00:18 CST) built-in shell (ash)\r\nEnter 'help' for a list of built-in commands.\r\n"); err != nil {
-						return err
-					}
-				}
+		}
+		out, exited := sh.Run(msg)
+		if exited {
+			if len(out) == 0 {
+				return nil
 			}
+			return s.write(toCRLF(string(out)))
 		}
-		if err := s.write(conn, "> "); err != nil {
+		if err := s.write(toCRLF(string(out) + sh.Prompt())); err != nil {
 			return err
 		}
 	}
 }
+
+// shellOptions presents a persona in the shell, a nil persona gets the
+// defaults of the shell
+func shellOptions(p *persona.Persona) shell.Options {
+	if p == nil {
+		return shell.Options{}
+	}
+	return shell.Options{
+		Hostname:    p.Hostname,
+		Version:     p.Version("busybox", ""),
+		Banner:      p.Banner(persona.BannerShell, ""),
+		OS:          p.OS.Name,
+		Kernel:      p.OS.Kernel,
+		KernelBuild: p.OS.Build,
+		Machine:     p.OS.Machine,
+		Files:       p.Files,
+	}
+}
+
+// toCRLF translates newlines for the terminal, like a tty does
+func toCRLF(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
@@ -0,0 +1,315 @@
+// Package telnet implements the telnet protocol layer, RFC 854, with option
+// negotiation for the telnet handler. It strips commands from the data
+// stream, answers option requests and records how the client negotiated.
+package telnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Commands, RFC 854
+const (
+	SE   = 240
+	NOP  = 241
+	DM   = 242
+	BRK  = 243
+	IP   = 244
+	AO   = 245
+	AYT  = 246
+	EC   = 247
+	EL   = 248
+	GA   = 249
+	SB   = 250
+	WILL = 251
+	WONT = 252
+	DO   = 253
+	DONT = 254
+	IAC  = 255
+)
+
+// Options
+const (
+	OptEcho     = 1
+	OptSGA      = 3
+	OptStatus   = 5
+	OptTTYPE    = 24
+	OptNAWS     = 31
+	OptTSpeed   = 32
+	OptLFlow    = 33
+	OptLinemode = 34
+	OptXDisplay = 35
+	OptEnviron  = 36
+	OptNewEnv   = 39
+)
+
+// TTYPE subnegotiation commands, RFC 1091
+const (
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+const (
+	// maxLine caps the length of a line, longer lines are split
+	maxLine = 64 << 10
+	// maxSubneg caps the length of a subnegotiation
+	maxSubneg = 512
+	// maxNegotiation caps the option commands recorded in a fingerprint
+	maxNegotiation = 64
+)
+
+var commandNames = map[byte]string{
+	WILL: "WILL",
+	WONT: "WONT",
+	DO:   "DO",
+	DONT: "DONT",
+}
+
+var optionNames = map[byte]string{
+	OptEcho:     "ECHO",
+	OptSGA:      "SGA",
+	OptStatus:   "STATUS",
+	OptTTYPE:    "TTYPE",
+	OptNAWS:     "NAWS",
+	OptTSpeed:   "TSPEED",
+	OptLFlow:    "LFLOW",
+	OptLinemode: "LINEMODE",
+	OptXDisplay: "XDISPLOC",
+	OptEnviron:  "ENVIRON",
+	OptNewEnv:   "NEW-ENVIRON",
+}
+
+// optionName returns the name of an option, or its number if it's unknown
+func optionName(opt byte) string {
+	if name, ok := optionNames[opt]; ok {
+		return name
+	}
+	return fmt.Sprint(opt)
+}
+
+// Fingerprint is how a client negotiated, which tells telnet clients and
+// bot families apart
+type Fingerprint struct {
+	// Negotiation lists the option commands of the client in order, like
+	// "WILL NAWS"
+	Negotiation []string `json:"negotiation,omitempty"`
+	// Signature is the negotiation as a single string, to group clients by
+	Signature    string `json:"signature,omitempty"`
+	TerminalType string `json:"terminalType,omitempty"`
+	Width        uint16 `json:"width,omitempty"`
+	Height       uint16 `json:"height,omitempty"`
+}
+
+// Empty reports whether the client didn't negotiate at all
+func (f *Fingerprint) Empty() bool {
+	return len(f.Negotiation) == 0 && f.TerminalType == "" && f.Width == 0 && f.Height == 0
+}
+
+// Conn speaks telnet over a connection
+type Conn struct {
+	r  *bufio.Reader
+	w  io.Writer
+	fp Fingerprint
+	// remote and local are the options enabled on the client's and our side
+	remote map[byte]bool
+	local  map[byte]bool
+	// requested are the options we asked the client to enable
+	requested map[byte]bool
+	// refusedRemote and refusedLocal are the options we answered DONT and
+	// WONT to, requests repeating them don't change their state
+	refusedRemote map[byte]bool
+	refusedLocal  map[byte]bool
+}
+
+// NewConn returns a telnet connection reading from and writing to rw
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{
+		r:             bufio.NewReader(rw),
+		w:             rw,
+		remote:        make(map[byte]bool),
+		local:         make(map[byte]bool),
+		requested:     make(map[byte]bool),
+		refusedRemote: make(map[byte]bool),
+		refusedLocal:  make(map[byte]bool),
+	}
+}
+
+// Negotiate asks the client for its window size and terminal type, the
+// answers are processed while reading
+func (c *Conn) Negotiate() error {
+	c.requested[OptTTYPE] = true
+	c.requested[OptNAWS] = true
+	return c.send(IAC, DO, OptTTYPE, IAC, DO, OptNAWS)
+}
+
+// Fingerprint returns how the client negotiated so far
+func (c *Conn) Fingerprint() Fingerprint {
+	fp := c.fp
+	fp.Negotiation = append([]string(nil), c.fp.Negotiation...)
+	fp.Signature = strings.Join(fp.Negotiation, ",")
+	return fp
+}
+
+func (c *Conn) send(b ...byte) error {
+	_, err := c.w.Write(b)
+	return err
+}
+
+// Write writes data to the client, escaping IAC bytes
+func (c *Conn) Write(p []byte) (int, error) {
+	if _, err := c.w.Write([]byte(strings.ReplaceAll(string(p), "\xff", "\xff\xff"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadLine reads a line of data, without telnet commands, up to and
+// including the line feed. A CR LF or CR NUL line end is returned as a line
+// feed. At the end of the stream the data read so far is returned with the
+// error.
+func (c *Conn) ReadLine() (string, error) {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return string(line), err
+		}
+		switch b {
+		case IAC:
+			data, err := c.command()
+			if err != nil {
+				return string(line), err
+			}
+			if data {
+				line = append(line, IAC)
+			}
+			continue
+		case '\r':
+			next, err := c.r.Peek(1)
+			if err == nil && (next[0] == '\n' || next[0] == 0) {
+				c.r.ReadByte()
+				b = '\n'
+			}
+		}
+		line = append(line, b)
+		if b == '\n' || len(line) >= maxLine {
+			return string(line), nil
+		}
+	}
+}
+
+// command handles the command after an IAC and reports whether it was an
+// escaped data byte
+func (c *Conn) command() (bool, error) {
+	cmd, err := c.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch cmd {
+	case IAC:
+		return true, nil
+	case WILL, WONT, DO, DONT:
+		opt, err := c.r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		if len(c.fp.Negotiation) < maxNegotiation {
+			c.fp.Negotiation = append(c.fp.Negotiation, commandNames[cmd]+" "+optionName(opt))
+		}
+		return false, c.option(cmd, opt)
+	case SB:
+		return false, c.subnegotiation()
+	case AYT:
+		return false, c.send([]byte("\r\n[Yes]\r\n")...)
+	}
+	// NOP, GA, DM, BRK, IP, AO, EC and EL don't change the line
+	return false, nil
+}
+
+// option answers an option command. Options are only acknowledged when their
+// state changes, so negotiation can't loop, RFC 854.
+func (c *Conn) option(cmd, opt byte) error {
+	switch cmd {
+	case WILL:
+		if c.remote[opt] || c.refusedRemote[opt] {
+			return nil
+		}
+		if opt != OptTTYPE && opt != OptNAWS {
+			c.refusedRemote[opt] = true
+			return c.send(IAC, DONT, opt)
+		}
+		c.remote[opt] = true
+		if !c.requested[opt] {
+			if err := c.send(IAC, DO, opt); err != nil {
+				return err
+			}
+		}
+		if opt == OptTTYPE {
+			return c.send(IAC, SB, OptTTYPE, ttypeSend, IAC, SE)
+		}
+	case WONT:
+		delete(c.requested, opt)
+		if c.remote[opt] {
+			c.remote[opt] = false
+			return c.send(IAC, DONT, opt)
+		}
+	case DO:
+		if c.local[opt] || c.refusedLocal[opt] {
+			return nil
+		}
+		// we never echo, so clients keep their local echo
+		if opt != OptSGA {
+			c.refusedLocal[opt] = true
+			return c.send(IAC, WONT, opt)
+		}
+		c.local[opt] = true
+		return c.send(IAC, WILL, opt)
+	case DONT:
+		if c.local[opt] {
+			c.local[opt] = false
+			return c.send(IAC, WONT, opt)
+		}
+	}
+	return nil
+}
+
+// subnegotiation reads a subnegotiation up to IAC SE and records what it
+// tells about the client
+func (c *Conn) subnegotiation() error {
+	var data []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == IAC {
+			if b, err = c.r.ReadByte(); err != nil {
+				return err
+			}
+			if b == SE {
+				break
+			}
+		}
+		if len(data) < maxSubneg {
+			data = append(data, b)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case OptNAWS:
+		if len(data) >= 5 {
+			c.fp.Width = binary.BigEndian.Uint16(data[1:3])
+			c.fp.Height = binary.BigEndian.Uint16(data[3:5])
+		}
+	case OptTTYPE:
+		if len(data) > 1 && data[1] == ttypeIs {
+			c.fp.TerminalType = string(data[2:])
+		}
+	}
+	return nil
+}
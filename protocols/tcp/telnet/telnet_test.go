@@ -0,0 +1,92 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type pipe struct {
+	io.Reader
+	io.Writer
+}
+
+func testConn(input string) (*Conn, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return NewConn(pipe{Reader: strings.NewReader(input), Writer: out}), out
+}
+
+func TestReadLine(t *testing.T) {
+	// Mirai style: answers the DOs, sends its window size, then the username
+	c, out := testConn("\xff\xfc\x18\xff\xfb\x1f\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0root\r\n" +
+		"pass\xff\xf1word\r\x00" +
+		"a\xff\xffb\n" +
+		"tail")
+	require.NoError(t, c.Negotiate())
+
+	line, err := c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "root\n", line)
+	line, err = c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "password\n", line)
+	line, err = c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "a\xffb\n", line)
+	line, err = c.ReadLine()
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, "tail", line)
+
+	// NAWS was requested, so WILL NAWS needs no answer
+	require.Equal(t, "\xff\xfd\x18\xff\xfd\x1f", out.String())
+	require.Equal(t, Fingerprint{
+		Negotiation: []string{"WONT TTYPE", "WILL NAWS"},
+		Signature:   "WONT TTYPE,WILL NAWS",
+		Width:       80,
+		Height:      24,
+	}, c.Fingerprint())
+}
+
+func TestNegotiation(t *testing.T) {
+	c, out := testConn("\xff\xfb\x18\xff\xfa\x18\x00xterm\xff\xf0" +
+		"\xff\xfd\x01\xff\xfd\x03\xff\xfd\x03\xff\xfb\x27\xff\xfe\x03\xff\xfb\xc8\n")
+	line, err := c.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "\n", line)
+
+	require.Equal(t, "\xff\xfd\x18\xff\xfa\x18\x01\xff\xf0"+ // DO TTYPE, SEND
+		"\xff\xfc\x01"+ // WONT ECHO
+		"\xff\xfb\x03"+ // WILL SGA, once
+		"\xff\xfe\x27"+ // DONT NEW-ENVIRON
+		"\xff\xfc\x03"+ // WONT SGA
+		"\xff\xfe\xc8", // DONT 200
+		out.String())
+	fp := c.Fingerprint()
+	require.Equal(t, "xterm", fp.TerminalType)
+	require.Equal(t, "WILL TTYPE,DO ECHO,DO SGA,DO SGA,WILL NEW-ENVIRON,DONT SGA,WILL 200", fp.Signature)
+	require.False(t, fp.Empty())
+}
+
+func TestRepeatedRefusal(t *testing.T) {
+	c, out := testConn("\xff\xfd\x01\xff\xfd\x01\xff\xfb\x27\xff\xfb\x27\n")
+	_, err := c.ReadLine()
+	require.NoError(t, err)
+
+	// refused options stay refused without another answer
+	require.Equal(t, "\xff\xfc\x01"+ // WONT ECHO, once
+		"\xff\xfe\x27", // DONT NEW-ENVIRON, once
+		out.String())
+}
+
+func TestWrite(t *testing.T) {
+	c, out := testConn("")
+	n, err := c.Write([]byte("\x7fELF\xff"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Equal(t, "\x7fELF\xff\xff", out.String())
+	fp := c.Fingerprint()
+	require.True(t, fp.Empty())
+}
@@ -0,0 +1,73 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTelnet(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+	h := newFakeHoneypot()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- HandleTelnet(context.Background(), server, connection.Metadata{}, &recordingLogger{}, h)
+	}()
+
+	r := bufio.NewReader(client)
+	expect := func(want string) {
+		t.Helper()
+		buf := make([]byte, len(want))
+		_, err := r.Read(buf[:1])
+		require.NoError(t, err)
+		for n := 1; n < len(want); {
+			m, err := r.Read(buf[n:])
+			require.NoError(t, err)
+			n += m
+		}
+		require.Equal(t, want, string(buf))
+	}
+	send := func(s string) {
+		t.Helper()
+		_, err := client.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	expect("\xff\xfd\x18\xff\xfd\x1fUsername: ")
+	send("\xff\xfb\x1f\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0root\r\n")
+	expect("Password: ")
+	send("xc3511\r\n")
+	line, err := r.ReadString('#')
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(line, "~ #"), line)
+	expect(" ")
+	send("echo kami > .k; cat .k\r\n")
+	expect("kami\r\n~ # ")
+	send("exit\r\n")
+
+	require.NoError(t, <-errs)
+	produced := <-h.produced
+	require.Equal(t, "telnet", produced.protocol)
+	decoded, ok := produced.decoded.(decodedTelnet)
+	require.True(t, ok)
+	require.NotNil(t, decoded.Fingerprint)
+	require.Equal(t, "WILL NAWS", decoded.Fingerprint.Signature)
+	require.Equal(t, uint16(80), decoded.Fingerprint.Width)
+	require.Equal(t, "root\n", decoded.Events[1].Message)
+	require.Equal(t, decoded.Events[0].Message, string(produced.payload))
+	require.Contains(t, string(produced.raw), "echo kami > .k; cat .k\n")
+}
+
+func TestToCRLF(t *testing.T) {
+	require.Equal(t, "Welcome\r\n# ", toCRLF("Welcome\n# "))
+	// line ends already in CRLF aren't doubled
+	require.Equal(t, "a\r\nb\r\n", toCRLF("a\r\nb\n"))
+}
@@ -0,0 +1,65 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/protocols/tcp/clienthello"
+	"github.com/mushorg/glutton/transcript"
+)
+
+// parsedTLS is the event of a failed TLS handshake
+type parsedTLS struct {
+	Error string `json:"error,omitempty"`
+}
+
+// TerminateTLS performs the server side of a TLS handshake with config and
+// returns the decrypted connection along with md describing the session. It
+// serves rules setting tls as well as STARTTLS. The handshake runs below a
+// transcript recorder, which records the decrypted session. When the
+// handshake fails after the ClientHello, like with clients rejecting the
+// certificate, the ClientHello is still produced as a tls event.
+func TerminateTLS(ctx context.Context, conn net.Conn, md connection.Metadata, config *tls.Config, logger interfaces.Logger, h interfaces.Honeypot) (net.Conn, connection.Metadata, error) {
+	// keeps the fingerprints of a peeked ClientHello
+	info := &connection.TLS{}
+	if md.TLS != nil {
+		*info = *md.TLS
+	}
+	var hello bool
+	config.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		hello = true
+		info.ServerName = chi.ServerName
+		info.ALPN = chi.SupportedProtos
+		info.CipherSuites = clienthello.CipherSuiteNames(chi.CipherSuites)
+		return nil, nil
+	}
+
+	// the handshake gets the full timeout, not what a peek left of it
+	if err := h.UpdateConnectionTimeout(ctx, conn); err != nil {
+		return nil, md, err
+	}
+	raw, rec := transcript.Unwrap(conn)
+	tlsConn := tls.Server(raw, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		if hello {
+			md.TLS = info
+			if err := h.ProduceTCP("tls", conn, md, nil, parsedTLS{Error: err.Error()}); err != nil {
+				logger.Error("Failed to produce message", slog.String("protocol", "tls"), producer.ErrAttr(err))
+			}
+		}
+		return nil, md, err
+	}
+	state := tlsConn.ConnectionState()
+	info.Version = tls.VersionName(state.Version)
+	info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	md.TLS = info
+	if rec != nil {
+		return transcript.NewConn(tlsConn, rec), md, nil
+	}
+	return tlsConn, md, nil
+}
@@ -0,0 +1,95 @@
+package protocols
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/interfaces"
+	"github.com/mushorg/glutton/protocols/tcp"
+	"github.com/mushorg/glutton/protocols/tcp/clienthello"
+	"github.com/mushorg/glutton/transcript"
+)
+
+// isClientHello reports whether a sample starts with a TLS handshake record,
+// the record a ClientHello arrives in
+func isClientHello(sample []byte) bool {
+	// record type 22 and a version from SSL 3.0 to TLS 1.3
+	return len(sample) >= 3 && sample[0] == 0x16 && sample[1] == 0x03 && sample[2] <= 0x04
+}
+
+// peekClientHello parses the ClientHello a connection starts with and adds it
+// and its fingerprints to md. The returned connection still holds the
+// ClientHello for whoever reads it next.
+func peekClientHello(conn net.Conn, md connection.Metadata, log interfaces.Logger) (BufferedConn, connection.Metadata) {
+	bufConn := BufferedConn{bufio.NewReaderSize(conn, clienthello.MaxRecordLen), conn}
+	if err := bufConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		return bufConn, md
+	}
+	header, err := bufConn.peek(5)
+	if err != nil {
+		log.Debug("failed to peek ClientHello", producer.ErrAttr(err))
+		return bufConn, md
+	}
+	length, ok := clienthello.RecordLen(header)
+	if !ok {
+		return bufConn, md
+	}
+	record, err := bufConn.peek(length)
+	if err != nil {
+		log.Debug("failed to peek ClientHello", producer.ErrAttr(err))
+		return bufConn, md
+	}
+	hello, err := clienthello.Parse(record)
+	if err != nil {
+		log.Debug("failed to parse ClientHello", producer.ErrAttr(err))
+		return bufConn, md
+	}
+	md.TLS = &connection.TLS{
+		ServerName:   hello.ServerName,
+		ALPN:         hello.ALPN,
+		CipherSuites: clienthello.CipherSuiteNames(hello.CipherSuites),
+		JA3:          hello.JA3(),
+		JA4:          hello.JA4(),
+	}
+	return bufConn, md
+}
+
+// terminateTLS terminates TLS with the configuration of the connection
+func terminateTLS(ctx context.Context, conn net.Conn, md connection.Metadata, log interfaces.Logger, h interfaces.Honeypot) (net.Conn, connection.Metadata, error) {
+	config, err := h.TLSConfig(md)
+	if err != nil {
+		return nil, md, err
+	}
+	return tcp.TerminateTLS(ctx, conn, md, config, log, h)
+}
+
+// withTLS fingerprints the ClientHello and terminates TLS before handler on
+// connections of rules setting tls
+func withTLS(handler TCPHandlerFunc, log interfaces.Logger, h interfaces.Honeypot) TCPHandlerFunc {
+	return func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		if md.Rule == nil || !md.Rule.TLS || md.TLS != nil {
+			return handler(ctx, conn, md)
+		}
+		// the ClientHello and handshake stay out of the transcript, which
+		// records the decrypted session
+		raw, rec := transcript.Unwrap(conn)
+		bufConn, md := peekClientHello(raw, md, log)
+		tlsConn, md, err := terminateTLS(ctx, bufConn, md, log, h)
+		if err != nil {
+			if err := conn.Close(); err != nil {
+				log.Error("failed to close connection", producer.ErrAttr(err))
+			}
+			log.Debug("TLS handshake failed", slog.String("handler", md.Rule.Handler()), producer.ErrAttr(err))
+			return nil
+		}
+		if rec != nil {
+			tlsConn = transcript.NewConn(tlsConn, rec)
+		}
+		return handler(ctx, tlsConn, md)
+	}
+}
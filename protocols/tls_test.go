@@ -0,0 +1,255 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/protocols/mocks"
+	"github.com/mushorg/glutton/rules"
+	"github.com/mushorg/glutton/tlscert"
+	"github.com/mushorg/glutton/transcript"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// connPair returns both ends of a TCP connection
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, server.SetDeadline(time.Now().Add(5*time.Second)))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func tlsHoneypot(t *testing.T) *mocks.MockHoneypot {
+	cert, err := tlscert.Generate("IPC")
+	require.NoError(t, err)
+	h := &mocks.MockHoneypot{}
+	h.EXPECT().TLSConfig(mock.Anything).RunAndReturn(func(connection.Metadata) (*tls.Config, error) {
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	})
+	h.EXPECT().UpdateConnectionTimeout(mock.Anything, mock.Anything).Return(nil)
+	return h
+}
+
+func TestIsClientHello(t *testing.T) {
+	require.True(t, isClientHello([]byte{0x16, 0x03, 0x01, 0x02}))
+	require.True(t, isClientHello([]byte{0x16, 0x03, 0x00}))
+	require.False(t, isClientHello([]byte{0x16, 0x03, 0x05}))
+	require.False(t, isClientHello([]byte("GET ")))
+	require.False(t, isClientHello([]byte{0x16}))
+}
+
+func TestWithTLS(t *testing.T) {
+	server, client := connPair(t)
+	h := tlsHoneypot(t)
+	l := &mocks.MockLogger{}
+
+	received := make(chan connection.Metadata, 1)
+	handler := withTLS(func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		defer conn.Close()
+		data := make([]byte, 5)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return err
+		}
+		received <- md
+		_, err := conn.Write(data)
+		return err
+	}, l, h)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- handler(context.Background(), server, connection.Metadata{Rule: &rules.Rule{Target: "echo", TLS: true}})
+	}()
+
+	conn := tls.Client(client, &tls.Config{
+		ServerName:         "camera.example",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	})
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	data := make([]byte, 5)
+	_, err = io.ReadFull(conn, data)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	require.NoError(t, <-errs)
+
+	md := <-received
+	require.NotNil(t, md.TLS)
+	require.Equal(t, "camera.example", md.TLS.ServerName)
+	require.Equal(t, []string{"h2", "http/1.1"}, md.TLS.ALPN)
+	require.Contains(t, md.TLS.CipherSuites, "TLS_AES_128_GCM_SHA256")
+	require.Equal(t, "TLS 1.3", md.TLS.Version)
+	require.NotEmpty(t, md.TLS.CipherSuite)
+	require.Len(t, md.TLS.JA3, 32)
+	require.Equal(t, "t13d", md.TLS.JA4[:4])
+}
+
+func TestWithTLSTranscript(t *testing.T) {
+	server, client := connPair(t)
+	h := tlsHoneypot(t)
+	l := &mocks.MockLogger{}
+	rec := transcript.NewRecorder(transcript.Options{})
+
+	handler := withTLS(func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		defer conn.Close()
+		if _, err := conn.Write([]byte("# ")); err != nil {
+			return err
+		}
+		data := make([]byte, 3)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return err
+		}
+		_, err := conn.Write([]byte("uid=0(root)\n"))
+		return err
+	}, l, h)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- handler(context.Background(), transcript.NewConn(server, rec), connection.Metadata{Rule: &rules.Rule{Target: "telnet", TLS: true}})
+	}()
+
+	conn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	_, err := io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("id\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.NoError(t, <-errs)
+
+	// the transcript holds the decrypted session, not the handshake
+	data, err := rec.Bytes()
+	require.NoError(t, err)
+	tr, err := transcript.Parse(bytes.NewReader(data))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	require.NoError(t, transcript.Play(context.Background(), out, tr, transcript.PlayOptions{MaxIdle: time.Millisecond}))
+	require.Equal(t, "# id\nuid=0(root)\n", out.String())
+}
+
+func TestWithTLSRejectedCertificate(t *testing.T) {
+	server, client := connPair(t)
+	h := tlsHoneypot(t)
+	h.EXPECT().ProduceTCP("tls", mock.Anything, mock.MatchedBy(func(md connection.Metadata) bool {
+		return md.TLS != nil && md.TLS.ServerName == "camera.example"
+	}), mock.Anything, mock.Anything).Return(nil).Once()
+	l := &mocks.MockLogger{}
+	l.EXPECT().Debug(mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	handler := withTLS(func(ctx context.Context, conn net.Conn, md connection.Metadata) error {
+		t.Error("handler called after a failed handshake")
+		return nil
+	}, l, h)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- handler(context.Background(), server, connection.Metadata{Rule: &rules.Rule{Target: "echo", TLS: true}})
+	}()
+
+	// the client verifies the self-signed certificate and aborts
+	conn := tls.Client(client, &tls.Config{ServerName: "camera.example"})
+	require.Error(t, conn.Handshake())
+	require.NoError(t, <-errs)
+	h.AssertExpectations(t)
+}
+
+func TestDetectTLS(t *testing.T) {
+	viper.Set("tls.detect", true)
+	viper.Set("spicy.enabled", false)
+	defer viper.Set("spicy.enabled", true)
+
+	server, client := connPair(t)
+	h := tlsHoneypot(t)
+	h.EXPECT().Store(mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	h.EXPECT().FetchSamples(mock.Anything, mock.Anything, mock.Anything).Return()
+	produced := make(chan connection.Metadata, 1)
+	h.EXPECT().ProduceTCP("tcp", mock.Anything, mock.Anything, []byte("GET / HTTP/1.0\r\n\r\n"), mock.Anything).RunAndReturn(
+		func(_ string, _ net.Conn, md connection.Metadata, _ []byte, _ interface{}, _ ...producer.EventOption) error {
+			produced <- md
+			return nil
+		})
+	l := &mocks.MockLogger{}
+	l.EXPECT().Info(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	l.EXPECT().Info(mock.Anything).Return().Maybe()
+
+	m := MapTCPProtocolHandlers(l, h)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m["tcp"](context.Background(), server, connection.Metadata{Rule: &rules.Rule{Target: "tcp"}})
+	}()
+
+	conn := tls.Client(client, &tls.Config{ServerName: "www.example", InsecureSkipVerify: true})
+	_, err := conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	// the catch-all answers with random bytes over TLS
+	_, err = conn.Read(make([]byte, 1024))
+	require.NoError(t, err)
+	require.NoError(t, <-errs)
+
+	md := <-produced
+	require.NotNil(t, md.TLS)
+	require.Equal(t, "www.example", md.TLS.ServerName)
+}
+
+func TestFingerprintTLS(t *testing.T) {
+	viper.Set("tls.detect", false)
+	viper.Set("spicy.enabled", false)
+	defer viper.Set("tls.detect", true)
+	defer viper.Set("spicy.enabled", true)
+
+	server, client := connPair(t)
+	h := &mocks.MockHoneypot{}
+	h.EXPECT().UpdateConnectionTimeout(mock.Anything, mock.Anything).Return(nil)
+	h.EXPECT().Store(mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	h.EXPECT().FetchSamples(mock.Anything, mock.Anything, mock.Anything).Return()
+	produced := make(chan connection.Metadata, 1)
+	payloads := make(chan []byte, 1)
+	h.EXPECT().ProduceTCP("tcp", mock.Anything, mock.Anything, mock.Anything, mock.Anything).RunAndReturn(
+		func(_ string, _ net.Conn, md connection.Metadata, payload []byte, _ interface{}, _ ...producer.EventOption) error {
+			produced <- md
+			payloads <- payload
+			return nil
+		})
+	l := &mocks.MockLogger{}
+	l.EXPECT().Info(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	l.EXPECT().Info(mock.Anything).Return().Maybe()
+
+	m := MapTCPProtocolHandlers(l, h)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m["tcp"](context.Background(), server, connection.Metadata{Rule: &rules.Rule{Target: "tcp"}})
+	}()
+
+	// the handshake fails on the random bytes the catch-all answers with
+	conn := tls.Client(client, &tls.Config{ServerName: "www.example", NextProtos: []string{"h2"}, MaxVersion: tls.VersionTLS12})
+	require.Error(t, conn.Handshake())
+	require.NoError(t, <-errs)
+
+	md := <-produced
+	require.NotNil(t, md.TLS)
+	require.Equal(t, "www.example", md.TLS.ServerName)
+	require.Equal(t, []string{"h2"}, md.TLS.ALPN)
+	require.NotEmpty(t, md.TLS.CipherSuites)
+	require.Len(t, md.TLS.JA3, 32)
+	require.Equal(t, "t12d", md.TLS.JA4[:4])
+	require.Empty(t, md.TLS.Version)
+	// the ClientHello is still the payload of the event
+	require.True(t, isClientHello(<-payloads))
+}
@@ -6,15 +6,15 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/mushorg/glutton/artifact"
 	"github.com/mushorg/glutton/connection"
 	"github.com/mushorg/glutton/producer"
-	"github.com/mushorg/glutton/protocols/helpers"
 	"github.com/mushorg/glutton/protocols/interfaces"
 )
 
 func HandleUDP(ctx context.Context, srcAddr, dstAddr *net.UDPAddr, data []byte, md connection.Metadata, log interfaces.Logger, h interfaces.Honeypot) error {
 	log.Info(fmt.Sprintf("UDP payload:\n%s", hex.Dump(data[:min(len(data), 1024)])))
-	if _, err := helpers.Store(data[:min(len(data), 1024)], "payloads"); err != nil {
+	if _, err := h.Store(md, data[:min(len(data), 1024)], artifact.Info{Kind: artifact.KindPayload, Handler: "udp"}); err != nil {
 		log.Error("failed to store UDP payload", producer.ErrAttr(err))
 	}
 	if err := h.ProduceUDP("udp", srcAddr, dstAddr, md, data[:min(len(data), 1024)], nil); err != nil {
@@ -1,9 +1,13 @@
 package rules
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,16 +26,43 @@ const (
 	Drop
 )
 
+// CurrentVersion is the newest rules file schema this package understands.
+// Version 1 (or an omitted version) is a flat list of rules evaluated in order,
+// version 2 adds include directives, named groups and priorities.
+const CurrentVersion = 2
+
 type Config struct {
-	Version int   `yaml:"version"`
-	Rules   Rules `yaml:"rules"`
+	Version        int      `yaml:"version"`
+	Include        []string `yaml:"include,omitempty"`
+	DisabledGroups []string `yaml:"disabled_groups,omitempty"`
+	Groups         []*Group `yaml:"groups,omitempty"`
+	Rules          Rules    `yaml:"rules"`
+}
+
+// Group is a named set of rules that can be enabled or disabled as a unit
+type Group struct {
+	Name     string `yaml:"name"`
+	Enabled  *bool  `yaml:"enabled,omitempty"`
+	Priority int    `yaml:"priority,omitempty"`
+	Rules    Rules  `yaml:"rules"`
+}
+
+func (g *Group) enabled() bool {
+	return g.Enabled == nil || *g.Enabled
 }
 
 type Rule struct {
-	Match  string `yaml:"match"`
-	Type   string `yaml:"type"`
-	Target string `yaml:"target,omitempty"`
-	Name   string `yaml:"name,omitempty"`
+	Match    string    `yaml:"match"`
+	Type     string    `yaml:"type"`
+	Target   string    `yaml:"target,omitempty"`
+	Name     string    `yaml:"name,omitempty"`
+	Priority int       `yaml:"priority,omitempty"`
+	Group    string    `yaml:"group,omitempty"`
+	Schedule *Schedule `yaml:"schedule,omitempty"`
+	// Persona names the persona presented on matching connections
+	Persona string `yaml:"persona,omitempty"`
+	// TLS terminates TLS before the connection is handed to the target
+	TLS bool `yaml:"tls,omitempty"`
 
 	isInit      bool
 	RuleType    RuleType
@@ -50,15 +81,207 @@ func (r *Rule) String() string {
 	return fmt.Sprintf("Rule: %s", r.Match)
 }
 
+// Index returns the position of the rule in the resolved rule list, or -1 for
+// rules that were not loaded from a rules file.
+func (r *Rule) Index() int {
+	if !r.isInit {
+		return -1
+	}
+	return r.index
+}
+
+// Active reports whether the rule's schedule allows it to match at the given time
+func (r *Rule) Active(now time.Time) bool {
+	return r.Schedule == nil || r.Schedule.Active(now)
+}
+
+// Handler returns the name of the protocol handler the rule dispatches to
+func (r *Rule) Handler() string {
+	switch r.Type {
+	case "proxy_tcp":
+		return r.Type
+	case "drop":
+		return ""
+	default:
+		return r.Target
+	}
+}
+
+// Init parses the rules from file. Include directives are resolved relative
+// to the current working directory.
 func Init(file io.Reader) (Rules, error) {
+	l := newLoader()
+	if err := l.parse(file, "."); err != nil {
+		return nil, err
+	}
+	return l.resolve()
+}
+
+// Load parses the rules file at path. Include directives are resolved relative
+// to the directory of the file containing them.
+func Load(path string) (Rules, error) {
+	l := newLoader()
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+	return l.resolve()
+}
+
+// loader collects the rules of a file and everything it includes
+type loader struct {
+	seen     map[string]bool
+	disabled map[string]bool
+	rules    Rules
+}
+
+func newLoader() *loader {
+	return &loader{
+		seen:     map[string]bool{},
+		disabled: map[string]bool{},
+	}
+}
+
+func (l *loader) load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.seen[abs] {
+		return fmt.Errorf("rules file included more than once: %s", path)
+	}
+	l.seen[abs] = true
+
+	fh, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if err := l.parse(fh, filepath.Dir(abs)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (l *loader) parse(file io.Reader, dir string) error {
 	config := &Config{}
 	if err := yaml.NewDecoder(file).Decode(config); err != nil {
-		return nil, err
+		if errors.Is(err, io.EOF) {
+			// empty file, nothing to add
+			return nil
+		}
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	for _, name := range config.DisabledGroups {
+		l.disabled[name] = true
+	}
+
+	for _, pattern := range config.Include {
+		paths, err := expandInclude(pattern, dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := l.load(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, group := range config.Groups {
+		if !group.enabled() {
+			l.disabled[group.Name] = true
+		}
+		for _, rule := range group.Rules {
+			rule.Group = group.Name
+			if rule.Priority == 0 {
+				rule.Priority = group.Priority
+			}
+			l.rules = append(l.rules, rule)
+		}
+	}
+
+	l.rules = append(l.rules, config.Rules...)
+	return nil
+}
+
+// resolve drops the rules of disabled groups and orders the remaining rules
+// by descending priority. Rules with equal priority keep their load order.
+func (l *loader) resolve() (Rules, error) {
+	resolved := Rules{}
+	for _, rule := range l.rules {
+		if rule.Group != "" && l.disabled[rule.Group] {
+			continue
+		}
+		resolved = append(resolved, rule)
 	}
-	if err := config.Rules.init(); err != nil {
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return resolved[i].Priority > resolved[j].Priority
+	})
+	if err := resolved.init(); err != nil {
 		return nil, err
 	}
-	return config.Rules, nil
+	return resolved, nil
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.Version < 0 || c.Version > CurrentVersion:
+		return fmt.Errorf("unsupported rules version: %d", c.Version)
+	case c.Version >= 2:
+		return nil
+	}
+
+	// version 1 files predate includes, groups and priorities
+	if len(c.Include) > 0 || len(c.Groups) > 0 || len(c.DisabledGroups) > 0 {
+		return fmt.Errorf("include and groups require rules version 2")
+	}
+	for _, rule := range c.Rules {
+		if rule.Priority != 0 || rule.Group != "" || rule.Schedule != nil {
+			return fmt.Errorf("rule priority, group and schedule require rules version 2")
+		}
+	}
+	return nil
+}
+
+// expandInclude turns an include directive into a sorted list of files. A
+// directory includes every YAML file directly inside it.
+func expandInclude(pattern, dir string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include %q: %w", pattern, err)
+	}
+
+	paths := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, match)
+			continue
+		}
+		entries, err := os.ReadDir(match)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				paths = append(paths, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
 }
 
 func (rule *Rule) init(idx int) error {
@@ -85,6 +308,12 @@ func (rule *Rule) init(idx int) error {
 		rule.ProxyTarget = target
 	}
 
+	if rule.Schedule != nil {
+		if err := rule.Schedule.init(); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+
 	var err error
 	if len(rule.Match) > 0 {
 		rule.matcher, err = pcap.NewBPF(layers.LinkTypeEthernet, 65535, rule.Match)
@@ -193,7 +422,14 @@ func fakePacketBytes(network, srcIP, dstIP string, srcPort, dstPort uint16) ([]b
 
 type Rules []*Rule
 
-func (rs Rules) Match(network string, srcAddr, dstAddr net.Addr) (*Rule, error) {
+// Evaluation is the outcome of testing a single rule against a flow
+type Evaluation struct {
+	Rule    *Rule
+	Active  bool
+	Matched bool
+}
+
+func flowPacket(network string, srcAddr, dstAddr net.Addr) ([]byte, error) {
 	srcIP, srcPort, err := splitAddr(srcAddr.String())
 	if err != nil {
 		return nil, err
@@ -206,24 +442,60 @@ func (rs Rules) Match(network string, srcAddr, dstAddr net.Addr) (*Rule, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fake packet: %w", err)
 	}
+	return b, nil
+}
+
+func (r *Rule) matches(b []byte) bool {
+	if r.matcher == nil {
+		return false
+	}
+	n := len(b)
+	return r.matcher.Matches(gopacket.CaptureInfo{
+		InterfaceIndex: 0,
+		CaptureLength:  n,
+		Length:         n,
+		Timestamp:      time.Now(),
+	}, b)
+}
+
+func (rs Rules) Match(network string, srcAddr, dstAddr net.Addr) (*Rule, error) {
+	b, err := flowPacket(network, srcAddr, dstAddr)
+	if err != nil {
+		return nil, err
+	}
 
+	now := time.Now()
 	for _, rule := range rs {
-		if rule.matcher != nil {
-			n := len(b)
-			if rule.matcher.Matches(gopacket.CaptureInfo{
-				InterfaceIndex: 0,
-				CaptureLength:  n,
-				Length:         n,
-				Timestamp:      time.Now(),
-			}, b) {
-				return rule, nil
-			}
+		if rule.Active(now) && rule.matches(b) {
+			return rule, nil
 		}
 	}
 
 	return nil, nil
 }
 
+// Explain evaluates every rule against the flow, including the ones Match
+// would never reach, and returns the winner Match would pick.
+func (rs Rules) Explain(network string, srcAddr, dstAddr net.Addr) ([]Evaluation, *Rule, error) {
+	b, err := flowPacket(network, srcAddr, dstAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var winner *Rule
+	now := time.Now()
+	evaluations := make([]Evaluation, 0, len(rs))
+	for _, rule := range rs {
+		active := rule.Active(now)
+		matched := rule.matches(b)
+		if active && matched && winner == nil {
+			winner = rule
+		}
+		evaluations = append(evaluations, Evaluation{Rule: rule, Active: active, Matched: matched})
+	}
+	return evaluations, winner, nil
+}
+
 // Init initializes the rules
 func (rs Rules) init() error {
 	for i, rule := range rs {
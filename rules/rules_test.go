@@ -3,6 +3,7 @@ package rules
 import (
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -135,6 +136,131 @@ func TestInitProxyTCPRuleRejectsInvalidTarget(t *testing.T) {
 	require.Contains(t, err.Error(), "too many colons")
 }
 
+func writeRules(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeRules(t, dir, "rules.d/10-telnet.yaml", `version: 2
+rules:
+  - name: telnet
+    match: tcp dst port 23
+    type: conn_handler
+    target: telnet
+`)
+	writeRules(t, dir, "rules.d/20-smtp.yml", `version: 2
+rules:
+  - name: smtp
+    match: tcp dst port 25
+    type: conn_handler
+    target: smtp
+`)
+	writeRules(t, dir, "extra/ftp.yaml", `version: 2
+rules:
+  - name: ftp
+    match: tcp dst port 21
+    type: conn_handler
+    target: ftp
+`)
+	path := writeRules(t, dir, "rules.yaml", `version: 2
+include:
+  - rules.d/
+  - extra/*.yaml
+rules:
+  - name: catch-all
+    match: tcp
+    type: conn_handler
+    target: tcp
+`)
+
+	rules, err := Load(path)
+	require.NoError(t, err)
+	names := []string{}
+	for i, rule := range rules {
+		require.Equal(t, i, rule.index)
+		names = append(names, rule.Name)
+	}
+	require.Equal(t, []string{"telnet", "smtp", "ftp", "catch-all"}, names)
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeRules(t, dir, "a.yaml", "version: 2\ninclude: [b.yaml]\n")
+	path := writeRules(t, dir, "b.yaml", "version: 2\ninclude: [a.yaml]\n")
+
+	_, err := Load(path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "included more than once")
+}
+
+func TestGroupsAndPriority(t *testing.T) {
+	rules, err := Init(strings.NewReader(`version: 2
+disabled_groups: [legacy]
+groups:
+  - name: mail
+    priority: 10
+    rules:
+      - name: smtp
+        match: tcp dst port 25
+        type: conn_handler
+        target: smtp
+  - name: legacy
+    rules:
+      - name: ftp
+        match: tcp dst port 21
+        type: conn_handler
+        target: ftp
+  - name: iot
+    enabled: false
+    rules:
+      - name: telnet
+        match: tcp dst port 23
+        type: conn_handler
+        target: telnet
+rules:
+  - name: catch-all
+    match: tcp
+    type: conn_handler
+    target: tcp
+  - name: proxy
+    priority: 20
+    match: tcp dst port 9889
+    type: proxy_tcp
+    target: 127.0.0.1:9889
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	require.Equal(t, "proxy", rules[0].Name)
+	require.Equal(t, "smtp", rules[1].Name)
+	require.Equal(t, "mail", rules[1].Group)
+	require.Equal(t, 10, rules[1].Priority)
+	require.Equal(t, "catch-all", rules[2].Name)
+}
+
+func TestRulesVersion(t *testing.T) {
+	_, err := Init(strings.NewReader("version: 3\nrules: []\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported rules version")
+
+	_, err = Init(strings.NewReader(`version: 1
+rules:
+  - match: tcp
+    type: conn_handler
+    target: tcp
+    priority: 5
+`))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "require rules version 2")
+
+	_, err = Init(strings.NewReader("include: [rules.d/]\n"))
+	require.Error(t, err)
+}
+
 func testConn(t *testing.T) (net.Conn, net.Listener) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -241,3 +367,73 @@ func TestWorkingMatch(t *testing.T) {
 		t.Fatal("didn't match")
 	}
 }
+
+func TestExplain(t *testing.T) {
+	rules := parseRules(t)
+	require.NotEmpty(t, rules)
+
+	srcAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+	dstAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}
+
+	evaluations, winner, err := rules.Explain("tcp", srcAddr, dstAddr)
+	require.NoError(t, err)
+	require.Len(t, evaluations, len(rules))
+	require.NotNil(t, winner)
+	require.Equal(t, 3, winner.Index())
+	require.Equal(t, "proxy_tcp", winner.Handler())
+
+	matched := []int{}
+	for _, evaluation := range evaluations {
+		if evaluation.Matched {
+			matched = append(matched, evaluation.Rule.Index())
+		}
+	}
+	// the catch-all matches too but is shadowed by the proxy rule
+	require.Equal(t, []int{3, 4}, matched)
+
+	match, err := rules.Match("tcp", srcAddr, dstAddr)
+	require.NoError(t, err)
+	require.Equal(t, winner, match)
+}
+
+func TestRuleIndexAndHandler(t *testing.T) {
+	rule := &Rule{Type: "conn_handler", Target: "telnet"}
+	require.Equal(t, -1, rule.Index())
+	require.Equal(t, "telnet", rule.Handler())
+	require.Empty(t, (&Rule{Type: "drop"}).Handler())
+}
+
+func TestRunMatchPerDestination(t *testing.T) {
+	rules, err := Init(strings.NewReader(`version: 2
+rules:
+  - name: router
+    match: tcp dst port 80 and dst host 203.0.113.1
+    type: conn_handler
+    target: http
+  - name: nas
+    match: tcp dst port 80 and dst host 203.0.113.2
+    type: proxy_tcp
+    target: 127.0.0.1:5000
+  - name: windows
+    match: dst net 203.0.113.8/29
+    type: conn_handler
+    target: smb
+`))
+	require.NoError(t, err)
+
+	srcAddr := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+	for dst, name := range map[string]string{
+		"203.0.113.1":  "router",
+		"203.0.113.2":  "nas",
+		"203.0.113.12": "windows",
+	} {
+		match, err := rules.Match("tcp", srcAddr, &net.TCPAddr{IP: net.ParseIP(dst), Port: 80})
+		require.NoError(t, err)
+		require.NotNil(t, match, dst)
+		require.Equal(t, name, match.Name, dst)
+	}
+
+	match, err := rules.Match("tcp", srcAddr, &net.TCPAddr{IP: net.ParseIP("203.0.113.3"), Port: 80})
+	require.NoError(t, err)
+	require.Nil(t, match)
+}
@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule limits when a rule is allowed to match. Start and End bound a
+// fixed window, Cron and Duration describe a recurring one: the rule is active
+// for Duration after every time the cron expression fires. Both can be combined.
+type Schedule struct {
+	Start    time.Time     `yaml:"start,omitempty"`
+	End      time.Time     `yaml:"end,omitempty"`
+	Cron     string        `yaml:"cron,omitempty"`
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Timezone the cron expression is evaluated in, defaults to the sensor's local time
+	Timezone string `yaml:"timezone,omitempty"`
+
+	cron     cron.Schedule
+	location *time.Location
+}
+
+func (s *Schedule) init() error {
+	if !s.Start.IsZero() && !s.End.IsZero() && !s.End.After(s.Start) {
+		return errors.New("end must be after start")
+	}
+
+	s.location = time.Local
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return err
+		}
+		s.location = location
+	}
+
+	if s.Cron == "" {
+		if s.Duration != 0 {
+			return errors.New("duration requires a cron expression")
+		}
+		return nil
+	}
+	if s.Duration <= 0 {
+		return errors.New("cron expression requires a positive duration")
+	}
+	schedule, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", s.Cron, err)
+	}
+	s.cron = schedule
+	return nil
+}
+
+// Active reports whether the schedule is active at the given time
+func (s *Schedule) Active(now time.Time) bool {
+	if !s.Start.IsZero() && now.Before(s.Start) {
+		return false
+	}
+	if !s.End.IsZero() && !now.Before(s.End) {
+		return false
+	}
+	if s.cron == nil {
+		return true
+	}
+	// active if the cron expression fired within the last Duration
+	next := s.cron.Next(now.In(s.location).Add(-s.Duration))
+	return !next.After(now)
+}
@@ -0,0 +1,78 @@
+package rules
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	s := &Schedule{Start: start, End: start.Add(7 * 24 * time.Hour)}
+	require.NoError(t, s.init())
+
+	require.False(t, s.Active(start.Add(-time.Second)))
+	require.True(t, s.Active(start))
+	require.True(t, s.Active(start.Add(3*24*time.Hour)))
+	require.False(t, s.Active(start.Add(7*24*time.Hour)))
+}
+
+func TestScheduleCron(t *testing.T) {
+	// business hours on weekdays in Berlin
+	s := &Schedule{Cron: "0 9 * * 1-5", Duration: 9 * time.Hour, Timezone: "Europe/Berlin"}
+	require.NoError(t, s.init())
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Monday 2026-10-19
+	require.False(t, s.Active(time.Date(2026, 10, 19, 8, 59, 0, 0, berlin)))
+	require.True(t, s.Active(time.Date(2026, 10, 19, 9, 0, 0, 0, berlin)))
+	require.True(t, s.Active(time.Date(2026, 10, 19, 17, 59, 0, 0, berlin)))
+	require.False(t, s.Active(time.Date(2026, 10, 19, 18, 0, 0, 0, berlin)))
+	// Saturday
+	require.False(t, s.Active(time.Date(2026, 10, 24, 12, 0, 0, 0, berlin)))
+	// same instant expressed in UTC
+	require.True(t, s.Active(time.Date(2026, 10, 19, 7, 30, 0, 0, time.UTC)))
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	require.Error(t, (&Schedule{Cron: "0 9 * * 1-5"}).init())
+	require.Error(t, (&Schedule{Duration: time.Hour}).init())
+	require.Error(t, (&Schedule{Cron: "not a cron", Duration: time.Hour}).init())
+	require.Error(t, (&Schedule{Cron: "0 9 * * *", Duration: time.Hour, Timezone: "Mars/Olympus"}).init())
+}
+
+func TestScheduledRuleMatch(t *testing.T) {
+	rules, err := Init(strings.NewReader(`version: 2
+rules:
+  - name: expired-campaign
+    match: tcp dst port 3389
+    type: conn_handler
+    target: rdp
+    schedule:
+      start: 2020-01-01T00:00:00Z
+      end: 2020-02-01T00:00:00Z
+  - name: proxy
+    match: tcp dst port 3389
+    type: proxy_tcp
+    target: 127.0.0.1:3389
+`))
+	require.NoError(t, err)
+	require.NotNil(t, rules[0].Schedule)
+
+	srcAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5555}
+	dstAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3389}
+	match, err := rules.Match("tcp", srcAddr, dstAddr)
+	require.NoError(t, err)
+	require.Equal(t, "proxy", match.Name)
+
+	evaluations, winner, err := rules.Explain("tcp", srcAddr, dstAddr)
+	require.NoError(t, err)
+	require.Equal(t, match, winner)
+	require.False(t, evaluations[0].Active)
+	require.True(t, evaluations[0].Matched)
+}
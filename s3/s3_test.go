@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	// example from the AWS signature version 4 documentation
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sum := sha256.Sum256(nil)
+	sign(req, hex.EncodeToString(sum[:]), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "iam",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	require.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7", req.Header.Get("Authorization"))
+}
+
+// bucket is a MinIO stand-in accepting signed uploads
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256(data)
+	if r.Method != http.MethodPut ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[r.URL.Path] = data
+	b.types[r.URL.Path] = r.Header.Get("Content-Type")
+}
+
+func TestPut(t *testing.T) {
+	b := &bucket{objects: map[string][]byte{}, types: map[string]string{}}
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	client, err := New(Config{Endpoint: server.URL, Bucket: "glutton", AccessKey: "minio", SecretKey: "minio123", PathStyle: true})
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/glutton/samples/abc", client.URL("samples/abc"))
+	require.NoError(t, client.Put(context.Background(), "samples/abc", []byte("#!/bin/sh"), "text/plain"))
+	require.Equal(t, []byte("#!/bin/sh"), b.objects["/glutton/samples/abc"])
+	require.Equal(t, "text/plain", b.types["/glutton/samples/abc"])
+
+	client, err = New(Config{Endpoint: server.URL, Bucket: "glutton", AccessKey: "other", PathStyle: true})
+	require.NoError(t, err)
+	err = client.Put(context.Background(), "abc", []byte("x"), "")
+	require.ErrorContains(t, err, "AccessDenied")
+
+	// virtual hosted style puts the bucket in the host name
+	client, err = New(Config{Endpoint: "https://s3.eu-west-1.amazonaws.com", Bucket: "glutton"})
+	require.NoError(t, err)
+	require.Equal(t, "https://glutton.s3.eu-west-1.amazonaws.com/abc", client.URL("abc"))
+
+	_, err = New(Config{Endpoint: "s3.amazonaws.com", Bucket: "glutton"})
+	require.Error(t, err)
+}
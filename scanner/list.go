@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Entry is a named scanner with its networks and reverse DNS suffixes
+type Entry struct {
+	Name  string   `yaml:"name"`
+	CIDRs []string `yaml:"cidrs"`
+	RDNS  []string `yaml:"rdns"`
+}
+
+// File is the format of a scanner list
+type File struct {
+	Scanners []Entry `yaml:"scanners"`
+}
+
+// ParseFile reads and validates a scanner list
+func ParseFile(r io.Reader) (*File, error) {
+	f := &File{}
+	if err := yaml.NewDecoder(r).Decode(f); err != nil {
+		if errors.Is(err, io.EOF) {
+			return f, nil
+		}
+		return nil, err
+	}
+	for i, entry := range f.Scanners {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("scanner %d has no name", i)
+		}
+		for _, cidr := range entry.CIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				return nil, fmt.Errorf("scanner %q: %w", entry.Name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+type suffix struct {
+	suffix string
+	name   string
+}
+
+// list is an immutable lookup structure built from scanner files
+type list struct {
+	v4       *trie
+	v6       *trie
+	suffixes []suffix
+}
+
+func newList(files ...*File) *list {
+	l := &list{v4: &trie{}, v6: &trie{}}
+	for _, f := range files {
+		for _, entry := range f.Scanners {
+			for _, cidr := range entry.CIDRs {
+				// validated by ParseFile
+				prefix := netip.MustParsePrefix(cidr).Masked()
+				if prefix.Addr().Is4() {
+					l.v4.insert(prefix, entry.Name)
+				} else {
+					l.v6.insert(prefix, entry.Name)
+				}
+			}
+			for _, s := range entry.RDNS {
+				s = strings.ToLower(strings.Trim(s, "."))
+				if s != "" {
+					l.suffixes = append(l.suffixes, suffix{suffix: s, name: entry.Name})
+				}
+			}
+		}
+	}
+	return l
+}
+
+// lookup returns the scanner owning the most specific network containing addr
+func (l *list) lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return l.v4.lookup(addr)
+	}
+	return l.v6.lookup(addr)
+}
+
+// matchName returns the scanner whose rDNS suffix matches the host name
+func (l *list) matchName(host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, s := range l.suffixes {
+		if host == s.suffix || strings.HasSuffix(host, "."+s.suffix) {
+			return s.name, true
+		}
+	}
+	return "", false
+}
+
+// trie is a binary prefix trie over address bits
+type trie struct {
+	children [2]*trie
+	name     string
+	terminal bool
+}
+
+func bit(b []byte, i int) int {
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+func (t *trie) insert(prefix netip.Prefix, name string) {
+	b := prefix.Addr().AsSlice()
+	node := t
+	for i := 0; i < prefix.Bits(); i++ {
+		next := node.children[bit(b, i)]
+		if next == nil {
+			next = &trie{}
+			node.children[bit(b, i)] = next
+		}
+		node = next
+	}
+	node.name = name
+	node.terminal = true
+}
+
+func (t *trie) lookup(addr netip.Addr) (string, bool) {
+	b := addr.AsSlice()
+	name, found := t.name, t.terminal
+	node := t
+	for i := 0; i < len(b)*8; i++ {
+		node = node.children[bit(b, i)]
+		if node == nil {
+			break
+		}
+		if node.terminal {
+			name, found = node.name, true
+		}
+	}
+	return name, found
+}
@@ -1,64 +1,322 @@
 package scanner
 
 import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/netip"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var (
-	scannerSubnet = map[string][]string{
-		"censys": {
-			"162.142.125.0/24",
-			"167.94.138.0/24",
-			"167.94.145.0/24",
-			"167.94.146.0/24",
-			"167.248.133.0/24",
-		},
-		"shadowserver": {
-			"64.62.202.96/27",
-			"66.220.23.112/29",
-			"74.82.47.0/26",
-			"184.105.139.64/26",
-			"184.105.143.128/26",
-			"184.105.247.192/26",
-			"216.218.206.64/26",
-			"141.212.0.0/16",
-		},
-		"PAN Expanse": {
-			"144.86.173.0/24",
-		},
-		"rwth": {
-			"137.226.113.56/26",
-		},
-	}
+//go:embed scanners.yaml
+var builtinList []byte
+
+const (
+	defaultCacheTTL    = 24 * time.Hour
+	defaultNegativeTTL = time.Hour
+	defaultWorkers     = 4
+	lookupTimeout      = 2 * time.Second
+	fetchTimeout       = 10 * time.Second
+	maxSourceSize      = 10 << 20
+	maxCacheEntries    = 65536
+	lookupQueueSize    = 1024
 )
 
-func IsScanner(ip net.IP) (bool, string, error) {
-	for scanner, subnets := range scannerSubnet {
-		for _, subnet := range subnets {
-			_, net, err := net.ParseCIDR(subnet)
-			if err != nil {
-				return false, "", err
+// Resolver does reverse DNS lookups
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// Options configure a Classifier
+type Options struct {
+	// Sources are scanner list files or http(s) URLs loaded on top of the
+	// built-in list
+	Sources []string
+	// Refresh is how often the sources are reloaded, zero disables reloading
+	Refresh time.Duration
+	// RDNS enables reverse DNS classification
+	RDNS bool
+	// CacheTTL is how long a reverse DNS result is kept
+	CacheTTL time.Duration
+	// NegativeTTL is how long a failed or unmatched lookup is kept
+	NegativeTTL time.Duration
+	Workers     int
+	Resolver    Resolver
+	Logger      *slog.Logger
+}
+
+type cacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// Classifier tells if an address belongs to a known scanner. Networks are
+// matched synchronously, reverse DNS is resolved in the background: the
+// first sighting of an address is queued for lookup and reported as unknown
+// until the result is cached.
+type Classifier struct {
+	opts    Options
+	list    atomic.Pointer[list]
+	mu      sync.Mutex
+	cache   map[netip.Addr]cacheEntry
+	pending map[netip.Addr]struct{}
+	lookups chan netip.Addr
+	client  *http.Client
+}
+
+// New loads the scanner lists and starts the lookup workers and the refresh
+// ticker, which stop when ctx is done
+func New(ctx context.Context, opts Options) (*Classifier, error) {
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultCacheTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = defaultNegativeTTL
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	c := &Classifier{
+		opts:    opts,
+		cache:   make(map[netip.Addr]cacheEntry),
+		pending: make(map[netip.Addr]struct{}),
+		lookups: make(chan netip.Addr, lookupQueueSize),
+		client:  &http.Client{Timeout: fetchTimeout},
+	}
+	// a source that is down doesn't keep the sensor from starting, the
+	// others and the built-in list are used until the next refresh
+	files, err := c.loadLists(ctx)
+	if files == nil {
+		return nil, err
+	}
+	if err != nil {
+		c.opts.Logger.Error("Failed to load scanner lists, continuing without them", slog.String("error", err.Error()), slog.String("reporter", "scanner"))
+	}
+	c.setLists(files)
+
+	if opts.RDNS {
+		for i := 0; i < opts.Workers; i++ {
+			go c.lookupWorker(ctx)
+		}
+	}
+	if opts.Refresh > 0 && len(opts.Sources) > 0 {
+		go func() {
+			ticker := time.NewTicker(opts.Refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := c.Refresh(ctx); err != nil {
+						c.opts.Logger.Error("Failed to refresh scanner lists, keeping the current ones", slog.String("error", err.Error()), slog.String("reporter", "scanner"))
+					}
+				}
 			}
-			if net.Contains(ip) {
-				return true, scanner, nil
+		}()
+	}
+	return c, nil
+}
+
+// Refresh reloads the built-in list and all sources. The current lists are
+// kept if any source fails to load.
+func (c *Classifier) Refresh(ctx context.Context) error {
+	files, err := c.loadLists(ctx)
+	if err != nil {
+		return err
+	}
+	c.setLists(files)
+	return nil
+}
+
+// loadLists parses the built-in list and loads the sources. The sources
+// that fail are left out and their errors returned along with the lists,
+// which are nil only if the built-in list is invalid.
+func (c *Classifier) loadLists(ctx context.Context) ([]*File, error) {
+	builtin, err := ParseFile(bytes.NewReader(builtinList))
+	if err != nil {
+		return nil, fmt.Errorf("invalid built-in scanner list: %w", err)
+	}
+	files := []*File{builtin}
+	var errs []error
+	for _, source := range c.opts.Sources {
+		f, err := c.load(ctx, source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load scanner list %s: %w", source, err))
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, errors.Join(errs...)
+}
+
+// setLists replaces the lists classified against
+func (c *Classifier) setLists(files []*File) {
+	c.list.Store(newList(files...))
+
+	// cached results may be stale against the new rDNS suffixes
+	c.mu.Lock()
+	c.cache = make(map[netip.Addr]cacheEntry)
+	c.mu.Unlock()
+}
+
+func (c *Classifier) load(ctx context.Context, source string) (*File, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseFile(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status " + resp.Status)
+	}
+	return ParseFile(io.LimitReader(resp.Body, maxSourceSize))
+}
+
+// Classify returns the name of the scanner owning ip, if known
+func (c *Classifier) Classify(ip net.IP) (bool, string) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false, ""
+	}
+	addr = addr.Unmap()
+	l := c.list.Load()
+	if name, ok := l.lookup(addr); ok {
+		return true, name
+	}
+	if !c.opts.RDNS || len(l.suffixes) == 0 {
+		return false, ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.cache[addr]; ok && time.Now().Before(entry.expires) {
+		return entry.name != "", entry.name
+	}
+	if _, ok := c.pending[addr]; !ok {
+		select {
+		case c.lookups <- addr:
+			c.pending[addr] = struct{}{}
+		default:
+			// the queue is full, the address is retried on its next sighting
+		}
+	}
+	return false, ""
+}
+
+func (c *Classifier) lookupWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case addr := <-c.lookups:
+			name := c.resolve(ctx, addr)
+			ttl := c.opts.CacheTTL
+			if name == "" {
+				ttl = c.opts.NegativeTTL
 			}
+			c.store(addr, cacheEntry{name: name, expires: time.Now().Add(ttl)})
 		}
 	}
-	names, err := net.LookupAddr(ip.String())
+}
+
+func (c *Classifier) resolve(ctx context.Context, addr netip.Addr) string {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	names, err := c.opts.Resolver.LookupAddr(ctx, addr.String())
 	if err != nil {
-		return false, "", nil
+		return ""
 	}
-	for _, name := range names {
-		if strings.HasSuffix(name, "shodan.io.") {
-			return true, "shodan", nil
+	l := c.list.Load()
+	for _, host := range names {
+		if name, ok := l.matchName(host); ok {
+			return name
 		}
-		if strings.HasSuffix(name, "binaryedge.ninja.") {
-			return true, "binaryedge", nil
+	}
+	return ""
+}
+
+func (c *Classifier) store(addr netip.Addr, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, addr)
+	if len(c.cache) >= maxCacheEntries {
+		now := time.Now()
+		for a, e := range c.cache {
+			if now.After(e.expires) {
+				delete(c.cache, a)
+			}
 		}
-		if strings.HasSuffix(name, "rwth-aachen.de.") {
-			return true, "rwth", nil
+		// still full, make room by dropping an arbitrary entry
+		for a := range c.cache {
+			if len(c.cache) < maxCacheEntries {
+				break
+			}
+			delete(c.cache, a)
 		}
 	}
-	return false, "", nil
+	c.cache[addr] = entry
+}
+
+var (
+	defaultClassifier atomic.Pointer[Classifier]
+	defaultOnce       sync.Once
+)
+
+// Init replaces the package classifier used by IsScanner
+func Init(ctx context.Context, opts Options) error {
+	c, err := New(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defaultOnce.Do(func() {})
+	defaultClassifier.Store(c)
+	return nil
+}
+
+func getDefault() *Classifier {
+	defaultOnce.Do(func() {
+		// the built-in list is validated by the tests, it can't fail to load
+		c, err := New(context.Background(), Options{})
+		if err != nil {
+			panic(err)
+		}
+		defaultClassifier.Store(c)
+	})
+	return defaultClassifier.Load()
+}
+
+// IsScanner classifies ip with the package classifier. Without Init it only
+// matches the networks of the built-in list.
+func IsScanner(ip net.IP) (bool, string, error) {
+	matched, name := getDefault().Classify(ip)
+	return matched, name, nil
 }
@@ -1,8 +1,19 @@
 package scanner
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -12,3 +23,112 @@ func TestIsScanner(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, matched, "IP should be a scanner")
 }
+
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if names, ok := r[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestLongestMatch(t *testing.T) {
+	f, err := ParseFile(strings.NewReader(`scanners:
+  - name: wide
+    cidrs: [10.0.0.0/8, "2001:db8::/32"]
+  - name: narrow
+    cidrs: [10.1.2.0/24]
+`))
+	require.NoError(t, err)
+	l := newList(f)
+
+	name, ok := l.lookup(netip.MustParseAddr("10.1.2.3"))
+	require.True(t, ok)
+	require.Equal(t, "narrow", name)
+	name, ok = l.lookup(netip.MustParseAddr("::ffff:10.9.9.9"))
+	require.True(t, ok)
+	require.Equal(t, "wide", name)
+	name, ok = l.lookup(netip.MustParseAddr("2001:db8::1"))
+	require.True(t, ok)
+	require.Equal(t, "wide", name)
+	_, ok = l.lookup(netip.MustParseAddr("192.0.2.1"))
+	require.False(t, ok)
+}
+
+func TestParseFileInvalid(t *testing.T) {
+	_, err := ParseFile(strings.NewReader("scanners:\n  - name: bad\n    cidrs: [10.0.0.0/33]\n"))
+	require.Error(t, err)
+	_, err = ParseFile(strings.NewReader("scanners:\n  - cidrs: [10.0.0.0/8]\n"))
+	require.Error(t, err)
+}
+
+func TestClassifierRDNS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := New(ctx, Options{
+		RDNS: true,
+		Resolver: fakeResolver{
+			"192.0.2.1": {"census1.shodan.io."},
+			"192.0.2.2": {"host.example.com."},
+		},
+	})
+	require.NoError(t, err)
+
+	// the first sighting only queues the lookup
+	matched, _ := c.Classify(net.ParseIP("192.0.2.1"))
+	require.False(t, matched)
+	require.Eventually(t, func() bool {
+		matched, name := c.Classify(net.ParseIP("192.0.2.1"))
+		return matched && name == "shodan"
+	}, time.Second, 10*time.Millisecond)
+
+	c.Classify(net.ParseIP("192.0.2.2"))
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entry, ok := c.cache[netip.MustParseAddr("192.0.2.2")]
+		return ok && entry.name == ""
+	}, time.Second, 10*time.Millisecond)
+	matched, _ = c.Classify(net.ParseIP("192.0.2.2"))
+	require.False(t, matched)
+}
+
+func TestClassifierSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("scanners:\n  - name: local\n    cidrs: [198.51.100.0/24]\n"), 0o600))
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("scanners:\n  - name: remote\n    cidrs: [203.0.113.0/24]\n"))
+	}))
+	defer svr.Close()
+
+	c, err := New(context.Background(), Options{Sources: []string{path, svr.URL}})
+	require.NoError(t, err)
+	_, name := c.Classify(net.ParseIP("198.51.100.7"))
+	require.Equal(t, "local", name)
+	_, name = c.Classify(net.ParseIP("203.0.113.7"))
+	require.Equal(t, "remote", name)
+	_, name = c.Classify(net.ParseIP("162.142.125.1"))
+	require.Equal(t, "censys", name)
+
+	// a broken source keeps the current lists
+	require.NoError(t, os.WriteFile(path, []byte("scanners: [\n"), 0o600))
+	require.Error(t, c.Refresh(context.Background()))
+	_, name = c.Classify(net.ParseIP("198.51.100.7"))
+	require.Equal(t, "local", name)
+}
+
+func TestClassifierUnreachableSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("scanners:\n  - name: local\n    cidrs: [198.51.100.0/24]\n"), 0o600))
+	svr := httptest.NewServer(http.NotFoundHandler())
+	defer svr.Close()
+
+	// starts with the built-in list and the sources that loaded
+	c, err := New(context.Background(), Options{Sources: []string{svr.URL, path}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	require.NoError(t, err)
+	_, name := c.Classify(net.ParseIP("198.51.100.7"))
+	require.Equal(t, "local", name)
+	_, name = c.Classify(net.ParseIP("162.142.125.1"))
+	require.Equal(t, "censys", name)
+}
@@ -0,0 +1,68 @@
+package glutton
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+)
+
+// session close reasons reported in session.end events
+const (
+	closeReasonClosed   = "closed"
+	closeReasonTimeout  = "timeout"
+	closeReasonShutdown = "shutdown"
+	closeReasonError    = "error"
+)
+
+func (g *Glutton) startSession(handler string, md connection.Metadata) {
+	g.recordSession(handler, md)
+	if g.Producer == nil {
+		return
+	}
+	if err := g.Producer.LogSessionStart(handler, md); err != nil {
+		g.Logger.Error("Failed to produce session start", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+	}
+}
+
+func (g *Glutton) endSession(handler string, md connection.Metadata, handlerErr error, opts ...producer.EventOption) {
+	g.connTable.Close(md)
+	g.observeSession(md)
+	g.takeAttachments(md)
+	g.takeMatches(md)
+	if g.Producer == nil {
+		return
+	}
+	if err := g.Producer.LogSessionEnd(handler, md, g.closeReason(handlerErr), opts...); err != nil {
+		g.Logger.Error("Failed to produce session end", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+	}
+}
+
+// expireSession ends a UDP flow once the connection table expires it, TCP
+// sessions end when their handler returns
+func (g *Glutton) expireSession(md connection.Metadata) {
+	if md.Key.Network != "udp" {
+		return
+	}
+	g.endSession(md.Rule.Handler(), md, os.ErrDeadlineExceeded)
+}
+
+// closeReason classifies how a session handler returned
+func (g *Glutton) closeReason(err error) string {
+	if g.ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return closeReasonShutdown
+	}
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return closeReasonClosed
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return closeReasonTimeout
+	}
+	return closeReasonError
+}
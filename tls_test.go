@@ -0,0 +1,66 @@
+package glutton
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/persona"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/rules"
+	"github.com/mushorg/glutton/tlscert"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig(t *testing.T) {
+	g := &Glutton{}
+	config, err := g.TLSConfig(connection.Metadata{})
+	require.NoError(t, err)
+	require.Equal(t, "localhost", config.Certificates[0].Leaf.Subject.CommonName)
+
+	g.personas, err = persona.Parse(bytes.NewReader(defaultPersonas))
+	require.NoError(t, err)
+	config, err = g.TLSConfig(connection.Metadata{})
+	require.NoError(t, err)
+	leaf := config.Certificates[0].Leaf
+	require.Equal(t, "IPC", leaf.Subject.CommonName)
+	require.Equal(t, []string{"Hikvision"}, leaf.Subject.Organization)
+	require.Equal(t, []string{"IPC"}, leaf.DNSNames)
+
+	config, err = g.TLSConfig(connection.Metadata{Rule: &rules.Rule{Persona: "synology-nas"}})
+	require.NoError(t, err)
+	leaf = config.Certificates[0].Leaf
+	require.Equal(t, "synology", leaf.Subject.CommonName)
+	require.Equal(t, []string{"DiskStation"}, leaf.DNSNames)
+}
+
+func TestInitTLS(t *testing.T) {
+	cert, err := tlscert.Generate("honeypot.example")
+	require.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	viper.Set("tls.cert_file", certFile)
+	viper.Set("tls.key_file", keyFile)
+	defer viper.Set("tls.cert_file", "")
+	defer viper.Set("tls.key_file", "")
+
+	g := &Glutton{Logger: producer.NewLogger("test")}
+	require.NoError(t, g.initTLS())
+	config, err := g.TLSConfig(connection.Metadata{})
+	require.NoError(t, err)
+	require.Equal(t, cert.Certificate, config.Certificates[0].Certificate)
+
+	viper.Set("tls.key_file", filepath.Join(dir, "missing.pem"))
+	require.Error(t, g.initTLS())
+}
@@ -0,0 +1,129 @@
+// Package tlscert generates the self-signed certificates handlers present
+// when they speak TLS, so every sensor doesn't share one well-known
+// certificate.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// validity is how long generated certificates are valid, like the
+	// default of appliances that generate one on first boot
+	validity = 10 * 365 * 24 * time.Hour
+	// maxAge is how far back the start of the validity is set
+	maxAge = 2 * 365 * 24 * time.Hour
+)
+
+// Subject is what a certificate is issued to
+type Subject struct {
+	CommonName   string
+	Organization string
+	// Names are the host names and addresses the certificate is valid for
+	Names []string
+}
+
+// key identifies the subject in a Cache
+func (s Subject) key() string {
+	return strings.Join(append([]string{s.CommonName, s.Organization}, s.Names...), "\x00")
+}
+
+// Generate creates a self-signed certificate for host names or addresses.
+// The first name is the common name.
+func Generate(names ...string) (tls.Certificate, error) {
+	if len(names) == 0 {
+		return tls.Certificate{}, errors.New("no host name")
+	}
+	return New(Subject{CommonName: names[0], Names: names})
+}
+
+// New creates a self-signed certificate for a subject
+func New(subject Subject) (tls.Certificate, error) {
+	if subject.CommonName == "" && len(subject.Names) == 0 {
+		return tls.Certificate{}, errors.New("no host name")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	// a certificate issued moments before the connection gives the sensor away
+	age, err := rand.Int(rand.Reader, big.NewInt(int64(maxAge/time.Second)))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	notBefore := time.Now().Add(-time.Duration(age.Int64()) * time.Second).Truncate(time.Hour)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: subject.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if subject.Organization != "" {
+		template.Subject.Organization = []string{subject.Organization}
+	}
+	for _, name := range subject.Names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// Cache generates a certificate once per subject. The zero value is ready to
+// use and it's safe for concurrent use.
+type Cache struct {
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// Get returns the certificate of a subject, generating it on first use
+func (c *Cache) Get(subject Subject) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := subject.key()
+	if cert := c.certs[key]; cert != nil {
+		return cert, nil
+	}
+	cert, err := New(subject)
+	if err != nil {
+		return nil, err
+	}
+	if c.certs == nil {
+		c.certs = make(map[string]*tls.Certificate)
+	}
+	c.certs[key] = &cert
+	return &cert, nil
+}
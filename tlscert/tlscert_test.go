@@ -0,0 +1,53 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	cert, err := Generate("web01.localdomain", "192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, "web01.localdomain", cert.Leaf.Subject.CommonName)
+	require.Equal(t, []string{"web01.localdomain"}, cert.Leaf.DNSNames)
+	require.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")))
+	require.True(t, cert.Leaf.NotBefore.Before(time.Now()))
+	require.True(t, cert.Leaf.NotAfter.After(time.Now()))
+
+	_, err = Generate()
+	require.Error(t, err)
+
+	cert, err = New(Subject{CommonName: "IPC", Organization: "Hikvision", Names: []string{"192.0.2.1"}})
+	require.NoError(t, err)
+	require.Equal(t, "IPC", cert.Leaf.Subject.CommonName)
+	require.Equal(t, []string{"Hikvision"}, cert.Leaf.Subject.Organization)
+	require.Empty(t, cert.Leaf.DNSNames)
+	require.Len(t, cert.Leaf.IPAddresses, 1)
+}
+
+func TestCache(t *testing.T) {
+	c := &Cache{}
+	a, err := c.Get(Subject{CommonName: "IPC"})
+	require.NoError(t, err)
+	b, err := c.Get(Subject{CommonName: "IPC"})
+	require.NoError(t, err)
+	require.Same(t, a, b)
+	other, err := c.Get(Subject{CommonName: "IPC", Organization: "Hikvision"})
+	require.NoError(t, err)
+	require.NotSame(t, a, other)
+
+	// the certificate works for a handshake
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		tls.Server(server, &tls.Config{Certificates: []tls.Certificate{*a}}).Handshake()
+		server.Close()
+	}()
+	conn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, conn.Handshake())
+	require.Equal(t, "IPC", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
@@ -0,0 +1,298 @@
+// Package transcript records interactive sessions as timed transcripts in the
+// asciicast v2 format of asciinema, and plays them back.
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	// Output is data sent to the client
+	Output = "o"
+	// Input is data received from the client
+	Input = "i"
+)
+
+const (
+	defaultWidth   = 80
+	defaultHeight  = 24
+	defaultMaxSize = 1 << 20
+)
+
+// Header is the first line of an asciicast
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is some data sent or received at a time
+type Event struct {
+	// Time is the offset from the start of the session
+	Time time.Duration
+	// Type is Output or Input
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes an event as an asciicast event line
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time.Seconds(), e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes an asciicast event line
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var v []interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v) != 3 {
+		return fmt.Errorf("invalid event %s", data)
+	}
+	seconds, ok := v[0].(float64)
+	typ, ok2 := v[1].(string)
+	msg, ok3 := v[2].(string)
+	if !ok || !ok2 || !ok3 || seconds < 0 {
+		return fmt.Errorf("invalid event %s", data)
+	}
+	e.Time = time.Duration(seconds * float64(time.Second))
+	e.Type, e.Data = typ, msg
+	return nil
+}
+
+// Options configure a Recorder
+type Options struct {
+	// Title of the transcript, like the session ID
+	Title string
+	// Env is recorded in the header, asciinema knows TERM and SHELL
+	Env map[string]string
+	// Width and Height of the terminal, 80x24 if zero
+	Width  int
+	Height int
+	// MaxSize caps the encoded size of the events, later data is dropped
+	// and the transcript marked truncated. 1 MB if zero.
+	MaxSize int
+}
+
+// Recorder records the data of a session with its timing. It's safe for
+// concurrent use.
+type Recorder struct {
+	opts      Options
+	start     time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+	events    []Event
+	size      int
+	truncated bool
+}
+
+// NewRecorder starts recording a session
+func NewRecorder(opts Options) *Recorder {
+	if opts.Width <= 0 {
+		opts.Width = defaultWidth
+	}
+	if opts.Height <= 0 {
+		opts.Height = defaultHeight
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	return &Recorder{
+		opts:  opts,
+		start: time.Now(),
+		now:   time.Now,
+	}
+}
+
+// Record adds data of type Input or Output
+func (r *Recorder) Record(typ string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.truncated {
+		return
+	}
+	// the encoded event is at least as long as its data
+	if r.size+len(data) > r.opts.MaxSize {
+		r.truncated = true
+		return
+	}
+	r.size += len(data)
+	r.events = append(r.events, Event{
+		Time: r.now().Sub(r.start),
+		Type: typ,
+		Data: string(data),
+	})
+}
+
+// Empty reports whether nothing was recorded
+func (r *Recorder) Empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events) == 0
+}
+
+// Bytes encodes the transcript as asciicast. Data that isn't valid UTF-8 is
+// replaced by U+FFFD, like telnet commands.
+func (r *Recorder) Bytes() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	env := make(map[string]string, len(r.opts.Env)+1)
+	for k, v := range r.opts.Env {
+		env[k] = v
+	}
+	if r.truncated {
+		env["GLUTTON_TRUNCATED"] = "1"
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(Header{
+		Version:   2,
+		Width:     r.opts.Width,
+		Height:    r.opts.Height,
+		Timestamp: r.start.Unix(),
+		Title:     r.opts.Title,
+		Env:       env,
+	}); err != nil {
+		return nil, err
+	}
+	for _, e := range r.events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// conn records everything read from and written to a connection
+type conn struct {
+	net.Conn
+	rec *Recorder
+}
+
+// NewConn returns a connection recording its data to rec, what's read as
+// input and what's written as output
+func NewConn(c net.Conn, rec *Recorder) net.Conn {
+	return &conn{Conn: c, rec: rec}
+}
+
+// Unwrap returns the connection a recording connection wraps and its
+// recorder, or c and nil if c isn't recording. It lets TLS be terminated
+// below the recorder, which then records the decrypted session.
+func Unwrap(c net.Conn) (net.Conn, *Recorder) {
+	if rc, ok := c.(*conn); ok {
+		return rc.Conn, rc.rec
+	}
+	return c, nil
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.rec.Record(Input, p[:n])
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.rec.Record(Output, p[:n])
+	return n, err
+}
+
+// Transcript is a parsed asciicast
+type Transcript struct {
+	Header Header
+	Events []Event
+}
+
+// Parse reads an asciicast v2 transcript
+func Parse(r io.Reader) (*Transcript, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), defaultMaxSize*8)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty transcript")
+	}
+	t := &Transcript{}
+	if err := json.Unmarshal(scanner.Bytes(), &t.Header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	if t.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", t.Header.Version)
+	}
+	for n := 2; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		t.Events = append(t.Events, e)
+	}
+	return t, scanner.Err()
+}
+
+// PlayOptions configure Play
+type PlayOptions struct {
+	// Speed multiplies the playback speed, 1 if zero
+	Speed float64
+	// MaxIdle caps the pause between events, zero keeps the recorded pauses
+	MaxIdle time.Duration
+	// Output only plays what was sent to the client. By default the input is
+	// played too, as telnet clients echo locally and bots don't echo at all.
+	Output bool
+}
+
+// Play writes the events of t to w with their recorded timing, until the end
+// of the transcript or ctx is done
+func Play(ctx context.Context, w io.Writer, t *Transcript, opts PlayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	var last time.Duration
+	for _, e := range t.Events {
+		if opts.Output && e.Type != Output {
+			continue
+		}
+		pause := e.Time - last
+		last = e.Time
+		if opts.MaxIdle > 0 && pause > opts.MaxIdle {
+			pause = opts.MaxIdle
+		}
+		if pause = time.Duration(float64(pause) / opts.Speed); pause > 0 {
+			timer.Reset(pause)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
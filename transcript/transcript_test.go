@@ -0,0 +1,103 @@
+package transcript
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndParse(t *testing.T) {
+	rec := NewRecorder(Options{Title: "telnet session", Env: map[string]string{"TERM": "xterm"}})
+	now := rec.start
+	rec.now = func() time.Time { return now }
+
+	rec.Record(Output, []byte("Username: "))
+	now = now.Add(1500 * time.Millisecond)
+	rec.Record(Input, []byte("root\r\n"))
+	rec.Record(Input, nil)
+	require.False(t, rec.Empty())
+
+	data, err := rec.Bytes()
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, `[1.5,"i","root\r\n"]`, lines[2])
+
+	tr, err := Parse(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 2, tr.Header.Version)
+	require.Equal(t, 80, tr.Header.Width)
+	require.Equal(t, "telnet session", tr.Header.Title)
+	require.Equal(t, []Event{
+		{Time: 0, Type: Output, Data: "Username: "},
+		{Time: 1500 * time.Millisecond, Type: Input, Data: "root\r\n"},
+	}, tr.Events)
+
+	_, err = Parse(strings.NewReader(`{"version":1}`))
+	require.Error(t, err)
+	_, err = Parse(strings.NewReader("{\"version\":2}\n[1,\"o\"]\n"))
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestTruncate(t *testing.T) {
+	rec := NewRecorder(Options{MaxSize: 8})
+	rec.Record(Input, []byte("12345"))
+	rec.Record(Input, []byte("6789"))
+	rec.Record(Input, []byte("0"))
+
+	data, err := rec.Bytes()
+	require.NoError(t, err)
+	tr, err := Parse(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, tr.Events, 1)
+	require.Equal(t, "1", tr.Header.Env["GLUTTON_TRUNCATED"])
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	rec := NewRecorder(Options{})
+	conn := NewConn(server, rec)
+	defer conn.Close()
+
+	go func() {
+		client.Write([]byte("uname\n"))
+		io.ReadAll(client)
+	}()
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("Linux\n"))
+	require.NoError(t, err)
+
+	require.Equal(t, "uname\n", string(buf[:n]))
+	require.Equal(t, Input, rec.events[0].Type)
+	require.Equal(t, "Linux\n", rec.events[1].Data)
+}
+
+func TestPlay(t *testing.T) {
+	tr := &Transcript{Events: []Event{
+		{Time: 0, Type: Output, Data: "# "},
+		{Time: time.Hour, Type: Input, Data: "id\n"},
+		{Time: time.Hour + 10*time.Millisecond, Type: Output, Data: "uid=0(root)\n"},
+	}}
+	out := &bytes.Buffer{}
+	start := time.Now()
+	require.NoError(t, Play(context.Background(), out, tr, PlayOptions{MaxIdle: 10 * time.Millisecond, Speed: 2}))
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, "# id\nuid=0(root)\n", out.String())
+
+	out.Reset()
+	require.NoError(t, Play(context.Background(), out, tr, PlayOptions{MaxIdle: time.Millisecond, Output: true}))
+	require.Equal(t, "# uid=0(root)\n", out.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, Play(ctx, io.Discard, tr, PlayOptions{}), context.Canceled)
+}
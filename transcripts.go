@@ -0,0 +1,71 @@
+package glutton
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/transcript"
+
+	"github.com/spf13/viper"
+)
+
+// newTranscript starts recording a session of handler, it's nil if the
+// sessions of handler aren't recorded
+func (g *Glutton) newTranscript(handler string, md connection.Metadata) *transcript.Recorder {
+	if g.artifacts == nil || !viper.GetBool("transcripts.enabled") || !slices.Contains(viper.GetStringSlice("transcripts.handlers"), handler) {
+		return nil
+	}
+	return transcript.NewRecorder(transcript.Options{
+		Title:   handler + " session " + md.ID.String(),
+		MaxSize: viper.GetInt("transcripts.max_size") << 10,
+	})
+}
+
+// storeTranscript keeps the transcript of a session in the artifact store and
+// returns the event options referencing it
+func (g *Glutton) storeTranscript(handler string, md connection.Metadata, rec *transcript.Recorder) []producer.EventOption {
+	if rec == nil || rec.Empty() {
+		return nil
+	}
+	data, err := rec.Bytes()
+	if err != nil {
+		g.Logger.Error("Failed to encode transcript", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+		return nil
+	}
+	_, opts, err := g.storeArtifact(md, data, artifact.Info{Kind: artifact.KindTranscript, Handler: handler})
+	if err != nil {
+		g.Logger.Error("Failed to store transcript", producer.ErrAttr(err), slog.String("session", md.ID.String()))
+		return nil
+	}
+	return opts
+}
+
+// adminTranscript returns the transcript of a session as asciicast
+func (g *Glutton) adminTranscript(w http.ResponseWriter, r *http.Request) {
+	if g.artifacts == nil {
+		http.Error(w, "artifact store disabled", http.StatusNotFound)
+		return
+	}
+	meta, err := g.artifacts.FindSession(r.PathValue("session"), artifact.KindTranscript)
+	if err != nil {
+		g.Logger.Error("Failed to look up transcript", producer.ErrAttr(err))
+		http.Error(w, "failed to look up transcript", http.StatusInternalServerError)
+		return
+	}
+	if meta == nil {
+		http.Error(w, "transcript not found", http.StatusNotFound)
+		return
+	}
+	data, err := g.artifacts.Read(meta.SHA256)
+	if err != nil {
+		g.Logger.Error("Failed to read transcript", producer.ErrAttr(err), slog.String("sha256", meta.SHA256))
+		http.Error(w, "failed to read transcript", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Write(data)
+}
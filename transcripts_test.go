@@ -0,0 +1,54 @@
+package glutton
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/connection"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/transcript"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscripts(t *testing.T) {
+	store, err := artifact.Open(t.TempDir(), artifact.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+	g := &Glutton{Logger: producer.NewLogger("test"), artifacts: store}
+	md := connection.Metadata{ID: uuid.New()}
+
+	viper.Set("transcripts.enabled", true)
+	viper.Set("transcripts.handlers", []string{"telnet"})
+	defer viper.Set("transcripts.enabled", false)
+	require.Nil(t, g.newTranscript("http", md))
+	rec := g.newTranscript("telnet", md)
+	require.NotNil(t, rec)
+	require.Empty(t, g.storeTranscript("telnet", md, rec))
+
+	rec.Record(transcript.Output, []byte("Username: "))
+	rec.Record(transcript.Input, []byte("root\r\n"))
+	event := &producer.Event{}
+	for _, opt := range g.storeTranscript("telnet", md, rec) {
+		opt(event)
+	}
+	require.Len(t, event.Artifacts, 1)
+	require.Equal(t, artifact.KindTranscript, event.Artifacts[0].Kind)
+
+	mux := http.NewServeMux()
+	g.registerAdminHandlers(mux)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/transcripts/"+md.ID.String(), nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	stored, err := store.Read(event.Artifacts[0].SHA256)
+	require.NoError(t, err)
+	require.Equal(t, stored, resp.Body.Bytes())
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/transcripts/"+uuid.NewString(), nil))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,53 @@
+package glutton
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/mushorg/glutton/artifact"
+	"github.com/mushorg/glutton/producer"
+	"github.com/mushorg/glutton/yara"
+
+	"github.com/spf13/viper"
+)
+
+// initYara starts scanning stored blobs with the YARA rules
+func (g *Glutton) initYara() error {
+	if !viper.GetBool("yara.enabled") {
+		return nil
+	}
+	var err error
+	g.yara, err = yara.New(g.ctx, yara.Options{
+		RulesDir: viper.GetString("yara.rules_dir"),
+		Binary:   viper.GetString("yara.binary"),
+		Timeout:  time.Duration(viper.GetInt("yara.timeout")) * time.Second,
+		Reload:   time.Duration(viper.GetInt("yara.reload_interval")) * time.Second,
+		Logger:   g.Logger,
+	})
+	return err
+}
+
+// scanArtifact scans an artifact with the YARA rules, unless it was
+// already scanned with the current ones, and returns the result if any
+// rule matched
+func (g *Glutton) scanArtifact(meta *artifact.Meta, data []byte) *yara.Result {
+	if g.yara == nil {
+		return nil
+	}
+	result := meta.Yara
+	if result == nil || result.RulesVersion != g.yara.Version() {
+		var err error
+		result, err = g.yara.Scan(g.ctx, data, meta.SHA256)
+		if err != nil {
+			g.Logger.Error("Failed to scan artifact", producer.ErrAttr(err), slog.String("sha256", meta.SHA256))
+			return nil
+		}
+		if err := g.artifacts.SetYara(meta.SHA256, result); err != nil {
+			g.Logger.Error("Failed to record YARA result", producer.ErrAttr(err), slog.String("sha256", meta.SHA256))
+		}
+	}
+	if len(result.Matches) == 0 {
+		return nil
+	}
+	return result
+}
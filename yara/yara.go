@@ -0,0 +1,249 @@
+package yara
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBinary  = "yara"
+	defaultTimeout = 10 * time.Second
+)
+
+// Match is a rule matching a scanned file
+type Match struct {
+	// Namespace is the name of the rule file without extension
+	Namespace string   `json:"namespace"`
+	Rule      string   `json:"rule"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Result is the outcome of scanning a blob
+type Result struct {
+	SHA256       string    `json:"sha256"`
+	Scanned      time.Time `json:"scanned"`
+	RulesVersion string    `json:"rulesVersion"`
+	Matches      []Match   `json:"matches"`
+}
+
+// Options configure a Scanner
+type Options struct {
+	// RulesDir holds the *.yar and *.yara rule files
+	RulesDir string
+	// Binary is the yara command line scanner, looked up in PATH
+	Binary string
+	// Timeout bounds a single scan
+	Timeout time.Duration
+	// Reload is how often the rules directory is checked for changes, zero
+	// disables reloading
+	Reload time.Duration
+	Logger *slog.Logger
+}
+
+type ruleSet struct {
+	// files maps namespaces to rule file paths
+	files   map[string]string
+	version string
+}
+
+// Scanner matches files against a directory of YARA rules with the yara
+// command line scanner
+type Scanner struct {
+	opts   Options
+	binary string
+	rules  atomic.Pointer[ruleSet]
+}
+
+// New loads the rules and starts the reload ticker, which stops when ctx is done
+func New(ctx context.Context, opts Options) (*Scanner, error) {
+	if opts.Binary == "" {
+		opts.Binary = defaultBinary
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	binary, err := exec.LookPath(opts.Binary)
+	if err != nil {
+		return nil, fmt.Errorf("yara scanner not found: %w", err)
+	}
+	s := &Scanner{opts: opts, binary: binary}
+	if err := s.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	if opts.Reload > 0 {
+		go func() {
+			ticker := time.NewTicker(opts.Reload)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := s.Reload(ctx); err != nil {
+						s.opts.Logger.Error("Failed to reload YARA rules, keeping the current ones", slog.String("error", err.Error()), slog.String("reporter", "yara"))
+					}
+				}
+			}
+		}()
+	}
+	return s, nil
+}
+
+var namespaceChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Reload reads the rules directory again if any rule file changed. The new
+// rules are compiled once and the current ones kept if that fails.
+func (s *Scanner) Reload(ctx context.Context) error {
+	entries, err := os.ReadDir(s.opts.RulesDir)
+	if err != nil {
+		return fmt.Errorf("failed to read YARA rules directory: %w", err)
+	}
+	files := map[string]string{}
+	hash := sha256.New()
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yar" && ext != ".yara") {
+			continue
+		}
+		path := filepath.Join(s.opts.RulesDir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		namespace := namespaceChars.ReplaceAllString(strings.TrimSuffix(entry.Name(), ext), "_")
+		if _, ok := files[namespace]; ok {
+			return fmt.Errorf("rule files %s and %s share the namespace %s", files[namespace], path, namespace)
+		}
+		files[namespace] = path
+		fmt.Fprintf(hash, "%s\x00%d\x00", namespace, len(data))
+		hash.Write(data)
+	}
+	rules := &ruleSet{files: files, version: hex.EncodeToString(hash.Sum(nil))[:16]}
+	if current := s.rules.Load(); current != nil && current.version == rules.version {
+		return nil
+	}
+
+	if len(files) > 0 {
+		// compile the rules against an empty file to catch syntax errors now
+		if _, err := s.run(ctx, rules, os.DevNull); err != nil {
+			return fmt.Errorf("invalid YARA rules: %w", err)
+		}
+	}
+	s.rules.Store(rules)
+	s.opts.Logger.Info("Loaded YARA rules", slog.Int("files", len(files)), slog.String("version", rules.version), slog.String("reporter", "yara"))
+	return nil
+}
+
+// Version identifies the loaded rules, it changes with any rule file
+func (s *Scanner) Version() string {
+	return s.rules.Load().version
+}
+
+// ScanFile matches the file at path against the loaded rules
+func (s *Scanner) ScanFile(ctx context.Context, path string) ([]Match, error) {
+	rules := s.rules.Load()
+	if len(rules.files) == 0 {
+		return nil, nil
+	}
+	return s.run(ctx, rules, path)
+}
+
+func (s *Scanner) run(ctx context.Context, rules *ruleSet, path string) ([]Match, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	// print namespaces and tags, no warnings
+	args := []string{"-e", "-g", "-w"}
+	namespaces := make([]string, 0, len(rules.files))
+	for namespace := range rules.files {
+		namespaces = append(namespaces, namespace)
+	}
+	slices.Sort(namespaces)
+	for _, namespace := range namespaces {
+		args = append(args, namespace+":"+rules.files[namespace])
+	}
+	args = append(args, path)
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, s.binary, args...)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return parseOutput(out, path)
+}
+
+// parseOutput reads the "namespace:rule [tag,...] path" lines of yara -e -g
+func parseOutput(out []byte, path string) ([]Match, error) {
+	var matches []Match
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), " "+path)
+		if line == "" {
+			continue
+		}
+		name, tags, ok := strings.Cut(line, " [")
+		if !ok || !strings.HasSuffix(tags, "]") {
+			return nil, fmt.Errorf("unexpected yara output %q", scanner.Text())
+		}
+		match := Match{Rule: name}
+		if namespace, rule, ok := strings.Cut(name, ":"); ok {
+			match.Namespace, match.Rule = namespace, rule
+		}
+		if tags = strings.TrimSuffix(tags, "]"); tags != "" {
+			match.Tags = strings.Split(tags, ",")
+		}
+		matches = append(matches, match)
+	}
+	return matches, scanner.Err()
+}
+
+// Scan matches data, named by its SHA256 hash, against the loaded rules
+func (s *Scanner) Scan(ctx context.Context, data []byte, sha256Hash string) (*Result, error) {
+	// the scanner reads from a file, artifacts may be stored compressed
+	f, err := os.CreateTemp("", "glutton-yara-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	version := s.Version()
+	matches, err := s.ScanFile(ctx, f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		SHA256:       sha256Hash,
+		Scanned:      time.Now().UTC(),
+		RulesVersion: version,
+		Matches:      matches,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package yara
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeYara mimics the output of yara -e -g: it fails on rule files
+// containing BROKEN and matches targets containing EVIL
+const fakeYara = `#!/bin/sh
+for arg; do target=$arg; done
+for arg; do
+	case $arg in
+	*:*)
+		if grep -q BROKEN "${arg#*:}"; then
+			echo "${arg#*:}(1): error: syntax error" >&2
+			exit 1
+		fi
+		;;
+	esac
+done
+if grep -q EVIL "$target"; then
+	echo "test:evil [malware,dropper] $target"
+	echo "test:plain [] $target"
+fi
+`
+
+func testScanner(t *testing.T) (*Scanner, string) {
+	t.Helper()
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "yara")
+	require.NoError(t, os.WriteFile(binary, []byte(fakeYara), 0o755))
+	rulesDir := filepath.Join(dir, "rules")
+	require.NoError(t, os.Mkdir(rulesDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rulesDir, "test.yar"), []byte("rule evil { condition: true }"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s, err := New(ctx, Options{RulesDir: rulesDir, Binary: binary})
+	require.NoError(t, err)
+	return s, rulesDir
+}
+
+func TestScan(t *testing.T) {
+	s, _ := testScanner(t)
+
+	result, err := s.Scan(context.Background(), []byte("an EVIL payload"), "abc")
+	require.NoError(t, err)
+	require.Equal(t, "abc", result.SHA256)
+	require.Equal(t, s.Version(), result.RulesVersion)
+	require.Equal(t, []Match{
+		{Namespace: "test", Rule: "evil", Tags: []string{"malware", "dropper"}},
+		{Namespace: "test", Rule: "plain"},
+	}, result.Matches)
+
+	result, err = s.Scan(context.Background(), []byte("nothing"), "def")
+	require.NoError(t, err)
+	require.Empty(t, result.Matches)
+}
+
+func TestReload(t *testing.T) {
+	s, rulesDir := testScanner(t)
+	version := s.Version()
+
+	// unchanged rules keep their version
+	require.NoError(t, s.Reload(context.Background()))
+	require.Equal(t, version, s.Version())
+
+	// broken rules are rejected and the current ones kept
+	require.NoError(t, os.WriteFile(filepath.Join(rulesDir, "broken.yara"), []byte("BROKEN"), 0o644))
+	require.Error(t, s.Reload(context.Background()))
+	require.Equal(t, version, s.Version())
+
+	require.NoError(t, os.WriteFile(filepath.Join(rulesDir, "broken.yara"), []byte("rule fixed { condition: true }"), 0o644))
+	require.NoError(t, s.Reload(context.Background()))
+	require.NotEqual(t, version, s.Version())
+	require.Len(t, s.rules.Load().files, 2)
+}
+
+func TestParseOutput(t *testing.T) {
+	_, err := parseOutput([]byte("garbage /tmp/x\n"), "/tmp/x")
+	require.Error(t, err)
+
+	matches, err := parseOutput([]byte("rule [] /tmp/x\n"), "/tmp/x")
+	require.NoError(t, err)
+	require.Equal(t, []Match{{Rule: "rule"}}, matches)
+}